@@ -0,0 +1,69 @@
+// Command createadmin creates the first admin account.
+//
+//	go run ./cmd/createadmin -name "Jane Doe" -email jane@example.com
+//
+// The password is read from ADMIN_PASSWORD or, if unset, from standard input.
+// The command refuses to run once an admin exists; further staff accounts are
+// created through invitations.
+package main
+
+import (
+	"Visa/config"
+	"Visa/internal/repos"
+	"Visa/internal/services"
+	"Visa/migration"
+	"Visa/models"
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	name := flag.String("name", "", "full name of the admin")
+	email := flag.String("email", "", "email address of the admin")
+	flag.Parse()
+
+	if *name == "" || *email == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		fmt.Print("Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			log.Fatal("Failed to read password:", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	config.ConnectToDB()
+	migration.Migrate()
+
+	userRepo := repos.NewUserRepo(config.Db)
+	admins, err := userRepo.GetAdminUsers()
+	if err != nil {
+		log.Fatal("Failed to check existing admins:", err)
+	}
+	if len(admins) > 0 {
+		log.Fatal("An admin already exists; invite further staff from the admin API")
+	}
+
+	// Creating a verified account needs neither tokens nor email
+	userService := services.NewUserService(userRepo, nil, nil, nil)
+	user := &models.User{
+		Name:     *name,
+		Email:    *email,
+		Password: password,
+		Role:     services.RoleAdmin,
+	}
+	if err := userService.CreateVerifiedUser(user); err != nil {
+		log.Fatal("Failed to create admin:", err)
+	}
+
+	fmt.Printf("Admin %s <%s> created with ID %d\n", user.Name, user.Email, user.ID)
+}
@@ -7,6 +7,8 @@ import (
 	"Visa/internal/services"
 	"Visa/middleware"
 	"Visa/migration"
+	"Visa/models"
+	"Visa/pkg"
 	"log"
 	"os"
 	"time"
@@ -27,19 +29,67 @@ func GetMe(c *gin.Context) {
 func main() {
 	config.ConnectToDB()
 	migration.Migrate()
+	issuer, err := pkg.NewIssuerFromEnv()
+	if err != nil {
+		log.Fatal("Failed to load token signing keys:", err)
+	}
+
+	mailer := pkg.NewMailerFromEnv()
+
+	oidcProviders, err := pkg.NewOIDCProvidersFromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure identity providers:", err)
+	}
+
+	rbacService := services.NewRBACService(repos.NewRBACRepo(config.Db))
+	if err := rbacService.SeedDefaults(); err != nil {
+		log.Fatal("Failed to seed roles and permissions:", err)
+	}
+
 	// Initialize services
-	userService := services.NewUserService(repos.NewUserRepo(config.Db))
-	visaService := services.NewVisaService(repos.NewVisaRepo(config.Db))
-	hotelService := services.NewHotelService(repos.NewHotelRepo(config.Db), repos.NewReservationRepo(config.Db))
-	flightService := services.NewFlightService(repos.NewFlightRepo(config.Db), repos.NewReservationRepo(config.Db))
+	authService := services.NewAuthService(issuer, repos.NewTokenRepo(config.Db), repos.NewUserTokenRepo(config.Db), repos.NewUserRepo(config.Db), repos.NewSessionRepo(config.Db), repos.NewAPIKeyRepo(config.Db))
+	loginAttemptService := services.NewLoginAttemptService(repos.NewLoginEventRepo(config.Db), repos.NewUserRepo(config.Db))
+	userService := services.NewUserService(repos.NewUserRepo(config.Db), authService, mailer, loginAttemptService)
+	visaService := services.NewVisaService(repos.NewVisaRepo(config.Db), repos.NewUserRepo(config.Db), repos.NewTravellerRepo(config.Db))
+	hotelService := services.NewHotelService(repos.NewHotelRepo(config.Db), repos.NewReservationRepo(config.Db), repos.NewUserRepo(config.Db), repos.NewTravellerRepo(config.Db))
+	preferencesService := services.NewPreferencesService(repos.NewPreferencesRepo(config.Db))
+	flightService := services.NewFlightService(repos.NewFlightRepo(config.Db), repos.NewReservationRepo(config.Db), repos.NewUserRepo(config.Db), repos.NewTravellerRepo(config.Db), preferencesService, mailer)
+	tripService := services.NewTripService(repos.NewReservationRepo(config.Db), repos.NewFlightRepo(config.Db), flightService)
+	itineraryService := services.NewItineraryService(repos.NewFlightRepo(config.Db), tripService)
+	seatService := services.NewSeatService(repos.NewSeatMapRepo(config.Db), repos.NewFlightRepo(config.Db), repos.NewReservationRepo(config.Db))
 	supportService := services.NewSupportService(repos.NewSupportRepo(config.Db))
+	invitationService := services.NewInvitationService(repos.NewInvitationRepo(config.Db), userService)
+	oidcService := services.NewOIDCService(oidcProviders, repos.NewIdentityRepo(config.Db), userService)
+	apiKeyService := services.NewAPIKeyService(repos.NewAPIKeyRepo(config.Db), repos.NewUserRepo(config.Db))
+	twoFactorService := services.NewTwoFactorService(repos.NewUserRepo(config.Db), repos.NewRecoveryCodeRepo(config.Db), authService, loginAttemptService)
+	privacyService := services.NewPrivacyService(repos.NewPrivacyRepo(config.Db), repos.NewUserRepo(config.Db), repos.NewReservationRepo(config.Db), repos.NewVisaRepo(config.Db), repos.NewSupportRepo(config.Db), repos.NewTravellerRepo(config.Db), repos.NewPreferencesRepo(config.Db), repos.NewSessionRepo(config.Db), repos.NewLoginEventRepo(config.Db), repos.NewIdentityRepo(config.Db), repos.NewAPIKeyRepo(config.Db), twoFactorService)
+	impersonationService := services.NewImpersonationService(authService, repos.NewUserRepo(config.Db), repos.NewAuditLogRepo(config.Db))
+	travellerService := services.NewTravellerService(repos.NewTravellerRepo(config.Db))
+	userAdminService := services.NewUserAdminService(repos.NewUserRepo(config.Db), userService, repos.NewReservationRepo(config.Db), repos.NewVisaRepo(config.Db), repos.NewSupportRepo(config.Db), repos.NewTravellerRepo(config.Db))
+
+	// Expired refresh tokens and revocation entries are deleted in the background
+	go authService.StartTokenCleanup(nil)
 
 	// Initialize handlers
+	authHandler := handlers.NewAuthHandler(authService)
 	userHandler := handlers.NewUserHandler(userService)
 	visaHandler := handlers.NewVisaHandler(visaService)
 	hotelHandler := handlers.NewHotelHandler(hotelService)
 	flightHandler := handlers.NewFlightHandler(flightService)
+	itineraryHandler := handlers.NewItineraryHandler(itineraryService)
+	tripHandler := handlers.NewTripHandler(tripService)
+	seatHandler := handlers.NewSeatHandler(seatService)
 	supportHandler := handlers.NewSupportHandler(supportService)
+	twoFactorHandler := handlers.NewTwoFactorHandler(twoFactorService)
+	invitationHandler := handlers.NewInvitationHandler(invitationService)
+	rbacHandler := handlers.NewRBACHandler(rbacService)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService)
+	oidcHandler := handlers.NewOIDCHandler(oidcService)
+	impersonationHandler := handlers.NewImpersonationHandler(impersonationService)
+	privacyHandler := handlers.NewPrivacyHandler(privacyService)
+	travellerHandler := handlers.NewTravellerHandler(travellerService)
+	preferencesHandler := handlers.NewPreferencesHandler(preferencesService)
+	userAdminHandler := handlers.NewUserAdminHandler(userAdminService)
 
 	// Setup router
 	r := gin.Default()
@@ -48,7 +98,7 @@ func main() {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"https://travel-app-backend-production-1aeb.up.railway.app", "https://travel-app-frontend-beta.vercel.app"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-API-Key"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
@@ -64,80 +114,177 @@ func main() {
 		// Auth
 
 		public.POST("/login", userHandler.LoginUser)
+		public.POST("/login/2fa", twoFactorHandler.Login)
+		public.POST("/login/magic-link", userHandler.LoginWithMagicLink)
+		public.POST("/auth/magic-link", userHandler.RequestMagicLink)
 		public.POST("/signup", userHandler.CreateUser)
-		// Public flight and hotel viewing
-		public.GET("/flights", flightHandler.GetFlights)
-		public.GET("/flights/:id", flightHandler.GetFlightById)
-		public.GET("/flights/city/:city", flightHandler.GetFlightsByCity)
-		public.GET("/flights/date/:date", flightHandler.GetFlightsByDepartDate)
-
-		public.GET("/hotels", hotelHandler.GetAllHotels)
-		public.GET("/hotels/:id", hotelHandler.GetHotelById)
-		public.GET("/hotels/city/:city", hotelHandler.GetHotelsByCity)
-		public.GET("/hotels/checkin/:date", hotelHandler.GetHotelsByCheckInDate)
-		public.GET("/hotels/checkout/:date", hotelHandler.GetHotelsByCheckOutDate)
+		public.POST("/auth/refresh", authHandler.RefreshToken)
+		public.POST("/auth/forgot-password", userHandler.ForgotPassword)
+		public.POST("/auth/reset-password", userHandler.ResetPassword)
+		public.POST("/auth/verify-email", userHandler.VerifyEmail)
+		public.POST("/auth/accept-invitation", invitationHandler.AcceptInvitation)
+		public.GET("/auth/oidc/providers", oidcHandler.GetProviders)
+		public.GET("/auth/oidc/:provider/start", oidcHandler.StartLogin)
+		public.POST("/auth/oidc/:provider/callback", oidcHandler.Callback)
+		public.GET("/preferences/options", preferencesHandler.GetPreferenceOptions)
+
+		// Public flight and hotel viewing, with prices and dates in the caller's preferences
+		localize := middleware.LoadLocalizer(authService, preferencesService)
+		public.GET("/flights", localize, flightHandler.SearchFlights)
+		public.GET("/flights/search", localize, flightHandler.SearchFlights)
+		public.GET("/flights/itineraries", localize, itineraryHandler.SearchItineraries)
+		public.GET("/flights/:id", localize, flightHandler.GetFlightById)
+		public.GET("/flights/:id/seats", localize, seatHandler.GetFlightSeatMap)
+		public.GET("/flights/city/:city", localize, flightHandler.GetFlightsByCity)
+		public.GET("/flights/date/:date", localize, flightHandler.GetFlightsByDepartDate)
+
+		public.GET("/hotels", localize, hotelHandler.GetAllHotels)
+		public.GET("/hotels/:id", localize, hotelHandler.GetHotelById)
+		public.GET("/hotels/city/:city", localize, hotelHandler.GetHotelsByCity)
+		public.GET("/hotels/checkin/:date", localize, hotelHandler.GetHotelsByCheckInDate)
+		public.GET("/hotels/checkout/:date", localize, hotelHandler.GetHotelsByCheckOutDate)
 		public.GET("/visas", visaHandler.GetAllVisa)
 	}
 
-	// Protected routes (require authentication)
+	// With REQUIRE_ADMIN_2FA, staff routes only accept tokens from a two-factor login
+	requireStaff2FA := os.Getenv("REQUIRE_ADMIN_2FA") == "true"
+
+	// Account routes (require a signed-in user, API keys are not accepted).
+	// Sensitive operations are blocked for staff impersonating the user.
+	account := r.Group("/api/v1")
+	account.Use(middleware.AuthMiddleware(authService), middleware.AuditImpersonation(impersonationService), middleware.LoadPermissions(rbacService))
+	{
+		// Staff changing or deleting another user's account are held to the admin 2FA rule
+		account.PUT("/users/:id", middleware.BlockImpersonation(), middleware.RequireTwoFactorForOtherUsers(requireStaff2FA), userHandler.UpdateUser)
+		account.DELETE("/users/:id", middleware.BlockImpersonation(), middleware.RequireTwoFactorForOtherUsers(requireStaff2FA), privacyHandler.EraseUser)
+		account.GET("/auth/me", GetMe)
+		account.POST("/auth/logout", authHandler.Logout)
+		account.POST("/auth/change-password", middleware.BlockImpersonation(), userHandler.ChangePassword)
+		account.POST("/auth/resend-verification", userHandler.ResendVerification)
+		account.GET("/auth/sign-ins", userHandler.GetRecentSignIns)
+		account.GET("/auth/data-export", middleware.BlockImpersonation(), privacyHandler.ExportData)
+		account.GET("/auth/identities", oidcHandler.GetIdentities)
+		account.GET("/auth/sessions", authHandler.GetSessions)
+		account.DELETE("/auth/sessions", middleware.BlockImpersonation(), authHandler.EndOtherSessions)
+		account.DELETE("/auth/sessions/:id", middleware.BlockImpersonation(), authHandler.EndSession)
+
+		// Two-factor authentication
+		account.POST("/auth/2fa/setup", middleware.BlockImpersonation(), twoFactorHandler.Setup)
+		account.POST("/auth/2fa/confirm", middleware.BlockImpersonation(), twoFactorHandler.Confirm)
+		account.POST("/auth/2fa/disable", middleware.BlockImpersonation(), twoFactorHandler.Disable)
+		account.POST("/auth/2fa/recovery-codes", middleware.BlockImpersonation(), twoFactorHandler.RegenerateRecoveryCodes)
+
+		// API keys for partner integrations
+		account.POST("/auth/api-keys", middleware.BlockImpersonation(), apiKeyHandler.CreateAPIKey)
+		account.GET("/auth/api-keys", apiKeyHandler.GetAPIKeys)
+		account.DELETE("/auth/api-keys/:id", middleware.BlockImpersonation(), apiKeyHandler.RevokeAPIKey)
+
+		// Currency, locale, time zone and notification preferences
+		account.GET("/preferences", preferencesHandler.GetPreferences)
+		account.PUT("/preferences", preferencesHandler.UpdatePreferences)
+
+		// Saved travellers
+		account.POST("/travellers", travellerHandler.CreateTraveller)
+		account.PUT("/travellers/:id", travellerHandler.UpdateTraveller)
+		account.DELETE("/travellers/:id", travellerHandler.DeleteTraveller)
+	}
+
+	// Protected routes (require a signed-in user or an API key with the route's scope)
 	protected := r.Group("/api/v1")
-	protected.Use(middleware.AuthMiddleware())
+	protected.Use(middleware.AuthOrAPIKeyMiddleware(authService, apiKeyService, pkg.NewRateLimiter(time.Minute)), middleware.AuditImpersonation(impersonationService), middleware.LoadPermissions(rbacService))
 	{
 		// User routes
-		protected.GET("/users/:id", userHandler.GetUserById)
-		protected.PUT("/users/:id", userHandler.UpdateUser)
-		protected.DELETE("/users/:id", userHandler.DeleteUser)
-		protected.GET("/auth/me", GetMe)
+		protected.GET("/users/:id", middleware.RequireScope(models.ScopeProfileRead), userHandler.GetUserById)
+		protected.GET("/travellers", middleware.RequireScope(models.ScopeProfileRead), travellerHandler.GetTravellers)
+		protected.GET("/travellers/:id", middleware.RequireScope(models.ScopeProfileRead), travellerHandler.GetTraveller)
 
 		// Visa routes
-		protected.POST("/visas", visaHandler.CreateVisa)
-		protected.GET("/visas/:id", visaHandler.GetVisaById)
-		protected.GET("/visas/user/:userId", visaHandler.GetVisasByUser)
-		protected.PUT("/visas/:id", visaHandler.UpdateVisa)
-		protected.DELETE("/visas/:id", visaHandler.DeleteVisa)
+		protected.POST("/visas", middleware.RequireScope(models.ScopeVisasWrite), visaHandler.CreateVisa)
+		protected.GET("/visas/:id", middleware.RequireScope(models.ScopeVisasRead), visaHandler.GetVisaById)
+		protected.GET("/visas/user/:userId", middleware.RequireScope(models.ScopeVisasRead), visaHandler.GetVisasByUser)
+		protected.PUT("/visas/:id", middleware.RequireScope(models.ScopeVisasWrite), visaHandler.UpdateVisa)
+		protected.DELETE("/visas/:id", middleware.RequireScope(models.ScopeVisasWrite), visaHandler.DeleteVisa)
 
 		// Hotel booking routes
-		protected.POST("/hotels/book", hotelHandler.BookHotel)
-		protected.POST("/hotels/cancel", hotelHandler.CancelHotel)
-		protected.GET("/hotels/user/:userId", hotelHandler.GetHotelsByUser)
+		protected.POST("/hotels/book", middleware.RequireScope(models.ScopeBookingsWrite), hotelHandler.BookHotel)
+		protected.POST("/hotels/cancel", middleware.RequireScope(models.ScopeBookingsWrite), hotelHandler.CancelHotel)
+		protected.GET("/hotels/user/:userId", middleware.RequireScope(models.ScopeBookingsRead), middleware.LoadLocalizer(authService, preferencesService), hotelHandler.GetHotelsByUser)
 
 		// Flight booking routes
-		protected.POST("/flights/book", flightHandler.BookFlight)
-		protected.POST("/flights/cancel", flightHandler.CancelFlight)
-		protected.GET("/flights/user/:userId", flightHandler.GetFlightsByUser)
+		protected.POST("/flights/book", middleware.RequireScope(models.ScopeBookingsWrite), flightHandler.BookFlight)
+		protected.POST("/flights/cancel", middleware.RequireScope(models.ScopeBookingsWrite), flightHandler.CancelFlight)
+		protected.POST("/flights/itineraries/book", middleware.RequireScope(models.ScopeBookingsWrite), middleware.LoadLocalizer(authService, preferencesService), itineraryHandler.BookItinerary)
+		protected.POST("/flights/trips/book", middleware.RequireScope(models.ScopeBookingsWrite), middleware.LoadLocalizer(authService, preferencesService), tripHandler.BookTrip)
+		protected.GET("/flights/trips/:reference", middleware.RequireScope(models.ScopeBookingsRead), middleware.LoadLocalizer(authService, preferencesService), tripHandler.GetTrip)
+		protected.POST("/flights/trips/:reference/cancel", middleware.RequireScope(models.ScopeBookingsWrite), middleware.LoadLocalizer(authService, preferencesService), tripHandler.CancelTrip)
+		protected.PUT("/flights/trips/:reference/seats", middleware.RequireScope(models.ScopeBookingsWrite), seatHandler.SelectSeat)
+		protected.GET("/flights/user/:userId", middleware.RequireScope(models.ScopeBookingsRead), middleware.LoadLocalizer(authService, preferencesService), flightHandler.GetFlightsByUser)
 
 		// Support ticket routes
-		protected.POST("/support", supportHandler.CreateTicket)
-		protected.GET("/support/:id", supportHandler.GetTicketById)
-		protected.GET("/support/user/:userId", supportHandler.GetTicketsByUser)
-		protected.PUT("/support/:id", supportHandler.UpdateTicket)
-		protected.DELETE("/support/:id", supportHandler.DeleteTicket)
+		protected.POST("/support", middleware.RequireScope(models.ScopeSupportWrite), supportHandler.CreateTicket)
+		protected.GET("/support/:id", middleware.RequireScope(models.ScopeSupportRead), supportHandler.GetTicketById)
+		protected.GET("/support/user/:userId", middleware.RequireScope(models.ScopeSupportRead), supportHandler.GetTicketsByUser)
+		protected.PUT("/support/:id", middleware.RequireScope(models.ScopeSupportWrite), supportHandler.UpdateTicket)
+		protected.DELETE("/support/:id", middleware.RequireScope(models.ScopeSupportWrite), supportHandler.DeleteTicket)
 	}
 
-	// Admin routes (require admin role)
+	// Staff routes (each route requires a permission of the caller's role,
+	// impersonation tokens are not accepted)
 	admin := r.Group("/api/v1/admin")
-	admin.Use(middleware.AuthMiddleware(), middleware.AdminMiddleware())
+	admin.Use(middleware.AuthMiddleware(authService), middleware.AuditImpersonation(impersonationService), middleware.BlockImpersonation(), middleware.LoadPermissions(rbacService))
+	if requireStaff2FA {
+		admin.Use(middleware.RequireTwoFactor())
+	}
 	{
 		// User management
-		admin.GET("/users", userHandler.GetAllUsers)
+		admin.GET("/users", middleware.RequirePermission(models.PermUserRead), userAdminHandler.SearchUsers)
+		admin.GET("/users/:id/overview", middleware.RequirePermission(models.PermUserRead), userAdminHandler.GetUserOverview)
+		admin.POST("/users/:id/suspend", middleware.RequirePermission(models.PermUserManage), userAdminHandler.SuspendUser)
+		admin.POST("/users/:id/reactivate", middleware.RequirePermission(models.PermUserManage), userAdminHandler.ReactivateUser)
+		admin.POST("/users/:id/force-password-reset", middleware.RequirePermission(models.PermUserManage), userAdminHandler.ForcePasswordReset)
+		admin.PUT("/users/:id/role", middleware.RequirePermission(models.PermRoleManage), userHandler.ChangeRole)
+		admin.POST("/users/:id/unlock", middleware.RequirePermission(models.PermUserManage), userHandler.UnlockUser)
+		admin.POST("/users/:id/impersonate", middleware.RequirePermission(models.PermUserImpersonate), impersonationHandler.StartImpersonation)
+		admin.GET("/audit-logs", middleware.RequirePermission(models.PermAuditRead), impersonationHandler.GetAuditLogs)
+		admin.POST("/invitations", middleware.RequirePermission(models.PermRoleManage), invitationHandler.CreateInvitation)
+		admin.GET("/invitations", middleware.RequirePermission(models.PermRoleManage), invitationHandler.GetPendingInvitations)
+		admin.DELETE("/invitations/:id", middleware.RequirePermission(models.PermRoleManage), invitationHandler.RevokeInvitation)
+
+		// Roles and permissions
+		admin.GET("/roles", middleware.RequirePermission(models.PermRoleManage), rbacHandler.GetRoles)
+		admin.GET("/permissions", middleware.RequirePermission(models.PermRoleManage), rbacHandler.GetPermissions)
+		admin.PUT("/roles/:name/permissions", middleware.RequirePermission(models.PermRoleManage), rbacHandler.UpdateRolePermissions)
 
 		// Visa management
-		admin.GET("/visas", visaHandler.GetAllVisa)
-		admin.GET("/visas/approved", visaHandler.GetApprovedVisas)
-		admin.GET("/visas/pending", visaHandler.GetPendingVisas)
-		admin.GET("/visas/rejected", visaHandler.GetRejectedVisas)
-		admin.POST("/visas/:id/approve", visaHandler.ApproveVisa)
-		admin.POST("/visas/:id/reject", visaHandler.RejectVisa)
+		admin.GET("/visas", middleware.RequirePermission(models.PermVisaRead), visaHandler.GetAllVisa)
+		admin.GET("/visas/approved", middleware.RequirePermission(models.PermVisaRead), visaHandler.GetApprovedVisas)
+		admin.GET("/visas/pending", middleware.RequirePermission(models.PermVisaRead), visaHandler.GetPendingVisas)
+		admin.GET("/visas/rejected", middleware.RequirePermission(models.PermVisaRead), visaHandler.GetRejectedVisas)
+		admin.POST("/visas/:id/approve", middleware.RequirePermission(models.PermVisaApprove), visaHandler.ApproveVisa)
+		admin.POST("/visas/:id/reject", middleware.RequirePermission(models.PermVisaApprove), visaHandler.RejectVisa)
 
 		// Hotel management
-		admin.POST("/hotels", hotelHandler.CreateHotel)
-		admin.PUT("/hotels/:id", hotelHandler.UpdateHotel)
-		admin.DELETE("/hotels/:id", hotelHandler.DeleteHotel)
+		admin.POST("/hotels", middleware.RequirePermission(models.PermHotelWrite), hotelHandler.CreateHotel)
+		admin.PUT("/hotels/:id", middleware.RequirePermission(models.PermHotelWrite), hotelHandler.UpdateHotel)
+		admin.DELETE("/hotels/:id", middleware.RequirePermission(models.PermHotelWrite), hotelHandler.DeleteHotel)
+
+		// Flight management
+		admin.POST("/flights", middleware.RequirePermission(models.PermFlightWrite), flightHandler.CreateFlight)
+		admin.PUT("/flights/:id", middleware.RequirePermission(models.PermFlightWrite), flightHandler.UpdateFlight)
+		admin.DELETE("/flights/:id", middleware.RequirePermission(models.PermFlightWrite), flightHandler.DeleteFlight)
+		admin.POST("/flights/:id/cancel", middleware.RequirePermission(models.PermFlightWrite), flightHandler.CancelFlightAndNotify)
+		admin.PUT("/flights/:id/seat-map", middleware.RequirePermission(models.PermFlightWrite), middleware.LoadLocalizer(authService, preferencesService), seatHandler.AssignSeatMap)
+		admin.POST("/seat-maps", middleware.RequirePermission(models.PermFlightWrite), seatHandler.CreateSeatMap)
+		admin.GET("/seat-maps", middleware.RequirePermission(models.PermFlightWrite), seatHandler.GetSeatMaps)
+		admin.GET("/seat-maps/:id", middleware.RequirePermission(models.PermFlightWrite), seatHandler.GetSeatMap)
 
 		// Support ticket management
-		admin.GET("/support", supportHandler.GetAllTickets)
+		admin.GET("/support", middleware.RequirePermission(models.PermTicketRead), supportHandler.GetAllTickets)
 	}
 
+	// Public keys for verifying access tokens in other services
+	r.GET("/.well-known/jwks.json", authHandler.JWKS)
+
 	// Health check
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -3,6 +3,7 @@ package handlers
 
 import (
 	"Visa/internal/services"
+	"Visa/middleware"
 	"Visa/models"
 	"errors"
 	"log"
@@ -22,11 +23,13 @@ func NewVisaHandler(visaService *services.VisaService) *VisaHandler {
 }
 
 type CreateVisaRequest struct {
-	VisaType       string `json:"visa_type" binding:"required,min=2,max=50"`
-	Destination    string `json:"destination" binding:"required,min=2,max=100"`
-	TravelDate     string `json:"travel_date" binding:"required"`
-	PassportNumber string `json:"passport_number" binding:"required,min=6,max=20"`
-	Nationality    string `json:"nationality" binding:"required,min=2,max=50"`
+	VisaType    string `json:"visa_type" binding:"required,min=2,max=50"`
+	Destination string `json:"destination" binding:"required,min=2,max=100"`
+	TravelDate  string `json:"travel_date" binding:"required"`
+	// Passport number and nationality can be left out to take them from a saved traveller
+	TravellerID    *uint  `json:"traveller_id"`
+	PassportNumber string `json:"passport_number" binding:"required_without=TravellerID,omitempty,min=6,max=20"`
+	Nationality    string `json:"nationality" binding:"required_without=TravellerID,omitempty,min=2,max=50"`
 }
 
 type UpdateVisaRequest struct {
@@ -53,6 +56,7 @@ func (vh *VisaHandler) CreateVisa(c *gin.Context) {
 	userID := c.GetUint("userId")
 	visa := models.VisaApplication{
 		UserID:         uint(userID),
+		TravellerID:    req.TravellerID,
 		VisaType:       req.VisaType,
 		Destination:    req.Destination,
 		TravelDate:     req.TravelDate,
@@ -62,6 +66,27 @@ func (vh *VisaHandler) CreateVisa(c *gin.Context) {
 	}
 
 	if err := vh.VisaService.CreateVisa(&visa); err != nil {
+		if errors.Is(err, services.ErrTravellerNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_traveller",
+				"message": "Travellers must be saved on your account first",
+			})
+			return
+		}
+		if errors.Is(err, services.ErrPassportExpired) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "passport_expired",
+				"message": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, services.ErrEmailNotVerified) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   "email_not_verified",
+				"message": "Please verify your email address first",
+			})
+			return
+		}
 		log.Printf("Error creating visa: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "server_error",
@@ -106,8 +131,7 @@ func (vh *VisaHandler) GetVisaById(c *gin.Context) {
 	}
 
 	userID := c.GetUint("userId")
-	role := c.GetString("role")
-	if role != "admin" && visa.UserID != userID {
+	if !middleware.HasPermission(c, models.PermVisaRead) && visa.UserID != userID {
 		c.JSON(http.StatusForbidden, gin.H{
 			"error":   "forbidden",
 			"message": "You don't have permission to view this visa",
@@ -131,8 +155,7 @@ func (vh *VisaHandler) GetVisasByUser(c *gin.Context) {
 	}
 
 	currentUserID := c.GetUint("userId")
-	role := c.GetString("role")
-	if role != "admin" && uint(userId) != currentUserID {
+	if !middleware.HasPermission(c, models.PermVisaRead) && uint(userId) != currentUserID {
 		c.JSON(http.StatusForbidden, gin.H{
 			"error":   "forbidden",
 			"message": "You can only view your own visa applications",
@@ -273,8 +296,7 @@ func (vh *VisaHandler) DeleteVisa(c *gin.Context) {
 	}
 
 	userID := c.GetUint("userId")
-	role := c.GetString("role")
-	if role != "admin" && visa.UserID != userID {
+	if !middleware.HasPermission(c, models.PermVisaDelete) && visa.UserID != userID {
 		c.JSON(http.StatusForbidden, gin.H{
 			"error":   "forbidden",
 			"message": "You can only delete your own visa applications",
@@ -0,0 +1,127 @@
+// handlers/api_key_handler.go
+package handlers
+
+import (
+	"Visa/internal/services"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type APIKeyHandler struct {
+	APIKeyService *services.APIKeyService
+}
+
+func NewAPIKeyHandler(apiKeyService *services.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{APIKeyService: apiKeyService}
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required,max=100"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	RateLimit int        `json:"rate_limit" binding:"omitempty,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKey creates an API key for the current user. The key value is
+// only returned in this response.
+func (kh *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	userID := c.GetUint("userId")
+	key, value, err := kh.APIKeyService.CreateAPIKey(userID, req.Name, req.Scopes, req.RateLimit, req.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, services.ErrTooManyAPIKeys) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "conflict",
+				"message": "Revoke an existing API key before creating a new one",
+			})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidScope) || errors.Is(err, services.ErrInvalidAPIKeyOptions) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error creating API key for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to create API key",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "API key created. Store it now, it will not be shown again",
+		"key":     value,
+		"data":    services.NewAPIKeyView(key),
+	})
+}
+
+// GetAPIKeys lists the current user's API keys
+func (kh *APIKeyHandler) GetAPIKeys(c *gin.Context) {
+	userID := c.GetUint("userId")
+	keys, err := kh.APIKeyService.GetAPIKeys(userID)
+	if err != nil {
+		log.Printf("Error fetching API keys of user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to retrieve API keys",
+		})
+		return
+	}
+
+	views := make([]services.APIKeyView, 0, len(keys))
+	for i := range keys {
+		views = append(views, services.NewAPIKeyView(&keys[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data":  views,
+		"count": len(views),
+	})
+}
+
+// RevokeAPIKey revokes one of the current user's API keys
+func (kh *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_id",
+			"message": "API key ID must be a valid number",
+		})
+		return
+	}
+
+	userID := c.GetUint("userId")
+	if err := kh.APIKeyService.RevokeAPIKey(userID, uint(id)); err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   "not_found",
+				"message": "API key not found",
+			})
+			return
+		}
+		log.Printf("Error revoking API key %d of user %d: %v", id, userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to revoke API key",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
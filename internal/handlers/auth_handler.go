@@ -0,0 +1,181 @@
+// handlers/auth_handler.go
+package handlers
+
+import (
+	"Visa/internal/services"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuthHandler struct {
+	AuthService *services.AuthService
+}
+
+func NewAuthHandler(authService *services.AuthService) *AuthHandler {
+	return &AuthHandler{AuthService: authService}
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshToken exchanges a refresh token for a new token pair
+func (ah *AuthHandler) RefreshToken(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Refresh token is required",
+		})
+		return
+	}
+
+	tokens, err := ah.AuthService.Refresh(req.RefreshToken, clientInfo(c))
+	if err != nil {
+		if respondSignInRefused(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrTokenRevoked) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":   "invalid_token",
+				"message": "Refresh token is invalid or expired",
+			})
+			return
+		}
+		log.Printf("Error refreshing token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to refresh token",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_at":    tokens.ExpiresAt,
+	})
+}
+
+// Logout revokes the current access token and the given refresh token
+func (ah *AuthHandler) Logout(c *gin.Context) {
+	var req LogoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	claims, ok := c.MustGet("claims").(*services.Claims)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":   "unauthorized",
+			"message": "Missing token claims",
+		})
+		return
+	}
+
+	if err := ah.AuthService.Logout(claims, req.RefreshToken); err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_token",
+				"message": "Refresh token is invalid",
+			})
+			return
+		}
+		log.Printf("Error logging out user %d: %v", claims.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to log out",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// GetSessions lists the current user's active sessions
+func (ah *AuthHandler) GetSessions(c *gin.Context) {
+	claims := c.MustGet("claims").(*services.Claims)
+
+	sessions, err := ah.AuthService.GetSessions(claims.UserID, claims.SessionID)
+	if err != nil {
+		log.Printf("Error fetching sessions of user %d: %v", claims.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to retrieve sessions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  sessions,
+		"count": len(sessions),
+	})
+}
+
+// EndSession signs the current user out of one of their sessions
+func (ah *AuthHandler) EndSession(c *gin.Context) {
+	claims := c.MustGet("claims").(*services.Claims)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_id",
+			"message": "Session ID must be a valid number",
+		})
+		return
+	}
+
+	if err := ah.AuthService.EndSession(claims.UserID, uint(id)); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   "not_found",
+				"message": "Session not found",
+			})
+			return
+		}
+		log.Printf("Error ending session %d of user %d: %v", id, claims.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to end session",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session ended successfully"})
+}
+
+// EndOtherSessions signs the current user out of every other session
+func (ah *AuthHandler) EndOtherSessions(c *gin.Context) {
+	claims := c.MustGet("claims").(*services.Claims)
+
+	if err := ah.AuthService.EndOtherSessions(claims.UserID, claims.SessionID); err != nil {
+		log.Printf("Error ending sessions of user %d: %v", claims.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to end sessions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Signed out of all other sessions"})
+}
+
+// JWKS publishes the public keys used to verify access tokens
+func (ah *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, ah.AuthService.JWKS())
+}
@@ -3,8 +3,12 @@ package handlers
 
 import (
 	"Visa/internal/services"
+	"Visa/middleware"
+	"Visa/models"
 	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 
@@ -20,9 +24,90 @@ func NewFlightHandler(flightService *services.FlightService) *FlightHandler {
 	return &FlightHandler{FlightService: flightService}
 }
 
+// BookFlightRequest books a flight at the fare named by fare_id, or at the
+// cheapest fare left in the cabin and fare family given
 type BookFlightRequest struct {
-	UserID   uint `json:"userId" binding:"required"`
-	FlightID uint `json:"flight_id" binding:"required"`
+	UserID       uint   `json:"userId" binding:"required"`
+	FlightID     uint   `json:"flight_id" binding:"required"`
+	FareID       uint   `json:"fare_id"`
+	Cabin        string `json:"cabin" binding:"omitempty,oneof=economy premium_economy business first"`
+	FareFamily   string `json:"fare_family" binding:"max=20"`
+	TravellerIDs []uint `json:"traveller_ids" binding:"omitempty,max=9"`
+}
+
+// FlightRequest describes a flight. Departure and arrival are local times at
+// the airports, such as "2026-11-02T09:30", in their IANA time zones. Without
+// fares the flight is sold at a single standard fare for price, holding every
+// seat of capacity.
+type FlightRequest struct {
+	FlightNumber      string        `json:"flight_number" binding:"required,max=8"`
+	Airline           string        `json:"airline" binding:"required,max=100"`
+	Origin            string        `json:"origin" binding:"required,len=3"`
+	Destination       string        `json:"destination" binding:"required,len=3"`
+	City              string        `json:"city" binding:"required,max=100"`
+	Departure         string        `json:"departure" binding:"required"`
+	DepartureTimeZone string        `json:"departure_time_zone" binding:"required"`
+	Arrival           string        `json:"arrival" binding:"required"`
+	ArrivalTimeZone   string        `json:"arrival_time_zone" binding:"required"`
+	Aircraft          string        `json:"aircraft" binding:"max=50"`
+	CabinClass        string        `json:"cabin_class" binding:"omitempty,oneof=economy premium_economy business first"`
+	Stops             int           `json:"stops" binding:"gte=0"`
+	Price             float64       `json:"price" binding:"required_without=Fares,gte=0"`
+	Capacity          int           `json:"capacity" binding:"required_without=Fares,gte=0"`
+	Fares             []FareRequest `json:"fares" binding:"omitempty,max=12,dive"`
+}
+
+// FareRequest describes a fare of a flight. Baggage is per passenger.
+type FareRequest struct {
+	Cabin        string  `json:"cabin" binding:"required,oneof=economy premium_economy business first"`
+	FareFamily   string  `json:"fare_family" binding:"required,max=20"`
+	Price        float64 `json:"price" binding:"required,gt=0"`
+	Seats        int     `json:"seats" binding:"required,gt=0"`
+	Refundable   bool    `json:"refundable"`
+	Changeable   bool    `json:"changeable"`
+	ChangeFee    float64 `json:"change_fee" binding:"gte=0"`
+	CabinBags    int     `json:"cabin_bags" binding:"gte=0,lte=3"`
+	CheckedBags  int     `json:"checked_bags" binding:"gte=0,lte=5"`
+	CheckedBagKg int     `json:"checked_bag_kg" binding:"gte=0,lte=50"`
+}
+
+func (req *FlightRequest) toDetails() services.FlightDetails {
+	details := services.FlightDetails{
+		FlightNumber:      req.FlightNumber,
+		Airline:           req.Airline,
+		Origin:            req.Origin,
+		Destination:       req.Destination,
+		City:              req.City,
+		Departure:         req.Departure,
+		DepartureTimeZone: req.DepartureTimeZone,
+		Arrival:           req.Arrival,
+		ArrivalTimeZone:   req.ArrivalTimeZone,
+		Aircraft:          req.Aircraft,
+		CabinClass:        req.CabinClass,
+		Stops:             req.Stops,
+		Price:             req.Price,
+		Capacity:          req.Capacity,
+		Fares:             make([]services.FareDetails, len(req.Fares)),
+	}
+	for i, fare := range req.Fares {
+		details.Fares[i] = services.FareDetails{
+			Cabin:        fare.Cabin,
+			FareFamily:   fare.FareFamily,
+			Price:        fare.Price,
+			Seats:        fare.Seats,
+			Refundable:   fare.Refundable,
+			Changeable:   fare.Changeable,
+			ChangeFee:    fare.ChangeFee,
+			CabinBags:    fare.CabinBags,
+			CheckedBags:  fare.CheckedBags,
+			CheckedBagKg: fare.CheckedBagKg,
+		}
+	}
+	return details
+}
+
+type CancelFlightRequest struct {
+	Reason string `json:"reason" binding:"max=500"`
 }
 
 // BookFlight books a flight for a user
@@ -46,7 +131,36 @@ func (fh *FlightHandler) BookFlight(c *gin.Context) {
 		return
 	}
 
-	if err := fh.FlightService.BookFlight(req.UserID, req.FlightID); err != nil {
+	fare := services.FareChoice{FareID: req.FareID, Cabin: req.Cabin, FareFamily: req.FareFamily}
+	if err := fh.FlightService.BookFlight(req.UserID, req.FlightID, fare, req.TravellerIDs); err != nil {
+		if errors.Is(err, services.ErrInvalidFare) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_fare",
+				"message": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, services.ErrTravellerNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_traveller",
+				"message": "Travellers must be saved on your account first",
+			})
+			return
+		}
+		if respondBookingConflict(c, err) {
+			return
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondFlightNotFound(c)
+			return
+		}
+		if errors.Is(err, services.ErrEmailNotVerified) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   "email_not_verified",
+				"message": "Please verify your email address first",
+			})
+			return
+		}
 		log.Printf("Error booking flight %d for user %d: %v", req.FlightID, req.UserID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "server_error",
@@ -80,6 +194,9 @@ func (fh *FlightHandler) CancelFlight(c *gin.Context) {
 	}
 
 	if err := fh.FlightService.CancelFlight(req.UserID, req.FlightID); err != nil {
+		if respondBookingConflict(c, err) {
+			return
+		}
 		log.Printf("Error cancelling flight %d for user %d: %v", req.FlightID, req.UserID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "server_error",
@@ -91,21 +208,58 @@ func (fh *FlightHandler) CancelFlight(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Flight cancelled successfully"})
 }
 
-// GetFlights retrieves all flights with pagination
-func (fh *FlightHandler) GetFlights(c *gin.Context) {
+// SearchFlights lists upcoming flights page by page, filtered by origin,
+// destination, date_from/date_to, passengers, cabin, max_stops and
+// min_price/max_price in the caller's currency, and sorted by price,
+// duration or departure
+func (fh *FlightHandler) SearchFlights(c *gin.Context) {
+	localizer := middleware.GetLocalizer(c)
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 
-	if page < 1 {
-		page = 1
+	search := services.FlightSearch{
+		Origin:      c.Query("origin"),
+		Destination: c.Query("destination"),
+		DateFrom:    c.Query("date_from"),
+		DateTo:      c.Query("date_to"),
+		CabinClass:  c.Query("cabin"),
+		Sort:        c.Query("sort"),
+		Order:       c.Query("order"),
+		Page:        page,
+		Limit:       limit,
+	}
+	var ok bool
+	if search.Passengers, ok = intQuery(c, "passengers"); !ok {
+		return
+	}
+	if c.Query("max_stops") != "" {
+		maxStops, ok := intQuery(c, "max_stops")
+		if !ok {
+			return
+		}
+		search.MaxStops = &maxStops
+	}
+	minPrice, ok := floatQuery(c, "min_price")
+	if !ok {
+		return
 	}
-	if limit < 1 || limit > 100 {
-		limit = 20
+	maxPrice, ok := floatQuery(c, "max_price")
+	if !ok {
+		return
 	}
+	search.MinPrice = localizer.ToBase(minPrice)
+	search.MaxPrice = localizer.ToBase(maxPrice)
 
-	flights, err := fh.FlightService.GetFlights()
+	result, err := fh.FlightService.SearchFlights(search)
 	if err != nil {
-		log.Printf("Error fetching flights: %v", err)
+		if errors.Is(err, services.ErrInvalidFlightSearch) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error searching flights: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "server_error",
 			"message": "Unable to retrieve flights",
@@ -113,20 +267,11 @@ func (fh *FlightHandler) GetFlights(c *gin.Context) {
 		return
 	}
 
-	start := (page - 1) * limit
-	end := start + limit
-	if start > len(flights) {
-		start = len(flights)
-	}
-	if end > len(flights) {
-		end = len(flights)
-	}
-
 	c.JSON(http.StatusOK, gin.H{
-		"data":  flights[start:end],
-		"page":  page,
-		"limit": limit,
-		"total": len(flights),
+		"data":  services.NewFlightViews(result.Flights, localizer),
+		"page":  result.Page,
+		"limit": result.Limit,
+		"total": result.Total,
 	})
 }
 
@@ -159,7 +304,7 @@ func (fh *FlightHandler) GetFlightById(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": flight})
+	c.JSON(http.StatusOK, gin.H{"data": services.NewFlightView(flight, middleware.GetLocalizer(c))})
 }
 
 // GetFlightsByCity retrieves flights by city
@@ -184,7 +329,7 @@ func (fh *FlightHandler) GetFlightsByCity(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"data":  flights,
+		"data":  services.NewFlightViews(flights, middleware.GetLocalizer(c)),
 		"count": len(flights),
 	})
 }
@@ -202,6 +347,13 @@ func (fh *FlightHandler) GetFlightsByDepartDate(c *gin.Context) {
 
 	flights, err := fh.FlightService.GetFlightsByDepartDate(date)
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidFlightSearch) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": err.Error(),
+			})
+			return
+		}
 		log.Printf("Error fetching flights by date %s: %v", date, err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "server_error",
@@ -211,7 +363,7 @@ func (fh *FlightHandler) GetFlightsByDepartDate(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"data":  flights,
+		"data":  services.NewFlightViews(flights, middleware.GetLocalizer(c)),
 		"count": len(flights),
 	})
 }
@@ -229,8 +381,7 @@ func (fh *FlightHandler) GetFlightsByUser(c *gin.Context) {
 	}
 
 	currentUserID := c.GetUint("userId")
-	role := c.GetString("role")
-	if role != "admin" && uint(userId) != currentUserID {
+	if !middleware.HasPermission(c, models.PermBookingRead) && uint(userId) != currentUserID {
 		c.JSON(http.StatusForbidden, gin.H{
 			"error":   "forbidden",
 			"message": "You can only view your own flight bookings",
@@ -249,7 +400,257 @@ func (fh *FlightHandler) GetFlightsByUser(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"data":  flights,
+		"data":  services.NewFlightViews(flights, middleware.GetLocalizer(c)),
 		"count": len(flights),
 	})
 }
+
+// CreateFlight creates a new flight (admin only)
+func (fh *FlightHandler) CreateFlight(c *gin.Context) {
+	var req FlightRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	flight, err := fh.FlightService.CreateFlight(req.toDetails())
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidFlight) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error creating flight: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to create flight",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Flight created successfully",
+		"data":    flight,
+	})
+}
+
+// UpdateFlight replaces the details of a flight (admin only)
+func (fh *FlightHandler) UpdateFlight(c *gin.Context) {
+	id, ok := flightIdParam(c)
+	if !ok {
+		return
+	}
+
+	var req FlightRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	flight, err := fh.FlightService.UpdateFlight(id, req.toDetails())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondFlightNotFound(c)
+			return
+		}
+		if errors.Is(err, services.ErrInvalidFlight) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, services.ErrFlightCancelled) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "flight_cancelled",
+				"message": "Cancelled flights cannot be changed",
+			})
+			return
+		}
+		log.Printf("Error updating flight %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to update flight",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Flight updated successfully",
+		"data":    flight,
+	})
+}
+
+// DeleteFlight deletes a flight without active bookings (admin only)
+func (fh *FlightHandler) DeleteFlight(c *gin.Context) {
+	id, ok := flightIdParam(c)
+	if !ok {
+		return
+	}
+
+	if err := fh.FlightService.DeleteFlight(id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondFlightNotFound(c)
+			return
+		}
+		if errors.Is(err, services.ErrFlightHasReservations) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "flight_has_bookings",
+				"message": "This flight has active bookings. Cancel it instead to cancel the bookings and notify the passengers.",
+				"cancel":  fmt.Sprintf("/api/v1/admin/flights/%d/cancel", id),
+			})
+			return
+		}
+		log.Printf("Error deleting flight %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to delete flight",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Flight deleted successfully"})
+}
+
+// CancelFlightAndNotify cancels a flight with all of its bookings and emails
+// the passengers (admin only)
+func (fh *FlightHandler) CancelFlightAndNotify(c *gin.Context) {
+	id, ok := flightIdParam(c)
+	if !ok {
+		return
+	}
+
+	var req CancelFlightRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": "Please check your input data",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+
+	cancellation, err := fh.FlightService.CancelFlightAndNotify(id, req.Reason)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondFlightNotFound(c)
+			return
+		}
+		if errors.Is(err, services.ErrFlightCancelled) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "flight_cancelled",
+				"message": "This flight has already been cancelled",
+			})
+			return
+		}
+		log.Printf("Error cancelling flight %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to cancel flight",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Flight cancelled and passengers notified",
+		"data":    cancellation,
+	})
+}
+
+// respondBookingConflict answers 409 if err means that the flights cannot be
+// booked because they were cancelled, have left, are full or are already
+// booked, and reports whether it did
+func respondBookingConflict(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrFlightCancelled):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "flight_cancelled",
+			"message": "This flight has been cancelled",
+		})
+	case errors.Is(err, services.ErrFlightDeparted):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "flight_departed",
+			"message": err.Error(),
+		})
+	case errors.Is(err, services.ErrNotEnoughSeats):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "not_enough_seats",
+			"message": err.Error(),
+		})
+	case errors.Is(err, services.ErrAlreadyBooked):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "already_booked",
+			"message": err.Error(),
+		})
+	default:
+		return false
+	}
+	return true
+}
+
+func flightIdParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_id",
+			"message": "Flight ID must be a valid number",
+		})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondFlightNotFound(c *gin.Context) {
+	c.JSON(http.StatusNotFound, gin.H{
+		"error":   "not_found",
+		"message": "Flight not found",
+	})
+}
+
+// intQuery reads an optional whole number query parameter, responding with
+// 400 when it is not one
+func intQuery(c *gin.Context, name string) (int, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": fmt.Sprintf("%s must be a whole number", name),
+		})
+		return 0, false
+	}
+	return n, true
+}
+
+// floatQuery reads an optional numeric query parameter, responding with 400
+// when it is not a number
+func floatQuery(c *gin.Context, name string) (float64, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, true
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": fmt.Sprintf("%s must be a number", name),
+		})
+		return 0, false
+	}
+	return n, true
+}
@@ -3,9 +3,11 @@ package handlers
 
 import (
 	"Visa/internal/services"
+	"Visa/middleware"
 	"Visa/models"
 	"errors"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 
@@ -24,8 +26,11 @@ func NewUserHandler(userService *services.UserService) *UserHandler {
 type CreateUserRequest struct {
 	Name     string `json:"name" binding:"required,min=2,max=100"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=8,max=72"`
-	Role     string `json:"role" binding:"omitempty,oneof=user admin"`
+	Password string `json:"password" binding:"required,min=8,max=256"`
+}
+
+type ChangeRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=user admin support_agent visa_officer hotel_partner finance"`
 }
 
 type LoginRequest struct {
@@ -33,10 +38,37 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=8,max=256"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type MagicLinkLoginRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
 type UpdateUserRequest struct {
-	Name     string `json:"name" binding:"omitempty,min=2,max=100"`
-	Email    string `json:"email" binding:"omitempty,email"`
-	Password string `json:"password" binding:"omitempty,min=8,max=72"`
+	Name  string `json:"name" binding:"omitempty,min=2,max=100"`
+	Email string `json:"email" binding:"omitempty,email"`
+	// Password is refused; passwords are changed with ChangePassword
+	Password string `json:"password"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=8,max=256"`
 }
 
 // CreateUser creates a new user account
@@ -51,18 +83,21 @@ func (uh *UserHandler) CreateUser(c *gin.Context) {
 		return
 	}
 
-	if req.Role == "" {
-		req.Role = "user"
-	}
-
+	// The role is always "user" for signups; staff accounts are invited
 	user := models.User{
 		Name:     req.Name,
 		Email:    req.Email,
 		Password: req.Password,
-		Role:     req.Role,
 	}
 
 	if err := uh.UserService.CreateUser(&user); err != nil {
+		if errors.Is(err, services.ErrWeakPassword) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "weak_password",
+				"message": err.Error(),
+			})
+			return
+		}
 		log.Printf("Error creating user: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "server_error",
@@ -93,8 +128,11 @@ func (uh *UserHandler) LoginUser(c *gin.Context) {
 		return
 	}
 
-	user, token, err := uh.UserService.Login(req.Email, req.Password)
+	result, err := uh.UserService.Login(req.Email, req.Password, clientInfo(c))
 	if err != nil {
+		if respondLockout(c, err) || respondSignInRefused(c, err) {
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error":   "authentication_failed",
 			"message": "Invalid email or password",
@@ -102,16 +140,321 @@ func (uh *UserHandler) LoginUser(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	writeLoginResult(c, result)
+}
+
+// writeLoginResult answers a successful first login step. Users with
+// two-factor authentication finish at /login/2fa.
+func writeLoginResult(c *gin.Context, result *services.LoginResult) {
+	if result.Tokens == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message":             "Two-factor authentication required",
+			"two_factor_required": true,
+			"challenge_token":     result.ChallengeToken,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, loginResponse(result.User, result.Tokens))
+}
+
+// loginResponse builds the response body for a successful login
+func loginResponse(user *models.User, tokens *services.TokenPair) gin.H {
+	return gin.H{
 		"message": "Login successful",
 		"user": gin.H{
-			"id":    user.ID,
-			"name":  user.Name,
-			"email": user.Email,
-			"role":  user.Role,
+			"id":                 user.ID,
+			"name":               user.Name,
+			"email":              user.Email,
+			"role":               user.Role,
+			"email_verified":     user.EmailVerifiedAt != nil,
+			"two_factor_enabled": user.TwoFactorEnabled,
 		},
-		"token": token,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_at":    tokens.ExpiresAt,
+	}
+}
+
+// clientInfo describes the client that sent the request
+func clientInfo(c *gin.Context) services.ClientInfo {
+	return services.ClientInfo{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// respondLockout answers 429 with a Retry-After header if err is a
+// sign-in lockout and reports whether it did
+func respondLockout(c *gin.Context, err error) bool {
+	var lockout *services.LockoutError
+	if !errors.As(err, &lockout) {
+		return false
+	}
+
+	code := "too_many_attempts"
+	message := "Too many failed sign-ins, please try again later"
+	if errors.Is(err, services.ErrAccountLocked) {
+		code = "account_locked"
+		message = "This account is temporarily locked after too many failed sign-ins"
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(lockout.RetryAfter.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       code,
+		"message":     message,
+		"retry_after": int(math.Ceil(lockout.RetryAfter.Seconds())),
 	})
+	return true
+}
+
+// respondSignInRefused answers 403 if err means that the credentials were
+// right but the user may not sign in, and reports whether it did
+func respondSignInRefused(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrAccountSuspended):
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "account_suspended",
+			"message": "This account has been suspended, please contact support",
+		})
+	case errors.Is(err, services.ErrPasswordResetRequired):
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "password_reset_required",
+			"message": "Please reset your password using the link we emailed you",
+		})
+	default:
+		return false
+	}
+	return true
+}
+
+// respondStaffAccount answers 403 if err means that a staff account was
+// changed without role:manage, and reports whether it did
+func respondStaffAccount(c *gin.Context, err error) bool {
+	if !errors.Is(err, services.ErrStaffAccount) {
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":   "forbidden",
+		"message": err.Error(),
+	})
+	return true
+}
+
+// ForgotPassword sends a password reset link to the given email
+func (uh *UserHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please provide a valid email",
+		})
+		return
+	}
+
+	// Failures only happen for registered emails, so they are not revealed
+	if err := uh.UserService.ForgotPassword(req.Email); err != nil {
+		log.Printf("Error sending password reset for %s: %v", req.Email, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "If the email is registered, a password reset link has been sent",
+	})
+}
+
+// RequestMagicLink emails a passwordless sign-in link to the given email
+func (uh *UserHandler) RequestMagicLink(c *gin.Context) {
+	var req MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please provide a valid email",
+		})
+		return
+	}
+
+	// Failures only happen for registered emails, so they are not revealed
+	if err := uh.UserService.RequestMagicLink(req.Email); err != nil {
+		log.Printf("Error sending magic link to %s: %v", req.Email, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "If the email is registered, a sign-in link has been sent",
+	})
+}
+
+// LoginWithMagicLink signs a user in with the token from a sign-in link
+func (uh *UserHandler) LoginWithMagicLink(c *gin.Context) {
+	var req MagicLinkLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Token is required",
+		})
+		return
+	}
+
+	result, err := uh.UserService.LoginWithMagicLink(req.Token, clientInfo(c))
+	if err != nil {
+		if respondLockout(c, err) || respondSignInRefused(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrInvalidUserToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":   "invalid_token",
+				"message": "Sign-in link is invalid or expired",
+			})
+			return
+		}
+		log.Printf("Error signing in with magic link: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to sign in",
+		})
+		return
+	}
+
+	writeLoginResult(c, result)
+}
+
+// ResetPassword sets a new password using a reset token
+func (uh *UserHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := uh.UserService.ResetPassword(req.Token, req.NewPassword); err != nil {
+		if errors.Is(err, services.ErrWeakPassword) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "weak_password",
+				"message": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidUserToken) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_token",
+				"message": "Reset link is invalid or expired",
+			})
+			return
+		}
+		log.Printf("Error resetting password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to reset password",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
+
+// ChangePassword changes the current user's password. Every other session is
+// signed out and the user's API keys are revoked.
+func (uh *UserHandler) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	claims := c.MustGet("claims").(*services.Claims)
+	if err := uh.UserService.ChangePassword(claims.UserID, claims.SessionID, req.CurrentPassword, req.NewPassword, clientInfo(c)); err != nil {
+		if respondLockout(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrWeakPassword) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "weak_password",
+				"message": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, services.ErrIncorrectPassword) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":   "incorrect_password",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error changing password of user %d: %v", claims.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to change password",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+}
+
+// VerifyEmail confirms a user's email address with a verification token
+func (uh *UserHandler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Verification token is required",
+		})
+		return
+	}
+
+	if err := uh.UserService.VerifyEmail(req.Token); err != nil {
+		if errors.Is(err, services.ErrInvalidUserToken) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_token",
+				"message": "Verification link is invalid or expired",
+			})
+			return
+		}
+		log.Printf("Error verifying email: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to verify email",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerification sends a new verification email to the current user
+func (uh *UserHandler) ResendVerification(c *gin.Context) {
+	userID := c.GetUint("userId")
+
+	if err := uh.UserService.ResendVerificationEmail(userID); err != nil {
+		if errors.Is(err, services.ErrEmailAlreadyVerified) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "already_verified",
+				"message": "Your email address is already verified",
+			})
+			return
+		}
+		if errors.Is(err, services.ErrTooManyRequests) {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":   "too_many_requests",
+				"message": "Please wait before requesting another verification email",
+			})
+			return
+		}
+		log.Printf("Error resending verification email for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to send verification email",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
 }
 
 // GetUserById retrieves a user by ID
@@ -127,8 +470,7 @@ func (uh *UserHandler) GetUserById(c *gin.Context) {
 	}
 
 	currentUserID := c.GetUint("userId")
-	role := c.GetString("role")
-	if role != "admin" && uint(id) != currentUserID {
+	if !middleware.HasPermission(c, models.PermUserRead) && uint(id) != currentUserID {
 		c.JSON(http.StatusForbidden, gin.H{
 			"error":   "forbidden",
 			"message": "You can only view your own profile",
@@ -176,8 +518,7 @@ func (uh *UserHandler) UpdateUser(c *gin.Context) {
 	}
 
 	currentUserID := c.GetUint("userId")
-	role := c.GetString("role")
-	if role != "admin" && uint(id) != currentUserID {
+	if !middleware.HasPermission(c, models.PermUserManage) && uint(id) != currentUserID {
 		c.JSON(http.StatusForbidden, gin.H{
 			"error":   "forbidden",
 			"message": "You can only update your own profile",
@@ -212,17 +553,34 @@ func (uh *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	if req.Password != "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Passwords are changed at /auth/change-password with the current password",
+		})
+		return
+	}
+
 	if req.Name != "" {
 		user.Name = req.Name
 	}
 	if req.Email != "" {
 		user.Email = req.Email
 	}
-	if req.Password != "" {
-		user.Password = req.Password
-	}
 
-	if err := uh.UserService.UpdateUser(user); err != nil {
+	// Changing the email signs the user out everywhere else; staff changing
+	// someone else's account end all of that user's sessions. Other staff
+	// accounts also take role:manage.
+	var currentSessionId uint
+	canManageStaff := middleware.HasPermission(c, models.PermRoleManage)
+	if uint(id) == currentUserID {
+		currentSessionId = c.MustGet("claims").(*services.Claims).SessionID
+		canManageStaff = true
+	}
+	if err := uh.UserService.UpdateUser(user, currentSessionId, canManageStaff); err != nil {
+		if respondStaffAccount(c, err) {
+			return
+		}
 		log.Printf("Error updating user %d: %v", id, err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "server_error",
@@ -242,8 +600,8 @@ func (uh *UserHandler) UpdateUser(c *gin.Context) {
 	})
 }
 
-// DeleteUser deletes a user account
-func (uh *UserHandler) DeleteUser(c *gin.Context) {
+// ChangeRole promotes or demotes a user (admin only)
+func (uh *UserHandler) ChangeRole(c *gin.Context) {
 	idParam := c.Param("id")
 	id, err := strconv.ParseUint(idParam, 10, 64)
 	if err != nil {
@@ -254,17 +612,18 @@ func (uh *UserHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
-	currentUserID := c.GetUint("userId")
-	role := c.GetString("role")
-	if role != "admin" && uint(id) != currentUserID {
-		c.JSON(http.StatusForbidden, gin.H{
-			"error":   "forbidden",
-			"message": "You can only delete your own account",
+	var req ChangeRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
 		})
 		return
 	}
 
-	if err := uh.UserService.DeleteUser(uint(id)); err != nil {
+	user, err := uh.UserService.ChangeRole(c.GetUint("userId"), uint(id), req.Role)
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{
 				"error":   "not_found",
@@ -272,63 +631,79 @@ func (uh *UserHandler) DeleteUser(c *gin.Context) {
 			})
 			return
 		}
-		log.Printf("Error deleting user %d: %v", id, err)
+		if errors.Is(err, services.ErrLastAdmin) || errors.Is(err, services.ErrInvalidRole) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error changing role of user %d: %v", id, err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "server_error",
-			"message": "Unable to delete user",
+			"message": "Unable to change role",
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Role updated successfully",
+		"data": gin.H{
+			"id":    user.ID,
+			"name":  user.Name,
+			"email": user.Email,
+			"role":  user.Role,
+		},
+	})
 }
 
-// GetAllUsers retrieves all users with pagination (admin only)
-func (uh *UserHandler) GetAllUsers(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
-
-	if page < 1 {
-		page = 1
-	}
-	if limit < 1 || limit > 100 {
-		limit = 20
-	}
-
-	users, err := uh.UserService.GetAllUsers()
+// GetRecentSignIns lists the latest sign-in attempts on the current user's account
+func (uh *UserHandler) GetRecentSignIns(c *gin.Context) {
+	events, err := uh.UserService.GetRecentSignIns(c.GetUint("userId"))
 	if err != nil {
-		log.Printf("Error fetching all users: %v", err)
+		log.Printf("Error fetching sign-ins of user %d: %v", c.GetUint("userId"), err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "server_error",
-			"message": "Unable to retrieve users",
+			"message": "Unable to retrieve sign-ins",
 		})
 		return
 	}
 
-	start := (page - 1) * limit
-	end := start + limit
-	if start > len(users) {
-		start = len(users)
-	}
-	if end > len(users) {
-		end = len(users)
+	c.JSON(http.StatusOK, gin.H{
+		"data":  events,
+		"count": len(events),
+	})
+}
+
+// UnlockUser lifts a lockout caused by failed sign-ins (admin only)
+func (uh *UserHandler) UnlockUser(c *gin.Context) {
+	idParam := c.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_id",
+			"message": "User ID must be a valid number",
+		})
+		return
 	}
 
-	sanitizedUsers := make([]gin.H, len(users[start:end]))
-	for i, user := range users[start:end] {
-		sanitizedUsers[i] = gin.H{
-			"id":    user.ID,
-			"name":  user.Name,
-			"email": user.Email,
-			"role":  user.Role,
+	if err := uh.UserService.UnlockUser(uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   "not_found",
+				"message": "User not found",
+			})
+			return
 		}
+		log.Printf("Error unlocking user %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to unlock user",
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"data":  sanitizedUsers,
-		"page":  page,
-		"limit": limit,
-		"total": len(users),
+		"message": "User unlocked successfully",
 	})
 }
-
@@ -3,6 +3,7 @@ package handlers
 
 import (
 	"Visa/internal/services"
+	"Visa/middleware"
 	"Visa/models"
 	"errors"
 	"log"
@@ -33,8 +34,9 @@ type CreateHotelRequest struct {
 }
 
 type BookHotelRequest struct {
-	UserID  uint `json:"userId" binding:"required"`
-	HotelID uint `json:"hotel_id" binding:"required"`
+	UserID       uint   `json:"userId" binding:"required"`
+	HotelID      uint   `json:"hotel_id" binding:"required"`
+	TravellerIDs []uint `json:"traveller_ids" binding:"omitempty,max=9"`
 }
 
 // CreateHotel creates a new hotel (admin only)
@@ -184,7 +186,7 @@ func (hh *HotelHandler) GetAllHotels(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"data":  hotels[start:end],
+		"data":  services.NewHotelViews(hotels[start:end], middleware.GetLocalizer(c)),
 		"page":  page,
 		"limit": limit,
 		"total": len(hotels),
@@ -220,7 +222,7 @@ func (hh *HotelHandler) GetHotelById(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": hotel})
+	c.JSON(http.StatusOK, gin.H{"data": services.NewHotelView(hotel, middleware.GetLocalizer(c))})
 }
 
 // GetHotelsByCity retrieves hotels by city
@@ -245,7 +247,7 @@ func (hh *HotelHandler) GetHotelsByCity(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"data":  hotels,
+		"data":  services.NewHotelViews(hotels, middleware.GetLocalizer(c)),
 		"count": len(hotels),
 	})
 }
@@ -272,7 +274,7 @@ func (hh *HotelHandler) GetHotelsByCheckInDate(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"data":  hotels,
+		"data":  services.NewHotelViews(hotels, middleware.GetLocalizer(c)),
 		"count": len(hotels),
 	})
 }
@@ -299,7 +301,7 @@ func (hh *HotelHandler) GetHotelsByCheckOutDate(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"data":  hotels,
+		"data":  services.NewHotelViews(hotels, middleware.GetLocalizer(c)),
 		"count": len(hotels),
 	})
 }
@@ -320,7 +322,7 @@ func (hh *HotelHandler) GetHotelsByFreeCancellation(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"data":  hotels,
+		"data":  services.NewHotelViews(hotels, middleware.GetLocalizer(c)),
 		"count": len(hotels),
 	})
 }
@@ -346,7 +348,21 @@ func (hh *HotelHandler) BookHotel(c *gin.Context) {
 		return
 	}
 
-	if err := hh.HotelService.BookHotel(req.UserID, req.HotelID); err != nil {
+	if err := hh.HotelService.BookHotel(req.UserID, req.HotelID, req.TravellerIDs); err != nil {
+		if errors.Is(err, services.ErrTravellerNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_traveller",
+				"message": "Travellers must be saved on your account first",
+			})
+			return
+		}
+		if errors.Is(err, services.ErrEmailNotVerified) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   "email_not_verified",
+				"message": "Please verify your email address first",
+			})
+			return
+		}
 		log.Printf("Error booking hotel %d for user %d: %v", req.HotelID, req.UserID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "server_error",
@@ -404,8 +420,7 @@ func (hh *HotelHandler) GetHotelsByUser(c *gin.Context) {
 	}
 
 	currentUserID := c.GetUint("userId")
-	role := c.GetString("role")
-	if role != "admin" && uint(userId) != currentUserID {
+	if !middleware.HasPermission(c, models.PermBookingRead) && uint(userId) != currentUserID {
 		c.JSON(http.StatusForbidden, gin.H{
 			"error":   "forbidden",
 			"message": "You can only view your own hotel bookings",
@@ -424,7 +439,7 @@ func (hh *HotelHandler) GetHotelsByUser(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"data":  hotels,
+		"data":  services.NewHotelViews(hotels, middleware.GetLocalizer(c)),
 		"count": len(hotels),
 	})
 }
@@ -0,0 +1,112 @@
+// handlers/impersonation_handler.go
+package handlers
+
+import (
+	"Visa/internal/services"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ImpersonationHandler struct {
+	ImpersonationService *services.ImpersonationService
+}
+
+func NewImpersonationHandler(impersonationService *services.ImpersonationService) *ImpersonationHandler {
+	return &ImpersonationHandler{ImpersonationService: impersonationService}
+}
+
+type StartImpersonationRequest struct {
+	Reason string `json:"reason" binding:"required,max=255"`
+}
+
+// StartImpersonation issues a short-lived token for acting as a user
+func (ih *ImpersonationHandler) StartImpersonation(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_id",
+			"message": "User ID must be a valid number",
+		})
+		return
+	}
+
+	var req StartImpersonationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "A reason for impersonating the user is required",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	token, err := ih.ImpersonationService.Start(c.GetUint("userId"), uint(id), req.Reason, clientInfo(c))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   "not_found",
+				"message": "User not found",
+			})
+			return
+		}
+		if errors.Is(err, services.ErrCannotImpersonate) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   "forbidden",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error starting impersonation of user %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to impersonate user",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Impersonation started. Every request made with this token is audited",
+		"data":    token,
+	})
+}
+
+// GetAuditLogs lists audit log entries, filtered by the optional actor_id
+// and user_id query parameters
+func (ih *ImpersonationHandler) GetAuditLogs(c *gin.Context) {
+	var filters [3]uint64
+	for i, name := range []string{"actor_id", "user_id", "limit"} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": name + " must be a valid number",
+			})
+			return
+		}
+		filters[i] = n
+	}
+
+	entries, err := ih.ImpersonationService.GetAuditLogs(uint(filters[0]), uint(filters[1]), int(filters[2]))
+	if err != nil {
+		log.Printf("Error fetching audit log: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to retrieve audit log",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  entries,
+		"count": len(entries),
+	})
+}
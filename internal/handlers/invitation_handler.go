@@ -0,0 +1,170 @@
+// handlers/invitation_handler.go
+package handlers
+
+import (
+	"Visa/internal/services"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type InvitationHandler struct {
+	InvitationService *services.InvitationService
+}
+
+func NewInvitationHandler(invitationService *services.InvitationService) *InvitationHandler {
+	return &InvitationHandler{InvitationService: invitationService}
+}
+
+type CreateInvitationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required,oneof=user admin support_agent visa_officer hotel_partner finance"`
+}
+
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Name     string `json:"name" binding:"required,min=2,max=100"`
+	Password string `json:"password" binding:"required,min=8,max=256"`
+}
+
+// CreateInvitation invites a new staff member by email (admin only)
+func (ih *InvitationHandler) CreateInvitation(c *gin.Context) {
+	var req CreateInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	invitation, err := ih.InvitationService.Invite(c.GetUint("userId"), req.Email, req.Role)
+	if err != nil {
+		if errors.Is(err, services.ErrEmailAlreadyRegistered) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "conflict",
+				"message": "A user with this email already exists",
+			})
+			return
+		}
+		log.Printf("Error inviting %s: %v", req.Email, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to send invitation",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Invitation sent successfully",
+		"data":    invitation,
+	})
+}
+
+// GetPendingInvitations lists invitations that can still be accepted (admin only)
+func (ih *InvitationHandler) GetPendingInvitations(c *gin.Context) {
+	invitations, err := ih.InvitationService.GetPendingInvitations()
+	if err != nil {
+		log.Printf("Error fetching invitations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to retrieve invitations",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  invitations,
+		"count": len(invitations),
+	})
+}
+
+// RevokeInvitation cancels a pending invitation (admin only)
+func (ih *InvitationHandler) RevokeInvitation(c *gin.Context) {
+	idParam := c.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_id",
+			"message": "Invitation ID must be a valid number",
+		})
+		return
+	}
+
+	if err := ih.InvitationService.RevokeInvitation(uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   "not_found",
+				"message": "Invitation not found",
+			})
+			return
+		}
+		log.Printf("Error revoking invitation %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to revoke invitation",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked successfully"})
+}
+
+// AcceptInvitation creates the invited account
+func (ih *InvitationHandler) AcceptInvitation(c *gin.Context) {
+	var req AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	user, err := ih.InvitationService.AcceptInvitation(req.Token, req.Name, req.Password)
+	if err != nil {
+		if errors.Is(err, services.ErrWeakPassword) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "weak_password",
+				"message": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidInvitation) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_token",
+				"message": "Invitation is invalid or expired",
+			})
+			return
+		}
+		if errors.Is(err, services.ErrEmailAlreadyRegistered) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "conflict",
+				"message": "This invitation has already been used",
+			})
+			return
+		}
+		log.Printf("Error accepting invitation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to accept invitation",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Account created successfully",
+		"data": gin.H{
+			"id":    user.ID,
+			"name":  user.Name,
+			"email": user.Email,
+			"role":  user.Role,
+		},
+	})
+}
@@ -0,0 +1,118 @@
+// handlers/itinerary_handler.go
+package handlers
+
+import (
+	"Visa/internal/services"
+	"Visa/middleware"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ItineraryHandler struct {
+	ItineraryService *services.ItineraryService
+}
+
+func NewItineraryHandler(itineraryService *services.ItineraryService) *ItineraryHandler {
+	return &ItineraryHandler{ItineraryService: itineraryService}
+}
+
+// BookItineraryRequest books each flight at the cheapest fare left in the
+// cabin and fare family given
+type BookItineraryRequest struct {
+	FlightIDs    []uint `json:"flight_ids" binding:"required,min=1,max=3"`
+	Cabin        string `json:"cabin" binding:"omitempty,oneof=economy premium_economy business first"`
+	FareFamily   string `json:"fare_family" binding:"max=20"`
+	TravellerIDs []uint `json:"traveller_ids" binding:"omitempty,max=9"`
+}
+
+// SearchItineraries finds direct and connecting journeys from ?origin= to
+// ?destination= on ?date=, for ?passengers= in ?cabin=, with up to
+// ?max_connections=, layovers between ?min_layover= and ?max_layover=
+// minutes, ranked by ?sort=
+func (ih *ItineraryHandler) SearchItineraries(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	search := services.ItinerarySearch{
+		Origin:      c.Query("origin"),
+		Destination: c.Query("destination"),
+		Date:        c.Query("date"),
+		CabinClass:  c.Query("cabin"),
+		Sort:        c.Query("sort"),
+		Limit:       limit,
+	}
+	var ok bool
+	if search.Passengers, ok = intQuery(c, "passengers"); !ok {
+		return
+	}
+	if c.Query("max_connections") != "" {
+		maxConnections, ok := intQuery(c, "max_connections")
+		if !ok {
+			return
+		}
+		search.MaxConnections = &maxConnections
+	}
+	if search.MinLayover, ok = intQuery(c, "min_layover"); !ok {
+		return
+	}
+	if search.MaxLayover, ok = intQuery(c, "max_layover"); !ok {
+		return
+	}
+
+	itineraries, err := ih.ItineraryService.SearchItineraries(search)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidItinerarySearch) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error searching itineraries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to search itineraries",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  services.NewItineraryViews(itineraries, middleware.GetLocalizer(c)),
+		"count": len(itineraries),
+	})
+}
+
+// BookItinerary books all flights of a journey for the current user as one
+// reservation
+func (ih *ItineraryHandler) BookItinerary(c *gin.Context) {
+	var req BookItineraryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	userID := c.GetUint("userId")
+	trip, err := ih.ItineraryService.BookItinerary(userID, req.FlightIDs, services.FareChoice{Cabin: req.Cabin, FareFamily: req.FareFamily}, req.TravellerIDs)
+	if err != nil {
+		if respondTripBookingError(c, err) {
+			return
+		}
+		log.Printf("Error booking itinerary %v for user %d: %v", req.FlightIDs, userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to book itinerary",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Itinerary booked successfully",
+		"data":    services.NewTripView(trip, middleware.GetLocalizer(c)),
+	})
+}
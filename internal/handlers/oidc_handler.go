@@ -0,0 +1,126 @@
+// handlers/oidc_handler.go
+package handlers
+
+import (
+	"Visa/internal/services"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OIDCHandler struct {
+	OIDCService *services.OIDCService
+}
+
+func NewOIDCHandler(oidcService *services.OIDCService) *OIDCHandler {
+	return &OIDCHandler{OIDCService: oidcService}
+}
+
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// GetProviders lists the identity providers users can sign in with
+func (oh *OIDCHandler) GetProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": oh.OIDCService.ProviderNames()})
+}
+
+// StartLogin returns the provider URL the frontend should redirect the user to
+func (oh *OIDCHandler) StartLogin(c *gin.Context) {
+	provider := c.Param("provider")
+
+	start, err := oh.OIDCService.StartLogin(c.Request.Context(), provider)
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownProvider) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   "not_found",
+				"message": "Unknown identity provider",
+			})
+			return
+		}
+		log.Printf("Error starting %s login: %v", provider, err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error":   "provider_unavailable",
+			"message": "Unable to reach the identity provider",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": start})
+}
+
+// Callback finishes a login with the code and state the provider sent back
+// to the frontend
+func (oh *OIDCHandler) Callback(c *gin.Context) {
+	provider := c.Param("provider")
+
+	var req OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Code and state are required",
+		})
+		return
+	}
+
+	result, err := oh.OIDCService.CompleteLogin(c.Request.Context(), provider, req.Code, req.State, clientInfo(c))
+	if err != nil {
+		if respondSignInRefused(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, services.ErrUnknownProvider):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   "not_found",
+				"message": "Unknown identity provider",
+			})
+		case errors.Is(err, services.ErrInvalidOIDCState):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_state",
+				"message": "Login session expired, please try again",
+			})
+		case errors.Is(err, services.ErrOIDCEmailNotVerified):
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   "email_not_verified",
+				"message": err.Error(),
+			})
+		case errors.Is(err, services.ErrOIDCLoginFailed):
+			log.Printf("Error completing %s login: %v", provider, err)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":   "authentication_failed",
+				"message": "Sign-in with the identity provider failed",
+			})
+		default:
+			log.Printf("Error completing %s login: %v", provider, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "server_error",
+				"message": "Unable to complete login",
+			})
+		}
+		return
+	}
+
+	writeLoginResult(c, result)
+}
+
+// GetIdentities lists the external identities linked to the current user
+func (oh *OIDCHandler) GetIdentities(c *gin.Context) {
+	userID := c.GetUint("userId")
+	identities, err := oh.OIDCService.GetIdentities(userID)
+	if err != nil {
+		log.Printf("Error fetching identities of user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to retrieve linked accounts",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  identities,
+		"count": len(identities),
+	})
+}
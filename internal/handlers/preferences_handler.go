@@ -0,0 +1,98 @@
+// handlers/preferences_handler.go
+package handlers
+
+import (
+	"Visa/internal/services"
+	"Visa/models"
+	"Visa/pkg"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PreferencesHandler struct {
+	PreferencesService *services.PreferencesService
+}
+
+func NewPreferencesHandler(preferencesService *services.PreferencesService) *PreferencesHandler {
+	return &PreferencesHandler{PreferencesService: preferencesService}
+}
+
+// UpdatePreferencesRequest changes only the fields that are sent
+type UpdatePreferencesRequest struct {
+	Currency      *string               `json:"currency" binding:"omitempty,len=3"`
+	Locale        *string               `json:"locale" binding:"omitempty,max=10"`
+	TimeZone      *string               `json:"time_zone" binding:"omitempty,max=64"`
+	Notifications *models.ChannelOptIns `json:"notifications"`
+	Marketing     *models.ChannelOptIns `json:"marketing"`
+}
+
+// GetPreferenceOptions lists the supported currencies and locales
+func (ph *PreferencesHandler) GetPreferenceOptions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"currencies": pkg.SupportedCurrencies(),
+		"locales":    pkg.SupportedLocales(),
+		"channels":   []string{models.ChannelEmail, models.ChannelSMS, models.ChannelPush},
+		"defaults":   services.DefaultPreferences(0),
+	})
+}
+
+// GetPreferences retrieves the current user's preferences
+func (ph *PreferencesHandler) GetPreferences(c *gin.Context) {
+	userID := c.GetUint("userId")
+	preferences, err := ph.PreferencesService.GetPreferences(userID)
+	if err != nil {
+		log.Printf("Error fetching preferences of user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to retrieve preferences",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": preferences})
+}
+
+// UpdatePreferences changes the current user's preferences
+func (ph *PreferencesHandler) UpdatePreferences(c *gin.Context) {
+	var req UpdatePreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	userID := c.GetUint("userId")
+	preferences, err := ph.PreferencesService.UpdatePreferences(userID, services.PreferencesUpdate{
+		Currency:      req.Currency,
+		Locale:        req.Locale,
+		TimeZone:      req.TimeZone,
+		Notifications: req.Notifications,
+		Marketing:     req.Marketing,
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPreferences) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error updating preferences of user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to update preferences",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Preferences updated successfully",
+		"data":    preferences,
+	})
+}
@@ -0,0 +1,158 @@
+// handlers/privacy_handler.go
+package handlers
+
+import (
+	"Visa/internal/services"
+	"Visa/middleware"
+	"Visa/models"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type PrivacyHandler struct {
+	PrivacyService *services.PrivacyService
+}
+
+func NewPrivacyHandler(privacyService *services.PrivacyService) *PrivacyHandler {
+	return &PrivacyHandler{PrivacyService: privacyService}
+}
+
+// EraseUserRequest confirms the erasure of the caller's own account. Staff
+// erasing someone else's account send no body.
+type EraseUserRequest struct {
+	Password string `json:"password" binding:"required"`
+	// Code is a TOTP or recovery code, required with two-factor authentication
+	Code string `json:"code"`
+}
+
+// ExportData downloads everything stored about the current user as JSON, or
+// as a ZIP archive with ?format=zip
+func (ph *PrivacyHandler) ExportData(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "zip" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "format must be 'json' or 'zip'",
+		})
+		return
+	}
+
+	userID := c.GetUint("userId")
+	export, err := ph.PrivacyService.Export(userID)
+	if err != nil {
+		log.Printf("Error exporting data of user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to export your data",
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	contentType := "application/json"
+	if format == "zip" {
+		contentType = "application/zip"
+		err = export.WriteZip(&buf)
+	} else {
+		encoder := json.NewEncoder(&buf)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(export)
+	}
+	if err != nil {
+		log.Printf("Error encoding data export of user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to export your data",
+		})
+		return
+	}
+
+	filename := fmt.Sprintf("travel-companion-data-%d-%s.%s", userID, export.ExportedAt.Format("20060102"), format)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
+// EraseUser deletes a user account by anonymizing its personal data. Bookings,
+// visa applications and tickets are kept without identifying details. Users
+// erasing their own account confirm it with their password and 2FA code.
+func (ph *PrivacyHandler) EraseUser(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_id",
+			"message": "User ID must be a valid number",
+		})
+		return
+	}
+
+	if uint(id) == c.GetUint("userId") {
+		var req EraseUserRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": "Please confirm with your password",
+			})
+			return
+		}
+		err = ph.PrivacyService.EraseOwnAccount(uint(id), req.Password, req.Code, clientInfo(c))
+	} else if middleware.HasPermission(c, models.PermUserManage) {
+		err = ph.PrivacyService.Erase(uint(id), middleware.HasPermission(c, models.PermRoleManage))
+	} else {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "forbidden",
+			"message": "You can only delete your own account",
+		})
+		return
+	}
+
+	if err != nil {
+		if respondLockout(c, err) || respondStaffAccount(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrIncorrectPassword) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":   "incorrect_password",
+				"message": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, services.ErrTwoFactorCodeRequired) || errors.Is(err, services.ErrInvalidTwoFactorCode) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":   "invalid_code",
+				"message": "A valid two-factor code or recovery code is required",
+			})
+			return
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, services.ErrAlreadyErased) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   "not_found",
+				"message": "User not found",
+			})
+			return
+		}
+		if errors.Is(err, services.ErrLastAdmin) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "conflict",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error erasing user %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to delete user",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+}
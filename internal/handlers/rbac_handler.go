@@ -0,0 +1,104 @@
+// handlers/rbac_handler.go
+package handlers
+
+import (
+	"Visa/internal/services"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type RBACHandler struct {
+	RBACService *services.RBACService
+}
+
+func NewRBACHandler(rbacService *services.RBACService) *RBACHandler {
+	return &RBACHandler{RBACService: rbacService}
+}
+
+type UpdateRolePermissionsRequest struct {
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+// GetRoles lists every role with its permissions
+func (rh *RBACHandler) GetRoles(c *gin.Context) {
+	roles, err := rh.RBACService.GetAllRoles()
+	if err != nil {
+		log.Printf("Error fetching roles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to retrieve roles",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  roles,
+		"count": len(roles),
+	})
+}
+
+// GetPermissions lists every permission that can be granted
+func (rh *RBACHandler) GetPermissions(c *gin.Context) {
+	permissions, err := rh.RBACService.GetAllPermissions()
+	if err != nil {
+		log.Printf("Error fetching permissions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to retrieve permissions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  permissions,
+		"count": len(permissions),
+	})
+}
+
+// UpdateRolePermissions replaces the permissions granted to a role
+func (rh *RBACHandler) UpdateRolePermissions(c *gin.Context) {
+	name := c.Param("name")
+
+	var req UpdateRolePermissionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	role, err := rh.RBACService.SetRolePermissions(name, req.Permissions)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   "not_found",
+				"message": "Role not found",
+			})
+			return
+		}
+		if errors.Is(err, services.ErrUnknownPermission) || errors.Is(err, services.ErrAdminRoleLockout) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error updating permissions of role %s: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to update role permissions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Role permissions updated successfully",
+		"data":    role,
+	})
+}
@@ -0,0 +1,310 @@
+// handlers/seat_handler.go
+package handlers
+
+import (
+	"Visa/internal/services"
+	"Visa/middleware"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SeatHandler struct {
+	SeatService *services.SeatService
+}
+
+func NewSeatHandler(seatService *services.SeatService) *SeatHandler {
+	return &SeatHandler{SeatService: seatService}
+}
+
+// SeatMapRequest describes a seat map template. Each section is a run of
+// rows of one cabin with the seat letters of a row, a space for each aisle.
+type SeatMapRequest struct {
+	Name             string               `json:"name" binding:"required,max=50"`
+	Aircraft         string               `json:"aircraft" binding:"max=50"`
+	Sections         []SeatSectionRequest `json:"sections" binding:"required,min=1,max=6,dive"`
+	ExitRows         []int                `json:"exit_rows" binding:"max=10"`
+	ExtraLegroomRows []int                `json:"extra_legroom_rows" binding:"max=20"`
+	BlockedSeats     []string             `json:"blocked_seats" binding:"max=50,dive,max=4"`
+	ExtraLegroomFee  float64              `json:"extra_legroom_fee" binding:"gte=0"`
+	ExitRowFee       float64              `json:"exit_row_fee" binding:"gte=0"`
+}
+
+type SeatSectionRequest struct {
+	Cabin    string  `json:"cabin" binding:"required,oneof=economy premium_economy business first"`
+	FirstRow int     `json:"first_row" binding:"required,gte=1,lte=99"`
+	LastRow  int     `json:"last_row" binding:"required,gte=1,lte=99"`
+	Layout   string  `json:"layout" binding:"required,max=20"`
+	SeatFee  float64 `json:"seat_fee" binding:"gte=0"`
+}
+
+type AssignSeatMapRequest struct {
+	SeatMapID uint `json:"seat_map_id" binding:"required"`
+}
+
+type SelectSeatRequest struct {
+	FlightID    uint   `json:"flight_id" binding:"required"`
+	TravellerID uint   `json:"traveller_id"`
+	Seat        string `json:"seat" binding:"required,max=4"`
+}
+
+// CreateSeatMap creates a seat map template (admin only)
+func (sh *SeatHandler) CreateSeatMap(c *gin.Context) {
+	var req SeatMapRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	details := services.SeatMapDetails{
+		Name:             req.Name,
+		Aircraft:         req.Aircraft,
+		Sections:         make([]services.SeatSection, len(req.Sections)),
+		ExitRows:         req.ExitRows,
+		ExtraLegroomRows: req.ExtraLegroomRows,
+		BlockedSeats:     req.BlockedSeats,
+		ExtraLegroomFee:  req.ExtraLegroomFee,
+		ExitRowFee:       req.ExitRowFee,
+	}
+	for i, section := range req.Sections {
+		details.Sections[i] = services.SeatSection{
+			Cabin:    section.Cabin,
+			FirstRow: section.FirstRow,
+			LastRow:  section.LastRow,
+			Layout:   section.Layout,
+			SeatFee:  section.SeatFee,
+		}
+	}
+
+	seatMap, err := sh.SeatService.CreateSeatMap(details)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidSeatMap) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, services.ErrSeatMapExists) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "seat_map_exists",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error creating seat map: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to create seat map",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Seat map created successfully",
+		"data":    seatMap,
+	})
+}
+
+// GetSeatMaps lists the seat map templates (admin only)
+func (sh *SeatHandler) GetSeatMaps(c *gin.Context) {
+	seatMaps, err := sh.SeatService.GetSeatMaps()
+	if err != nil {
+		log.Printf("Error retrieving seat maps: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to retrieve seat maps",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  seatMaps,
+		"count": len(seatMaps),
+	})
+}
+
+// GetSeatMap retrieves a seat map template with its seats (admin only)
+func (sh *SeatHandler) GetSeatMap(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_id",
+			"message": "Seat map ID must be a valid number",
+		})
+		return
+	}
+
+	seatMap, err := sh.SeatService.GetSeatMap(uint(id))
+	if err != nil {
+		if errors.Is(err, services.ErrSeatMapNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   "not_found",
+				"message": "Seat map not found",
+			})
+			return
+		}
+		log.Printf("Error retrieving seat map %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to retrieve seat map",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": seatMap})
+}
+
+// AssignSeatMap gives a flight the seats of a seat map template (admin only)
+func (sh *SeatHandler) AssignSeatMap(c *gin.Context) {
+	id, ok := flightIdParam(c)
+	if !ok {
+		return
+	}
+
+	var req AssignSeatMapRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	seatMap, err := sh.SeatService.AssignSeatMap(id, req.SeatMapID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			respondFlightNotFound(c)
+		case errors.Is(err, services.ErrSeatMapNotFound):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": "Seat map not found",
+			})
+		case errors.Is(err, services.ErrInvalidSeatMap):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": err.Error(),
+			})
+		case errors.Is(err, services.ErrFlightCancelled):
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "flight_cancelled",
+				"message": "Cancelled flights cannot be changed",
+			})
+		case errors.Is(err, services.ErrSeatsAssigned):
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "seats_assigned",
+				"message": err.Error(),
+			})
+		default:
+			log.Printf("Error assigning seat map %d to flight %d: %v", req.SeatMapID, id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "server_error",
+				"message": "Unable to assign seat map",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Seat map assigned successfully",
+		"data":    services.NewFlightSeatMapView(seatMap, middleware.GetLocalizer(c)),
+	})
+}
+
+// GetFlightSeatMap retrieves the seats of a flight and which are free
+func (sh *SeatHandler) GetFlightSeatMap(c *gin.Context) {
+	id, ok := flightIdParam(c)
+	if !ok {
+		return
+	}
+
+	seatMap, err := sh.SeatService.GetFlightSeatMap(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondFlightNotFound(c)
+			return
+		}
+		if errors.Is(err, services.ErrNoSeatMap) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   "no_seat_map",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error retrieving seats of flight %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to retrieve seats",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": services.NewFlightSeatMapView(seatMap, middleware.GetLocalizer(c)),
+	})
+}
+
+// SelectSeat chooses or changes the seat of a passenger of one of the current
+// user's bookings on one of its flights
+func (sh *SeatHandler) SelectSeat(c *gin.Context) {
+	var req SelectSeatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	reference := c.Param("reference")
+	seat, err := sh.SeatService.SelectSeat(c.GetUint("userId"), reference, req.FlightID, req.TravellerID, req.Seat)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTripNotFound):
+			respondTripNotFound(c)
+		case errors.Is(err, services.ErrInvalidSeatSelection):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_seat",
+				"message": err.Error(),
+			})
+		case errors.Is(err, services.ErrNoSeatMap):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "no_seat_map",
+				"message": err.Error(),
+			})
+		case errors.Is(err, services.ErrSeatTaken):
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "seat_taken",
+				"message": err.Error(),
+			})
+		case errors.Is(err, services.ErrFlightCancelled):
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "flight_cancelled",
+				"message": "This flight has been cancelled",
+			})
+		default:
+			log.Printf("Error selecting seat %s on flight %d for trip %s: %v", req.Seat, req.FlightID, reference, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "server_error",
+				"message": "Unable to select seat",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Seat selected successfully",
+		"data":    seat,
+	})
+}
@@ -3,6 +3,7 @@ package handlers
 
 import (
 	"Visa/internal/services"
+	"Visa/middleware"
 	"Visa/models"
 	"errors"
 	"log"
@@ -98,8 +99,7 @@ func (sh *SupportHandler) GetTicketById(c *gin.Context) {
 	}
 
 	userID := c.GetUint("userId")
-	role := c.GetString("role")
-	if role != "admin" && ticket.UserID != userID {
+	if !middleware.HasPermission(c, models.PermTicketRead) && ticket.UserID != userID {
 		c.JSON(http.StatusForbidden, gin.H{
 			"error":   "forbidden",
 			"message": "You don't have permission to view this ticket",
@@ -133,10 +133,9 @@ func (sh *SupportHandler) GetTicketsByUser(c *gin.Context) {
 		})
 		return
 	}
-	role := c.GetString("role")
 
 	// 3 Permission check
-	if role != "admin" && userId != currentUserID {
+	if !middleware.HasPermission(c, models.PermTicketRead) && userId != currentUserID {
 		c.JSON(http.StatusForbidden, gin.H{
 			"error":   "forbidden",
 			"message": "You can only view your own support tickets",
@@ -209,8 +208,7 @@ func (sh *SupportHandler) UpdateTicket(c *gin.Context) {
 	}
 
 	userID := c.GetUint("userId")
-	role := c.GetString("role")
-	if role != "admin" && ticket.UserID != userID {
+	if !middleware.HasPermission(c, models.PermTicketAssign) && ticket.UserID != userID {
 		c.JSON(http.StatusForbidden, gin.H{
 			"error":   "forbidden",
 			"message": "You can only update your own tickets",
@@ -235,11 +233,11 @@ func (sh *SupportHandler) UpdateTicket(c *gin.Context) {
 		ticket.Message = req.Message
 	}
 	if req.Status != "" {
-		// Only admins can change status to anything other than "open"
-		if role != "admin" && req.Status != "open" {
+		// Only support staff can change status to anything other than "open"
+		if !middleware.HasPermission(c, models.PermTicketAssign) && req.Status != "open" {
 			c.JSON(http.StatusForbidden, gin.H{
 				"error":   "forbidden",
-				"message": "Only admins can change ticket status",
+				"message": "Only support staff can change ticket status",
 			})
 			return
 		}
@@ -291,8 +289,7 @@ func (sh *SupportHandler) DeleteTicket(c *gin.Context) {
 	}
 
 	userID := c.GetUint("userId")
-	role := c.GetString("role")
-	if role != "admin" && ticket.UserID != userID {
+	if !middleware.HasPermission(c, models.PermTicketDelete) && ticket.UserID != userID {
 		c.JSON(http.StatusForbidden, gin.H{
 			"error":   "forbidden",
 			"message": "You can only delete your own tickets",
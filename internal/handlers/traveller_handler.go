@@ -0,0 +1,218 @@
+// handlers/traveller_handler.go
+package handlers
+
+import (
+	"Visa/internal/services"
+	"Visa/models"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TravellerHandler struct {
+	TravellerService *services.TravellerService
+}
+
+func NewTravellerHandler(travellerService *services.TravellerService) *TravellerHandler {
+	return &TravellerHandler{TravellerService: travellerService}
+}
+
+type LoyaltyNumberRequest struct {
+	Program string `json:"program" binding:"required,max=100"`
+	Number  string `json:"number" binding:"required,max=50"`
+}
+
+type TravellerRequest struct {
+	Relationship   string                 `json:"relationship" binding:"omitempty,oneof=self partner child parent other"`
+	FirstName      string                 `json:"first_name" binding:"required,max=100"`
+	LastName       string                 `json:"last_name" binding:"required,max=100"`
+	DateOfBirth    string                 `json:"date_of_birth" binding:"required"`
+	Nationality    string                 `json:"nationality" binding:"required,min=2,max=50"`
+	PassportNumber string                 `json:"passport_number" binding:"omitempty,min=6,max=20"`
+	PassportExpiry string                 `json:"passport_expiry"`
+	LoyaltyNumbers []LoyaltyNumberRequest `json:"loyalty_numbers" binding:"omitempty,max=10,dive"`
+}
+
+func (req *TravellerRequest) toTraveller() *models.Traveller {
+	traveller := &models.Traveller{
+		Relationship:   req.Relationship,
+		FirstName:      req.FirstName,
+		LastName:       req.LastName,
+		DateOfBirth:    req.DateOfBirth,
+		Nationality:    req.Nationality,
+		PassportNumber: req.PassportNumber,
+		PassportExpiry: req.PassportExpiry,
+		LoyaltyNumbers: make([]models.LoyaltyNumber, 0, len(req.LoyaltyNumbers)),
+	}
+	for _, loyalty := range req.LoyaltyNumbers {
+		traveller.LoyaltyNumbers = append(traveller.LoyaltyNumbers, models.LoyaltyNumber{
+			Program: loyalty.Program,
+			Number:  loyalty.Number,
+		})
+	}
+	return traveller
+}
+
+// GetTravellers lists the current user's saved travellers
+func (th *TravellerHandler) GetTravellers(c *gin.Context) {
+	userID := c.GetUint("userId")
+	travellers, err := th.TravellerService.GetTravellers(userID)
+	if err != nil {
+		log.Printf("Error fetching travellers of user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to retrieve travellers",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  travellers,
+		"count": len(travellers),
+	})
+}
+
+// GetTraveller retrieves one of the current user's travellers
+func (th *TravellerHandler) GetTraveller(c *gin.Context) {
+	id, ok := travellerIdParam(c)
+	if !ok {
+		return
+	}
+
+	traveller, err := th.TravellerService.GetTraveller(c.GetUint("userId"), id)
+	if err != nil {
+		respondTravellerNotFound(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": traveller})
+}
+
+// CreateTraveller saves a traveller for the current user
+func (th *TravellerHandler) CreateTraveller(c *gin.Context) {
+	var req TravellerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	userID := c.GetUint("userId")
+	traveller := req.toTraveller()
+	if err := th.TravellerService.CreateTraveller(userID, traveller); err != nil {
+		if errors.Is(err, services.ErrInvalidTraveller) || errors.Is(err, services.ErrTooManyTravellers) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error creating traveller for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to save traveller",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Traveller saved successfully",
+		"data":    traveller,
+	})
+}
+
+// UpdateTraveller replaces the details of one of the current user's travellers
+func (th *TravellerHandler) UpdateTraveller(c *gin.Context) {
+	id, ok := travellerIdParam(c)
+	if !ok {
+		return
+	}
+
+	var req TravellerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	userID := c.GetUint("userId")
+	traveller := req.toTraveller()
+	traveller.ID = id
+	if err := th.TravellerService.UpdateTraveller(userID, traveller); err != nil {
+		if errors.Is(err, services.ErrTravellerNotFound) {
+			respondTravellerNotFound(c)
+			return
+		}
+		if errors.Is(err, services.ErrInvalidTraveller) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error updating traveller %d of user %d: %v", id, userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to update traveller",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Traveller updated successfully",
+		"data":    traveller,
+	})
+}
+
+// DeleteTraveller removes one of the current user's travellers. Existing
+// reservations keep naming them.
+func (th *TravellerHandler) DeleteTraveller(c *gin.Context) {
+	id, ok := travellerIdParam(c)
+	if !ok {
+		return
+	}
+
+	userID := c.GetUint("userId")
+	if err := th.TravellerService.DeleteTraveller(userID, id); err != nil {
+		if errors.Is(err, services.ErrTravellerNotFound) {
+			respondTravellerNotFound(c)
+			return
+		}
+		log.Printf("Error deleting traveller %d of user %d: %v", id, userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to delete traveller",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Traveller deleted successfully"})
+}
+
+func travellerIdParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_id",
+			"message": "Traveller ID must be a valid number",
+		})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondTravellerNotFound(c *gin.Context) {
+	c.JSON(http.StatusNotFound, gin.H{
+		"error":   "not_found",
+		"message": "Traveller not found",
+	})
+}
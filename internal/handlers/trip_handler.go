@@ -0,0 +1,158 @@
+// handlers/trip_handler.go
+package handlers
+
+import (
+	"Visa/internal/services"
+	"Visa/middleware"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TripHandler struct {
+	TripService *services.TripService
+}
+
+func NewTripHandler(tripService *services.TripService) *TripHandler {
+	return &TripHandler{TripService: tripService}
+}
+
+// BookTripRequest lists the flight IDs of each journey in travel order, such
+// as the outbound and return flights of a round trip. Each flight is booked
+// at the cheapest fare left in the cabin and fare family given.
+type BookTripRequest struct {
+	TripType     string   `json:"trip_type" binding:"required,oneof=one_way round_trip multi_city"`
+	Journeys     [][]uint `json:"journeys" binding:"required,min=1,max=6,dive,min=1,max=3"`
+	Cabin        string   `json:"cabin" binding:"omitempty,oneof=economy premium_economy business first"`
+	FareFamily   string   `json:"fare_family" binding:"max=20"`
+	TravellerIDs []uint   `json:"traveller_ids" binding:"omitempty,max=9"`
+}
+
+// BookTrip books a one way, round or multi-city trip for the current user
+// under a single booking reference
+func (th *TripHandler) BookTrip(c *gin.Context) {
+	var req BookTripRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please check your input data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	userID := c.GetUint("userId")
+	trip, err := th.TripService.BookTrip(userID, req.TripType, req.Journeys, services.FareChoice{Cabin: req.Cabin, FareFamily: req.FareFamily}, req.TravellerIDs)
+	if err != nil {
+		if respondTripBookingError(c, err) {
+			return
+		}
+		log.Printf("Error booking %s trip %v for user %d: %v", req.TripType, req.Journeys, userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to book trip",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Trip booked successfully",
+		"data":    services.NewTripView(trip, middleware.GetLocalizer(c)),
+	})
+}
+
+// GetTrip retrieves one of the current user's flight bookings by its
+// booking reference
+func (th *TripHandler) GetTrip(c *gin.Context) {
+	trip, err := th.TripService.GetTrip(c.GetUint("userId"), c.Param("reference"))
+	if err != nil {
+		if errors.Is(err, services.ErrTripNotFound) {
+			respondTripNotFound(c)
+			return
+		}
+		log.Printf("Error retrieving trip %s: %v", c.Param("reference"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to retrieve trip",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": services.NewTripView(trip, middleware.GetLocalizer(c)),
+	})
+}
+
+// CancelTrip cancels every flight of one of the current user's bookings
+func (th *TripHandler) CancelTrip(c *gin.Context) {
+	trip, err := th.TripService.CancelTrip(c.GetUint("userId"), c.Param("reference"))
+	if err != nil {
+		if errors.Is(err, services.ErrTripNotFound) {
+			respondTripNotFound(c)
+			return
+		}
+		if errors.Is(err, services.ErrInvalidTrip) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "not_cancellable",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error cancelling trip %s: %v", c.Param("reference"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to cancel trip",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Trip cancelled successfully",
+		"data":    services.NewTripView(trip, middleware.GetLocalizer(c)),
+	})
+}
+
+// respondTripBookingError writes the response for errors a booking can fail
+// with because of the request or the flights' state. It reports whether it
+// did.
+func respondTripBookingError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrInvalidItinerary):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_itinerary",
+			"message": err.Error(),
+		})
+	case errors.Is(err, services.ErrInvalidTrip):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_trip",
+			"message": err.Error(),
+		})
+	case errors.Is(err, services.ErrInvalidFare):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_fare",
+			"message": err.Error(),
+		})
+	case errors.Is(err, services.ErrTravellerNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_traveller",
+			"message": "Travellers must be saved on your account first",
+		})
+	case errors.Is(err, services.ErrEmailNotVerified):
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "email_not_verified",
+			"message": "Please verify your email address first",
+		})
+	default:
+		return respondBookingConflict(c, err)
+	}
+	return true
+}
+
+func respondTripNotFound(c *gin.Context) {
+	c.JSON(http.StatusNotFound, gin.H{
+		"error":   "not_found",
+		"message": "Trip not found",
+	})
+}
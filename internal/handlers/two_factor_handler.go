@@ -0,0 +1,197 @@
+// handlers/two_factor_handler.go
+package handlers
+
+import (
+	"Visa/internal/services"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TwoFactorHandler struct {
+	TwoFactorService *services.TwoFactorService
+}
+
+func NewTwoFactorHandler(twoFactorService *services.TwoFactorService) *TwoFactorHandler {
+	return &TwoFactorHandler{TwoFactorService: twoFactorService}
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type SetupTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// Setup starts two-factor enrollment after checking the password and
+// returns the TOTP secret
+func (th *TwoFactorHandler) Setup(c *gin.Context) {
+	var req SetupTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Password is required",
+		})
+		return
+	}
+
+	userID := c.GetUint("userId")
+	setup, err := th.TwoFactorService.Setup(userID, req.Password, clientInfo(c))
+	if err != nil {
+		if respondLockout(c, err) {
+			return
+		}
+		th.handleError(c, err, "Unable to set up two-factor authentication")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Scan the QR code with your authenticator app and confirm with a code",
+		"data":    setup,
+	})
+}
+
+// Confirm enables two-factor authentication and returns recovery codes
+func (th *TwoFactorHandler) Confirm(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Code is required",
+		})
+		return
+	}
+
+	userID := c.GetUint("userId")
+	codes, err := th.TwoFactorService.Confirm(userID, req.Code)
+	if err != nil {
+		th.handleError(c, err, "Unable to enable two-factor authentication")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled. Store your recovery codes in a safe place",
+		"recovery_codes": codes,
+	})
+}
+
+// Disable turns off two-factor authentication
+func (th *TwoFactorHandler) Disable(c *gin.Context) {
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Password and code are required",
+		})
+		return
+	}
+
+	userID := c.GetUint("userId")
+	if err := th.TwoFactorService.Disable(userID, req.Password, req.Code, clientInfo(c)); err != nil {
+		if respondLockout(c, err) {
+			return
+		}
+		th.handleError(c, err, "Unable to disable two-factor authentication")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes
+func (th *TwoFactorHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Code is required",
+		})
+		return
+	}
+
+	userID := c.GetUint("userId")
+	codes, err := th.TwoFactorService.RegenerateRecoveryCodes(userID, req.Code)
+	if err != nil {
+		th.handleError(c, err, "Unable to generate recovery codes")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// Login completes a two-step login with a challenge token and a code
+func (th *TwoFactorHandler) Login(c *gin.Context) {
+	var req TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Challenge token and code are required",
+		})
+		return
+	}
+
+	user, tokens, err := th.TwoFactorService.CompleteLogin(req.ChallengeToken, req.Code, clientInfo(c))
+	if err != nil {
+		if respondLockout(c, err) || respondSignInRefused(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrInvalidToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":   "invalid_token",
+				"message": "Login session expired, please sign in again",
+			})
+			return
+		}
+		th.handleError(c, err, "Unable to complete login")
+		return
+	}
+
+	c.JSON(http.StatusOK, loginResponse(user, tokens))
+}
+
+// handleError maps two-factor errors to responses
+func (th *TwoFactorHandler) handleError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidTwoFactorCode):
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":   "invalid_code",
+			"message": "The code is invalid or has already been used",
+		})
+	case errors.Is(err, services.ErrTwoFactorCodeRequired):
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":   "invalid_code",
+			"message": "A valid two-factor code or recovery code is required",
+		})
+	case errors.Is(err, services.ErrIncorrectPassword):
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":   "incorrect_password",
+			"message": err.Error(),
+		})
+	case errors.Is(err, services.ErrTwoFactorAlreadyActive),
+		errors.Is(err, services.ErrTwoFactorNotEnabled),
+		errors.Is(err, services.ErrTwoFactorNotSetUp):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_state",
+			"message": err.Error(),
+		})
+	default:
+		log.Printf("Two-factor error for user %d: %v", c.GetUint("userId"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": message,
+		})
+	}
+}
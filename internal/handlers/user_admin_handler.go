@@ -0,0 +1,225 @@
+// handlers/user_admin_handler.go
+package handlers
+
+import (
+	"Visa/internal/services"
+	"Visa/middleware"
+	"Visa/models"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type UserAdminHandler struct {
+	UserAdminService *services.UserAdminService
+}
+
+func NewUserAdminHandler(userAdminService *services.UserAdminService) *UserAdminHandler {
+	return &UserAdminHandler{UserAdminService: userAdminService}
+}
+
+type SuspendUserRequest struct {
+	Reason string `json:"reason" binding:"required,max=255"`
+}
+
+// SearchUsers lists users page by page, filtered by ?q= (part of the name or
+// email), role, status and created_from/created_to (admin only)
+func (ah *UserAdminHandler) SearchUsers(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	result, err := ah.UserAdminService.SearchUsers(services.UserSearch{
+		Query:       c.Query("q"),
+		Role:        c.Query("role"),
+		Status:      c.Query("status"),
+		CreatedFrom: c.Query("created_from"),
+		CreatedTo:   c.Query("created_to"),
+		Page:        page,
+		Limit:       limit,
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidUserSearch) || errors.Is(err, services.ErrInvalidRole) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error searching users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to retrieve users",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetUserOverview shows a user with their bookings, visa applications and
+// support tickets (admin only)
+func (ah *UserAdminHandler) GetUserOverview(c *gin.Context) {
+	id, ok := userIdParam(c)
+	if !ok {
+		return
+	}
+
+	overview, err := ah.UserAdminService.GetUserOverview(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondUserNotFound(c)
+			return
+		}
+		log.Printf("Error fetching overview of user %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to retrieve user",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": overview})
+}
+
+// SuspendUser blocks a user from signing in (admin only)
+func (ah *UserAdminHandler) SuspendUser(c *gin.Context) {
+	id, ok := userIdParam(c)
+	if !ok {
+		return
+	}
+
+	var req SuspendUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_input",
+			"message": "Please give a reason for the suspension",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	user, err := ah.UserAdminService.SuspendUser(c.GetUint("userId"), id, req.Reason, middleware.HasPermission(c, models.PermRoleManage))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondUserNotFound(c)
+			return
+		}
+		if respondStaffAccount(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrCannotSuspendSelf) || errors.Is(err, services.ErrLastAdmin) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_input",
+				"message": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, services.ErrAlreadySuspended) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "conflict",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error suspending user %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to suspend user",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User suspended successfully",
+		"data":    user,
+	})
+}
+
+// ReactivateUser lifts a user's suspension (admin only)
+func (ah *UserAdminHandler) ReactivateUser(c *gin.Context) {
+	id, ok := userIdParam(c)
+	if !ok {
+		return
+	}
+
+	user, err := ah.UserAdminService.ReactivateUser(id, middleware.HasPermission(c, models.PermRoleManage))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondUserNotFound(c)
+			return
+		}
+		if respondStaffAccount(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrNotSuspended) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "conflict",
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("Error reactivating user %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to reactivate user",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User reactivated successfully",
+		"data":    user,
+	})
+}
+
+// ForcePasswordReset signs a user out and makes them choose a new password
+// before they can sign in with a password again (admin only)
+func (ah *UserAdminHandler) ForcePasswordReset(c *gin.Context) {
+	id, ok := userIdParam(c)
+	if !ok {
+		return
+	}
+
+	if err := ah.UserAdminService.ForcePasswordReset(id, middleware.HasPermission(c, models.PermRoleManage)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondUserNotFound(c)
+			return
+		}
+		if respondStaffAccount(c, err) {
+			return
+		}
+		log.Printf("Error forcing password reset of user %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "server_error",
+			"message": "Unable to force password reset",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User signed out and sent a password reset link",
+	})
+}
+
+func userIdParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_id",
+			"message": "User ID must be a valid number",
+		})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondUserNotFound(c *gin.Context) {
+	c.JSON(http.StatusNotFound, gin.H{
+		"error":   "not_found",
+		"message": "User not found",
+	})
+}
@@ -0,0 +1,84 @@
+// repos/api_key_repo.go
+package repos
+
+import (
+	"Visa/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type APIKeyRepo struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepo(db *gorm.DB) *APIKeyRepo {
+	return &APIKeyRepo{db: db}
+}
+
+// CreateAPIKey stores a new API key
+func (ar *APIKeyRepo) CreateAPIKey(key *models.APIKey) error {
+	return ar.db.Create(key).Error
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of its value
+func (ar *APIKeyRepo) GetAPIKeyByHash(hash string) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := ar.db.Where("key_hash = ?", hash).First(&key).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetAPIKeyById retrieves an API key by its ID
+func (ar *APIKeyRepo) GetAPIKeyById(id uint) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := ar.db.First(&key, id).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetAPIKeysByUser retrieves the API keys of a user that have not been revoked
+func (ar *APIKeyRepo) GetAPIKeysByUser(userId uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	if err := ar.db.Where("user_id = ? AND revoked_at IS NULL", userId).
+		Order("created_at DESC").
+		Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// CountActiveAPIKeys counts the API keys of a user that have not been revoked
+func (ar *APIKeyRepo) CountActiveAPIKeys(userId uint) (int64, error) {
+	var count int64
+	if err := ar.db.Model(&models.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL", userId).
+		Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// RevokeAPIKey marks an API key as revoked
+func (ar *APIKeyRepo) RevokeAPIKey(id uint) error {
+	return ar.db.Model(&models.APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeAPIKeysByUser marks every API key of a user as revoked
+func (ar *APIKeyRepo) RevokeAPIKeysByUser(userId uint) error {
+	return ar.db.Model(&models.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL", userId).
+		Update("revoked_at", time.Now()).Error
+}
+
+// TouchAPIKey records the use of an API key. The update is skipped if the key
+// was already used after notBefore, which keeps writes infrequent.
+func (ar *APIKeyRepo) TouchAPIKey(id uint, ip string, usedAt, notBefore time.Time) error {
+	return ar.db.Model(&models.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, notBefore).
+		Updates(map[string]interface{}{"last_used_at": usedAt, "last_used_ip": ip}).Error
+}
@@ -0,0 +1,39 @@
+// repos/audit_log_repo.go
+package repos
+
+import (
+	"Visa/models"
+
+	"gorm.io/gorm"
+)
+
+type AuditLogRepo struct {
+	db *gorm.DB
+}
+
+func NewAuditLogRepo(db *gorm.DB) *AuditLogRepo {
+	return &AuditLogRepo{db: db}
+}
+
+// CreateAuditLog stores an audit log entry
+func (ar *AuditLogRepo) CreateAuditLog(entry *models.AuditLog) error {
+	return ar.db.Create(entry).Error
+}
+
+// GetAuditLogs retrieves the most recent audit log entries, optionally only
+// those of one actor or one user
+func (ar *AuditLogRepo) GetAuditLogs(actorId, userId uint, limit int) ([]models.AuditLog, error) {
+	query := ar.db.Order("created_at DESC").Limit(limit)
+	if actorId != 0 {
+		query = query.Where("actor_id = ?", actorId)
+	}
+	if userId != 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+
+	var entries []models.AuditLog
+	if err := query.Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
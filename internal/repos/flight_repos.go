@@ -3,8 +3,24 @@ package repos
 
 import (
 	"Visa/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrSeatsBooked is returned when a change to a flight would leave it
+	// with fewer seats than are already booked
+	ErrSeatsBooked = errors.New("fewer seats than are already booked")
+	// ErrFlightCancelled is returned when a cancelled flight is changed or
+	// cancelled again
+	ErrFlightCancelled = errors.New("flight has been cancelled")
+	// ErrFlightBooked is returned when a flight with booked reservations is deleted
+	ErrFlightBooked = errors.New("flight has booked reservations")
 )
 
 type FlightRepo struct {
@@ -18,7 +34,274 @@ func NewFlightRepo(db *gorm.DB) *FlightRepo {
 // GetAllFlights retrieves all flights from the database
 func (fr *FlightRepo) GetAllFlights() ([]models.Flight, error) {
 	var flights []models.Flight
-	if err := fr.db.Find(&flights).Error; err != nil {
+	if err := withFares(fr.db).Find(&flights).Error; err != nil {
+		return nil, err
+	}
+	return flights, nil
+}
+
+// FlightFilter narrows down a flight search. Zero values are ignored.
+type FlightFilter struct {
+	Origin      string
+	Destination string
+	// DateFrom and DateTo bound the local departure date, as YYYY-MM-DD
+	DateFrom string
+	DateTo   string
+	// DepartAfter leaves out flights that have already left
+	DepartAfter time.Time
+	// Passengers, CabinClass and the prices are matched against the fares:
+	// a flight matches when one of them has a seat for every passenger in
+	// the cabin and price range. Flights found are priced and sorted by the
+	// cheapest fare that matches.
+	Passengers int
+	CabinClass string
+	MaxStops   *int
+	MinPrice   float64
+	MaxPrice   float64
+	// Sort is "price", "duration" or "departure"
+	Sort       string
+	Descending bool
+	Offset     int
+	Limit      int
+}
+
+var flightSortColumns = map[string]string{
+	"duration":  "duration_minutes",
+	"departure": "departure_at",
+}
+
+// SearchFlights retrieves a page of scheduled flights matching the filter
+// and the number of matching flights. Each flight's price and cabin are those
+// of its cheapest matching fare.
+func (fr *FlightRepo) SearchFlights(filter FlightFilter) ([]models.Flight, int64, error) {
+	query := fr.db.Model(&models.Flight{}).Where("status = ?", models.FlightStatusScheduled)
+	if filter.Origin != "" {
+		query = query.Where("origin = ?", filter.Origin)
+	}
+	if filter.Destination != "" {
+		query = query.Where("destination = ?", filter.Destination)
+	}
+	if filter.DateFrom != "" {
+		query = query.Where("departure_date >= ?", filter.DateFrom)
+	}
+	if filter.DateTo != "" {
+		query = query.Where("departure_date <= ?", filter.DateTo)
+	}
+	if !filter.DepartAfter.IsZero() {
+		query = query.Where("departure_at > ?", filter.DepartAfter)
+	}
+	if filter.MaxStops != nil {
+		query = query.Where("stops <= ?", *filter.MaxStops)
+	}
+	query = query.Where("EXISTS (?)", matchingFares(fr.db, filter, "1"))
+
+	// Count and Find each start from the filtered query
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	// Ties are broken by ID so pages do not overlap
+	var order clause.OrderBy
+	if filter.Sort == "price" {
+		direction := ""
+		if filter.Descending {
+			direction = " DESC"
+		}
+		order.Expression = clause.Expr{
+			SQL:  "(?)" + direction + ", id",
+			Vars: []interface{}{matchingFares(fr.db, filter, "MIN(fare_buckets.price)")},
+		}
+	} else {
+		column, ok := flightSortColumns[filter.Sort]
+		if !ok {
+			column = flightSortColumns["departure"]
+		}
+		order.Columns = []clause.OrderByColumn{
+			{Column: clause.Column{Name: column}, Desc: filter.Descending},
+			{Column: clause.Column{Name: "id"}},
+		}
+	}
+	var flights []models.Flight
+	if err := withFares(query).
+		Order(order).
+		Offset(filter.Offset).
+		Limit(filter.Limit).
+		Find(&flights).Error; err != nil {
+		return nil, 0, err
+	}
+	for i := range flights {
+		// Fares are loaded cheapest first
+		for _, fare := range flights[i].Fares {
+			if filter.matchesFare(&fare) {
+				flights[i].Price = fare.Price
+				flights[i].CabinClass = fare.Cabin
+				break
+			}
+		}
+	}
+	return flights, total, nil
+}
+
+// matchingFares starts a subquery selecting from the fares of the flight of
+// each row of an outer query on flights those the filter asks for
+func matchingFares(db *gorm.DB, filter FlightFilter, selection string) *gorm.DB {
+	fares := faresOfFlights(db, selection).Where("fare_buckets.seats_available >= ?", max(filter.Passengers, 1))
+	if filter.CabinClass != "" {
+		fares = fares.Where("fare_buckets.cabin = ?", filter.CabinClass)
+	}
+	if filter.MinPrice > 0 {
+		fares = fares.Where("fare_buckets.price >= ?", filter.MinPrice)
+	}
+	if filter.MaxPrice > 0 {
+		fares = fares.Where("fare_buckets.price <= ?", filter.MaxPrice)
+	}
+	return fares
+}
+
+// matchesFare reports whether a fare is one matchingFares selects
+func (filter FlightFilter) matchesFare(fare *models.FareBucket) bool {
+	return fare.SeatsAvailable >= max(filter.Passengers, 1) &&
+		(filter.CabinClass == "" || fare.Cabin == filter.CabinClass) &&
+		(filter.MinPrice <= 0 || fare.Price >= filter.MinPrice) &&
+		(filter.MaxPrice <= 0 || fare.Price <= filter.MaxPrice)
+}
+
+// ItineraryFilter describes the journeys FindItineraries looks for. Layovers
+// are in minutes.
+type ItineraryFilter struct {
+	Origin        string
+	Destination   string
+	DepartureDate string
+	DepartAfter   time.Time
+	// Passengers and CabinClass are matched against the fares: every flight
+	// of a journey needs a fare with a seat for every passenger in the cabin
+	Passengers int
+	CabinClass string
+	MinLayover int
+	MaxLayover int
+	// Sort is "price", "duration" or "departure"
+	Sort  string
+	Limit int
+}
+
+// FindItineraries retrieves up to Limit journeys from the origin to the
+// destination that change planes exactly connections times, best first. Each
+// journey is the IDs of its flights in travel order. Connections are made at
+// the airport the previous flight lands at, and no airport is visited twice.
+// Journeys are priced at the cheapest matching fare of each flight.
+func (fr *FlightRepo) FindItineraries(filter ItineraryFilter, connections int) ([][]uint, error) {
+	legs := connections + 1
+	last := legs - 1
+	columns := make([]string, legs)
+	prices := make([]string, legs)
+	var from strings.Builder
+	var args []interface{}
+
+	// Each flight is joined to the price of its cheapest fare with a seat for
+	// every passenger in the cabin, leaving out flights without one
+	fares := "SELECT flight_id, MIN(price) AS price FROM fare_buckets WHERE seats_available >= ?"
+	fareArgs := []interface{}{filter.Passengers}
+	if filter.CabinClass != "" {
+		fares += " AND cabin = ?"
+		fareArgs = append(fareArgs, filter.CabinClass)
+	}
+	fares += " GROUP BY flight_id"
+
+	for i := 0; i < legs; i++ {
+		columns[i] = fmt.Sprintf("f%d.id", i)
+		prices[i] = fmt.Sprintf("p%d.price", i)
+		if i == 0 {
+			from.WriteString("flights f0")
+		} else {
+			fmt.Fprintf(&from, " JOIN flights f%[1]d ON f%[1]d.origin = f%[2]d.destination AND %[3]s", i, i-1,
+				fr.departsWithin(fmt.Sprintf("f%d", i-1), fmt.Sprintf("f%d", i)))
+			args = append(args, filter.MinLayover, filter.MaxLayover)
+		}
+		fmt.Fprintf(&from, " JOIN (%s) p%[2]d ON p%[2]d.flight_id = f%[2]d.id", fares, i)
+		args = append(args, fareArgs...)
+	}
+
+	where := []string{"f0.origin = ?", fmt.Sprintf("f%d.destination = ?", last), "f0.departure_date = ?", "f0.departure_at > ?"}
+	args = append(args, filter.Origin, filter.Destination, filter.DepartureDate, filter.DepartAfter)
+	for i := 0; i < legs; i++ {
+		where = append(where, fmt.Sprintf("f%d.status = ?", i))
+		args = append(args, models.FlightStatusScheduled)
+	}
+	// Connecting airports are neither the origin, the destination nor an
+	// earlier connection
+	for i := 0; i < last; i++ {
+		where = append(where, fmt.Sprintf("f%d.destination NOT IN (?, ?)", i))
+		args = append(args, filter.Origin, filter.Destination)
+		for j := 0; j < i; j++ {
+			where = append(where, fmt.Sprintf("f%d.destination <> f%d.destination", i, j))
+		}
+	}
+
+	price := strings.Join(prices, " + ")
+	duration := fr.minutesBetween("f0.departure_at", fmt.Sprintf("f%d.arrival_at", last))
+	var order string
+	switch filter.Sort {
+	case "duration":
+		order = duration + ", " + price
+	case "departure":
+		order = "f0.departure_at, " + price
+	default:
+		order = price + ", " + duration
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s LIMIT ?",
+		strings.Join(columns, ", "), from.String(), strings.Join(where, " AND "), order)
+	args = append(args, filter.Limit)
+
+	rows, err := fr.db.Raw(query, args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var itineraries [][]uint
+	for rows.Next() {
+		ids := make([]uint, legs)
+		targets := make([]interface{}, legs)
+		for i := range ids {
+			targets[i] = &ids[i]
+		}
+		if err := rows.Scan(targets...); err != nil {
+			return nil, err
+		}
+		itineraries = append(itineraries, ids)
+	}
+	return itineraries, rows.Err()
+}
+
+// departsWithin is the condition that the next flight leaves between a
+// minimum and a maximum number of minutes, given as two arguments, after the
+// previous one lands. MySQL and SQLite, which the tests run on, have no date
+// arithmetic in common.
+func (fr *FlightRepo) departsWithin(previous, next string) string {
+	if fr.db.Dialector.Name() == "sqlite" {
+		return fmt.Sprintf("%s BETWEEN ? AND ?", fr.minutesBetween(previous+".arrival_at", next+".departure_at"))
+	}
+	return fmt.Sprintf("%[2]s.departure_at BETWEEN DATE_ADD(%[1]s.arrival_at, INTERVAL ? MINUTE)"+
+		" AND DATE_ADD(%[1]s.arrival_at, INTERVAL ? MINUTE)", previous, next)
+}
+
+// minutesBetween is the number of whole minutes from one time column to
+// another
+func (fr *FlightRepo) minutesBetween(from, to string) string {
+	if fr.db.Dialector.Name() == "sqlite" {
+		return fmt.Sprintf("CAST(ROUND((julianday(%s) - julianday(%s)) * 1440) AS INTEGER)", to, from)
+	}
+	return fmt.Sprintf("TIMESTAMPDIFF(MINUTE, %s, %s)", from, to)
+}
+
+// GetFlightsByIds retrieves the flights with the given IDs
+func (fr *FlightRepo) GetFlightsByIds(ids []uint) ([]models.Flight, error) {
+	var flights []models.Flight
+	if err := withFares(fr.db).Where("id IN ?", ids).Find(&flights).Error; err != nil {
 		return nil, err
 	}
 	return flights, nil
@@ -27,95 +310,290 @@ func (fr *FlightRepo) GetAllFlights() ([]models.Flight, error) {
 // GetFlightById retrieves a flight by its ID
 func (fr *FlightRepo) GetFlightById(id uint) (*models.Flight, error) {
 	var flight models.Flight
-	if err := fr.db.First(&flight, id).Error; err != nil {
+	if err := withFares(fr.db).First(&flight, id).Error; err != nil {
 		return nil, err
 	}
 	return &flight, nil
 }
 
-// CreateFlight creates a new flight in the database
+// CreateFlight creates a new flight in the database together with its fares
 func (fr *FlightRepo) CreateFlight(flight *models.Flight) error {
 	return fr.db.Create(flight).Error
 }
 
-// UpdateFlight updates an existing flight
+// UpdateFlight updates an existing flight and replaces its fares in one
+// transaction. Fares are matched by ID; fares the flight no longer lists are
+// deleted and new ones start with every seat available. The flight and its
+// fares are locked while they change and a change of capacity moves the seats
+// available by the same amount, so seats booked in the meantime stay booked.
+// It returns ErrSeatsBooked if a fare would have fewer seats than are booked
+// or a fare with bookings would be deleted, and ErrFlightCancelled if the
+// flight was cancelled. The flight is reloaded with the seats left.
 func (fr *FlightRepo) UpdateFlight(flight *models.Flight) error {
-	return fr.db.Save(flight).Error
+	return fr.db.Transaction(func(tx *gorm.DB) error {
+		var current models.Flight
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, flight.ID).Error; err != nil {
+			return err
+		}
+		if current.Status == models.FlightStatusCancelled {
+			return ErrFlightCancelled
+		}
+		var fares []models.FareBucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("flight_id = ?", flight.ID).Find(&fares).Error; err != nil {
+			return err
+		}
+		currentFares := make(map[uint]models.FareBucket, len(fares))
+		for _, fare := range fares {
+			currentFares[fare.ID] = fare
+		}
+
+		if err := tx.Model(flight).Select("*").Omit("id", "status", "capacity", "seats_available", clause.Associations).
+			Updates(flight).Error; err != nil {
+			return err
+		}
+		if err := addFlightCapacity(tx, flight.ID, flight.Capacity-current.Capacity); err != nil {
+			return err
+		}
+
+		kept := make([]uint, 0, len(flight.Fares))
+		for i := range flight.Fares {
+			fare := &flight.Fares[i]
+			fare.FlightID = flight.ID
+			old, ok := currentFares[fare.ID]
+			if !ok {
+				fare.ID = 0
+				fare.SeatsAvailable = fare.Capacity
+				if err := tx.Create(fare).Error; err != nil {
+					return err
+				}
+				kept = append(kept, fare.ID)
+				continue
+			}
+
+			seats := fare.Capacity - old.Capacity
+			if old.SeatsAvailable+seats < 0 {
+				return ErrSeatsBooked
+			}
+			if err := tx.Model(&models.FareBucket{}).Where("id = ?", fare.ID).Updates(map[string]interface{}{
+				"price":           fare.Price,
+				"capacity":        fare.Capacity,
+				"seats_available": gorm.Expr("seats_available + ?", seats),
+				"refundable":      fare.Refundable,
+				"changeable":      fare.Changeable,
+				"change_fee":      fare.ChangeFee,
+				"cabin_bags":      fare.CabinBags,
+				"checked_bags":    fare.CheckedBags,
+				"checked_bag_kg":  fare.CheckedBagKg,
+			}).Error; err != nil {
+				return err
+			}
+			kept = append(kept, fare.ID)
+			delete(currentFares, fare.ID)
+		}
+		for _, old := range currentFares {
+			if old.Booked() > 0 {
+				return ErrSeatsBooked
+			}
+		}
+		if err := tx.Where("flight_id = ? AND id NOT IN ?", flight.ID, kept).Delete(&models.FareBucket{}).Error; err != nil {
+			return err
+		}
+
+		flight.Fares = nil
+		return withFares(tx).First(flight, flight.ID).Error
+	})
 }
 
-// DeleteFlight deletes a flight by its ID
+// addFlightCapacity changes the capacity of a flight and its seats available
+// by the same number of seats, unless that leaves fewer seats than are booked
+func addFlightCapacity(tx *gorm.DB, flightId uint, seats int) error {
+	if seats == 0 {
+		return nil
+	}
+	result := tx.Model(&models.Flight{}).
+		Where("id = ? AND seats_available + ? >= 0", flightId, seats).
+		Updates(map[string]interface{}{
+			"capacity":        gorm.Expr("capacity + ?", seats),
+			"seats_available": gorm.Expr("seats_available + ?", seats),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSeatsBooked
+	}
+	return nil
+}
+
+// DeleteFlight deletes a flight with its fares and seats by the flight's ID.
+// The flight is locked first, so a booking either finishes before the check
+// for booked reservations or fails to take seats on the deleted flight. It
+// returns ErrFlightBooked, deleting nothing, if the flight has bookings.
 func (fr *FlightRepo) DeleteFlight(id uint) error {
-	return fr.db.Delete(&models.Flight{}, id).Error
+	return fr.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Take(&models.Flight{}, id).Error; err != nil {
+			return err
+		}
+		var booked int64
+		if err := reservationsOfFlight(tx.Model(&models.Reservation{}), id).
+			Where("status = ?", "booked").
+			Count(&booked).Error; err != nil {
+			return err
+		}
+		if booked > 0 {
+			return ErrFlightBooked
+		}
+
+		if err := tx.Where("flight_id = ?", id).Delete(&models.FareBucket{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("flight_id = ?", id).Delete(&models.FlightSeat{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Flight{}, id).Error
+	})
+}
+
+// CancelFlight marks a flight cancelled with no seats left on any fare and
+// cancels every booked reservation that includes it in one transaction, with
+// a full refund whatever their fares. Seats on the other flights of those
+// reservations are given back. It returns the reservations it cancelled,
+// leaving out those cancelled by their users in the meantime, or
+// ErrFlightCancelled if the flight was already cancelled.
+func (fr *FlightRepo) CancelFlight(id uint) ([]models.Reservation, error) {
+	var cancelled []models.Reservation
+	err := fr.db.Transaction(func(tx *gorm.DB) error {
+		var reservations []models.Reservation
+		result := tx.Model(&models.Flight{}).
+			Where("id = ? AND status <> ?", id, models.FlightStatusCancelled).
+			Updates(map[string]interface{}{
+				"status":          models.FlightStatusCancelled,
+				"seats_available": 0,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrFlightCancelled
+		}
+		if err := tx.Model(&models.FareBucket{}).Where("flight_id = ?", id).
+			Update("seats_available", 0).Error; err != nil {
+			return err
+		}
+		if err := reservationsOfFlight(tx, id).
+			Preload("Segments").
+			Preload("Travellers", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+			Where("status = ?", "booked").
+			Find(&reservations).Error; err != nil {
+			return err
+		}
+		for i := range reservations {
+			err := cancelFlightReservation(tx, &reservations[i], reservations[i].Seats(), true)
+			if errors.Is(err, ErrAlreadyCancelled) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			cancelled = append(cancelled, reservations[i])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cancelled, nil
 }
 
 // FindFlightByCity retrieves all flights for a specific city
 func (fr *FlightRepo) FindFlightByCity(city string) ([]models.Flight, error) {
 	var flights []models.Flight
-	if err := fr.db.Where("city = ?", city).Find(&flights).Error; err != nil {
+	if err := withFares(fr.db).Where("city = ?", city).Find(&flights).Error; err != nil {
 		return nil, err
 	}
 	return flights, nil
 }
 
-// FindByDepartDate retrieves all flights for a specific departure date
+// FindByDepartDate retrieves all flights departing on a date (YYYY-MM-DD),
+// local to the origin airport
 func (fr *FlightRepo) FindByDepartDate(date string) ([]models.Flight, error) {
 	var flights []models.Flight
-	if err := fr.db.Where("depart_date = ?", date).Find(&flights).Error; err != nil {
+	if err := withFares(fr.db).Where("departure_date = ?", date).Order("departure_at").Find(&flights).Error; err != nil {
 		return nil, err
 	}
 	return flights, nil
 }
 
-// FindByArriveDate retrieves all flights for a specific arrival date
+// FindByArriveDate retrieves all flights arriving on a date (YYYY-MM-DD),
+// local to the destination airport
 func (fr *FlightRepo) FindByArriveDate(date string) ([]models.Flight, error) {
 	var flights []models.Flight
-	if err := fr.db.Where("arrive_date = ?", date).Find(&flights).Error; err != nil {
+	if err := withFares(fr.db).Where("arrival_date = ?", date).Order("arrival_at").Find(&flights).Error; err != nil {
 		return nil, err
 	}
 	return flights, nil
 }
 
-// FindByClass retrieves all flights for a specific class (economy, business, first)
+// FindByClass retrieves all flights with a fare in a cabin class
 func (fr *FlightRepo) FindByClass(class string) ([]models.Flight, error) {
+	fares := faresOfFlights(fr.db, "1").Where("fare_buckets.cabin = ?", class)
 	var flights []models.Flight
-	if err := fr.db.Where("class = ?", class).Find(&flights).Error; err != nil {
+	if err := withFares(fr.db).Where("EXISTS (?)", fares).Find(&flights).Error; err != nil {
 		return nil, err
 	}
 	return flights, nil
 }
 
-// DirectFlight retrieves all direct or connecting flights based on the flag
+// DirectFlight retrieves all direct flights, or all flights with stops when
+// checked is false
 func (fr *FlightRepo) DirectFlight(checked bool) ([]models.Flight, error) {
+	query := fr.db.Where("stops = 0")
+	if !checked {
+		query = fr.db.Where("stops > 0")
+	}
 	var flights []models.Flight
-	if err := fr.db.Where("direct_flight = ?", checked).Find(&flights).Error; err != nil {
+	if err := withFares(query).Find(&flights).Error; err != nil {
 		return nil, err
 	}
 	return flights, nil
 }
 
-// SortFromHigherToLower retrieves all flights sorted by price (highest to lowest)
-func (fr *FlightRepo) SortFromHigherToLower() ([]models.Flight, error) {
+// FindByUser retrieves the flights of a user's booked reservations in order
+// of departure
+func (fr *FlightRepo) FindByUser(userId uint) ([]models.Flight, error) {
+	booked := fr.db.Model(&models.Reservation{}).
+		Select("id").
+		Where("user_id = ? AND status = ?", userId, "booked")
+	segments := fr.db.Model(&models.ReservationFlight{}).
+		Select("flight_id").
+		Where("reservation_id IN (?)", booked)
+	// Bookings made before segments were recorded only have a flight ID
+	legacy := fr.db.Model(&models.Reservation{}).
+		Select("flight_id").
+		Where("user_id = ? AND status = ? AND flight_id IS NOT NULL", userId, "booked")
+
 	var flights []models.Flight
-	if err := fr.db.Order("price desc").Find(&flights).Error; err != nil {
+	if err := withFares(fr.db).
+		Where("id IN (?) OR id IN (?)", segments, legacy).
+		Order("departure_at, id").
+		Find(&flights).Error; err != nil {
 		return nil, err
 	}
 	return flights, nil
 }
 
-// SortFromLowerToUpper retrieves all flights sorted by price (lowest to highest)
-func (fr *FlightRepo) SortFromLowerToUpper() ([]models.Flight, error) {
-	var flights []models.Flight
-	if err := fr.db.Order("price asc").Find(&flights).Error; err != nil {
-		return nil, err
-	}
-	return flights, nil
+// withFares loads the fares of the flights a query finds, cheapest first
+func withFares(db *gorm.DB) *gorm.DB {
+	return db.Preload("Fares", func(db *gorm.DB) *gorm.DB { return db.Order("price, id") })
 }
 
-// FindByUser retrieves all flights booked by a specific user
-func (fr *FlightRepo) FindByUser(userId uint) ([]models.Flight, error) {
-	var flights []models.Flight
-	if err := fr.db.Where("user_id = ?", userId).Find(&flights).Error; err != nil {
-		return nil, err
-	}
-	return flights, nil
+// faresOfFlights starts a subquery selecting from the fares of the flight of
+// each row of an outer query on flights
+func faresOfFlights(db *gorm.DB, selection string) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true}).
+		Model(&models.FareBucket{}).
+		Select(selection).
+		Where("fare_buckets.flight_id = flights.id")
 }
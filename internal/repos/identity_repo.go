@@ -0,0 +1,64 @@
+// repos/identity_repo.go
+package repos
+
+import (
+	"Visa/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type IdentityRepo struct {
+	db *gorm.DB
+}
+
+func NewIdentityRepo(db *gorm.DB) *IdentityRepo {
+	return &IdentityRepo{db: db}
+}
+
+// GetIdentity retrieves a linked identity by provider and subject
+func (ir *IdentityRepo) GetIdentity(provider, subject string) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	if err := ir.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// GetIdentitiesByUser retrieves the identities linked to a user
+func (ir *IdentityRepo) GetIdentitiesByUser(userId uint) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	if err := ir.db.Where("user_id = ?", userId).Find(&identities).Error; err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// CreateIdentity links an external identity to a user
+func (ir *IdentityRepo) CreateIdentity(identity *models.UserIdentity) error {
+	return ir.db.Create(identity).Error
+}
+
+// CreateLoginState stores the state of a login started at a provider
+func (ir *IdentityRepo) CreateLoginState(state *models.OIDCLoginState) error {
+	return ir.db.Create(state).Error
+}
+
+// ConsumeLoginState retrieves a login state by its hash and marks it as used.
+// It returns gorm.ErrRecordNotFound if the state is unknown or already used.
+func (ir *IdentityRepo) ConsumeLoginState(provider, hash string) (*models.OIDCLoginState, error) {
+	var state models.OIDCLoginState
+	if err := ir.db.Where("provider = ? AND state_hash = ? AND used_at IS NULL", provider, hash).First(&state).Error; err != nil {
+		return nil, err
+	}
+	result := ir.db.Model(&models.OIDCLoginState{}).
+		Where("id = ? AND used_at IS NULL", state.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &state, nil
+}
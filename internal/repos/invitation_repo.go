@@ -0,0 +1,82 @@
+// repos/invitation_repo.go
+package repos
+
+import (
+	"Visa/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type InvitationRepo struct {
+	db *gorm.DB
+}
+
+func NewInvitationRepo(db *gorm.DB) *InvitationRepo {
+	return &InvitationRepo{db: db}
+}
+
+// CreateInvitation stores a new invitation
+func (ir *InvitationRepo) CreateInvitation(invitation *models.Invitation) error {
+	return ir.db.Create(invitation).Error
+}
+
+// GetInvitationById retrieves an invitation by its ID
+func (ir *InvitationRepo) GetInvitationById(id uint) (*models.Invitation, error) {
+	var invitation models.Invitation
+	if err := ir.db.First(&invitation, id).Error; err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// GetInvitationByHash retrieves an invitation by the hash of its token
+func (ir *InvitationRepo) GetInvitationByHash(hash string) (*models.Invitation, error) {
+	var invitation models.Invitation
+	if err := ir.db.Where("token_hash = ?", hash).First(&invitation).Error; err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// GetPendingInvitations retrieves invitations that were neither accepted, revoked nor expired
+func (ir *InvitationRepo) GetPendingInvitations() ([]models.Invitation, error) {
+	var invitations []models.Invitation
+	if err := ir.db.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", time.Now()).
+		Order("created_at desc").Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// AcceptInvitation marks a pending invitation as accepted and creates the
+// user in one transaction. It returns false, creating nothing, if the
+// invitation was accepted, revoked or expired in the meantime.
+func (ir *InvitationRepo) AcceptInvitation(id uint, user *models.User) (bool, error) {
+	accepted := false
+	err := ir.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&models.Invitation{}).
+			Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", id, now).
+			Update("accepted_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return nil
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		accepted = true
+		return nil
+	})
+	return accepted, err
+}
+
+// RevokeInvitation revokes a pending invitation
+func (ir *InvitationRepo) RevokeInvitation(id uint) error {
+	return ir.db.Model(&models.Invitation{}).
+		Where("id = ? AND accepted_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,45 @@
+// repos/login_event_repo.go
+package repos
+
+import (
+	"Visa/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type LoginEventRepo struct {
+	db *gorm.DB
+}
+
+func NewLoginEventRepo(db *gorm.DB) *LoginEventRepo {
+	return &LoginEventRepo{db: db}
+}
+
+// CreateEvent stores a login event
+func (lr *LoginEventRepo) CreateEvent(event *models.LoginEvent) error {
+	return lr.db.Create(event).Error
+}
+
+// GetEventsByUser retrieves the most recent login events of a user
+func (lr *LoginEventRepo) GetEventsByUser(userId uint, limit int) ([]models.LoginEvent, error) {
+	var events []models.LoginEvent
+	if err := lr.db.Where("user_id = ?", userId).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// CountFailuresByIP counts failed login attempts with the given reasons from an IP since a given time
+func (lr *LoginEventRepo) CountFailuresByIP(ip string, since time.Time, reasons []string) (int64, error) {
+	var count int64
+	if err := lr.db.Model(&models.LoginEvent{}).
+		Where("ip = ? AND success = ? AND reason IN ? AND created_at > ?", ip, false, reasons, since).
+		Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,30 @@
+// repos/preferences_repo.go
+package repos
+
+import (
+	"Visa/models"
+
+	"gorm.io/gorm"
+)
+
+type PreferencesRepo struct {
+	db *gorm.DB
+}
+
+func NewPreferencesRepo(db *gorm.DB) *PreferencesRepo {
+	return &PreferencesRepo{db: db}
+}
+
+// GetPreferences retrieves the stored preferences of a user
+func (pr *PreferencesRepo) GetPreferences(userId uint) (*models.UserPreferences, error) {
+	var preferences models.UserPreferences
+	if err := pr.db.Where("user_id = ?", userId).First(&preferences).Error; err != nil {
+		return nil, err
+	}
+	return &preferences, nil
+}
+
+// SavePreferences creates or replaces the preferences of a user
+func (pr *PreferencesRepo) SavePreferences(preferences *models.UserPreferences) error {
+	return pr.db.Save(preferences).Error
+}
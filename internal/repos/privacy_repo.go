@@ -0,0 +1,114 @@
+// repos/privacy_repo.go
+package repos
+
+import (
+	"Visa/models"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Placeholders written over erased personal data
+const (
+	ErasedName = "Deleted user"
+	ErasedText = "[erased]"
+)
+
+type PrivacyRepo struct {
+	db *gorm.DB
+}
+
+func NewPrivacyRepo(db *gorm.DB) *PrivacyRepo {
+	return &PrivacyRepo{db: db}
+}
+
+// EraseUser anonymizes a user in a single transaction. The user row,
+// reservations, visa applications, support tickets and travellers are kept
+// with their personal details overwritten; credentials, sessions, linked
+// accounts, preferences and sign-in history are deleted. Deleting the
+// sessions also invalidates every access token. It returns ErrLastAdmin if
+// the user is the only admin who can sign in.
+func (pr *PrivacyRepo) EraseUser(user *models.User, erasedAt time.Time) error {
+	return pr.db.Transaction(func(tx *gorm.DB) error {
+		if err := keepAnotherAdmin(tx, user.ID); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"name":                 ErasedName,
+			"email":                fmt.Sprintf("deleted-%d@erased.invalid", user.ID),
+			"password":             "",
+			"role":                 "user",
+			"email_verified_at":    nil,
+			"two_factor_enabled":   false,
+			"two_factor_secret":    "",
+			"two_factor_last_step": 0,
+			"failed_login_count":   0,
+			"locked_until":         nil,
+			"tokens_revoked_at":    erasedAt,
+			"erased_at":            erasedAt,
+		}).Error; err != nil {
+			return err
+		}
+
+		// Visa applications stay for the record, without identity documents
+		if err := tx.Model(&models.VisaApplication{}).Where("user_id = ?", user.ID).Updates(map[string]interface{}{
+			"passport_number": "",
+			"passport_url":    "",
+		}).Error; err != nil {
+			return err
+		}
+
+		// Free text may contain anything the user wrote about themselves
+		if err := tx.Model(&models.SupportTicket{}).Where("user_id = ?", user.ID).Updates(map[string]interface{}{
+			"subject": ErasedText,
+			"message": ErasedText,
+		}).Error; err != nil {
+			return err
+		}
+
+		// Travellers are still referenced by reservations, so only their
+		// details go
+		var travellerIds []uint
+		if err := tx.Unscoped().Model(&models.Traveller{}).Where("user_id = ?", user.ID).
+			Pluck("id", &travellerIds).Error; err != nil {
+			return err
+		}
+		if len(travellerIds) > 0 {
+			if err := tx.Unscoped().Model(&models.Traveller{}).Where("id IN ?", travellerIds).Updates(map[string]interface{}{
+				"first_name":      ErasedName,
+				"last_name":       "",
+				"date_of_birth":   "",
+				"nationality":     "",
+				"passport_number": "",
+				"passport_expiry": "",
+				"deleted_at":      erasedAt,
+			}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("traveller_id IN ?", travellerIds).Delete(&models.LoyaltyNumber{}).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&models.Invitation{}).Where("email = ?", user.Email).
+			Update("email", fmt.Sprintf("deleted-%d@erased.invalid", user.ID)).Error; err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{
+			&models.Session{},
+			&models.RefreshToken{},
+			&models.UserToken{},
+			&models.RecoveryCode{},
+			&models.UserIdentity{},
+			&models.APIKey{},
+			&models.UserPreferences{},
+		} {
+			if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("user_id = ? OR email = ?", user.ID, user.Email).Delete(&models.LoginEvent{}).Error
+	})
+}
@@ -0,0 +1,72 @@
+// repos/rbac_repo.go
+package repos
+
+import (
+	"Visa/models"
+
+	"gorm.io/gorm"
+)
+
+type RBACRepo struct {
+	db *gorm.DB
+}
+
+func NewRBACRepo(db *gorm.DB) *RBACRepo {
+	return &RBACRepo{db: db}
+}
+
+// GetAllRoles retrieves all roles with their permissions
+func (rr *RBACRepo) GetAllRoles() ([]models.Role, error) {
+	var roles []models.Role
+	if err := rr.db.Preload("Permissions").Order("name").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// GetRoleByName retrieves a role and its permissions by name
+func (rr *RBACRepo) GetRoleByName(name string) (*models.Role, error) {
+	var role models.Role
+	if err := rr.db.Preload("Permissions").Where("name = ?", name).First(&role).Error; err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// GetAllPermissions retrieves all permissions
+func (rr *RBACRepo) GetAllPermissions() ([]models.Permission, error) {
+	var permissions []models.Permission
+	if err := rr.db.Order("name").Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// GetPermissionsByNames retrieves the permissions with the given names
+func (rr *RBACRepo) GetPermissionsByNames(names []string) ([]models.Permission, error) {
+	var permissions []models.Permission
+	if err := rr.db.Where("name IN ?", names).Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// CreateRole creates a new role with its permissions
+func (rr *RBACRepo) CreateRole(role *models.Role) error {
+	return rr.db.Create(role).Error
+}
+
+// CreatePermission creates a new permission
+func (rr *RBACRepo) CreatePermission(permission *models.Permission) error {
+	return rr.db.Create(permission).Error
+}
+
+// ReplaceRolePermissions sets the permissions of a role
+func (rr *RBACRepo) ReplaceRolePermissions(role *models.Role, permissions []models.Permission) error {
+	return rr.db.Model(role).Association("Permissions").Replace(permissions)
+}
+
+// AddRolePermissions grants additional permissions to a role
+func (rr *RBACRepo) AddRolePermissions(role *models.Role, permissions []models.Permission) error {
+	return rr.db.Model(role).Association("Permissions").Append(permissions)
+}
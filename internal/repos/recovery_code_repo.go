@@ -0,0 +1,53 @@
+// repos/recovery_code_repo.go
+package repos
+
+import (
+	"Visa/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RecoveryCodeRepo struct {
+	db *gorm.DB
+}
+
+func NewRecoveryCodeRepo(db *gorm.DB) *RecoveryCodeRepo {
+	return &RecoveryCodeRepo{db: db}
+}
+
+// ReplaceCodes deletes every recovery code of a user and stores new ones
+func (rr *RecoveryCodeRepo) ReplaceCodes(userId uint, codes []models.RecoveryCode) error {
+	return rr.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userId).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseCode marks an unused recovery code of a user as used. It returns false
+// if no such code exists.
+func (rr *RecoveryCodeRepo) UseCode(userId uint, codeHash string) (bool, error) {
+	result := rr.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userId, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// CountUnused counts the recovery codes a user has left
+func (rr *RecoveryCodeRepo) CountUnused(userId uint) (int64, error) {
+	var count int64
+	if err := rr.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userId).
+		Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
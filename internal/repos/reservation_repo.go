@@ -3,8 +3,26 @@ package repos
 
 import (
 	"Visa/models"
+	"errors"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrNotEnoughSeats is returned when a flight or fare of a booking is
+	// short of seats
+	ErrNotEnoughSeats = errors.New("not enough seats available")
+	// ErrAlreadyBooked is returned when the user already has a booking of a
+	// flight of a new booking
+	ErrAlreadyBooked = errors.New("flight is already booked by the user")
+	// ErrAlreadyCancelled is returned when a booking being cancelled is no
+	// longer booked
+	ErrAlreadyCancelled = errors.New("booking is already cancelled")
+	// ErrDeparted is returned when a booking is made or cancelled after one
+	// of its flights has left
+	ErrDeparted = errors.New("a flight of the booking has already departed")
 )
 
 type ReservationRepo struct {
@@ -43,6 +61,11 @@ func (rr *ReservationRepo) UpdateReservation(reservation *models.Reservation) er
 	return rr.db.Save(reservation).Error
 }
 
+// UpdateReservationStatus sets the status of a reservation
+func (rr *ReservationRepo) UpdateReservationStatus(id uint, status string) error {
+	return rr.db.Model(&models.Reservation{}).Where("id = ?", id).Update("status", status).Error
+}
+
 // DeleteReservation deletes a reservation by its ID
 func (rr *ReservationRepo) DeleteReservation(id uint) error {
 	return rr.db.Delete(&models.Reservation{}, id).Error
@@ -57,6 +80,229 @@ func (rr *ReservationRepo) GetReservationsByUserId(userId uint) ([]models.Reserv
 	return reservations, nil
 }
 
+// GetActiveFlightReservation retrieves a user's most recent booked reservation
+// of a flight together with its segments and the travellers it names
+func (rr *ReservationRepo) GetActiveFlightReservation(userId, flightId uint) (*models.Reservation, error) {
+	var reservation models.Reservation
+	if err := reservationsOfFlight(rr.db, flightId).
+		Preload("Segments", func(db *gorm.DB) *gorm.DB { return db.Order("sequence") }).
+		Preload("Travellers", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Where("user_id = ? AND status = ?", userId, "booked").
+		Order("id DESC").
+		First(&reservation).Error; err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// GetReservationByReference retrieves one of a user's reservations by its
+// booking reference together with its segments, the travellers it names and
+// their seats
+func (rr *ReservationRepo) GetReservationByReference(userId uint, reference string) (*models.Reservation, error) {
+	var reservation models.Reservation
+	if err := rr.db.
+		Preload("Segments", func(db *gorm.DB) *gorm.DB { return db.Order("sequence") }).
+		Preload("Travellers", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Preload("AssignedSeats", func(db *gorm.DB) *gorm.DB { return db.Order("flight_id, seat_row, letter") }).
+		Where("user_id = ? AND reference = ?", userId, reference).
+		First(&reservation).Error; err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// CreateFlightReservation takes seats for every passenger from the fare of
+// each segment of the reservation and stores it, all in one transaction. If
+// any fare is short of seats or its flight no longer scheduled nothing is
+// booked and ErrNotEnoughSeats is returned, or ErrDeparted if the flight has
+// left by the time its seats are taken. If the user already has a booking
+// of one of the flights ErrAlreadyBooked is returned; the user is locked
+// while booking, so concurrent bookings of the same flight cannot both pass.
+func (rr *ReservationRepo) CreateFlightReservation(reservation *models.Reservation, seats int) error {
+	return rr.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Where("id = ?", reservation.UserID).
+			Take(&models.User{}).Error; err != nil {
+			return err
+		}
+		for _, segment := range reservation.Segments {
+			var booked int64
+			if err := reservationsOfFlight(tx.Model(&models.Reservation{}), segment.FlightID).
+				Where("user_id = ? AND status = ?", reservation.UserID, "booked").
+				Count(&booked).Error; err != nil {
+				return err
+			}
+			if booked > 0 {
+				return ErrAlreadyBooked
+			}
+
+			now := time.Now()
+			result := tx.Model(&models.Flight{}).
+				Where("id = ? AND status = ? AND seats_available >= ? AND departure_at > ?", segment.FlightID, models.FlightStatusScheduled, seats, now).
+				Update("seats_available", gorm.Expr("seats_available - ?", seats))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				var departed int64
+				if err := tx.Model(&models.Flight{}).
+					Where("id = ? AND departure_at <= ?", segment.FlightID, now).
+					Count(&departed).Error; err != nil {
+					return err
+				}
+				if departed > 0 {
+					return ErrDeparted
+				}
+				return ErrNotEnoughSeats
+			}
+			if segment.FareBucketID == nil {
+				continue
+			}
+			result = tx.Model(&models.FareBucket{}).
+				Where("id = ? AND flight_id = ? AND seats_available >= ?", *segment.FareBucketID, segment.FlightID, seats).
+				Update("seats_available", gorm.Expr("seats_available - ?", seats))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrNotEnoughSeats
+			}
+		}
+		return tx.Create(reservation).Error
+	})
+}
+
+// CancelFlightReservation cancels a flight booking at the traveller's request
+// and gives its seats back on every flight that is still scheduled. The
+// price of its non-refundable fares and the seat and change fees paid on
+// those flights are kept and the rest refunded. It returns
+// ErrDeparted if any of its flights has left and ErrAlreadyCancelled if the
+// booking was cancelled first.
+func (rr *ReservationRepo) CancelFlightReservation(reservation *models.Reservation, seats int) error {
+	return rr.db.Transaction(func(tx *gorm.DB) error {
+		var departed int64
+		if err := tx.Model(&models.Flight{}).
+			Where("id IN ? AND departure_at <= ?", reservation.FlightIDs(), time.Now()).
+			Count(&departed).Error; err != nil {
+			return err
+		}
+		if departed > 0 {
+			return ErrDeparted
+		}
+		return cancelFlightReservation(tx, reservation, seats, false)
+	})
+}
+
+// cancelFlightReservation marks a flight booking cancelled with its refund,
+// frees the seats chosen for its passengers and gives its seats back to their
+// fares on those of its flights that are still scheduled. Unless refundAll is
+// set, the price and fees of the segments booked at non-refundable fares are
+// not refunded. Only the caller that moves the booking from booked to
+// cancelled gives seats back; others get ErrAlreadyCancelled. The refund is
+// worked out once the booking is locked by that move, so fees added by a
+// seat change that committed first are included.
+func cancelFlightReservation(tx *gorm.DB, reservation *models.Reservation, seats int, refundAll bool) error {
+	result := tx.Model(&models.Reservation{}).
+		Where("id = ? AND status = ?", reservation.ID, "booked").
+		Update("status", "cancelled")
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAlreadyCancelled
+	}
+	withheld := 0.0
+	if !refundAll {
+		var err error
+		if withheld, err = nonRefundablePrice(tx, reservation.ID, seats); err != nil {
+			return err
+		}
+	}
+	if err := tx.Model(&models.Reservation{}).Where("id = ?", reservation.ID).
+		Update("refund_amount", gorm.Expr("total_price - ?", withheld)).Error; err != nil {
+		return err
+	}
+	if err := tx.Select("status", "total_price", "refund_amount").First(reservation, reservation.ID).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.FlightSeat{}).Where("reservation_id = ?", reservation.ID).Updates(map[string]interface{}{
+		"reservation_id": nil,
+		"traveller_id":   0,
+	}).Error; err != nil {
+		return err
+	}
+	segments := reservation.Segments
+	if len(segments) == 0 {
+		for _, flightId := range reservation.FlightIDs() {
+			segments = append(segments, models.ReservationFlight{FlightID: flightId})
+		}
+	}
+	for _, segment := range segments {
+		result := tx.Model(&models.Flight{}).
+			Where("id = ? AND status = ?", segment.FlightID, models.FlightStatusScheduled).
+			Update("seats_available", gorm.Expr("seats_available + ?", seats))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 || segment.FareBucketID == nil {
+			continue
+		}
+		if err := tx.Model(&models.FareBucket{}).
+			Where("id = ?", *segment.FareBucketID).
+			Update("seats_available", gorm.Expr("seats_available + ?", seats)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nonRefundablePrice is what the segments of a flight booking booked at
+// non-refundable fares cost for all of its seats, together with the seat and
+// change fees paid on them. Segments booked before fares existed are
+// refundable.
+func nonRefundablePrice(tx *gorm.DB, reservationId uint, seats int) (float64, error) {
+	var segments []models.ReservationFlight
+	if err := tx.Where("reservation_id = ? AND fare_bucket_id IS NOT NULL", reservationId).
+		Find(&segments).Error; err != nil {
+		return 0, err
+	}
+	if len(segments) == 0 {
+		return 0, nil
+	}
+	fareIds := make([]uint, len(segments))
+	for i, segment := range segments {
+		fareIds[i] = *segment.FareBucketID
+	}
+
+	var nonRefundable []uint
+	if err := tx.Model(&models.FareBucket{}).
+		Where("id IN ? AND refundable = ?", fareIds, false).
+		Pluck("id", &nonRefundable).Error; err != nil {
+		return 0, err
+	}
+	withheld := 0.0
+	for _, segment := range segments {
+		for _, id := range nonRefundable {
+			if *segment.FareBucketID == id {
+				withheld += segment.Price*float64(seats) + segment.Fees
+				break
+			}
+		}
+	}
+	return withheld, nil
+}
+
+// reservationsOfFlight narrows a query to the reservations that include a
+// flight, either as their only flight or as one of their segments
+func reservationsOfFlight(db *gorm.DB, flightId uint) *gorm.DB {
+	segments := db.Session(&gorm.Session{NewDB: true}).
+		Model(&models.ReservationFlight{}).
+		Select("reservation_id").
+		Where("flight_id = ?", flightId)
+	return db.Where("(flight_id = ? OR id IN (?))", flightId, segments)
+}
+
 // GetReservationsByHotelId retrieves all reservations for a specific hotel
 func (rr *ReservationRepo) GetReservationsByHotelId(hotelId uint) ([]models.Reservation, error) {
 	var reservations []models.Reservation
@@ -0,0 +1,225 @@
+// repos/seat_map_repo.go
+package repos
+
+import (
+	"Visa/models"
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrSeatsAssigned is returned when a flight's seats cannot be replaced
+	// because passengers hold some of them
+	ErrSeatsAssigned = errors.New("seats are assigned to passengers")
+	// ErrSeatMapExists is returned when another seat map has the same name
+	ErrSeatMapExists = errors.New("seat map name is taken")
+	// ErrSeatTaken is returned when a seat was taken by someone else first
+	ErrSeatTaken = errors.New("seat is already taken")
+	// ErrSeatChangeNotAllowed is returned when a passenger who has a seat
+	// tries to move but their fare does not allow changes
+	ErrSeatChangeNotAllowed = errors.New("seat changes are not allowed")
+)
+
+// SeatChange is what the fare of a passenger who already has a seat on a
+// flight allows: whether they may move to another seat and the fee charged
+// for moving
+type SeatChange struct {
+	Allowed bool
+	Fee     float64
+}
+
+type SeatMapRepo struct {
+	db *gorm.DB
+}
+
+func NewSeatMapRepo(db *gorm.DB) *SeatMapRepo {
+	return &SeatMapRepo{db: db}
+}
+
+// CreateSeatMap creates a seat map together with its seats. It returns
+// ErrSeatMapExists if another seat map has the same name.
+func (sr *SeatMapRepo) CreateSeatMap(seatMap *models.SeatMap) error {
+	err := sr.db.Create(seatMap).Error
+	if isDuplicateKey(sr.db, err) {
+		return ErrSeatMapExists
+	}
+	return err
+}
+
+// isDuplicateKey reports whether err is a unique index violation. The
+// driver's error is translated here rather than for every query so that
+// callers elsewhere keep seeing the driver's own errors.
+func isDuplicateKey(db *gorm.DB, err error) bool {
+	if err == nil {
+		return false
+	}
+	if translator, ok := db.Dialector.(gorm.ErrorTranslator); ok {
+		err = translator.Translate(err)
+	}
+	return errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
+// GetSeatMaps retrieves all seat maps without their seats
+func (sr *SeatMapRepo) GetSeatMaps() ([]models.SeatMap, error) {
+	var seatMaps []models.SeatMap
+	if err := sr.db.Order("name").Find(&seatMaps).Error; err != nil {
+		return nil, err
+	}
+	return seatMaps, nil
+}
+
+// GetSeatMapById retrieves a seat map by its ID with its seats in row order
+func (sr *SeatMapRepo) GetSeatMapById(id uint) (*models.SeatMap, error) {
+	var seatMap models.SeatMap
+	if err := sr.db.
+		Preload("Seats", func(db *gorm.DB) *gorm.DB { return db.Order("seat_row, letter") }).
+		First(&seatMap, id).Error; err != nil {
+		return nil, err
+	}
+	return &seatMap, nil
+}
+
+// GenerateFlightSeats replaces the seats of a flight with a copy of the seats
+// of a seat map and records the seat map on the flight, in one transaction.
+// It returns ErrSeatsAssigned if any seat of the flight is taken. The flight
+// stays locked until its seats are replaced, so a seat assignment either
+// comes first and is counted or waits for the new seats.
+func (sr *SeatMapRepo) GenerateFlightSeats(flightId uint, seatMap *models.SeatMap) ([]models.FlightSeat, error) {
+	seats := make([]models.FlightSeat, len(seatMap.Seats))
+	for i, seat := range seatMap.Seats {
+		seats[i] = models.FlightSeat{FlightID: flightId, Number: seat.Number, Seat: seat.Seat}
+	}
+
+	err := sr.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockFlight(tx, flightId); err != nil {
+			return err
+		}
+
+		var assigned int64
+		if err := tx.Model(&models.FlightSeat{}).
+			Where("flight_id = ? AND reservation_id IS NOT NULL", flightId).
+			Count(&assigned).Error; err != nil {
+			return err
+		}
+		if assigned > 0 {
+			return ErrSeatsAssigned
+		}
+		if err := tx.Where("flight_id = ?", flightId).Delete(&models.FlightSeat{}).Error; err != nil {
+			return err
+		}
+		if err := tx.CreateInBatches(seats, 200).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Flight{}).Where("id = ?", flightId).Update("seat_map_id", seatMap.ID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return seats, nil
+}
+
+// lockFlight locks the row of a flight until the transaction ends
+func lockFlight(tx *gorm.DB, flightId uint) error {
+	var flight models.Flight
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&flight, flightId).Error
+}
+
+// GetFlightSeats retrieves the seats of a flight in row order
+func (sr *SeatMapRepo) GetFlightSeats(flightId uint) ([]models.FlightSeat, error) {
+	var seats []models.FlightSeat
+	if err := sr.db.Where("flight_id = ?", flightId).Order("seat_row, letter").Find(&seats).Error; err != nil {
+		return nil, err
+	}
+	return seats, nil
+}
+
+// GetFlightSeat retrieves a seat of a flight by its number
+func (sr *SeatMapRepo) GetFlightSeat(flightId uint, number string) (*models.FlightSeat, error) {
+	var seat models.FlightSeat
+	if err := sr.db.Where("flight_id = ? AND number = ?", flightId, number).First(&seat).Error; err != nil {
+		return nil, err
+	}
+	return &seat, nil
+}
+
+// AssignSeat gives a passenger of a reservation a seat on a flight in one
+// transaction: the passenger's current seat on the flight is released, the
+// new seat is taken if it is still free, and the reservation's total price
+// and the fees of its segment on the flight move by the difference in seat
+// fees. A passenger moving from one seat to
+// another also pays the change fee, if change allows the move at all. It
+// returns ErrSeatTaken if the seat was taken first,
+// ErrSeatChangeNotAllowed if the passenger may not move and
+// ErrAlreadyCancelled if the reservation was cancelled first. The reservation
+// stays locked until the seat is assigned, so a cancellation either frees
+// the seat and refunds its fee or waits for the assignment. So does the
+// flight, so that its seats are not replaced under the assignment.
+func (sr *SeatMapRepo) AssignSeat(reservationId, flightId, travellerId uint, number string, change SeatChange) (*models.FlightSeat, error) {
+	var seat models.FlightSeat
+	err := sr.db.Transaction(func(tx *gorm.DB) error {
+		var reservation models.Reservation
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "status").First(&reservation, reservationId).Error; err != nil {
+			return err
+		}
+		if reservation.Status != "booked" {
+			return ErrAlreadyCancelled
+		}
+		if err := lockFlight(tx, flightId); err != nil {
+			return err
+		}
+
+		var current models.FlightSeat
+		changeFee := 0.0
+		err := tx.Where("flight_id = ? AND reservation_id = ? AND traveller_id = ?", flightId, reservationId, travellerId).
+			First(&current).Error
+		switch {
+		case err == nil:
+			if !change.Allowed {
+				return ErrSeatChangeNotAllowed
+			}
+			changeFee = change.Fee
+			if err := tx.Model(&models.FlightSeat{}).Where("id = ?", current.ID).Updates(map[string]interface{}{
+				"reservation_id": nil,
+				"traveller_id":   0,
+			}).Error; err != nil {
+				return err
+			}
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return err
+		}
+
+		result := tx.Model(&models.FlightSeat{}).
+			Where("flight_id = ? AND number = ? AND blocked = ? AND reservation_id IS NULL", flightId, number, false).
+			Updates(map[string]interface{}{
+				"reservation_id": reservationId,
+				"traveller_id":   travellerId,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrSeatTaken
+		}
+		if err := tx.Where("flight_id = ? AND number = ?", flightId, number).First(&seat).Error; err != nil {
+			return err
+		}
+
+		fee := seat.Fee - current.Fee + changeFee
+		if fee == 0 {
+			return nil
+		}
+		if err := tx.Model(&models.ReservationFlight{}).Where("reservation_id = ? AND flight_id = ?", reservationId, flightId).
+			Update("fees", gorm.Expr("fees + ?", fee)).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Reservation{}).Where("id = ?", reservationId).
+			Update("total_price", gorm.Expr("total_price + ?", fee)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &seat, nil
+}
@@ -0,0 +1,68 @@
+// repos/session_repo.go
+package repos
+
+import (
+	"Visa/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type SessionRepo struct {
+	db *gorm.DB
+}
+
+func NewSessionRepo(db *gorm.DB) *SessionRepo {
+	return &SessionRepo{db: db}
+}
+
+// CreateSession stores a new session
+func (sr *SessionRepo) CreateSession(session *models.Session) error {
+	return sr.db.Create(session).Error
+}
+
+// GetSessionById retrieves a session by its ID
+func (sr *SessionRepo) GetSessionById(id uint) (*models.Session, error) {
+	var session models.Session
+	if err := sr.db.First(&session, id).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetActiveSessionsByUser retrieves the sessions of a user that have not ended, most recently used first
+func (sr *SessionRepo) GetActiveSessionsByUser(userId uint) ([]models.Session, error) {
+	var sessions []models.Session
+	if err := sr.db.Where("user_id = ? AND ended_at IS NULL", userId).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// TouchSession records activity on a session. The update is skipped if the
+// session was already seen after notBefore, which keeps writes infrequent.
+func (sr *SessionRepo) TouchSession(id uint, ip string, seenAt, notBefore time.Time) error {
+	updates := map[string]interface{}{"last_seen_at": seenAt}
+	if ip != "" {
+		updates["ip"] = ip
+	}
+	return sr.db.Model(&models.Session{}).
+		Where("id = ? AND last_seen_at < ?", id, notBefore).
+		Updates(updates).Error
+}
+
+// EndSession marks a session as ended
+func (sr *SessionRepo) EndSession(id uint) error {
+	return sr.db.Model(&models.Session{}).
+		Where("id = ? AND ended_at IS NULL", id).
+		Update("ended_at", time.Now()).Error
+}
+
+// EndSessionsByUser ends every active session of a user except exceptId (0 ends all)
+func (sr *SessionRepo) EndSessionsByUser(userId, exceptId uint) error {
+	return sr.db.Model(&models.Session{}).
+		Where("user_id = ? AND id <> ? AND ended_at IS NULL", userId, exceptId).
+		Update("ended_at", time.Now()).Error
+}
@@ -0,0 +1,94 @@
+// repos/token_repo.go
+package repos
+
+import (
+	"Visa/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type TokenRepo struct {
+	db *gorm.DB
+}
+
+func NewTokenRepo(db *gorm.DB) *TokenRepo {
+	return &TokenRepo{db: db}
+}
+
+// CreateRefreshToken stores a new refresh token
+func (tr *TokenRepo) CreateRefreshToken(token *models.RefreshToken) error {
+	return tr.db.Create(token).Error
+}
+
+// GetRefreshTokenByHash retrieves a refresh token by the hash of its value
+func (tr *TokenRepo) GetRefreshTokenByHash(hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	if err := tr.db.Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RevokeRefreshToken revokes a refresh token unless it already was. It
+// reports whether this call revoked it, so of several concurrent callers
+// presenting the same token only one gets true.
+func (tr *TokenRepo) RevokeRefreshToken(id uint, at time.Time) (bool, error) {
+	result := tr.db.Model(&models.RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", at)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RotateRefreshToken revokes a refresh token in exchange for its replacement
+// unless it already was revoked. It reports whether this call rotated it, so
+// of several concurrent callers presenting the same token only one gets true.
+func (tr *TokenRepo) RotateRefreshToken(id, replacementId uint, at time.Time) (bool, error) {
+	result := tr.db.Model(&models.RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Updates(map[string]interface{}{"revoked_at": at, "replaced_by_id": replacementId})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RevokeRefreshTokensByUser revokes every active refresh token of a user
+func (tr *TokenRepo) RevokeRefreshTokensByUser(userId uint) error {
+	return tr.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userId).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeRefreshTokensBySession revokes every active refresh token of a session
+func (tr *TokenRepo) RevokeRefreshTokensBySession(sessionId uint) error {
+	return tr.db.Model(&models.RefreshToken{}).
+		Where("session_id = ? AND revoked_at IS NULL", sessionId).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeAccessToken adds an access token ID to the revocation list
+func (tr *TokenRepo) RevokeAccessToken(token *models.RevokedToken) error {
+	return tr.db.Create(token).Error
+}
+
+// IsAccessTokenRevoked checks if an access token ID has been revoked
+func (tr *TokenRepo) IsAccessTokenRevoked(jti string) (bool, error) {
+	var count int64
+	if err := tr.db.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// DeleteExpired removes refresh tokens and revocation entries that have expired
+func (tr *TokenRepo) DeleteExpired() error {
+	now := time.Now()
+	if err := tr.db.Where("expires_at < ?", now).Delete(&models.RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return tr.db.Where("expires_at < ?", now).Delete(&models.RefreshToken{}).Error
+}
@@ -0,0 +1,89 @@
+// repos/traveller_repo.go
+package repos
+
+import (
+	"Visa/models"
+
+	"gorm.io/gorm"
+)
+
+type TravellerRepo struct {
+	db *gorm.DB
+}
+
+func NewTravellerRepo(db *gorm.DB) *TravellerRepo {
+	return &TravellerRepo{db: db}
+}
+
+// GetTravellersByUser retrieves the travellers of a user with their loyalty numbers
+func (tr *TravellerRepo) GetTravellersByUser(userId uint) ([]models.Traveller, error) {
+	var travellers []models.Traveller
+	if err := tr.db.Preload("LoyaltyNumbers").
+		Where("user_id = ?", userId).
+		Order("id").
+		Find(&travellers).Error; err != nil {
+		return nil, err
+	}
+	return travellers, nil
+}
+
+// GetTravellerById retrieves a traveller of a user by its ID
+func (tr *TravellerRepo) GetTravellerById(userId, id uint) (*models.Traveller, error) {
+	var traveller models.Traveller
+	if err := tr.db.Preload("LoyaltyNumbers").
+		Where("user_id = ?", userId).
+		First(&traveller, id).Error; err != nil {
+		return nil, err
+	}
+	return &traveller, nil
+}
+
+// GetTravellersByIds retrieves the travellers of a user with the given IDs
+func (tr *TravellerRepo) GetTravellersByIds(userId uint, ids []uint) ([]models.Traveller, error) {
+	var travellers []models.Traveller
+	if err := tr.db.Where("user_id = ? AND id IN ?", userId, ids).Find(&travellers).Error; err != nil {
+		return nil, err
+	}
+	return travellers, nil
+}
+
+// CountTravellersByRelationship counts a user's travellers with the given relationship
+func (tr *TravellerRepo) CountTravellersByRelationship(userId uint, relationship string, excludeId uint) (int64, error) {
+	var count int64
+	if err := tr.db.Model(&models.Traveller{}).
+		Where("user_id = ? AND relationship = ? AND id <> ?", userId, relationship, excludeId).
+		Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// CreateTraveller stores a traveller and its loyalty numbers
+func (tr *TravellerRepo) CreateTraveller(traveller *models.Traveller) error {
+	return tr.db.Create(traveller).Error
+}
+
+// UpdateTraveller saves a traveller and replaces its loyalty numbers
+func (tr *TravellerRepo) UpdateTraveller(traveller *models.Traveller) error {
+	return tr.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("LoyaltyNumbers").Save(traveller).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("traveller_id = ?", traveller.ID).Delete(&models.LoyaltyNumber{}).Error; err != nil {
+			return err
+		}
+		for i := range traveller.LoyaltyNumbers {
+			traveller.LoyaltyNumbers[i].ID = 0
+			traveller.LoyaltyNumbers[i].TravellerID = traveller.ID
+		}
+		if len(traveller.LoyaltyNumbers) == 0 {
+			return nil
+		}
+		return tx.Create(&traveller.LoyaltyNumbers).Error
+	})
+}
+
+// DeleteTraveller removes a traveller. Reservations naming the traveller keep it.
+func (tr *TravellerRepo) DeleteTraveller(id uint) error {
+	return tr.db.Delete(&models.Traveller{}, id).Error
+}
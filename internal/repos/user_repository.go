@@ -3,10 +3,17 @@ package repos
 
 import (
 	"Visa/models"
+	"errors"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// ErrLastAdmin is returned when a change would leave no admin who can sign in
+var ErrLastAdmin = errors.New("cannot remove the last admin")
+
 type UserRepo struct {
 	db *gorm.DB
 }
@@ -24,6 +31,68 @@ func (ur *UserRepo) GetAllUsers() ([]models.User, error) {
 	return users, nil
 }
 
+// UserFilter narrows down a user search. Zero values are ignored.
+type UserFilter struct {
+	// Query matches part of the name or email
+	Query string
+	Role  string
+	// Status is "active", "suspended" or "erased"
+	Status        string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Offset        int
+	Limit         int
+}
+
+// SearchUsers retrieves a page of users matching the filter, newest first,
+// and the number of matching users
+func (ur *UserRepo) SearchUsers(filter UserFilter) ([]models.User, int64, error) {
+	query := ur.db.Model(&models.User{})
+	if filter.Query != "" {
+		pattern := "%" + escapeLike(filter.Query) + "%"
+		query = query.Where("name LIKE ? OR email LIKE ?", pattern, pattern)
+	}
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	switch filter.Status {
+	case "active":
+		query = query.Where("suspended_at IS NULL AND erased_at IS NULL")
+	case "suspended":
+		query = query.Where("suspended_at IS NOT NULL AND erased_at IS NULL")
+	case "erased":
+		query = query.Where("erased_at IS NOT NULL")
+	}
+	if !filter.CreatedAfter.IsZero() {
+		query = query.Where("created_at >= ?", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		query = query.Where("created_at < ?", filter.CreatedBefore)
+	}
+
+	// Count and Find each start from the filtered query
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []models.User
+	if err := query.Order("created_at DESC, id DESC").
+		Offset(filter.Offset).
+		Limit(filter.Limit).
+		Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
 // GetUserById retrieves a user by their ID
 func (ur *UserRepo) GetUserById(id uint) (*models.User, error) {
 	var user models.User
@@ -47,9 +116,139 @@ func (ur *UserRepo) CreateUser(user *models.User) error {
 	return ur.db.Create(user).Error
 }
 
-// UpdateUser updates an existing user
-func (ur *UserRepo) UpdateUser(user *models.User) error {
-	return ur.db.Save(user).Error
+// UpdateColumns writes only the given columns of a user, so that changes
+// other requests made to the rest of the row in the meantime are kept
+func (ur *UserRepo) UpdateColumns(id uint, columns map[string]interface{}) error {
+	return ur.db.Model(&models.User{}).Where("id = ?", id).Updates(columns).Error
+}
+
+// SetTokensRevokedAt sets the time before which a user's access tokens are rejected
+func (ur *UserRepo) SetTokensRevokedAt(id uint, revokedAt time.Time) error {
+	return ur.db.Model(&models.User{}).Where("id = ?", id).Update("tokens_revoked_at", revokedAt).Error
+}
+
+// MarkEmailVerified sets the email verification time of a user
+func (ur *UserRepo) MarkEmailVerified(id uint, verifiedAt time.Time) error {
+	return ur.db.Model(&models.User{}).Where("id = ?", id).Update("email_verified_at", verifiedAt).Error
+}
+
+// ClaimUnverifiedAccount verifies the email of a user that has not verified
+// it yet and removes what the registrant set up: the password is replaced
+// and two-factor authentication is turned off. It returns false if the email
+// was verified in the meantime, in which case nothing is changed.
+func (ur *UserRepo) ClaimUnverifiedAccount(id uint, passwordHash string, verifiedAt time.Time) (bool, error) {
+	result := ur.db.Model(&models.User{}).
+		Where("id = ? AND email_verified_at IS NULL", id).
+		Updates(map[string]interface{}{
+			"email_verified_at":       verifiedAt,
+			"password":                passwordHash,
+			"password_reset_required": false,
+			"two_factor_enabled":      false,
+			"two_factor_secret":       "",
+			"two_factor_last_step":    0,
+			"failed_login_count":      0,
+			"locked_until":            nil,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// AdvanceTwoFactorStep records the time step of a used TOTP code. It returns
+// false if a code from the same or a later step was already used.
+func (ur *UserRepo) AdvanceTwoFactorStep(id uint, step int64) (bool, error) {
+	result := ur.db.Model(&models.User{}).
+		Where("id = ? AND two_factor_last_step < ?", id, step).
+		Update("two_factor_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// IncrementFailedLogins adds one to a user's failed login count and returns the new count
+func (ur *UserRepo) IncrementFailedLogins(id uint) (int, error) {
+	var count int
+	err := ur.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", id).
+			UpdateColumn("failed_login_count", gorm.Expr("failed_login_count + 1")).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", id).Select("failed_login_count").Scan(&count).Error
+	})
+	return count, err
+}
+
+// LockUser blocks sign-ins for a user until the given time
+func (ur *UserRepo) LockUser(id uint, until time.Time) error {
+	return ur.db.Model(&models.User{}).Where("id = ?", id).Update("locked_until", until).Error
+}
+
+// SuspendUser blocks a user until reactivated. It returns ErrLastAdmin if
+// the user is the only admin who can sign in.
+func (ur *UserRepo) SuspendUser(id uint, reason string, suspendedAt time.Time) error {
+	return ur.db.Transaction(func(tx *gorm.DB) error {
+		if err := keepAnotherAdmin(tx, id); err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", id).
+			Updates(map[string]interface{}{"suspended_at": suspendedAt, "suspension_reason": reason}).Error
+	})
+}
+
+// UpdateRole gives a user a new role. It returns ErrLastAdmin if that takes
+// the admin role from the only admin who can sign in.
+func (ur *UserRepo) UpdateRole(id uint, role string) error {
+	return ur.db.Transaction(func(tx *gorm.DB) error {
+		if role != "admin" {
+			if err := keepAnotherAdmin(tx, id); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.User{}).Where("id = ?", id).Update("role", role).Error
+	})
+}
+
+// keepAnotherAdmin returns ErrLastAdmin if the user is the only admin who is
+// neither suspended nor erased. Those admins stay locked until the
+// transaction ends, so two admins removing each other at the same time are
+// checked one after the other and the second one is refused.
+func keepAnotherAdmin(tx *gorm.DB, userId uint) error {
+	var admins []uint
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Model(&models.User{}).
+		Where("role = ? AND suspended_at IS NULL AND erased_at IS NULL", "admin").
+		Order("id").
+		Pluck("id", &admins).Error; err != nil {
+		return err
+	}
+	if len(admins) == 1 && admins[0] == userId {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// ReactivateUser lifts a user's suspension
+func (ur *UserRepo) ReactivateUser(id uint) error {
+	return ur.db.Model(&models.User{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"suspended_at": nil, "suspension_reason": ""}).Error
+}
+
+// SetPasswordResetRequired sets whether a user must reset their password
+// before signing in with it again
+func (ur *UserRepo) SetPasswordResetRequired(id uint, required bool) error {
+	return ur.db.Model(&models.User{}).Where("id = ?", id).Update("password_reset_required", required).Error
+}
+
+// ResetFailedLogins clears a user's failed login count and lockout
+func (ur *UserRepo) ResetFailedLogins(id uint) error {
+	return ur.db.Model(&models.User{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"failed_login_count": 0, "locked_until": nil}).Error
+}
+
+// UpdatePassword replaces a user's password hash
+func (ur *UserRepo) UpdatePassword(id uint, hash string) error {
+	return ur.db.Model(&models.User{}).Where("id = ?", id).Update("password", hash).Error
 }
 
 // DeleteUser deletes a user by their ID
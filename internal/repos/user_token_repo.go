@@ -0,0 +1,60 @@
+// repos/user_token_repo.go
+package repos
+
+import (
+	"Visa/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type UserTokenRepo struct {
+	db *gorm.DB
+}
+
+func NewUserTokenRepo(db *gorm.DB) *UserTokenRepo {
+	return &UserTokenRepo{db: db}
+}
+
+// CreateToken stores a new one-time token
+func (tr *UserTokenRepo) CreateToken(token *models.UserToken) error {
+	return tr.db.Create(token).Error
+}
+
+// GetTokenByHash retrieves a one-time token by its purpose and hash
+func (tr *UserTokenRepo) GetTokenByHash(purpose, hash string) (*models.UserToken, error) {
+	var token models.UserToken
+	if err := tr.db.Where("purpose = ? AND token_hash = ?", purpose, hash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// CountTokensSince counts the tokens of a user for a purpose created after a given time
+func (tr *UserTokenRepo) CountTokensSince(userId uint, purpose string, since time.Time) (int64, error) {
+	var count int64
+	if err := tr.db.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND created_at > ?", userId, purpose, since).
+		Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// MarkUsed marks a token as used. It returns false if the token was already used.
+func (tr *UserTokenRepo) MarkUsed(id uint) (bool, error) {
+	result := tr.db.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// InvalidateTokens marks every unused token of a user for a purpose as used
+func (tr *UserTokenRepo) InvalidateTokens(userId uint, purpose string) error {
+	return tr.db.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userId, purpose).
+		Update("used_at", time.Now()).Error
+}
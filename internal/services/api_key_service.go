@@ -0,0 +1,180 @@
+// services/api_key_service.go
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyPrefix           = "tck_"
+	apiKeyDefaultRateLimit = 60
+	apiKeyMaxRateLimit     = 600
+	apiKeyMaxPerUser       = 10
+
+	// A key's last use is updated at most once per interval
+	apiKeyTouchInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey        = errors.New("invalid, expired or revoked API key")
+	ErrAPIKeyNotFound       = errors.New("API key not found")
+	ErrInvalidScope         = errors.New("invalid API key scope")
+	ErrInvalidAPIKeyOptions = errors.New("invalid API key options")
+	ErrTooManyAPIKeys       = errors.New("too many API keys")
+)
+
+// validScopes are the scopes an API key can be granted
+var validScopes = map[string]bool{
+	models.ScopeProfileRead:   true,
+	models.ScopeBookingsRead:  true,
+	models.ScopeBookingsWrite: true,
+	models.ScopeVisasRead:     true,
+	models.ScopeVisasWrite:    true,
+	models.ScopeSupportRead:   true,
+	models.ScopeSupportWrite:  true,
+}
+
+// APIKeyView is an API key as shown to its owner
+type APIKeyView struct {
+	*models.APIKey
+	GrantedScopes []string `json:"scopes"`
+}
+
+// NewAPIKeyView wraps an API key for JSON responses
+func NewAPIKeyView(key *models.APIKey) APIKeyView {
+	return APIKeyView{APIKey: key, GrantedScopes: key.ScopeList()}
+}
+
+type APIKeyService struct {
+	Repo     *repos.APIKeyRepo
+	UserRepo *repos.UserRepo
+}
+
+func NewAPIKeyService(apiKeyRepo *repos.APIKeyRepo, userRepo *repos.UserRepo) *APIKeyService {
+	return &APIKeyService{
+		Repo:     apiKeyRepo,
+		UserRepo: userRepo,
+	}
+}
+
+// CreateAPIKey creates a key for a user and returns it with its secret
+// value, which is only available at this point. rateLimit is in requests per
+// minute; 0 selects the default.
+func (ks *APIKeyService) CreateAPIKey(userId uint, name string, scopes []string, rateLimit int, expiresAt *time.Time) (*models.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", fmt.Errorf("%w: name is required", ErrInvalidAPIKeyOptions)
+	}
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	seen := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		if !validScopes[scope] {
+			return nil, "", fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+		seen[scope] = true
+	}
+	if rateLimit == 0 {
+		rateLimit = apiKeyDefaultRateLimit
+	}
+	if rateLimit < 0 || rateLimit > apiKeyMaxRateLimit {
+		return nil, "", fmt.Errorf("%w: rate limit must be between 1 and %d requests per minute", ErrInvalidAPIKeyOptions, apiKeyMaxRateLimit)
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", fmt.Errorf("%w: expiry must be in the future", ErrInvalidAPIKeyOptions)
+	}
+
+	count, err := ks.Repo.CountActiveAPIKeys(userId)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to count API keys: %w", err)
+	}
+	if count >= apiKeyMaxPerUser {
+		return nil, "", ErrTooManyAPIKeys
+	}
+
+	secret, err := randomToken(24)
+	if err != nil {
+		return nil, "", err
+	}
+	value := apiKeyPrefix + secret
+
+	granted := make([]string, 0, len(seen))
+	for scope := range seen {
+		granted = append(granted, scope)
+	}
+	sort.Strings(granted)
+
+	key := &models.APIKey{
+		UserID:    userId,
+		Name:      name,
+		Prefix:    value[:len(apiKeyPrefix)+8],
+		KeyHash:   hashToken(value),
+		Scopes:    strings.Join(granted, ","),
+		RateLimit: rateLimit,
+		ExpiresAt: expiresAt,
+	}
+	if err := ks.Repo.CreateAPIKey(key); err != nil {
+		return nil, "", fmt.Errorf("failed to store API key: %w", err)
+	}
+	return key, value, nil
+}
+
+// GetAPIKeys lists a user's API keys that have not been revoked
+func (ks *APIKeyService) GetAPIKeys(userId uint) ([]models.APIKey, error) {
+	keys, err := ks.Repo.GetAPIKeysByUser(userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes one of a user's API keys
+func (ks *APIKeyService) RevokeAPIKey(userId, keyId uint) error {
+	key, err := ks.Repo.GetAPIKeyById(keyId)
+	if err != nil || key.UserID != userId || key.RevokedAt != nil {
+		return ErrAPIKeyNotFound
+	}
+	if err := ks.Repo.RevokeAPIKey(key.ID); err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	return nil
+}
+
+// Authenticate resolves an API key value to the key and its user and
+// records its use
+func (ks *APIKeyService) Authenticate(value, ip string) (*models.APIKey, *models.User, error) {
+	if !strings.HasPrefix(value, apiKeyPrefix) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	key, err := ks.Repo.GetAPIKeyByHash(hashToken(value))
+	if err != nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	user, err := ks.UserRepo.GetUserById(key.UserID)
+	if err != nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if user.SuspendedAt != nil {
+		return nil, nil, ErrAccountSuspended
+	}
+
+	if err := ks.Repo.TouchAPIKey(key.ID, ip, now, now.Add(-apiKeyTouchInterval)); err != nil {
+		return nil, nil, fmt.Errorf("failed to record API key use: %w", err)
+	}
+
+	user.Password = ""
+	return key, user, nil
+}
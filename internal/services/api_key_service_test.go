@@ -0,0 +1,131 @@
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"Visa/pkg"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCreateAPIKeyScopes(t *testing.T) {
+	db := newTestDB(t)
+	ks := NewAPIKeyService(repos.NewAPIKeyRepo(db), repos.NewUserRepo(db))
+	user := createTestUser(t, db, "developer@example.com", "user")
+
+	key, value, err := ks.CreateAPIKey(user.ID, "ci", []string{models.ScopeVisasRead, models.ScopeBookingsRead, models.ScopeVisasRead}, 0, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if want := []string{models.ScopeBookingsRead, models.ScopeVisasRead}; !reflect.DeepEqual(key.ScopeList(), want) {
+		t.Errorf("scopes = %v, want %v", key.ScopeList(), want)
+	}
+	if key.RateLimit != apiKeyDefaultRateLimit {
+		t.Errorf("rate limit = %d, want the default %d", key.RateLimit, apiKeyDefaultRateLimit)
+	}
+
+	authenticated, _, err := ks.Authenticate(value, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if !authenticated.HasScope(models.ScopeBookingsRead) || authenticated.HasScope(models.ScopeBookingsWrite) {
+		t.Errorf("authenticated key has scopes %v", authenticated.ScopeList())
+	}
+
+	tests := []struct {
+		name      string
+		scopes    []string
+		rateLimit int
+		want      error
+	}{
+		{"no scopes", nil, 0, ErrInvalidScope},
+		{"unknown scope", []string{models.ScopeVisasRead, "admin"}, 0, ErrInvalidScope},
+		{"negative rate limit", []string{models.ScopeVisasRead}, -1, ErrInvalidAPIKeyOptions},
+		{"rate limit too high", []string{models.ScopeVisasRead}, apiKeyMaxRateLimit + 1, ErrInvalidAPIKeyOptions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ks.CreateAPIKey(user.ID, "ci", tt.scopes, tt.rateLimit, nil); !errors.Is(err, tt.want) {
+				t.Errorf("CreateAPIKey = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	db := newTestDB(t)
+	ks := NewAPIKeyService(repos.NewAPIKeyRepo(db), repos.NewUserRepo(db))
+	user := createTestUser(t, db, "developer@example.com", "user")
+	scopes := []string{models.ScopeProfileRead}
+
+	revoked, revokedValue, err := ks.CreateAPIKey(user.ID, "revoked", scopes, 0, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if err := ks.RevokeAPIKey(user.ID, revoked.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	if _, _, err := ks.Authenticate(revokedValue, ""); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Authenticate with a revoked key = %v, want %v", err, ErrInvalidAPIKey)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	expiring, expiringValue, err := ks.CreateAPIKey(user.ID, "expiring", scopes, 0, &expiresAt)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if err := db.Model(expiring).Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatalf("expire key: %v", err)
+	}
+	if _, _, err := ks.Authenticate(expiringValue, ""); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Authenticate with an expired key = %v, want %v", err, ErrInvalidAPIKey)
+	}
+
+	_, value, err := ks.CreateAPIKey(user.ID, "active", scopes, 0, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if _, _, err := ks.Authenticate(value+"x", ""); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Authenticate with a wrong key = %v, want %v", err, ErrInvalidAPIKey)
+	}
+	if err := db.Model(user).Update("suspended_at", time.Now()).Error; err != nil {
+		t.Fatalf("suspend user: %v", err)
+	}
+	if _, _, err := ks.Authenticate(value, ""); !errors.Is(err, ErrAccountSuspended) {
+		t.Errorf("Authenticate for a suspended user = %v, want %v", err, ErrAccountSuspended)
+	}
+}
+
+func TestResetPasswordRevokesAPIKeys(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAuthService(t, db)
+	us := NewUserService(as.UserRepo, as, pkg.LogMailer{}, nil)
+	ks := NewAPIKeyService(as.APIKeyRepo, as.UserRepo)
+	user := createTestUser(t, db, "developer@example.com", "user")
+	other := createTestUser(t, db, "partner@example.com", "user")
+
+	_, value, err := ks.CreateAPIKey(user.ID, "partner", []string{models.ScopeProfileRead}, 0, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	_, otherValue, err := ks.CreateAPIKey(other.ID, "partner", []string{models.ScopeProfileRead}, 0, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	token, err := as.IssueUserToken(user.ID, models.TokenPurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueUserToken: %v", err)
+	}
+
+	if err := us.ResetPassword(token, "correct-horse-battery-staple"); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	if _, _, err := ks.Authenticate(value, ""); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Authenticate after a password reset = %v, want %v", err, ErrInvalidAPIKey)
+	}
+	if _, _, err := ks.Authenticate(otherValue, ""); err != nil {
+		t.Errorf("Authenticate with another user's key: %v", err)
+	}
+}
@@ -1,161 +1,722 @@
-	// services/auth_service.go
-	package services
+// services/auth_service.go
+package services
 
-	import (
-		"errors"
-		"os"
-		"time"
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"Visa/pkg"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"time"
 
-		"github.com/golang-jwt/jwt/v5"
-	)
+	"github.com/golang-jwt/jwt/v5"
+)
 
-	// Get JWT secret from environment variable, fallback to default (change in production!)
-	var jwtSecret = []byte(getEnvOrDefault("JWT_SECRET", "your-secret-key-change-this-in-production"))
+const (
+	tokenIssuerName   = "visa-app"
+	challengeTokenTTL = 5 * time.Minute
 
-	// Claims represents the JWT claims structure
-	type Claims struct {
-		UserID uint   `json:"userId"`
-		Email  string `json:"email"`
-		Role   string `json:"role"`
-		jwt.RegisteredClaims
+	// Impersonation tokens cannot be refreshed
+	impersonationTokenTTL = 15 * time.Minute
+
+	// A session's last seen time is updated at most once per interval
+	sessionTouchInterval = time.Minute
+
+	// Expired refresh tokens and revocation entries are deleted once per interval
+	tokenCleanupInterval = time.Hour
+)
+
+// Purposes of signed JWTs
+const (
+	tokenPurposeAccess    = "access"
+	tokenPurposeChallenge = "2fa_challenge"
+)
+
+var (
+	accessTokenTTL  = getDurationOrDefault("ACCESS_TOKEN_TTL", 15*time.Minute)
+	refreshTokenTTL = getDurationOrDefault("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+)
+
+var (
+	ErrInvalidToken        = errors.New("invalid or expired token")
+	ErrTokenRevoked        = errors.New("token has been revoked")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrInvalidUserToken    = errors.New("invalid or expired link")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrAccountSuspended    = errors.New("account has been suspended")
+)
+
+// Claims represents the JWT claims structure
+type Claims struct {
+	UserID  uint   `json:"userId"`
+	Email   string `json:"email"`
+	Role    string `json:"role"`
+	Purpose string `json:"purpose"`
+	// MFA is set when the user completed a second factor at login
+	MFA bool `json:"mfa,omitempty"`
+	// SessionID is the session the token belongs to
+	SessionID uint `json:"sid,omitempty"`
+	// ImpersonatorID is the staff member acting as the user
+	ImpersonatorID uint `json:"imp,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// ClientInfo identifies where a request came from
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// TokenPair is returned to clients after a successful login or refresh
+type TokenPair struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type AuthService struct {
+	Issuer        pkg.TokenIssuer
+	TokenRepo     *repos.TokenRepo
+	UserTokenRepo *repos.UserTokenRepo
+	UserRepo      *repos.UserRepo
+	SessionRepo   *repos.SessionRepo
+	APIKeyRepo    *repos.APIKeyRepo
+}
+
+func NewAuthService(issuer pkg.TokenIssuer, tokenRepo *repos.TokenRepo, userTokenRepo *repos.UserTokenRepo, userRepo *repos.UserRepo, sessionRepo *repos.SessionRepo, apiKeyRepo *repos.APIKeyRepo) *AuthService {
+	return &AuthService{
+		Issuer:        issuer,
+		TokenRepo:     tokenRepo,
+		UserTokenRepo: userTokenRepo,
+		UserRepo:      userRepo,
+		SessionRepo:   sessionRepo,
+		APIKeyRepo:    apiKeyRepo,
 	}
+}
 
-	// GenerateToken generates a JWT token for a user
-	func GenerateToken(userID uint, email, role string) (string, error) {
-		if userID == 0 {
-			return "", errors.New("invalid user ID")
-		}
-		if email == "" {
-			return "", errors.New("email is required")
+// IssueTokens starts a new session for a user on the client's device and
+// returns its access and refresh tokens. mfa records whether the user
+// completed two-factor authentication.
+func (as *AuthService) IssueTokens(user *models.User, mfa bool, client ClientInfo) (*TokenPair, error) {
+	if user == nil || user.ID == 0 {
+		return nil, errors.New("invalid user")
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		UserID:     user.ID,
+		Device:     pkg.DescribeDevice(client.UserAgent),
+		UserAgent:  truncate(client.UserAgent, 255),
+		IP:         client.IP,
+		LastSeenAt: now,
+	}
+	if err := as.SessionRepo.CreateSession(session); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	accessToken, expiresAt, err := as.generateAccessToken(user, mfa, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, _, err := as.createRefreshToken(user.ID, session.ID, mfa)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt,
+	}, nil
+}
+
+// IssueImpersonationToken starts a session in which the staff member
+// actorId acts as user. It returns a short-lived access token without a
+// refresh token, its expiry and the new session's ID.
+func (as *AuthService) IssueImpersonationToken(actorId uint, user *models.User, client ClientInfo) (string, time.Time, uint, error) {
+	if actorId == 0 || user == nil || user.ID == 0 {
+		return "", time.Time{}, 0, errors.New("invalid user")
+	}
+
+	session := &models.Session{
+		UserID:         user.ID,
+		Device:         pkg.DescribeDevice(client.UserAgent),
+		UserAgent:      truncate(client.UserAgent, 255),
+		IP:             client.IP,
+		LastSeenAt:     time.Now(),
+		ImpersonatorID: &actorId,
+	}
+	if err := as.SessionRepo.CreateSession(session); err != nil {
+		return "", time.Time{}, 0, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	claims := &Claims{
+		UserID:         user.ID,
+		Email:          user.Email,
+		Role:           user.Role,
+		Purpose:        tokenPurposeAccess,
+		SessionID:      session.ID,
+		ImpersonatorID: actorId,
+	}
+	token, expiresAt, err := as.signToken(claims, impersonationTokenTTL)
+	if err != nil {
+		return "", time.Time{}, 0, err
+	}
+	return token, expiresAt, session.ID, nil
+}
+
+// ValidateAccessToken validates an access token and checks that it has not
+// been revoked, that its session is still active and that its user still
+// exists and is not suspended
+func (as *AuthService) ValidateAccessToken(tokenString string) (*Claims, error) {
+	claims, err := as.ParseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := as.TokenRepo.IsAccessTokenRevoked(claims.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+
+	user, err := as.UserRepo.GetUserById(claims.UserID)
+	if err != nil {
+		return nil, ErrTokenRevoked
+	}
+	if user.TokensRevokedAt != nil && claims.IssuedAt != nil &&
+		claims.IssuedAt.Time.Before(user.TokensRevokedAt.Truncate(time.Second)) {
+		return nil, ErrTokenRevoked
+	}
+	if user.SuspendedAt != nil {
+		return nil, ErrAccountSuspended
+	}
+
+	session, err := as.SessionRepo.GetSessionById(claims.SessionID)
+	if err != nil || session.UserID != claims.UserID || session.EndedAt != nil {
+		return nil, ErrTokenRevoked
+	}
+	if claims.ImpersonatorID != 0 {
+		if session.ImpersonatorID == nil || *session.ImpersonatorID != claims.ImpersonatorID {
+			return nil, ErrTokenRevoked
 		}
-		if role == "" {
-			return "", errors.New("role is required")
+		// Impersonation ends when the staff member's account is deleted or suspended
+		actor, err := as.UserRepo.GetUserById(claims.ImpersonatorID)
+		if err != nil || actor.SuspendedAt != nil {
+			return nil, ErrTokenRevoked
 		}
+	}
+	now := time.Now()
+	if err := as.SessionRepo.TouchSession(session.ID, "", now, now.Add(-sessionTouchInterval)); err != nil {
+		return nil, fmt.Errorf("failed to update session: %w", err)
+	}
 
-		// Create claims with user information
-		claims := Claims{
-			UserID: userID,
-			Email:  email,
-			Role:   role,
-			RegisteredClaims: jwt.RegisteredClaims{
-				ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // Token expires in 24 hours
-				IssuedAt:  jwt.NewNumericDate(time.Now()),
-				NotBefore: jwt.NewNumericDate(time.Now()),
-				Issuer:    "visa-app",
-			},
-		}
+	return claims, nil
+}
 
-		// Create token with claims
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+// Refresh exchanges a refresh token for a new token pair in the same session.
+// The old refresh token is revoked; presenting it again, even concurrently
+// with the exchange, ends every session of the user. Tokens revoked by
+// signing out or ending a session are merely refused.
+func (as *AuthService) Refresh(refreshToken string, client ClientInfo) (*TokenPair, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
 
-		// Sign token with secret key
-		signedToken, err := token.SignedString(jwtSecret)
-		if err != nil {
-			return "", errors.New("failed to sign token")
-		}
+	stored, err := as.TokenRepo.GetRefreshTokenByHash(hashToken(refreshToken))
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
 
-		return signedToken, nil
+	if stored.RevokedAt != nil {
+		return nil, as.rejectRevokedRefreshToken(stored)
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
 	}
 
-	// ValidateToken validates a JWT token and returns the claims
-	func ValidateToken(tokenString string) (*Claims, error) {
-		if tokenString == "" {
-			return nil, errors.New("token is required")
-		}
+	session, err := as.SessionRepo.GetSessionById(stored.SessionID)
+	if err != nil || session.EndedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, err := as.UserRepo.GetUserById(stored.UserID)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if user.SuspendedAt != nil {
+		return nil, ErrAccountSuspended
+	}
 
-		// Parse token
-		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			// Verify signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("invalid signing method")
-			}
-			return jwtSecret, nil
-		})
+	newRefreshToken, replacement, err := as.createRefreshToken(user.ID, session.ID, stored.MFA)
+	if err != nil {
+		return nil, err
+	}
 
+	// Only the caller that rotates the token gets a new pair. The token is
+	// revoked and linked to its replacement at once, so that a replay always
+	// finds out that it was rotated.
+	now := time.Now()
+	rotated, err := as.TokenRepo.RotateRefreshToken(stored.ID, replacement.ID, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	if !rotated {
+		if _, err := as.TokenRepo.RevokeRefreshToken(replacement.ID, now); err != nil {
+			return nil, fmt.Errorf("failed to revoke refresh token: %w", err)
+		}
+		stored, err = as.TokenRepo.GetRefreshTokenByHash(stored.TokenHash)
 		if err != nil {
-			return nil, errors.New("invalid or expired token")
+			return nil, ErrInvalidRefreshToken
 		}
+		return nil, as.rejectRevokedRefreshToken(stored)
+	}
 
-		// Extract claims
-		if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-			return claims, nil
-		}
+	accessToken, expiresAt, err := as.generateAccessToken(user, stored.MFA, session.ID)
+	if err != nil {
+		return nil, err
+	}
 
-		return nil, errors.New("invalid token claims")
+	if err := as.SessionRepo.TouchSession(session.ID, client.IP, now, now); err != nil {
+		return nil, fmt.Errorf("failed to update session: %w", err)
 	}
 
-	// RefreshToken generates a new token for a user (extends expiration)
-	func RefreshToken(oldToken string) (string, error) {
-		// Validate old token
-		claims, err := ValidateToken(oldToken)
-		if err != nil {
-			return "", err
-		}
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: newRefreshToken,
+		ExpiresAt:    expiresAt,
+	}, nil
+}
 
-		// Generate new token with same user data
-		return GenerateToken(claims.UserID, claims.Email, claims.Role)
+// rejectRevokedRefreshToken refuses a revoked refresh token. A token that was
+// rotated is being reused, while one revoked by signing out or ending its
+// session is just stale.
+func (as *AuthService) rejectRevokedRefreshToken(stored *models.RefreshToken) error {
+	if stored.ReplacedByID != nil {
+		return as.refreshTokenReused(stored.UserID)
 	}
+	return ErrInvalidRefreshToken
+}
 
-	// ExtractUserIDFromToken extracts the user ID from a token without full validation
-	func ExtractUserIDFromToken(tokenString string) (uint, error) {
-		claims, err := ValidateToken(tokenString)
-		if err != nil {
-			return 0, err
-		}
-		return claims.UserID, nil
+// refreshTokenReused ends every session and revokes every API key of a user
+// whose revoked refresh token was presented again, since it was probably
+// stolen
+func (as *AuthService) refreshTokenReused(userId uint) error {
+	if err := as.TokenRepo.RevokeRefreshTokensByUser(userId); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	if err := as.SessionRepo.EndSessionsByUser(userId, 0); err != nil {
+		return fmt.Errorf("failed to end sessions: %w", err)
 	}
+	if err := as.APIKeyRepo.RevokeAPIKeysByUser(userId); err != nil {
+		return fmt.Errorf("failed to revoke API keys: %w", err)
+	}
+	return ErrTokenRevoked
+}
 
-	// ExtractRoleFromToken extracts the role from a token without full validation
-	func ExtractRoleFromToken(tokenString string) (string, error) {
-		claims, err := ValidateToken(tokenString)
-		if err != nil {
-			return "", err
+// Logout ends the session of the given access token and revokes the token.
+// A refresh token, if provided, must belong to the same user.
+func (as *AuthService) Logout(claims *Claims, refreshToken string) error {
+	if claims == nil {
+		return ErrInvalidToken
+	}
+
+	if err := as.RevokeToken(claims); err != nil {
+		return err
+	}
+	if err := as.endSession(claims.SessionID); err != nil {
+		return err
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	stored, err := as.TokenRepo.GetRefreshTokenByHash(hashToken(refreshToken))
+	if err != nil || stored.UserID != claims.UserID {
+		return ErrInvalidRefreshToken
+	}
+	if _, err := as.TokenRepo.RevokeRefreshToken(stored.ID, time.Now()); err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+	return nil
+}
+
+// RevokeToken adds an access token to the revocation list until it expires
+func (as *AuthService) RevokeToken(claims *Claims) error {
+	if claims == nil || claims.ID == "" {
+		return ErrInvalidToken
+	}
+
+	revoked := &models.RevokedToken{
+		JTI:    claims.ID,
+		UserID: claims.UserID,
+	}
+	if claims.ExpiresAt != nil {
+		revoked.ExpiresAt = claims.ExpiresAt.Time
+	}
+
+	if err := as.TokenRepo.RevokeAccessToken(revoked); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+// RevokeAllForUser ends every session of a user: refresh tokens are revoked
+// and access tokens issued so far are rejected
+func (as *AuthService) RevokeAllForUser(userId uint) error {
+	if userId == 0 {
+		return errors.New("invalid user ID")
+	}
+	if err := as.TokenRepo.RevokeRefreshTokensByUser(userId); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	if err := as.UserRepo.SetTokensRevokedAt(userId, time.Now()); err != nil {
+		return fmt.Errorf("failed to revoke access tokens: %w", err)
+	}
+	if err := as.SessionRepo.EndSessionsByUser(userId, 0); err != nil {
+		return fmt.Errorf("failed to end sessions: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredTokens removes refresh tokens and access token revocations
+// that have expired, so the revocation list checked on every request does
+// not keep growing
+func (as *AuthService) DeleteExpiredTokens() error {
+	if err := as.TokenRepo.DeleteExpired(); err != nil {
+		return fmt.Errorf("failed to delete expired tokens: %w", err)
+	}
+	return nil
+}
+
+// StartTokenCleanup deletes expired tokens now and then periodically until
+// stop is closed
+func (as *AuthService) StartTokenCleanup(stop <-chan struct{}) {
+	ticker := time.NewTicker(tokenCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := as.DeleteExpiredTokens(); err != nil {
+			log.Println("token cleanup:", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
 		}
-		return claims.Role, nil
 	}
+}
 
-	// IsTokenExpired checks if a token is expired
-	func IsTokenExpired(tokenString string) bool {
-		claims, err := ValidateToken(tokenString)
-		if err != nil {
-			return true
+// RevokeCredentialsForUser ends every session of a user like
+// RevokeAllForUser and also revokes their API keys. Use it when the account
+// may be compromised, e.g. on a password reset; keys are not shown again, so
+// partners have to be given new ones.
+func (as *AuthService) RevokeCredentialsForUser(userId uint) error {
+	if err := as.RevokeAllForUser(userId); err != nil {
+		return err
+	}
+	if err := as.APIKeyRepo.RevokeAPIKeysByUser(userId); err != nil {
+		return fmt.Errorf("failed to revoke API keys: %w", err)
+	}
+	return nil
+}
+
+// RevokeCredentialsExceptSession is RevokeCredentialsForUser for a change the
+// user made themselves, e.g. of their password: the session they made it from
+// stays signed in.
+func (as *AuthService) RevokeCredentialsExceptSession(userId, sessionId uint) error {
+	if err := as.EndOtherSessions(userId, sessionId); err != nil {
+		return err
+	}
+	if err := as.APIKeyRepo.RevokeAPIKeysByUser(userId); err != nil {
+		return fmt.Errorf("failed to revoke API keys: %w", err)
+	}
+	return nil
+}
+
+// GetSessions lists the active sessions of a user. The session with ID
+// currentSessionId is flagged as the current one.
+func (as *AuthService) GetSessions(userId, currentSessionId uint) ([]models.Session, error) {
+	sessions, err := as.SessionRepo.GetActiveSessionsByUser(userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve sessions: %w", err)
+	}
+	active := sessions[:0]
+	for _, session := range sessions {
+		// Impersonation sessions have no refresh token and lapse with their access token
+		if session.ImpersonatorID != nil && time.Since(session.CreatedAt) > impersonationTokenTTL {
+			continue
 		}
+		session.Current = session.ID == currentSessionId
+		active = append(active, session)
+	}
+	return active, nil
+}
 
-		return claims.ExpiresAt.Time.Before(time.Now())
+// EndSession signs a user out of one of their sessions
+func (as *AuthService) EndSession(userId, sessionId uint) error {
+	session, err := as.SessionRepo.GetSessionById(sessionId)
+	if err != nil || session.UserID != userId || session.EndedAt != nil {
+		return ErrSessionNotFound
 	}
+	return as.endSession(session.ID)
+}
 
-	// GetTokenExpirationTime returns the expiration time of a token
-	func GetTokenExpirationTime(tokenString string) (time.Time, error) {
-		claims, err := ValidateToken(tokenString)
-		if err != nil {
-			return time.Time{}, err
+// EndOtherSessions signs a user out everywhere except the current session
+func (as *AuthService) EndOtherSessions(userId, currentSessionId uint) error {
+	sessions, err := as.SessionRepo.GetActiveSessionsByUser(userId)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve sessions: %w", err)
+	}
+	for _, session := range sessions {
+		if session.ID == currentSessionId {
+			continue
+		}
+		if err := as.endSession(session.ID); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// endSession ends a session and revokes its refresh tokens. Its access
+// tokens are rejected from then on by ValidateAccessToken.
+func (as *AuthService) endSession(sessionId uint) error {
+	if err := as.SessionRepo.EndSession(sessionId); err != nil {
+		return fmt.Errorf("failed to end session: %w", err)
+	}
+	if err := as.TokenRepo.RevokeRefreshTokensBySession(sessionId); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// IssueUserToken creates a single-use token for a user that is sent by email.
+// Earlier unused tokens with the same purpose are invalidated.
+func (as *AuthService) IssueUserToken(userId uint, purpose string, ttl time.Duration) (string, error) {
+	if userId == 0 {
+		return "", errors.New("invalid user ID")
+	}
+
+	if err := as.UserTokenRepo.InvalidateTokens(userId, purpose); err != nil {
+		return "", fmt.Errorf("failed to invalidate previous tokens: %w", err)
+	}
+
+	value, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
 
-		return claims.ExpiresAt.Time, nil
+	token := &models.UserToken{
+		UserID:    userId,
+		Purpose:   purpose,
+		TokenHash: hashToken(value),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := as.UserTokenRepo.CreateToken(token); err != nil {
+		return "", fmt.Errorf("failed to store token: %w", err)
+	}
+	return value, nil
+}
+
+// ConsumeUserToken validates a single-use token and marks it as used
+func (as *AuthService) ConsumeUserToken(purpose, value string) (*models.UserToken, error) {
+	if value == "" {
+		return nil, ErrInvalidUserToken
 	}
 
-	// RevokeToken would typically mark a token as revoked in a database
-	// For now, this is a placeholder that always returns an error
-	// In production, you'd want to maintain a blacklist of revoked tokens
-	func RevokeToken(tokenString string) error {
-		// Validate token exists
-		_, err := ValidateToken(tokenString)
+	token, err := as.UserTokenRepo.GetTokenByHash(purpose, hashToken(value))
+	if err != nil {
+		return nil, ErrInvalidUserToken
+	}
+	if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+		return nil, ErrInvalidUserToken
+	}
+
+	// MarkUsed only succeeds once, even with concurrent requests
+	used, err := as.UserTokenRepo.MarkUsed(token.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume token: %w", err)
+	}
+	if !used {
+		return nil, ErrInvalidUserToken
+	}
+	return token, nil
+}
+
+// IssueChallengeToken creates a short-lived token proving that the user
+// entered a correct password, to be exchanged once the second factor is verified
+func (as *AuthService) IssueChallengeToken(user *models.User) (string, error) {
+	claims := &Claims{
+		UserID:  user.ID,
+		Email:   user.Email,
+		Purpose: tokenPurposeChallenge,
+	}
+	token, _, err := as.signToken(claims, challengeTokenTTL)
+	return token, err
+}
+
+// ParseChallengeToken validates a two-factor challenge token
+func (as *AuthService) ParseChallengeToken(tokenString string) (*Claims, error) {
+	return as.parseTokenWithPurpose(tokenString, tokenPurposeChallenge)
+}
+
+// generateAccessToken generates a short-lived JWT access token for a user
+func (as *AuthService) generateAccessToken(user *models.User, mfa bool, sessionID uint) (string, time.Time, error) {
+	if user.Email == "" {
+		return "", time.Time{}, errors.New("email is required")
+	}
+	if user.Role == "" {
+		return "", time.Time{}, errors.New("role is required")
+	}
+
+	claims := &Claims{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Role:      user.Role,
+		Purpose:   tokenPurposeAccess,
+		MFA:       mfa,
+		SessionID: sessionID,
+	}
+	return as.signToken(claims, accessTokenTTL)
+}
+
+// signToken fills in the registered claims and signs the token
+func (as *AuthService) signToken(claims *Claims, ttl time.Duration) (string, time.Time, error) {
+	if claims.UserID == 0 {
+		return "", time.Time{}, errors.New("invalid user ID")
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        jti,
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Issuer:    tokenIssuerName,
+	}
+
+	signedToken, err := as.Issuer.Sign(claims)
+	if err != nil {
+		return "", time.Time{}, errors.New("failed to sign token")
+	}
+	return signedToken, expiresAt, nil
+}
+
+// createRefreshToken generates and stores a new refresh token for a session
+func (as *AuthService) createRefreshToken(userID, sessionID uint, mfa bool) (string, *models.RefreshToken, error) {
+	value, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := &models.RefreshToken{
+		UserID:    userID,
+		SessionID: sessionID,
+		TokenHash: hashToken(value),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+		MFA:       mfa,
+	}
+	if err := as.TokenRepo.CreateRefreshToken(token); err != nil {
+		return "", nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return value, token, nil
+}
+
+// ParseToken verifies an access token's signature, expiry and issuer and returns its claims
+func (as *AuthService) ParseToken(tokenString string) (*Claims, error) {
+	return as.parseTokenWithPurpose(tokenString, tokenPurposeAccess)
+}
+
+// parseTokenWithPurpose verifies a JWT and checks that it was issued for purpose
+func (as *AuthService) parseTokenWithPurpose(tokenString, purpose string) (*Claims, error) {
+	if tokenString == "" {
+		return nil, errors.New("token is required")
+	}
+
+	claims := &Claims{}
+	if err := as.Issuer.Parse(tokenString, claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.Issuer != tokenIssuerName || claims.ID == "" || claims.UserID == 0 || claims.Purpose != purpose {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// JWKS returns the public keys that verify access tokens
+func (as *AuthService) JWKS() pkg.JWKS {
+	return as.Issuer.JWKS()
+}
+
+// randomToken returns n random bytes encoded as hex
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// randomString returns n characters picked uniformly from alphabet
+func randomString(alphabet string, n int) (string, error) {
+	max := big.NewInt(int64(len(alphabet)))
+	b := make([]byte, n)
+	for i := range b {
+		j, err := rand.Int(rand.Reader, max)
 		if err != nil {
-			return err
+			return "", fmt.Errorf("failed to generate random string: %w", err)
 		}
+		b[i] = alphabet[j.Int64()]
+	}
+	return string(b), nil
+}
 
-		// In a real implementation, you would:
-		// 1. Store the token or its hash in a revoked tokens database table
-		// 2. Check this table during token validation
-		// 3. Clean up expired tokens periodically
+// hashToken returns the hex encoded SHA-256 hash of a token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// truncate shortens s to at most max bytes
+func truncate(s string, max int) string {
+	if len(s) > max {
+		return s[:max]
+	}
+	return s
+}
 
-		return errors.New("token revocation not implemented - use short expiration times instead")
+// getEnvOrDefault gets an environment variable or returns a default value
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
 	}
+	return defaultValue
+}
 
-	// getEnvOrDefault gets an environment variable or returns a default value
-	func getEnvOrDefault(key, defaultValue string) string {
-		if value := os.Getenv(key); value != "" {
-			return value
+// getDurationOrDefault parses a duration environment variable or returns a default value
+func getDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
 		}
-		return defaultValue
 	}
+	return defaultValue
+}
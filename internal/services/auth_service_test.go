@@ -0,0 +1,258 @@
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"Visa/pkg"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func newTestAuthService(t *testing.T, db *gorm.DB) *AuthService {
+	t.Helper()
+
+	key, err := pkg.GenerateEd25519Key("test")
+	if err != nil {
+		t.Fatalf("failed to generate signing key: %v", err)
+	}
+	issuer, err := pkg.NewKeySetIssuer("test", key)
+	if err != nil {
+		t.Fatalf("failed to create issuer: %v", err)
+	}
+	return NewAuthService(issuer, repos.NewTokenRepo(db), repos.NewUserTokenRepo(db), repos.NewUserRepo(db), repos.NewSessionRepo(db), repos.NewAPIKeyRepo(db))
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAuthService(t, db)
+	user := createTestUser(t, db, "traveller@example.com", "user")
+
+	pair, err := as.IssueTokens(user, false, ClientInfo{IP: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+
+	refreshed, err := as.Refresh(pair.RefreshToken, ClientInfo{IP: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if refreshed.RefreshToken == pair.RefreshToken {
+		t.Fatal("Refresh returned the same refresh token")
+	}
+
+	old, err := as.TokenRepo.GetRefreshTokenByHash(hashToken(pair.RefreshToken))
+	if err != nil {
+		t.Fatalf("GetRefreshTokenByHash: %v", err)
+	}
+	if old.RevokedAt == nil {
+		t.Error("old refresh token was not revoked")
+	}
+	replacement, err := as.TokenRepo.GetRefreshTokenByHash(hashToken(refreshed.RefreshToken))
+	if err != nil {
+		t.Fatalf("GetRefreshTokenByHash: %v", err)
+	}
+	if old.ReplacedByID == nil || *old.ReplacedByID != replacement.ID {
+		t.Errorf("old refresh token replaced by %v, want %d", old.ReplacedByID, replacement.ID)
+	}
+	if replacement.SessionID != old.SessionID {
+		t.Errorf("new refresh token in session %d, want %d", replacement.SessionID, old.SessionID)
+	}
+
+	if _, err := as.Refresh(refreshed.RefreshToken, ClientInfo{}); err != nil {
+		t.Errorf("Refresh with the new token: %v", err)
+	}
+}
+
+func TestRefreshReuseEndsSessions(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAuthService(t, db)
+	user := createTestUser(t, db, "traveller@example.com", "user")
+
+	pair, err := as.IssueTokens(user, false, ClientInfo{})
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	other, err := as.IssueTokens(user, false, ClientInfo{})
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	refreshed, err := as.Refresh(pair.RefreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	ks := NewAPIKeyService(as.APIKeyRepo, as.UserRepo)
+	_, key, err := ks.CreateAPIKey(user.ID, "partner", []string{models.ScopeBookingsRead}, 0, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	if _, err := as.Refresh(pair.RefreshToken, ClientInfo{}); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Refresh with a used token = %v, want %v", err, ErrTokenRevoked)
+	}
+
+	sessions, err := as.SessionRepo.GetActiveSessionsByUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetActiveSessionsByUser: %v", err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("%d sessions still active after reuse", len(sessions))
+	}
+	for _, token := range []string{refreshed.RefreshToken, other.RefreshToken} {
+		if _, err := as.Refresh(token, ClientInfo{}); err == nil {
+			t.Error("a refresh token of the user still works after reuse")
+		}
+	}
+	if _, _, err := ks.Authenticate(key, ""); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Authenticate with an API key after reuse = %v, want %v", err, ErrInvalidAPIKey)
+	}
+}
+
+func TestRefreshConcurrentReplay(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAuthService(t, db)
+	user := createTestUser(t, db, "traveller@example.com", "user")
+
+	pair, err := as.IssueTokens(user, false, ClientInfo{})
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+
+	const attempts = 5
+	var wg sync.WaitGroup
+	pairs := make([]*TokenPair, attempts)
+	errs := make([]error, attempts)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pairs[i], errs[i] = as.Refresh(pair.RefreshToken, ClientInfo{})
+		}(i)
+	}
+	wg.Wait()
+
+	var winner *TokenPair
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			if winner != nil {
+				t.Fatal("more than one concurrent refresh succeeded")
+			}
+			winner = pairs[i]
+		case !errors.Is(err, ErrTokenRevoked):
+			t.Errorf("Refresh = %v, want nil or %v", err, ErrTokenRevoked)
+		}
+	}
+	if winner == nil {
+		t.Fatal("no concurrent refresh succeeded")
+	}
+
+	// The replay ended the session the winning pair belongs to
+	if _, err := as.Refresh(winner.RefreshToken, ClientInfo{}); err == nil {
+		t.Error("the rotated refresh token still works after a replay")
+	}
+	var active int64
+	if err := db.Model(&models.Session{}).Where("user_id = ? AND ended_at IS NULL", user.ID).Count(&active).Error; err != nil {
+		t.Fatalf("count sessions: %v", err)
+	}
+	if active != 0 {
+		t.Errorf("%d sessions still active after a replay", active)
+	}
+}
+
+// testRefreshOfEndedSession opens a session that is then ended by end, and
+// another one, and checks that refreshing the ended session is refused without being
+// taken for reuse of a stolen token
+func testRefreshOfEndedSession(t *testing.T, end func(as *AuthService, claims *Claims, pair *TokenPair) error) {
+	t.Helper()
+
+	db := newTestDB(t)
+	as := newTestAuthService(t, db)
+	ks := NewAPIKeyService(as.APIKeyRepo, as.UserRepo)
+	user := createTestUser(t, db, "traveller@example.com", "user")
+
+	ended, err := as.IssueTokens(user, false, ClientInfo{})
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	other, err := as.IssueTokens(user, false, ClientInfo{})
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	_, key, err := ks.CreateAPIKey(user.ID, "partner", []string{models.ScopeBookingsRead}, 0, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	claims, err := as.ValidateAccessToken(ended.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateAccessToken: %v", err)
+	}
+	if err := end(as, claims, ended); err != nil {
+		t.Fatalf("ending the session: %v", err)
+	}
+
+	// The device refreshes in the background later on
+	if _, err := as.Refresh(ended.RefreshToken, ClientInfo{}); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Refresh of the ended session = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	if _, err := as.Refresh(other.RefreshToken, ClientInfo{}); err != nil {
+		t.Errorf("Refresh of another session: %v", err)
+	}
+	if _, _, err := ks.Authenticate(key, ""); err != nil {
+		t.Errorf("Authenticate with an API key: %v", err)
+	}
+}
+
+func TestRefreshAfterLogout(t *testing.T) {
+	testRefreshOfEndedSession(t, func(as *AuthService, claims *Claims, pair *TokenPair) error {
+		return as.Logout(claims, pair.RefreshToken)
+	})
+}
+
+func TestRefreshAfterEndSession(t *testing.T) {
+	testRefreshOfEndedSession(t, func(as *AuthService, claims *Claims, pair *TokenPair) error {
+		return as.EndSession(claims.UserID, claims.SessionID)
+	})
+}
+
+func TestDeleteExpiredTokens(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAuthService(t, db)
+	user := createTestUser(t, db, "traveller@example.com", "user")
+
+	pair, err := as.IssueTokens(user, false, ClientInfo{})
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	past := time.Now().Add(-time.Minute)
+	expired := []any{
+		&models.RefreshToken{UserID: user.ID, TokenHash: hashToken("expired"), ExpiresAt: past},
+		&models.RevokedToken{JTI: "expired", UserID: user.ID, ExpiresAt: past},
+		&models.RevokedToken{JTI: "current", UserID: user.ID, ExpiresAt: time.Now().Add(time.Minute)},
+	}
+	for _, row := range expired {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatalf("create token: %v", err)
+		}
+	}
+
+	if err := as.DeleteExpiredTokens(); err != nil {
+		t.Fatalf("DeleteExpiredTokens: %v", err)
+	}
+
+	if _, err := as.TokenRepo.GetRefreshTokenByHash(hashToken("expired")); err == nil {
+		t.Error("expired refresh token was not deleted")
+	}
+	if _, err := as.TokenRepo.GetRefreshTokenByHash(hashToken(pair.RefreshToken)); err != nil {
+		t.Errorf("active refresh token was deleted: %v", err)
+	}
+	if revoked, _ := as.TokenRepo.IsAccessTokenRevoked("expired"); revoked {
+		t.Error("expired revocation was not deleted")
+	}
+	if revoked, _ := as.TokenRepo.IsAccessTokenRevoked("current"); !revoked {
+		t.Error("revocation of an unexpired access token was deleted")
+	}
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"Visa/pkg"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB opens an empty in-memory database with the application's tables.
+// It has a single connection, so concurrent transactions run one after the
+// other the way row locks would order them.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	err = db.AutoMigrate(
+		&models.User{},
+		&models.Flight{},
+		&models.FareBucket{},
+		&models.Reservation{},
+		&models.ReservationFlight{},
+		&models.SeatMap{},
+		&models.SeatMapSeat{},
+		&models.FlightSeat{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.UserToken{},
+		&models.RecoveryCode{},
+		&models.Session{},
+		&models.LoginEvent{},
+		&models.UserIdentity{},
+		&models.OIDCLoginState{},
+		&models.APIKey{},
+		&models.AuditLog{},
+		&models.Traveller{},
+		&models.LoyaltyNumber{},
+		&models.UserPreferences{},
+		&models.VisaApplication{},
+		&models.SupportTicket{},
+		&models.Invitation{},
+		&models.Permission{},
+		&models.Role{},
+	)
+	if err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return db
+}
+
+// createTestUser stores a verified user with the given role
+func createTestUser(t *testing.T, db *gorm.DB, email, role string) *models.User {
+	t.Helper()
+
+	now := time.Now()
+	user := &models.User{Name: "Test User", Email: email, Role: role, EmailVerifiedAt: &now}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return user
+}
+
+func newTestFlightService(db *gorm.DB) *FlightService {
+	return NewFlightService(repos.NewFlightRepo(db), repos.NewReservationRepo(db), repos.NewUserRepo(db), repos.NewTravellerRepo(db),
+		NewPreferencesService(repos.NewPreferencesRepo(db)), pkg.LogMailer{})
+}
+
+func newTestTripService(fs *FlightService) *TripService {
+	return NewTripService(fs.ReservationRepo, fs.Repo, fs)
+}
+
+func newTestSeatService(db *gorm.DB, fs *FlightService) *SeatService {
+	return NewSeatService(repos.NewSeatMapRepo(db), fs.Repo, fs.ReservationRepo)
+}
+
+// testFlightDetails describe a two hour flight leaving at departure. Without
+// fares it is sold at a single economy fare of 100 with 10 seats.
+func testFlightDetails(number, origin, destination string, departure time.Time, fares ...FareDetails) FlightDetails {
+	const layout = "2006-01-02T15:04"
+	departure = departure.UTC()
+	return FlightDetails{
+		FlightNumber:      number,
+		Airline:           "Test Air",
+		Origin:            origin,
+		Destination:       destination,
+		City:              destination,
+		Departure:         departure.Format(layout),
+		DepartureTimeZone: "UTC",
+		Arrival:           departure.Add(2 * time.Hour).Format(layout),
+		ArrivalTimeZone:   "UTC",
+		Price:             100,
+		Capacity:          10,
+		Fares:             fares,
+	}
+}
+
+// createTestFlight creates a flight with testFlightDetails
+func createTestFlight(t *testing.T, fs *FlightService, number, origin, destination string, departure time.Time, fares ...FareDetails) *models.Flight {
+	t.Helper()
+
+	flight, err := fs.CreateFlight(testFlightDetails(number, origin, destination, departure, fares...))
+	if err != nil {
+		t.Fatalf("CreateFlight: %v", err)
+	}
+	return flight
+}
+
+// departTestFlight moves a flight's departure into the past
+func departTestFlight(t *testing.T, db *gorm.DB, flight *models.Flight) {
+	t.Helper()
+
+	if err := db.Model(flight).Update("departure_at", time.Now().Add(-time.Hour)).Error; err != nil {
+		t.Fatalf("move departure: %v", err)
+	}
+}
+
+// seatsAvailable reads the free seats of a flight and of each of its fares
+func seatsAvailable(t *testing.T, db *gorm.DB, flightId uint) (int, map[uint]int) {
+	t.Helper()
+
+	var flight models.Flight
+	if err := db.Preload("Fares").First(&flight, flightId).Error; err != nil {
+		t.Fatalf("load flight: %v", err)
+	}
+	fares := make(map[uint]int, len(flight.Fares))
+	for _, fare := range flight.Fares {
+		fares[fare.ID] = fare.SeatsAvailable
+	}
+	return flight.SeatsAvailable, fares
+}
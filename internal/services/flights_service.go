@@ -4,25 +4,148 @@ package services
 import (
 	"Visa/internal/repos"
 	"Visa/models"
+	"Visa/pkg"
 	"errors"
 	"fmt"
+	"log"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
+const (
+	flightMaxStops           = 3
+	flightMaxFares           = 12
+	flightMaxPassengers      = 9
+	flightSearchDefaultLimit = 20
+	flightSearchMaxLimit     = 100
+)
+
+var (
+	flightNumberPattern = regexp.MustCompile(`^[A-Z0-9]{2}[0-9]{1,4}[A-Z]?$`)
+	iataPattern         = regexp.MustCompile(`^[A-Z]{3}$`)
+	fareFamilyPattern   = regexp.MustCompile(`^[a-z][a-z0-9_]{1,19}$`)
+)
+
+var validCabinClasses = map[string]bool{
+	models.CabinEconomy:        true,
+	models.CabinPremiumEconomy: true,
+	models.CabinBusiness:       true,
+	models.CabinFirst:          true,
+}
+
+var (
+	ErrInvalidFlight         = errors.New("invalid flight")
+	ErrFlightHasReservations = errors.New("flight has active bookings")
+	ErrFlightCancelled       = errors.New("flight has been cancelled")
+	ErrInvalidFlightSearch   = errors.New("invalid flight search")
+	ErrNotEnoughSeats        = errors.New("not enough seats available for this flight")
+	ErrAlreadyBooked         = errors.New("you already have a booking for this flight")
+	ErrFlightDeparted        = errors.New("flight has already departed")
+	ErrInvalidFare           = errors.New("invalid fare")
+)
+
+// FlightDetails describe a flight as entered by staff. Departure and arrival
+// are local times at each airport, in the given IANA time zones.
+type FlightDetails struct {
+	FlightNumber      string
+	Airline           string
+	Origin            string
+	Destination       string
+	City              string
+	Departure         string
+	DepartureTimeZone string
+	Arrival           string
+	ArrivalTimeZone   string
+	Aircraft          string
+	CabinClass        string
+	Stops             int
+	Price             float64
+	Capacity          int
+	// Fares lists the fares the flight is sold at. Without them the flight
+	// is sold at a single standard fare in CabinClass for Price, holding
+	// every seat of Capacity.
+	Fares []FareDetails
+}
+
+// FareDetails describe a fare a flight is sold at as entered by staff.
+// Baggage is per passenger; CheckedBagKg is the weight allowed per checked
+// bag.
+type FareDetails struct {
+	Cabin        string
+	FareFamily   string
+	Price        float64
+	Seats        int
+	Refundable   bool
+	Changeable   bool
+	ChangeFee    float64
+	CabinBags    int
+	CheckedBags  int
+	CheckedBagKg int
+}
+
+// FareChoice picks the fare booked on each flight. FareID names one fare of
+// a single flight; otherwise the cheapest fare with a seat for every
+// passenger is booked, in Cabin and FareFamily when they are given.
+type FareChoice struct {
+	FareID     uint
+	Cabin      string
+	FareFamily string
+}
+
+// FlightSearch is a search over upcoming flights. Airports are IATA codes and
+// dates are local departure dates formatted as YYYY-MM-DD. Prices are in the
+// base currency.
+type FlightSearch struct {
+	Origin      string
+	Destination string
+	DateFrom    string
+	DateTo      string
+	Passengers  int
+	CabinClass  string
+	MaxStops    *int
+	MinPrice    float64
+	MaxPrice    float64
+	// Sort is "price", "duration" or "departure"; Order is "asc" or "desc"
+	Sort  string
+	Order string
+	Page  int
+	Limit int
+}
+
+// FlightSearchResult is one page of a flight search
+type FlightSearchResult struct {
+	Flights []models.Flight `json:"data"`
+	Page    int             `json:"page"`
+	Limit   int             `json:"limit"`
+	Total   int64           `json:"total"`
+}
+
 type FlightService struct {
 	Repo            *repos.FlightRepo
 	ReservationRepo *repos.ReservationRepo
+	UserRepo        *repos.UserRepo
+	TravellerRepo   *repos.TravellerRepo
+	Preferences     *PreferencesService
+	Mailer          pkg.Mailer
 }
 
-func NewFlightService(flightRepo *repos.FlightRepo, reservationRepo *repos.ReservationRepo) *FlightService {
+func NewFlightService(flightRepo *repos.FlightRepo, reservationRepo *repos.ReservationRepo, userRepo *repos.UserRepo, travellerRepo *repos.TravellerRepo, preferencesService *PreferencesService, mailer pkg.Mailer) *FlightService {
 	return &FlightService{
 		Repo:            flightRepo,
 		ReservationRepo: reservationRepo,
+		UserRepo:        userRepo,
+		TravellerRepo:   travellerRepo,
+		Preferences:     preferencesService,
+		Mailer:          mailer,
 	}
 }
 
-// BookFlight books a flight for a user
-func (fs *FlightService) BookFlight(userId uint, flightId uint) error {
+// BookFlight books a flight for a user at the chosen fare. travellerIds name
+// the passengers from the user's saved travellers; each takes a seat.
+// Without travellers one seat is booked for the user.
+func (fs *FlightService) BookFlight(userId uint, flightId uint, fare FareChoice, travellerIds []uint) error {
 	// Validate input
 	if userId == 0 {
 		return errors.New("invalid user ID")
@@ -37,45 +160,137 @@ func (fs *FlightService) BookFlight(userId uint, flightId uint) error {
 		return fmt.Errorf("flight not found: %w", err)
 	}
 
-	// Check seat availability
-	if flight.SeatsAvailable <= 0 {
-		return errors.New("no seats available for this flight")
+	_, err = fs.bookFlights(userId, models.TripOneWay, [][]models.Flight{{*flight}}, fare, travellerIds)
+	return err
+}
+
+// bookFlights books seats on the flights of one or more journeys as a single
+// reservation with its own booking reference, taking them from the chosen
+// fare of each flight. Either every flight gets its seats or none does.
+func (fs *FlightService) bookFlights(userId uint, tripType string, journeys [][]models.Flight, fare FareChoice, travellerIds []uint) (*models.Reservation, error) {
+	fare.Cabin = strings.ToLower(strings.TrimSpace(fare.Cabin))
+	fare.FareFamily = strings.ToLower(strings.TrimSpace(fare.FareFamily))
+	if fare.Cabin != "" && !validCabinClasses[fare.Cabin] {
+		return nil, fmt.Errorf("%w: cabin must be economy, premium_economy, business or first", ErrInvalidFare)
 	}
 
-	// Check if user already has an active booking for this flight
-	userFlights, err := fs.Repo.FindByUser(userId)
-	if err == nil {
-		for _, f := range userFlights {
-			if f.ID == flightId {
-				return errors.New("you already have a booking for this flight")
-			}
-		}
+	// Only verified accounts can book
+	if err := requireVerifiedEmail(fs.UserRepo, userId); err != nil {
+		return nil, err
 	}
 
-	// Create reservation
-	flightIDStr := strconv.FormatUint(uint64(flightId), 10)
+	travellers, err := loadTravellers(fs.TravellerRepo, userId, travellerIds)
+	if err != nil {
+		return nil, err
+	}
+	reference, err := newBookingReference()
+	if err != nil {
+		return nil, err
+	}
 	res := &models.Reservation{
-		UserID:   strconv.FormatUint(uint64(userId), 10),
-		FlightID: &flightIDStr,
-		Status:   "booked",
+		Reference:  &reference,
+		TripType:   tripType,
+		UserID:     strconv.FormatUint(uint64(userId), 10),
+		Status:     "booked",
+		Travellers: travellers,
+	}
+	seats := res.Seats()
+
+	for journey, flights := range journeys {
+		for _, flight := range flights {
+			// Check the flight can still be booked
+			if flight.Status == models.FlightStatusCancelled {
+				return nil, ErrFlightCancelled
+			}
+			if !flight.DepartureAt.After(time.Now()) {
+				return nil, fmt.Errorf("%w: flight %s", ErrFlightDeparted, flight.FlightNumber)
+			}
+			bucket, err := chooseFare(&flight, fare, seats)
+			if err != nil {
+				return nil, err
+			}
+
+			res.Segments = append(res.Segments, models.ReservationFlight{
+				FlightID:     flight.ID,
+				Journey:      journey + 1,
+				Sequence:     len(res.Segments) + 1,
+				FareBucketID: &bucket.ID,
+				Cabin:        bucket.Cabin,
+				FareFamily:   bucket.FareFamily,
+				Price:        bucket.Price,
+			})
+			res.TotalPrice += bucket.Price * float64(seats)
+		}
 	}
+	firstFlightId := strconv.FormatUint(uint64(res.Segments[0].FlightID), 10)
+	res.FlightID = &firstFlightId
 
-	if err := fs.ReservationRepo.CreateReservation(res); err != nil {
-		return fmt.Errorf("failed to create reservation: %w", err)
+	// Create reservation and reduce available seats together, unless the
+	// user already booked one of the flights
+	if err := fs.ReservationRepo.CreateFlightReservation(res, seats); err != nil {
+		if errors.Is(err, repos.ErrNotEnoughSeats) {
+			return nil, ErrNotEnoughSeats
+		}
+		if errors.Is(err, repos.ErrAlreadyBooked) {
+			return nil, ErrAlreadyBooked
+		}
+		if errors.Is(err, repos.ErrDeparted) {
+			return nil, fmt.Errorf("%w: a flight left while it was being booked", ErrFlightDeparted)
+		}
+		return nil, fmt.Errorf("failed to create reservation: %w", err)
 	}
+	return res, nil
+}
 
-	// Reduce available seats
-	flight.SeatsAvailable -= 1
-	if err := fs.Repo.UpdateFlight(flight); err != nil {
-		// Try to rollback reservation if flight update fails
-		fs.ReservationRepo.DeleteReservation(res.ID)
-		return fmt.Errorf("failed to update flight availability: %w", err)
+// chooseFare picks the fare of a flight to book seats from: the fare named
+// by ID, or the cheapest one in the chosen cabin and family with enough seats
+func chooseFare(flight *models.Flight, fare FareChoice, seats int) (*models.FareBucket, error) {
+	matched := false
+	for i := range flight.Fares {
+		bucket := &flight.Fares[i]
+		if fare.FareID != 0 && bucket.ID != fare.FareID {
+			continue
+		}
+		if fare.Cabin != "" && bucket.Cabin != fare.Cabin {
+			continue
+		}
+		if fare.FareFamily != "" && bucket.FareFamily != fare.FareFamily {
+			continue
+		}
+		matched = true
+		// Fares are loaded cheapest first
+		if bucket.SeatsAvailable >= seats {
+			return bucket, nil
+		}
+	}
+	if !matched {
+		switch {
+		case fare.FareID != 0:
+			return nil, fmt.Errorf("%w: fare %d is not sold on flight %s", ErrInvalidFare, fare.FareID, flight.FlightNumber)
+		case fare.Cabin != "" || fare.FareFamily != "":
+			return nil, fmt.Errorf("%w: flight %s has no %s fare", ErrInvalidFare, flight.FlightNumber,
+				strings.TrimSpace(fare.Cabin+" "+fare.FareFamily))
+		}
 	}
+	return nil, ErrNotEnoughSeats
+}
 
-	return nil
+// newBookingReference returns a random six character booking reference,
+// leaving out letters and digits that are easily confused
+func newBookingReference() (string, error) {
+	const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+	reference, err := randomString(alphabet, 6)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate booking reference: %w", err)
+	}
+	return reference, nil
 }
 
-// CancelFlight cancels a flight booking for a user
+// CancelFlight cancels a user's booking of a flight. Bookings of several
+// flights are cancelled as a whole, refunding all but the price of their
+// non-refundable fares. Once any of their flights has left they can no
+// longer be cancelled.
 func (fs *FlightService) CancelFlight(userId uint, flightId uint) error {
 	// Validate input
 	if userId == 0 {
@@ -86,57 +301,121 @@ func (fs *FlightService) CancelFlight(userId uint, flightId uint) error {
 	}
 
 	// Get flight details
-	flight, err := fs.Repo.GetFlightById(flightId)
-	if err != nil {
+	if _, err := fs.Repo.GetFlightById(flightId); err != nil {
 		return fmt.Errorf("flight not found: %w", err)
 	}
 
 	// Verify user has a booking for this flight
-	userFlights, err := fs.Repo.FindByUser(userId)
+	res, err := fs.ReservationRepo.GetActiveFlightReservation(userId, flightId)
 	if err != nil {
-		return fmt.Errorf("failed to verify booking: %w", err)
+		return errors.New("no active booking found for this flight")
 	}
 
-	hasBooking := false
-	for _, f := range userFlights {
-		if f.ID == flightId {
-			hasBooking = true
-			break
+	// Give back every seat of the booking
+	if err := fs.ReservationRepo.CancelFlightReservation(res, res.Seats()); err != nil {
+		if errors.Is(err, repos.ErrAlreadyCancelled) {
+			return errors.New("no active booking found for this flight")
+		}
+		if errors.Is(err, repos.ErrDeparted) {
+			return fmt.Errorf("%w: the booking can no longer be cancelled", ErrFlightDeparted)
 		}
+		return fmt.Errorf("failed to cancel reservation: %w", err)
 	}
 
-	if !hasBooking {
-		return errors.New("no active booking found for this flight")
-	}
+	return nil
+}
 
-	// Create cancellation reservation record
-	flightIDStr := strconv.FormatUint(uint64(flightId), 10)
-	res := &models.Reservation{
-		UserID:   strconv.FormatUint(uint64(userId), 10),
-		FlightID: &flightIDStr,
-		Status:   "cancelled",
+// SearchFlights retrieves a page of upcoming flights matching the search
+// that still have a seat for every passenger
+func (fs *FlightService) SearchFlights(search FlightSearch) (*FlightSearchResult, error) {
+	if search.Page < 1 {
+		search.Page = 1
+	}
+	if search.Limit < 1 {
+		search.Limit = flightSearchDefaultLimit
+	}
+	if search.Limit > flightSearchMaxLimit {
+		search.Limit = flightSearchMaxLimit
+	}
+	if search.Passengers == 0 {
+		search.Passengers = 1
+	}
+
+	filter := repos.FlightFilter{
+		Origin:      strings.ToUpper(strings.TrimSpace(search.Origin)),
+		Destination: strings.ToUpper(strings.TrimSpace(search.Destination)),
+		DateFrom:    search.DateFrom,
+		DateTo:      search.DateTo,
+		DepartAfter: time.Now(),
+		Passengers:  search.Passengers,
+		CabinClass:  strings.ToLower(strings.TrimSpace(search.CabinClass)),
+		MaxStops:    search.MaxStops,
+		MinPrice:    search.MinPrice,
+		MaxPrice:    search.MaxPrice,
+		Sort:        search.Sort,
+		Offset:      (search.Page - 1) * search.Limit,
+		Limit:       search.Limit,
+	}
+	if filter.Origin != "" && !iataPattern.MatchString(filter.Origin) {
+		return nil, fmt.Errorf("%w: origin must be a 3-letter IATA airport code", ErrInvalidFlightSearch)
+	}
+	if filter.Destination != "" && !iataPattern.MatchString(filter.Destination) {
+		return nil, fmt.Errorf("%w: destination must be a 3-letter IATA airport code", ErrInvalidFlightSearch)
+	}
+	if filter.DateFrom != "" {
+		if _, err := time.Parse(dateLayout, filter.DateFrom); err != nil {
+			return nil, fmt.Errorf("%w: date_from must be formatted as YYYY-MM-DD", ErrInvalidFlightSearch)
+		}
 	}
-
-	if err := fs.ReservationRepo.CreateReservation(res); err != nil {
-		return fmt.Errorf("failed to create cancellation record: %w", err)
+	if filter.DateTo != "" {
+		if _, err := time.Parse(dateLayout, filter.DateTo); err != nil {
+			return nil, fmt.Errorf("%w: date_to must be formatted as YYYY-MM-DD", ErrInvalidFlightSearch)
+		}
 	}
-
-	// Increase available seats
-	flight.SeatsAvailable += 1
-	if err := fs.Repo.UpdateFlight(flight); err != nil {
-		return fmt.Errorf("failed to update flight availability: %w", err)
+	// Dates are formatted alike, so they compare as strings
+	if filter.DateFrom != "" && filter.DateTo != "" && filter.DateFrom > filter.DateTo {
+		return nil, fmt.Errorf("%w: date_from must not be after date_to", ErrInvalidFlightSearch)
+	}
+	if filter.Passengers < 1 || filter.Passengers > flightMaxPassengers {
+		return nil, fmt.Errorf("%w: passengers must be between 1 and %d", ErrInvalidFlightSearch, flightMaxPassengers)
+	}
+	if filter.CabinClass != "" && !validCabinClasses[filter.CabinClass] {
+		return nil, fmt.Errorf("%w: cabin must be economy, premium_economy, business or first", ErrInvalidFlightSearch)
+	}
+	if filter.MaxStops != nil && *filter.MaxStops < 0 {
+		return nil, fmt.Errorf("%w: max_stops cannot be negative", ErrInvalidFlightSearch)
+	}
+	if filter.MinPrice < 0 || filter.MaxPrice < 0 {
+		return nil, fmt.Errorf("%w: prices cannot be negative", ErrInvalidFlightSearch)
+	}
+	if filter.MaxPrice > 0 && filter.MinPrice > filter.MaxPrice {
+		return nil, fmt.Errorf("%w: min_price must not be above max_price", ErrInvalidFlightSearch)
+	}
+	switch filter.Sort {
+	case "":
+		filter.Sort = "departure"
+	case "price", "duration", "departure":
+	default:
+		return nil, fmt.Errorf("%w: sort must be 'price', 'duration' or 'departure'", ErrInvalidFlightSearch)
+	}
+	switch search.Order {
+	case "", "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return nil, fmt.Errorf("%w: order must be 'asc' or 'desc'", ErrInvalidFlightSearch)
 	}
 
-	return nil
-}
-
-// GetFlights retrieves all flights
-func (fs *FlightService) GetFlights() ([]models.Flight, error) {
-	flights, err := fs.Repo.GetAllFlights()
+	flights, total, err := fs.Repo.SearchFlights(filter)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve flights: %w", err)
-	}
-	return flights, nil
+		return nil, fmt.Errorf("failed to search flights: %w", err)
+	}
+	return &FlightSearchResult{
+		Flights: flights,
+		Page:    search.Page,
+		Limit:   search.Limit,
+		Total:   total,
+	}, nil
 }
 
 // GetFlightById retrieves a flight by its ID
@@ -165,11 +444,14 @@ func (fs *FlightService) GetFlightsByCity(city string) ([]models.Flight, error)
 	return flights, nil
 }
 
-// GetFlightsByDepartDate retrieves all flights for a specific departure date
+// GetFlightsByDepartDate retrieves all flights departing on a local date
 func (fs *FlightService) GetFlightsByDepartDate(date string) ([]models.Flight, error) {
 	if date == "" {
 		return nil, errors.New("departure date is required")
 	}
+	if _, err := time.Parse(dateLayout, date); err != nil {
+		return nil, fmt.Errorf("%w: departure date must be formatted as YYYY-MM-DD", ErrInvalidFlightSearch)
+	}
 
 	flights, err := fs.Repo.FindByDepartDate(date)
 	if err != nil {
@@ -178,11 +460,14 @@ func (fs *FlightService) GetFlightsByDepartDate(date string) ([]models.Flight, e
 	return flights, nil
 }
 
-// GetFlightsByArriveDate retrieves all flights for a specific arrival date
+// GetFlightsByArriveDate retrieves all flights arriving on a local date
 func (fs *FlightService) GetFlightsByArriveDate(date string) ([]models.Flight, error) {
 	if date == "" {
 		return nil, errors.New("arrival date is required")
 	}
+	if _, err := time.Parse(dateLayout, date); err != nil {
+		return nil, fmt.Errorf("%w: arrival date must be formatted as YYYY-MM-DD", ErrInvalidFlightSearch)
+	}
 
 	flights, err := fs.Repo.FindByArriveDate(date)
 	if err != nil {
@@ -197,14 +482,8 @@ func (fs *FlightService) GetFlightsByClass(class string) ([]models.Flight, error
 		return nil, errors.New("flight class is required")
 	}
 
-	// Validate class
-	validClasses := map[string]bool{
-		"economy":  true,
-		"business": true,
-		"first":    true,
-	}
-	if !validClasses[class] {
-		return nil, errors.New("invalid flight class. Must be economy, business, or first")
+	if !validCabinClasses[class] {
+		return nil, errors.New("invalid flight class. Must be economy, premium_economy, business, or first")
 	}
 
 	flights, err := fs.Repo.FindByClass(class)
@@ -223,23 +502,6 @@ func (fs *FlightService) GetDirectFlights(direct bool) ([]models.Flight, error)
 	return flights, nil
 }
 
-// GetFlightsSortedByPrice retrieves flights sorted by price
-func (fs *FlightService) GetFlightsSortedByPrice(ascending bool) ([]models.Flight, error) {
-	var flights []models.Flight
-	var err error
-
-	if ascending {
-		flights, err = fs.Repo.SortFromLowerToUpper()
-	} else {
-		flights, err = fs.Repo.SortFromHigherToLower()
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve sorted flights: %w", err)
-	}
-	return flights, nil
-}
-
 // GetFlightsByUser retrieves all flights booked by a specific user
 func (fs *FlightService) GetFlightsByUser(userId uint) ([]models.Flight, error) {
 	if userId == 0 {
@@ -253,60 +515,394 @@ func (fs *FlightService) GetFlightsByUser(userId uint) ([]models.Flight, error)
 	return flights, nil
 }
 
-// CreateFlight creates a new flight (admin only)
-func (fs *FlightService) CreateFlight(flight *models.Flight) error {
-	if flight == nil {
-		return errors.New("flight data is required")
-	}
-
-	// Validate flight data
-	if flight.City == "" {
-		return errors.New("flight city is required")
-	}
-	if flight.Price <= 0 {
-		return errors.New("flight price must be greater than 0")
-	}
-	if flight.SeatsAvailable < 0 {
-		return errors.New("seats available cannot be negative")
+// CreateFlight creates a new flight with its fares (admin only). Every seat
+// of each fare starts out available.
+func (fs *FlightService) CreateFlight(details FlightDetails) (*models.Flight, error) {
+	flight := &models.Flight{Status: models.FlightStatusScheduled}
+	if err := applyFlightDetails(flight, details); err != nil {
+		return nil, err
 	}
 
 	if err := fs.Repo.CreateFlight(flight); err != nil {
-		return fmt.Errorf("failed to create flight: %w", err)
+		return nil, fmt.Errorf("failed to create flight: %w", err)
 	}
-	return nil
+	return flight, nil
 }
 
-// UpdateFlight updates an existing flight (admin only)
-func (fs *FlightService) UpdateFlight(flight *models.Flight) error {
-	if flight == nil {
-		return errors.New("flight data is required")
-	}
-	if flight.ID == 0 {
-		return errors.New("flight ID is required")
+// UpdateFlight replaces the details and fares of an existing flight (admin
+// only). Fares are matched by cabin and fare family. Seats that are already
+// booked stay booked, so a fare cannot drop below its booked seats and fares
+// with bookings cannot be removed.
+func (fs *FlightService) UpdateFlight(id uint, details FlightDetails) (*models.Flight, error) {
+	if id == 0 {
+		return nil, errors.New("flight ID is required")
 	}
 
 	// Verify flight exists
-	_, err := fs.Repo.GetFlightById(flight.ID)
+	flight, err := fs.Repo.GetFlightById(id)
 	if err != nil {
-		return fmt.Errorf("flight not found: %w", err)
+		return nil, fmt.Errorf("flight not found: %w", err)
+	}
+	if flight.Status == models.FlightStatusCancelled {
+		return nil, ErrFlightCancelled
+	}
+
+	if err := applyFlightDetails(flight, details); err != nil {
+		return nil, err
 	}
 
 	if err := fs.Repo.UpdateFlight(flight); err != nil {
-		return fmt.Errorf("failed to update flight: %w", err)
+		if errors.Is(err, repos.ErrSeatsBooked) {
+			return nil, invalidFlight("fares cannot have fewer seats than are already booked, and fares with bookings cannot be removed")
+		}
+		if errors.Is(err, repos.ErrFlightCancelled) {
+			return nil, ErrFlightCancelled
+		}
+		return nil, fmt.Errorf("failed to update flight: %w", err)
 	}
-	return nil
+	return flight, nil
 }
 
-// DeleteFlight deletes a flight (admin only)
+// DeleteFlight deletes a flight (admin only). Flights with active bookings
+// have to be cancelled with CancelFlightAndNotify instead.
 func (fs *FlightService) DeleteFlight(id uint) error {
 	if id == 0 {
 		return errors.New("invalid flight ID")
 	}
 
-	// Check if flight has active bookings
-	// You might want to prevent deletion if there are active bookings
 	if err := fs.Repo.DeleteFlight(id); err != nil {
+		if errors.Is(err, repos.ErrFlightBooked) {
+			return ErrFlightHasReservations
+		}
 		return fmt.Errorf("failed to delete flight: %w", err)
 	}
 	return nil
 }
+
+// FlightCancellation reports what cancelling a flight affected
+type FlightCancellation struct {
+	Flight                *models.Flight `json:"flight"`
+	CancelledReservations int            `json:"cancelled_reservations"`
+	NotifiedUsers         int            `json:"notified_users"`
+	// OptedOutUsers turned off booking updates by email
+	OptedOutUsers int `json:"opted_out_users"`
+}
+
+// CancelFlightAndNotify cancels a flight and all of its bookings, then emails
+// every affected user who gets booking updates by email (admin only). Emails
+// that cannot be sent are logged and do not undo the cancellation.
+func (fs *FlightService) CancelFlightAndNotify(id uint, reason string) (*FlightCancellation, error) {
+	if id == 0 {
+		return nil, errors.New("invalid flight ID")
+	}
+
+	flight, err := fs.Repo.GetFlightById(id)
+	if err != nil {
+		return nil, fmt.Errorf("flight not found: %w", err)
+	}
+	if flight.Status == models.FlightStatusCancelled {
+		return nil, ErrFlightCancelled
+	}
+
+	reservations, err := fs.Repo.CancelFlight(id)
+	if err != nil {
+		if errors.Is(err, repos.ErrFlightCancelled) {
+			return nil, ErrFlightCancelled
+		}
+		return nil, fmt.Errorf("failed to cancel flight: %w", err)
+	}
+	flight.Status = models.FlightStatusCancelled
+	flight.SeatsAvailable = 0
+
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		reason = "The airline has cancelled this flight."
+	}
+	notified, optedOut := 0, 0
+	seen := make(map[string]bool, len(reservations))
+	for _, res := range reservations {
+		if seen[res.UserID] {
+			continue
+		}
+		seen[res.UserID] = true
+
+		userId, err := strconv.ParseUint(res.UserID, 10, 64)
+		if err != nil {
+			continue
+		}
+		if !fs.Preferences.AllowsNotification(uint(userId), models.ChannelEmail) {
+			optedOut++
+			continue
+		}
+		user, err := fs.UserRepo.GetUserById(uint(userId))
+		if err != nil {
+			log.Printf("Unable to notify user %d of cancelled flight %d: %v", userId, id, err)
+			continue
+		}
+		body := fmt.Sprintf("Hello %s,\n\nYour flight %s from %s to %s departing %s local time has been cancelled, and so has your booking.\n\n%s\n\nYou can book another flight at any time.",
+			user.Name, flight.FlightNumber, flight.Origin, flight.Destination, flight.LocalDeparture().Format("2 Jan 2006 15:04"), reason)
+		if err := fs.Mailer.Send(user.Email, "Your flight has been cancelled", body); err != nil {
+			log.Printf("Unable to notify user %d of cancelled flight %d: %v", userId, id, err)
+			continue
+		}
+		notified++
+	}
+
+	return &FlightCancellation{
+		Flight:                flight,
+		CancelledReservations: len(reservations),
+		NotifiedUsers:         notified,
+		OptedOutUsers:         optedOut,
+	}, nil
+}
+
+// applyFlightDetails checks the details entered for a flight and copies them
+// onto it
+func applyFlightDetails(flight *models.Flight, details FlightDetails) error {
+	flightNumber := strings.ToUpper(strings.ReplaceAll(details.FlightNumber, " ", ""))
+	origin := strings.ToUpper(strings.TrimSpace(details.Origin))
+	destination := strings.ToUpper(strings.TrimSpace(details.Destination))
+	cabinClass := strings.ToLower(strings.TrimSpace(details.CabinClass))
+	if cabinClass == "" {
+		cabinClass = models.CabinEconomy
+	}
+
+	if !flightNumberPattern.MatchString(flightNumber) {
+		return invalidFlight("flight number must be an airline code followed by up to 4 digits, such as BA117")
+	}
+	airline := strings.TrimSpace(details.Airline)
+	if airline == "" || len(airline) > 100 {
+		return invalidFlight("airline must be between 1 and 100 characters")
+	}
+	if !iataPattern.MatchString(origin) || !iataPattern.MatchString(destination) {
+		return invalidFlight("origin and destination must be 3-letter IATA airport codes")
+	}
+	if origin == destination {
+		return invalidFlight("origin and destination airports must differ")
+	}
+	city := strings.TrimSpace(details.City)
+	if city == "" {
+		return invalidFlight("flight city is required")
+	}
+
+	departureZone, err := loadAirportTimeZone(details.DepartureTimeZone)
+	if err != nil {
+		return invalidFlight("departure time zone must be an IANA time zone such as Europe/London")
+	}
+	arrivalZone, err := loadAirportTimeZone(details.ArrivalTimeZone)
+	if err != nil {
+		return invalidFlight("arrival time zone must be an IANA time zone such as America/New_York")
+	}
+	departure, ok := pkg.ParseDateTimeIn(strings.TrimSpace(details.Departure), departureZone)
+	if !ok {
+		return invalidFlight("departure must be a local date and time such as 2006-01-02T15:04")
+	}
+	arrival, ok := pkg.ParseDateTimeIn(strings.TrimSpace(details.Arrival), arrivalZone)
+	if !ok {
+		return invalidFlight("arrival must be a local date and time such as 2006-01-02T15:04")
+	}
+	if !arrival.After(departure) {
+		return invalidFlight("arrival must be after departure")
+	}
+
+	aircraft := strings.TrimSpace(details.Aircraft)
+	if len(aircraft) > 50 {
+		return invalidFlight("aircraft must be at most 50 characters")
+	}
+	if !validCabinClasses[cabinClass] {
+		return invalidFlight("cabin class must be economy, premium_economy, business or first")
+	}
+	if details.Stops < 0 || details.Stops > flightMaxStops {
+		return invalidFlight(fmt.Sprintf("stops must be between 0 and %d", flightMaxStops))
+	}
+	fares := details.Fares
+	if len(fares) == 0 {
+		if details.Price <= 0 {
+			return invalidFlight("price must be greater than 0")
+		}
+		if details.Capacity <= 0 {
+			return invalidFlight("capacity must be greater than 0")
+		}
+		standard := models.StandardFare(cabinClass, details.Price, details.Capacity)
+		fares = []FareDetails{{
+			Cabin:        standard.Cabin,
+			FareFamily:   standard.FareFamily,
+			Price:        standard.Price,
+			Seats:        standard.Capacity,
+			Refundable:   standard.Refundable,
+			Changeable:   standard.Changeable,
+			CabinBags:    standard.CabinBags,
+			CheckedBags:  standard.CheckedBags,
+			CheckedBagKg: standard.CheckedBagKg,
+		}}
+	}
+	if err := applyFares(flight, fares); err != nil {
+		return err
+	}
+
+	flight.FlightNumber = flightNumber
+	flight.Airline = airline
+	flight.Origin = origin
+	flight.Destination = destination
+	flight.City = city
+	flight.SetSchedule(departure, arrival)
+	flight.Aircraft = aircraft
+	flight.CabinClass = cabinClass
+	flight.Stops = details.Stops
+	return nil
+}
+
+// applyFares checks the fares entered for a flight and replaces its fares
+// with them, keeping the IDs of fares of the same cabin and family so their
+// booked seats stay booked. The flight's cabin and price become those of its
+// cheapest fare and its capacity the sum over all fares. Seats available are
+// those of a new flight; FlightRepo.UpdateFlight works out the seats left on
+// an existing one.
+func applyFares(flight *models.Flight, fares []FareDetails) error {
+	if len(fares) > flightMaxFares {
+		return invalidFlight(fmt.Sprintf("a flight has at most %d fares", flightMaxFares))
+	}
+
+	existing := make(map[string]models.FareBucket, len(flight.Fares))
+	for _, bucket := range flight.Fares {
+		existing[bucket.Cabin+"/"+bucket.FareFamily] = bucket
+	}
+	listed := make(map[string]bool, len(fares))
+	buckets := make([]models.FareBucket, 0, len(fares))
+	for _, fare := range fares {
+		cabin := strings.ToLower(strings.TrimSpace(fare.Cabin))
+		family := strings.ToLower(strings.TrimSpace(fare.FareFamily))
+		if !validCabinClasses[cabin] {
+			return invalidFlight("fare cabin must be economy, premium_economy, business or first")
+		}
+		if !fareFamilyPattern.MatchString(family) {
+			return invalidFlight("fare family must be 2 to 20 lowercase letters, digits or underscores, such as basic or flex")
+		}
+		key := cabin + "/" + family
+		if listed[key] {
+			return invalidFlight(fmt.Sprintf("fare %s is listed twice", key))
+		}
+		listed[key] = true
+		if fare.Price <= 0 {
+			return invalidFlight(fmt.Sprintf("price of fare %s must be greater than 0", key))
+		}
+		if fare.Seats <= 0 {
+			return invalidFlight(fmt.Sprintf("seats of fare %s must be greater than 0", key))
+		}
+		if fare.ChangeFee < 0 || (!fare.Changeable && fare.ChangeFee > 0) {
+			return invalidFlight(fmt.Sprintf("fare %s can only have a change fee when it is changeable", key))
+		}
+		if fare.CabinBags < 0 || fare.CheckedBags < 0 || fare.CheckedBagKg < 0 {
+			return invalidFlight(fmt.Sprintf("baggage allowance of fare %s cannot be negative", key))
+		}
+		if fare.CheckedBags > 0 && fare.CheckedBagKg == 0 {
+			return invalidFlight(fmt.Sprintf("fare %s must give the weight allowed per checked bag", key))
+		}
+
+		bucket := models.FareBucket{
+			FlightID:       flight.ID,
+			Cabin:          cabin,
+			FareFamily:     family,
+			Price:          fare.Price,
+			Capacity:       fare.Seats,
+			SeatsAvailable: fare.Seats,
+			Refundable:     fare.Refundable,
+			Changeable:     fare.Changeable,
+			ChangeFee:      fare.ChangeFee,
+			CabinBags:      fare.CabinBags,
+			CheckedBags:    fare.CheckedBags,
+			CheckedBagKg:   fare.CheckedBagKg,
+		}
+		if old, ok := existing[key]; ok {
+			if bucket.Capacity < old.Booked() {
+				return invalidFlight(fmt.Sprintf("seats of fare %s cannot be less than the %d already booked", key, old.Booked()))
+			}
+			bucket.ID = old.ID
+		}
+		buckets = append(buckets, bucket)
+	}
+	for key, old := range existing {
+		if !listed[key] && old.Booked() > 0 {
+			return invalidFlight(fmt.Sprintf("fare %s has %d seats booked and cannot be removed", key, old.Booked()))
+		}
+	}
+
+	flight.Fares = buckets
+	flight.Capacity, flight.SeatsAvailable = 0, 0
+	for i, bucket := range buckets {
+		if i == 0 || bucket.Price < flight.Price {
+			flight.Price = bucket.Price
+			flight.CabinClass = bucket.Cabin
+		}
+		flight.Capacity += bucket.Capacity
+		flight.SeatsAvailable += bucket.SeatsAvailable
+	}
+	return nil
+}
+
+// loadAirportTimeZone loads a named IANA time zone. "Local" and the empty
+// name are refused because they depend on the server.
+func loadAirportTimeZone(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "Local" {
+		return nil, errors.New("time zone is required")
+	}
+	return time.LoadLocation(name)
+}
+
+// invalidFlight returns a validation error wrapping ErrInvalidFlight
+func invalidFlight(message string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidFlight, message)
+}
+
+// FlightView is a flight with its price and times rendered for the caller.
+// Departure and Arrival are the local times at the airports, with their offsets.
+type FlightView struct {
+	models.Flight
+	Departure string          `json:"departure"`
+	Arrival   string          `json:"arrival"`
+	Localized LocalizedFlight `json:"localized"`
+}
+
+type LocalizedFlight struct {
+	Price     pkg.LocalizedPrice `json:"price"`
+	Departure string             `json:"departure"`
+	Arrival   string             `json:"arrival"`
+	Fares     []LocalizedFare    `json:"fares,omitempty"`
+}
+
+// LocalizedFare is the price and change fee of a fare, by fare ID
+type LocalizedFare struct {
+	FareID    uint               `json:"fare_id"`
+	Price     pkg.LocalizedPrice `json:"price"`
+	ChangeFee pkg.LocalizedPrice `json:"change_fee"`
+}
+
+func NewFlightView(flight *models.Flight, localizer *pkg.Localizer) FlightView {
+	view := FlightView{
+		Flight:    *flight,
+		Departure: flight.LocalDeparture().Format(time.RFC3339),
+		Arrival:   flight.LocalArrival().Format(time.RFC3339),
+		Localized: LocalizedFlight{
+			Price:     localizer.Price(flight.Price),
+			Departure: localizer.Time(flight.DepartureAt),
+			Arrival:   localizer.Time(flight.ArrivalAt),
+		},
+	}
+	for _, fare := range flight.Fares {
+		view.Localized.Fares = append(view.Localized.Fares, LocalizedFare{
+			FareID:    fare.ID,
+			Price:     localizer.Price(fare.Price),
+			ChangeFee: localizer.Price(fare.ChangeFee),
+		})
+	}
+	return view
+}
+
+func NewFlightViews(flights []models.Flight, localizer *pkg.Localizer) []FlightView {
+	views := make([]FlightView, len(flights))
+	for i := range flights {
+		views[i] = NewFlightView(&flights[i], localizer)
+	}
+	return views
+}
@@ -0,0 +1,378 @@
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	testBasicFare    = FareDetails{Cabin: models.CabinEconomy, FareFamily: "basic", Price: 100, Seats: 2}
+	testFlexFare     = FareDetails{Cabin: models.CabinEconomy, FareFamily: "flex", Price: 150, Seats: 5, Refundable: true, Changeable: true}
+	testBusinessFare = FareDetails{Cabin: models.CabinBusiness, FareFamily: "flex", Price: 900, Seats: 2, Refundable: true, Changeable: true}
+)
+
+// fareId finds the ID of a fare of a flight
+func fareId(t *testing.T, flight *models.Flight, cabin, family string) uint {
+	t.Helper()
+	for _, fare := range flight.Fares {
+		if fare.Cabin == cabin && fare.FareFamily == family {
+			return fare.ID
+		}
+	}
+	t.Fatalf("flight %s has no %s %s fare", flight.FlightNumber, cabin, family)
+	return 0
+}
+
+// recordingMailer keeps the recipients of the emails it sends
+type recordingMailer struct {
+	sent []string
+}
+
+func (m *recordingMailer) Send(to, subject, body string) error {
+	m.sent = append(m.sent, to)
+	return nil
+}
+
+func TestBookFlightTakesSeatsFromFare(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	flight := createTestFlight(t, fs, "TA100", "LHR", "JFK", time.Now().Add(48*time.Hour), testBasicFare, testFlexFare)
+	basic, flex := fareId(t, flight, models.CabinEconomy, "basic"), fareId(t, flight, models.CabinEconomy, "flex")
+	users := []*models.User{
+		createTestUser(t, db, "one@example.com", RoleUser),
+		createTestUser(t, db, "two@example.com", RoleUser),
+		createTestUser(t, db, "three@example.com", RoleUser),
+	}
+
+	// The cheapest fare with a seat is booked unless one is chosen
+	if err := fs.BookFlight(users[0].ID, flight.ID, FareChoice{}, nil); err != nil {
+		t.Fatalf("BookFlight: %v", err)
+	}
+	if err := fs.BookFlight(users[1].ID, flight.ID, FareChoice{FareFamily: "basic"}, nil); err != nil {
+		t.Fatalf("BookFlight: %v", err)
+	}
+	if err := fs.BookFlight(users[2].ID, flight.ID, FareChoice{FareFamily: "basic"}, nil); !errors.Is(err, ErrNotEnoughSeats) {
+		t.Fatalf("BookFlight of a sold out fare = %v, want %v", err, ErrNotEnoughSeats)
+	}
+	if err := fs.BookFlight(users[2].ID, flight.ID, FareChoice{Cabin: models.CabinBusiness}, nil); !errors.Is(err, ErrInvalidFare) {
+		t.Fatalf("BookFlight of a cabin that is not sold = %v, want %v", err, ErrInvalidFare)
+	}
+	if err := fs.BookFlight(users[2].ID, flight.ID, FareChoice{}, nil); err != nil {
+		t.Fatalf("BookFlight: %v", err)
+	}
+
+	seats, fares := seatsAvailable(t, db, flight.ID)
+	if seats != 4 || fares[basic] != 0 || fares[flex] != 4 {
+		t.Errorf("flight has %d seats, basic %d and flex %d available, want 4, 0 and 4", seats, fares[basic], fares[flex])
+	}
+	res, err := fs.ReservationRepo.GetActiveFlightReservation(users[2].ID, flight.ID)
+	if err != nil {
+		t.Fatalf("GetActiveFlightReservation: %v", err)
+	}
+	if segment := res.Segments[0]; segment.FareBucketID == nil || *segment.FareBucketID != flex || segment.Price != 150 || res.TotalPrice != 150 {
+		t.Errorf("booking has fare %v at %v for %v, want fare %d at 150 for 150", segment.FareBucketID, segment.Price, res.TotalPrice, flex)
+	}
+}
+
+func TestUpdateFlightKeepsBookedSeats(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	departure := time.Now().Add(48 * time.Hour)
+	flight := createTestFlight(t, fs, "TA100", "LHR", "JFK", departure, testBasicFare, testFlexFare)
+	basic, flex := fareId(t, flight, models.CabinEconomy, "basic"), fareId(t, flight, models.CabinEconomy, "flex")
+	users := []*models.User{
+		createTestUser(t, db, "one@example.com", RoleUser),
+		createTestUser(t, db, "two@example.com", RoleUser),
+		createTestUser(t, db, "three@example.com", RoleUser),
+	}
+	if err := fs.BookFlight(users[0].ID, flight.ID, FareChoice{FareFamily: "basic"}, nil); err != nil {
+		t.Fatalf("BookFlight: %v", err)
+	}
+
+	moreBasic := testBasicFare
+	moreBasic.Seats = 4
+	updated, err := fs.UpdateFlight(flight.ID, testFlightDetails("TA100", "LHR", "JFK", departure, moreBasic, testFlexFare))
+	if err != nil {
+		t.Fatalf("UpdateFlight: %v", err)
+	}
+	if updated.Capacity != 9 || updated.SeatsAvailable != 8 {
+		t.Errorf("updated flight has capacity %d with %d available, want 9 with 8", updated.Capacity, updated.SeatsAvailable)
+	}
+	if _, fares := seatsAvailable(t, db, flight.ID); fares[basic] != 3 || fares[flex] != 5 {
+		t.Errorf("basic has %d and flex %d seats available, want 3 and 5", fares[basic], fares[flex])
+	}
+
+	if _, err := fs.UpdateFlight(flight.ID, testFlightDetails("TA100", "LHR", "JFK", departure, testFlexFare)); !errors.Is(err, ErrInvalidFlight) {
+		t.Errorf("UpdateFlight removing a booked fare = %v, want %v", err, ErrInvalidFlight)
+	}
+
+	// A booking made while the update was being prepared stays booked
+	stale, err := fs.Repo.GetFlightById(flight.ID)
+	if err != nil {
+		t.Fatalf("GetFlightById: %v", err)
+	}
+	moreFlex := testFlexFare
+	moreFlex.Seats = 6
+	if err := applyFlightDetails(stale, testFlightDetails("TA100", "LHR", "JFK", departure, moreBasic, moreFlex)); err != nil {
+		t.Fatalf("applyFlightDetails: %v", err)
+	}
+	if err := fs.BookFlight(users[1].ID, flight.ID, FareChoice{FareFamily: "basic"}, nil); err != nil {
+		t.Fatalf("BookFlight: %v", err)
+	}
+	if err := fs.Repo.UpdateFlight(stale); err != nil {
+		t.Fatalf("UpdateFlight: %v", err)
+	}
+	seats, fares := seatsAvailable(t, db, flight.ID)
+	if seats != 8 || fares[basic] != 2 || fares[flex] != 6 {
+		t.Errorf("flight has %d seats, basic %d and flex %d available, want 8, 2 and 6", seats, fares[basic], fares[flex])
+	}
+
+	// Nor can such a booking leave a fare with fewer seats than are booked
+	stale, err = fs.Repo.GetFlightById(flight.ID)
+	if err != nil {
+		t.Fatalf("GetFlightById: %v", err)
+	}
+	if err := applyFlightDetails(stale, testFlightDetails("TA100", "LHR", "JFK", departure, testBasicFare, moreFlex)); err != nil {
+		t.Fatalf("applyFlightDetails: %v", err)
+	}
+	if err := fs.BookFlight(users[2].ID, flight.ID, FareChoice{FareFamily: "basic"}, nil); err != nil {
+		t.Fatalf("BookFlight: %v", err)
+	}
+	if err := fs.Repo.UpdateFlight(stale); !errors.Is(err, repos.ErrSeatsBooked) {
+		t.Fatalf("UpdateFlight below the booked seats = %v, want %v", err, repos.ErrSeatsBooked)
+	}
+	seats, fares = seatsAvailable(t, db, flight.ID)
+	if seats != 7 || fares[basic] != 1 || fares[flex] != 6 {
+		t.Errorf("flight has %d seats, basic %d and flex %d available, want 7, 1 and 6", seats, fares[basic], fares[flex])
+	}
+}
+
+func TestCancelRefundsByFare(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	ts := newTestTripService(fs)
+	departure := time.Now().Add(48 * time.Hour)
+	outbound := createTestFlight(t, fs, "TA100", "LHR", "JFK", departure, testBasicFare, testFlexFare)
+	inbound := createTestFlight(t, fs, "TA101", "JFK", "LHR", departure.Add(24*time.Hour), testBasicFare, testFlexFare)
+	journeys := [][]uint{{outbound.ID}, {inbound.ID}}
+
+	tests := []struct {
+		family string
+		refund float64
+	}{
+		{"basic", 0},
+		{"flex", 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.family, func(t *testing.T) {
+			user := createTestUser(t, db, tt.family+"@example.com", RoleUser)
+			trip, err := ts.BookTrip(user.ID, models.TripRoundTrip, journeys, FareChoice{FareFamily: tt.family}, nil)
+			if err != nil {
+				t.Fatalf("BookTrip: %v", err)
+			}
+			cancelled, err := ts.CancelTrip(user.ID, trip.Reference)
+			if err != nil {
+				t.Fatalf("CancelTrip: %v", err)
+			}
+			if cancelled.Status != "cancelled" || cancelled.RefundAmount != tt.refund {
+				t.Errorf("cancelled trip is %s with a refund of %v, want cancelled with %v", cancelled.Status, cancelled.RefundAmount, tt.refund)
+			}
+		})
+	}
+
+	// The airline cancelling a flight refunds every fare
+	user := createTestUser(t, db, "airline@example.com", RoleUser)
+	trip, err := ts.BookTrip(user.ID, models.TripRoundTrip, journeys, FareChoice{FareFamily: "basic"}, nil)
+	if err != nil {
+		t.Fatalf("BookTrip: %v", err)
+	}
+	if _, err := fs.CancelFlightAndNotify(inbound.ID, ""); err != nil {
+		t.Fatalf("CancelFlightAndNotify: %v", err)
+	}
+	cancelled, err := ts.GetTrip(user.ID, trip.Reference)
+	if err != nil {
+		t.Fatalf("GetTrip: %v", err)
+	}
+	if cancelled.Status != "cancelled" || cancelled.RefundAmount != 200 {
+		t.Errorf("trip is %s with a refund of %v, want cancelled with 200", cancelled.Status, cancelled.RefundAmount)
+	}
+	if seats, _ := seatsAvailable(t, db, outbound.ID); seats != outbound.Capacity {
+		t.Errorf("outbound has %d seats available, want %d", seats, outbound.Capacity)
+	}
+}
+
+func TestSearchFlightsPricesMatchingFare(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	departure := time.Now().Add(48 * time.Hour)
+	cheapBusiness := testBusinessFare
+	cheapBusiness.Price, cheapBusiness.Seats = 500, 4
+	dearEconomy := testBasicFare
+	dearEconomy.Price = 300
+	a := createTestFlight(t, fs, "TA100", "LHR", "JFK", departure, testBasicFare, testBusinessFare)
+	b := createTestFlight(t, fs, "TA200", "LHR", "JFK", departure.Add(time.Hour), dearEconomy, cheapBusiness)
+	c := createTestFlight(t, fs, "TA300", "LHR", "JFK", departure.Add(2*time.Hour))
+
+	tests := []struct {
+		name   string
+		search FlightSearch
+		ids    []uint
+		prices []float64
+	}{
+		{"any cabin", FlightSearch{Sort: "price"}, []uint{a.ID, c.ID, b.ID}, []float64{100, 100, 300}},
+		{"business", FlightSearch{CabinClass: models.CabinBusiness, Sort: "price"}, []uint{b.ID, a.ID}, []float64{500, 900}},
+		{"business descending", FlightSearch{CabinClass: models.CabinBusiness, Sort: "price", Order: "desc"}, []uint{a.ID, b.ID}, []float64{900, 500}},
+		{"business for three", FlightSearch{CabinClass: models.CabinBusiness, Passengers: 3}, []uint{b.ID}, []float64{500}},
+		{"economy under 200", FlightSearch{CabinClass: models.CabinEconomy, MaxPrice: 200, Sort: "price"}, []uint{a.ID, c.ID}, []float64{100, 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.search.Origin, tt.search.Destination = "LHR", "JFK"
+			result, err := fs.SearchFlights(tt.search)
+			if err != nil {
+				t.Fatalf("SearchFlights: %v", err)
+			}
+			if result.Total != int64(len(tt.ids)) || len(result.Flights) != len(tt.ids) {
+				t.Fatalf("found %d of %d flights, want %d", len(result.Flights), result.Total, len(tt.ids))
+			}
+			for i, flight := range result.Flights {
+				if flight.ID != tt.ids[i] || flight.Price != tt.prices[i] {
+					t.Errorf("result %d is flight %d at %v, want flight %d at %v", i, flight.ID, flight.Price, tt.ids[i], tt.prices[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteFlightWhileBooking(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+	flight := createTestFlight(t, fs, "TA100", "LHR", "JFK", time.Now().Add(48*time.Hour))
+
+	// Book the flight as soon as the deletion has checked for bookings, and
+	// give the booking the time to commit before the deletion goes on
+	var once sync.Once
+	booked := make(chan error, 1)
+	err := db.Callback().Query().After("gorm:query").Register("test:book_during_delete", func(tx *gorm.DB) {
+		if tx.Statement.Table != "reservations" {
+			return
+		}
+		once.Do(func() {
+			go func() { booked <- fs.BookFlight(user.ID, flight.ID, FareChoice{}, nil) }()
+			select {
+			case err := <-booked:
+				booked <- err
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	})
+	if err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+
+	deleteErr := fs.DeleteFlight(flight.ID)
+	bookErr := <-booked
+	if bookErr == nil && deleteErr == nil {
+		t.Fatal("the flight was deleted and booked")
+	}
+	if bookErr == nil && !errors.Is(deleteErr, ErrFlightHasReservations) {
+		t.Errorf("DeleteFlight of a booked flight = %v, want %v", deleteErr, ErrFlightHasReservations)
+	}
+
+	var orphaned int64
+	if err := db.Model(&models.Reservation{}).
+		Where("flight_id NOT IN (?)", db.Model(&models.Flight{}).Select("id")).
+		Count(&orphaned).Error; err != nil {
+		t.Fatalf("count reservations: %v", err)
+	}
+	if orphaned != 0 {
+		t.Errorf("%d reservations point at a deleted flight", orphaned)
+	}
+}
+
+func TestGetFlightsByUser(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	ts := newTestTripService(fs)
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+	other := createTestUser(t, db, "other@example.com", RoleUser)
+
+	departure := time.Now().Add(48 * time.Hour)
+	outbound := createTestFlight(t, fs, "TA100", "LHR", "JFK", departure)
+	inbound := createTestFlight(t, fs, "TA101", "JFK", "LHR", departure.Add(72*time.Hour))
+	cancelled := createTestFlight(t, fs, "TA200", "LHR", "CDG", departure.Add(24*time.Hour))
+	othersFlight := createTestFlight(t, fs, "TA300", "LHR", "AMS", departure)
+
+	if _, err := ts.BookTrip(user.ID, models.TripRoundTrip, [][]uint{{outbound.ID}, {inbound.ID}}, FareChoice{}, nil); err != nil {
+		t.Fatalf("BookTrip: %v", err)
+	}
+	if err := fs.BookFlight(user.ID, cancelled.ID, FareChoice{}, nil); err != nil {
+		t.Fatalf("BookFlight: %v", err)
+	}
+	if err := fs.CancelFlight(user.ID, cancelled.ID); err != nil {
+		t.Fatalf("CancelFlight: %v", err)
+	}
+	if err := fs.BookFlight(other.ID, othersFlight.ID, FareChoice{}, nil); err != nil {
+		t.Fatalf("BookFlight: %v", err)
+	}
+
+	flights, err := fs.GetFlightsByUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetFlightsByUser: %v", err)
+	}
+	var ids []uint
+	for _, flight := range flights {
+		ids = append(ids, flight.ID)
+	}
+	if len(ids) != 2 || ids[0] != outbound.ID || ids[1] != inbound.ID {
+		t.Errorf("GetFlightsByUser = %v, want the booked flights %d and %d", ids, outbound.ID, inbound.ID)
+	}
+}
+
+func TestCancelFlightTwice(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	mailer := &recordingMailer{}
+	fs.Mailer = mailer
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+	flight := createTestFlight(t, fs, "TA100", "LHR", "JFK", time.Now().Add(48*time.Hour))
+	if err := fs.BookFlight(user.ID, flight.ID, FareChoice{}, nil); err != nil {
+		t.Fatalf("BookFlight: %v", err)
+	}
+
+	// A second cancellation starts from the flight as it was before the
+	// first one
+	started := false
+	var again *FlightCancellation
+	var againErr error
+	err := db.Callback().Query().After("gorm:query").Register("test:cancel_during_cancel", func(tx *gorm.DB) {
+		if tx.Statement.Table != "flights" || started {
+			return
+		}
+		started = true
+		again, againErr = fs.CancelFlightAndNotify(flight.ID, "")
+	})
+	if err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+
+	if _, err := fs.CancelFlightAndNotify(flight.ID, ""); !errors.Is(err, ErrFlightCancelled) {
+		t.Errorf("CancelFlightAndNotify of a flight cancelled meanwhile = %v, want %v", err, ErrFlightCancelled)
+	}
+	if againErr != nil {
+		t.Fatalf("CancelFlightAndNotify: %v", againErr)
+	}
+	if again.CancelledReservations != 1 || len(mailer.sent) != 1 {
+		t.Errorf("cancelled %d reservations and sent %d emails, want 1 and 1", again.CancelledReservations, len(mailer.sent))
+	}
+	if _, err := fs.Repo.CancelFlight(flight.ID); !errors.Is(err, repos.ErrFlightCancelled) {
+		t.Errorf("CancelFlight of a cancelled flight = %v, want %v", err, repos.ErrFlightCancelled)
+	}
+}
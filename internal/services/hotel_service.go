@@ -4,6 +4,7 @@ package services
 import (
 	"Visa/internal/repos"
 	"Visa/models"
+	"Visa/pkg"
 	"errors"
 	"fmt"
 	"strconv"
@@ -12,12 +13,16 @@ import (
 type HotelService struct {
 	Repo            *repos.HotelRepo
 	ReservationRepo *repos.ReservationRepo
+	UserRepo        *repos.UserRepo
+	TravellerRepo   *repos.TravellerRepo
 }
 
-func NewHotelService(hotelRepo *repos.HotelRepo, reservationRepo *repos.ReservationRepo) *HotelService {
+func NewHotelService(hotelRepo *repos.HotelRepo, reservationRepo *repos.ReservationRepo, userRepo *repos.UserRepo, travellerRepo *repos.TravellerRepo) *HotelService {
 	return &HotelService{
 		Repo:            hotelRepo,
 		ReservationRepo: reservationRepo,
+		UserRepo:        userRepo,
+		TravellerRepo:   travellerRepo,
 	}
 }
 
@@ -89,8 +94,9 @@ func (hs *HotelService) DeleteHotel(hotelId uint) error {
 	return nil
 }
 
-// BookHotel books a hotel room for a user
-func (hs *HotelService) BookHotel(userId uint, hotelId uint) error {
+// BookHotel books a hotel room for a user. travellerIds optionally name the
+// guests from the user's saved travellers.
+func (hs *HotelService) BookHotel(userId uint, hotelId uint, travellerIds []uint) error {
 	// Validate input
 	if userId == 0 {
 		return errors.New("invalid user ID")
@@ -99,12 +105,22 @@ func (hs *HotelService) BookHotel(userId uint, hotelId uint) error {
 		return errors.New("invalid hotel ID")
 	}
 
+	// Only verified accounts can book
+	if err := requireVerifiedEmail(hs.UserRepo, userId); err != nil {
+		return err
+	}
+
 	// Get hotel details
 	hotel, err := hs.Repo.GetHotelById(hotelId)
 	if err != nil {
 		return fmt.Errorf("hotel not found: %w", err)
 	}
 
+	travellers, err := loadTravellers(hs.TravellerRepo, userId, travellerIds)
+	if err != nil {
+		return err
+	}
+
 	// Check room availability
 	if hotel.AvailableRooms <= 0 {
 		return errors.New("no rooms available at this hotel")
@@ -123,9 +139,10 @@ func (hs *HotelService) BookHotel(userId uint, hotelId uint) error {
 	// Create reservation
 	hotelIDStr := strconv.FormatUint(uint64(hotelId), 10)
 	res := &models.Reservation{
-		UserID:  strconv.FormatUint(uint64(userId), 10),
-		HotelID: hotelIDStr,
-		Status:  "booked",
+		UserID:     strconv.FormatUint(uint64(userId), 10),
+		HotelID:    hotelIDStr,
+		Status:     "booked",
+		Travellers: travellers,
 	}
 
 	if err := hs.ReservationRepo.CreateReservation(res); err != nil {
@@ -335,3 +352,34 @@ func (hs *HotelService) SearchHotels(city string, minPrice, maxPrice float64, fr
 
 	return filtered, nil
 }
+
+// HotelView is a hotel with its price and dates rendered for the caller
+type HotelView struct {
+	models.Hotel
+	Localized LocalizedHotel `json:"localized"`
+}
+
+type LocalizedHotel struct {
+	PricePerNight pkg.LocalizedPrice `json:"price_per_night"`
+	CheckInDate   string             `json:"check_in_date"`
+	CheckOutDate  string             `json:"check_out_date"`
+}
+
+func NewHotelView(hotel *models.Hotel, localizer *pkg.Localizer) HotelView {
+	return HotelView{
+		Hotel: *hotel,
+		Localized: LocalizedHotel{
+			PricePerNight: localizer.Price(hotel.PricePerNight),
+			CheckInDate:   localizer.Date(hotel.CheckInDate),
+			CheckOutDate:  localizer.Date(hotel.CheckOutDate),
+		},
+	}
+}
+
+func NewHotelViews(hotels []models.Hotel, localizer *pkg.Localizer) []HotelView {
+	views := make([]HotelView, len(hotels))
+	for i := range hotels {
+		views[i] = NewHotelView(&hotels[i], localizer)
+	}
+	return views
+}
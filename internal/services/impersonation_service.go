@@ -0,0 +1,121 @@
+// services/impersonation_service.go
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	auditLogDefaultLimit = 100
+	auditLogMaxLimit     = 1000
+)
+
+var ErrCannotImpersonate = errors.New("only active regular user accounts other than your own can be impersonated")
+
+// ImpersonationToken is returned when a staff member starts impersonating a user
+type ImpersonationToken struct {
+	AccessToken string    `json:"access_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	UserID      uint      `json:"user_id"`
+}
+
+type ImpersonationService struct {
+	Auth      *AuthService
+	UserRepo  *repos.UserRepo
+	AuditRepo *repos.AuditLogRepo
+}
+
+func NewImpersonationService(authService *AuthService, userRepo *repos.UserRepo, auditLogRepo *repos.AuditLogRepo) *ImpersonationService {
+	return &ImpersonationService{
+		Auth:      authService,
+		UserRepo:  userRepo,
+		AuditRepo: auditLogRepo,
+	}
+}
+
+// Start issues a short-lived token with which the staff member actorId acts
+// as the user. Only accounts with the plain user role can be impersonated, so
+// the token never carries staff permissions. The reason is kept in the audit log.
+func (is *ImpersonationService) Start(actorId, userId uint, reason string, client ClientInfo) (*ImpersonationToken, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, errors.New("reason is required")
+	}
+
+	user, err := is.UserRepo.GetUserById(userId)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == actorId || user.Role != RoleUser || user.SuspendedAt != nil || user.ErasedAt != nil {
+		return nil, ErrCannotImpersonate
+	}
+
+	token, expiresAt, sessionId, err := is.Auth.IssueImpersonationToken(actorId, user, client)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &models.AuditLog{
+		ActorID:   actorId,
+		UserID:    user.ID,
+		SessionID: sessionId,
+		Action:    models.AuditImpersonationStarted,
+		Detail:    truncate(reason, 255),
+		IP:        client.IP,
+	}
+	if err := is.AuditRepo.CreateAuditLog(entry); err != nil {
+		// Without an audit trail the token must not be usable
+		if endErr := is.Auth.endSession(sessionId); endErr != nil {
+			return nil, endErr
+		}
+		return nil, fmt.Errorf("failed to write audit log: %w", err)
+	}
+
+	return &ImpersonationToken{
+		AccessToken: token,
+		ExpiresAt:   expiresAt,
+		UserID:      user.ID,
+	}, nil
+}
+
+// RecordRequest adds a request made with an impersonation token to the audit log
+func (is *ImpersonationService) RecordRequest(claims *Claims, method, path string, status int, ip string) error {
+	if claims == nil || claims.ImpersonatorID == 0 {
+		return nil
+	}
+	entry := &models.AuditLog{
+		ActorID:   claims.ImpersonatorID,
+		UserID:    claims.UserID,
+		SessionID: claims.SessionID,
+		Action:    models.AuditImpersonatedRequest,
+		Method:    method,
+		Path:      truncate(path, 255),
+		Status:    status,
+		IP:        ip,
+	}
+	if err := is.AuditRepo.CreateAuditLog(entry); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// GetAuditLogs retrieves the most recent audit log entries, optionally
+// filtered by actor and user
+func (is *ImpersonationService) GetAuditLogs(actorId, userId uint, limit int) ([]models.AuditLog, error) {
+	if limit <= 0 {
+		limit = auditLogDefaultLimit
+	}
+	if limit > auditLogMaxLimit {
+		limit = auditLogMaxLimit
+	}
+	entries, err := is.AuditRepo.GetAuditLogs(actorId, userId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve audit log: %w", err)
+	}
+	return entries, nil
+}
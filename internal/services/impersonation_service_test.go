@@ -0,0 +1,84 @@
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStartImpersonationRejectsTargets(t *testing.T) {
+	db := newTestDB(t)
+	is := NewImpersonationService(newTestAuthService(t, db), repos.NewUserRepo(db), repos.NewAuditLogRepo(db))
+	agent := createTestUser(t, db, "agent@example.com", "support")
+	admin := createTestUser(t, db, "admin@example.com", RoleAdmin)
+	suspended := createTestUser(t, db, "suspended@example.com", RoleUser)
+	if err := db.Model(suspended).Update("suspended_at", time.Now()).Error; err != nil {
+		t.Fatalf("suspend user: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		userId uint
+	}{
+		{"self", agent.ID},
+		{"staff member", admin.ID},
+		{"suspended user", suspended.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := is.Start(agent.ID, tt.userId, "support ticket", ClientInfo{}); !errors.Is(err, ErrCannotImpersonate) {
+				t.Errorf("Start = %v, want %v", err, ErrCannotImpersonate)
+			}
+		})
+	}
+
+	var started int64
+	if err := db.Model(&models.Session{}).Where("impersonator_id IS NOT NULL").Count(&started).Error; err != nil {
+		t.Fatalf("count sessions: %v", err)
+	}
+	if started != 0 {
+		t.Errorf("%d impersonation sessions started for rejected targets", started)
+	}
+}
+
+func TestImpersonationToken(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAuthService(t, db)
+	is := NewImpersonationService(as, repos.NewUserRepo(db), repos.NewAuditLogRepo(db))
+	agent := createTestUser(t, db, "agent@example.com", "support")
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+
+	if _, err := is.Start(agent.ID, user.ID, " ", ClientInfo{}); err == nil {
+		t.Error("Start without a reason succeeded")
+	}
+
+	token, err := is.Start(agent.ID, user.ID, "support ticket 42", ClientInfo{IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	claims, err := as.ValidateAccessToken(token.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateAccessToken: %v", err)
+	}
+	if claims.UserID != user.ID || claims.ImpersonatorID != agent.ID {
+		t.Errorf("token is for user %d by %d, want user %d by %d", claims.UserID, claims.ImpersonatorID, user.ID, agent.ID)
+	}
+
+	entries, err := is.GetAuditLogs(agent.ID, user.ID, 0)
+	if err != nil {
+		t.Fatalf("GetAuditLogs: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Action != models.AuditImpersonationStarted || entries[0].Detail != "support ticket 42" {
+		t.Errorf("audit log = %+v, want one impersonation_started entry with the reason", entries)
+	}
+
+	// The token stops working once the staff member is suspended
+	if err := db.Model(agent).Update("suspended_at", time.Now()).Error; err != nil {
+		t.Fatalf("suspend agent: %v", err)
+	}
+	if _, err := as.ValidateAccessToken(token.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("ValidateAccessToken after suspending the agent = %v, want %v", err, ErrTokenRevoked)
+	}
+}
@@ -0,0 +1,142 @@
+// services/invitation_service.go
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+var ErrInvalidInvitation = errors.New("invalid or expired invitation")
+
+type InvitationService struct {
+	Repo        *repos.InvitationRepo
+	UserService *UserService
+}
+
+func NewInvitationService(invitationRepo *repos.InvitationRepo, userService *UserService) *InvitationService {
+	return &InvitationService{
+		Repo:        invitationRepo,
+		UserService: userService,
+	}
+}
+
+// Invite emails an invitation to create an account with the given role (admin only)
+func (is *InvitationService) Invite(invitedById uint, email, role string) (*models.Invitation, error) {
+	email = strings.TrimSpace(email)
+	if !isValidEmail(email) {
+		return nil, errors.New("invalid email format")
+	}
+	if !validRoles[role] {
+		return nil, ErrInvalidRole
+	}
+
+	exists, err := is.UserService.Repo.EmailExists(email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check email existence: %w", err)
+	}
+	if exists {
+		return nil, ErrEmailAlreadyRegistered
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := &models.Invitation{
+		Email:       email,
+		Role:        role,
+		TokenHash:   hashToken(token),
+		InvitedByID: invitedById,
+		ExpiresAt:   time.Now().Add(invitationTTL),
+	}
+	if err := is.Repo.CreateInvitation(invitation); err != nil {
+		return nil, fmt.Errorf("failed to create invitation: %w", err)
+	}
+
+	body := fmt.Sprintf("Hello,\n\nYou have been invited to join Travel Companion as %s. Open the link below to set up your account. It expires in %d days.\n\n%s/accept-invitation?token=%s",
+		role, int(invitationTTL.Hours()/24), frontendURL, token)
+	if err := is.UserService.Mailer.Send(email, "You have been invited to Travel Companion", body); err != nil {
+		return nil, fmt.Errorf("failed to send invitation email: %w", err)
+	}
+
+	return invitation, nil
+}
+
+// GetPendingInvitations retrieves invitations that can still be accepted
+func (is *InvitationService) GetPendingInvitations() ([]models.Invitation, error) {
+	invitations, err := is.Repo.GetPendingInvitations()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve invitations: %w", err)
+	}
+	return invitations, nil
+}
+
+// RevokeInvitation cancels a pending invitation
+func (is *InvitationService) RevokeInvitation(id uint) error {
+	if id == 0 {
+		return errors.New("invalid invitation ID")
+	}
+
+	invitation, err := is.Repo.GetInvitationById(id)
+	if err != nil {
+		return fmt.Errorf("invitation not found: %w", err)
+	}
+	if invitation.AcceptedAt != nil {
+		return errors.New("invitation has already been accepted")
+	}
+
+	if err := is.Repo.RevokeInvitation(id); err != nil {
+		return fmt.Errorf("failed to revoke invitation: %w", err)
+	}
+	return nil
+}
+
+// AcceptInvitation creates the invited account with the invitation's role
+func (is *InvitationService) AcceptInvitation(token, name, password string) (*models.User, error) {
+	if token == "" {
+		return nil, ErrInvalidInvitation
+	}
+
+	invitation, err := is.Repo.GetInvitationByHash(hashToken(token))
+	if err != nil {
+		return nil, ErrInvalidInvitation
+	}
+	if invitation.AcceptedAt != nil || invitation.RevokedAt != nil || time.Now().After(invitation.ExpiresAt) {
+		return nil, ErrInvalidInvitation
+	}
+
+	if !validRoles[invitation.Role] {
+		return nil, ErrInvalidRole
+	}
+	now := time.Now()
+	user := &models.User{
+		Name:            name,
+		Email:           invitation.Email,
+		Password:        password,
+		Role:            invitation.Role,
+		EmailVerifiedAt: &now,
+	}
+	if err := is.UserService.prepareAccount(user); err != nil {
+		return nil, err
+	}
+
+	// The invitation is claimed before the account is created, so that an
+	// invitation revoked meanwhile creates nothing
+	accepted, err := is.Repo.AcceptInvitation(invitation.ID, user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to accept invitation: %w", err)
+	}
+	if !accepted {
+		return nil, ErrInvalidInvitation
+	}
+
+	user.Password = ""
+	return user, nil
+}
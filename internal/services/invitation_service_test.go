@@ -0,0 +1,54 @@
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestAcceptRevokedInvitation(t *testing.T) {
+	db := newTestDB(t)
+	us := newTestUserService(t, db)
+	is := NewInvitationService(repos.NewInvitationRepo(db), us)
+	admin := createTestUser(t, db, "admin@example.com", RoleAdmin)
+
+	const token = "invitation-token"
+	invitation := &models.Invitation{
+		Email:       "agent@example.com",
+		Role:        RoleSupportAgent,
+		TokenHash:   hashToken(token),
+		InvitedByID: admin.ID,
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+	if err := db.Create(invitation).Error; err != nil {
+		t.Fatalf("create invitation: %v", err)
+	}
+
+	// The admin revokes the invitation while it is being accepted
+	revoked := false
+	var revokeErr error
+	err := db.Callback().Query().After("gorm:query").Register("test:revoke_during_accept", func(tx *gorm.DB) {
+		if tx.Statement.Table != "invitations" || revoked {
+			return
+		}
+		revoked = true
+		revokeErr = is.Repo.RevokeInvitation(invitation.ID)
+	})
+	if err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+
+	if _, err := is.AcceptInvitation(token, "Support Agent", "correct-horse-battery-staple"); !errors.Is(err, ErrInvalidInvitation) {
+		t.Errorf("AcceptInvitation of a revoked invitation = %v, want %v", err, ErrInvalidInvitation)
+	}
+	if revokeErr != nil {
+		t.Fatalf("RevokeInvitation: %v", revokeErr)
+	}
+	if exists, err := us.Repo.EmailExists(invitation.Email); err != nil || exists {
+		t.Errorf("an account was created from a revoked invitation (err %v)", err)
+	}
+}
@@ -0,0 +1,329 @@
+// services/itinerary_service.go
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"Visa/pkg"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Layover limits in minutes. Searches may narrow them but not go beyond them.
+const (
+	itineraryMinLayover        = 30
+	itineraryMaxLayover        = 24 * 60
+	itineraryDefaultMinLayover = 45
+	itineraryDefaultMaxLayover = 6 * 60
+	itineraryMaxConnections    = 2
+	itineraryDefaultLimit      = 20
+	itineraryMaxLimit          = 50
+)
+
+var (
+	ErrInvalidItinerarySearch = errors.New("invalid itinerary search")
+	ErrInvalidItinerary       = errors.New("invalid itinerary")
+)
+
+// ItinerarySearch is a search for journeys between two airports, direct or
+// with connections. The date is the local departure date at the origin,
+// formatted as YYYY-MM-DD. Layovers are in minutes; zero values use the
+// defaults.
+type ItinerarySearch struct {
+	Origin         string
+	Destination    string
+	Date           string
+	Passengers     int
+	CabinClass     string
+	MaxConnections *int
+	MinLayover     int
+	MaxLayover     int
+	// Sort is "price", "duration" or "departure"
+	Sort  string
+	Limit int
+}
+
+// Itinerary is a journey of one or more flights. Each flight's price and
+// cabin are those of the fare taken on it.
+type Itinerary struct {
+	Flights         []models.Flight `json:"flights"`
+	Connections     int             `json:"connections"`
+	Layovers        []Layover       `json:"layovers"`
+	DepartureAt     time.Time       `json:"departure_at"`
+	ArrivalAt       time.Time       `json:"arrival_at"`
+	DurationMinutes int             `json:"duration_minutes"`
+	// TotalPrice is the price of one seat on every flight
+	TotalPrice float64 `json:"total_price"`
+}
+
+// Layover is the wait between two flights at the airport they connect at
+type Layover struct {
+	Airport string `json:"airport"`
+	Minutes int    `json:"minutes"`
+}
+
+// newItinerary works out the layovers, duration and price of flights taken
+// one after the other
+func newItinerary(flights []models.Flight) Itinerary {
+	first, last := flights[0], flights[len(flights)-1]
+	itinerary := Itinerary{
+		Flights:         flights,
+		Connections:     len(flights) - 1,
+		Layovers:        make([]Layover, 0, len(flights)-1),
+		DepartureAt:     first.DepartureAt,
+		ArrivalAt:       last.ArrivalAt,
+		DurationMinutes: int(last.ArrivalAt.Sub(first.DepartureAt).Minutes()),
+	}
+	for i, flight := range flights {
+		itinerary.TotalPrice += flight.Price
+		if i > 0 {
+			itinerary.Layovers = append(itinerary.Layovers, Layover{
+				Airport: flight.Origin,
+				Minutes: int(flight.DepartureAt.Sub(flights[i-1].ArrivalAt).Minutes()),
+			})
+		}
+	}
+	return itinerary
+}
+
+type ItineraryService struct {
+	Repo  *repos.FlightRepo
+	Trips *TripService
+}
+
+func NewItineraryService(flightRepo *repos.FlightRepo, tripService *TripService) *ItineraryService {
+	return &ItineraryService{
+		Repo:  flightRepo,
+		Trips: tripService,
+	}
+}
+
+// SearchItineraries finds direct flights and journeys with up to two
+// connections where every flight has a fare with a seat for every passenger
+// in the cabin, ranked by total price, duration or departure time. Journeys
+// are priced at the cheapest such fare of each flight.
+func (is *ItineraryService) SearchItineraries(search ItinerarySearch) ([]Itinerary, error) {
+	filter := repos.ItineraryFilter{
+		Origin:        strings.ToUpper(strings.TrimSpace(search.Origin)),
+		Destination:   strings.ToUpper(strings.TrimSpace(search.Destination)),
+		DepartureDate: search.Date,
+		DepartAfter:   time.Now(),
+		Passengers:    search.Passengers,
+		CabinClass:    strings.ToLower(strings.TrimSpace(search.CabinClass)),
+		MinLayover:    search.MinLayover,
+		MaxLayover:    search.MaxLayover,
+		Sort:          search.Sort,
+		Limit:         search.Limit,
+	}
+	maxConnections := itineraryMaxConnections
+	if search.MaxConnections != nil {
+		maxConnections = *search.MaxConnections
+	}
+	if filter.Passengers == 0 {
+		filter.Passengers = 1
+	}
+	if filter.MinLayover == 0 {
+		filter.MinLayover = itineraryDefaultMinLayover
+	}
+	if filter.MaxLayover == 0 {
+		filter.MaxLayover = itineraryDefaultMaxLayover
+	}
+	if filter.Limit < 1 {
+		filter.Limit = itineraryDefaultLimit
+	}
+	if filter.Limit > itineraryMaxLimit {
+		filter.Limit = itineraryMaxLimit
+	}
+
+	if !iataPattern.MatchString(filter.Origin) || !iataPattern.MatchString(filter.Destination) {
+		return nil, fmt.Errorf("%w: origin and destination must be 3-letter IATA airport codes", ErrInvalidItinerarySearch)
+	}
+	if filter.Origin == filter.Destination {
+		return nil, fmt.Errorf("%w: origin and destination must differ", ErrInvalidItinerarySearch)
+	}
+	if _, err := time.Parse(dateLayout, filter.DepartureDate); err != nil {
+		return nil, fmt.Errorf("%w: date must be formatted as YYYY-MM-DD", ErrInvalidItinerarySearch)
+	}
+	if filter.Passengers < 1 || filter.Passengers > flightMaxPassengers {
+		return nil, fmt.Errorf("%w: passengers must be between 1 and %d", ErrInvalidItinerarySearch, flightMaxPassengers)
+	}
+	if filter.CabinClass != "" && !validCabinClasses[filter.CabinClass] {
+		return nil, fmt.Errorf("%w: cabin must be economy, premium_economy, business or first", ErrInvalidItinerarySearch)
+	}
+	if maxConnections < 0 || maxConnections > itineraryMaxConnections {
+		return nil, fmt.Errorf("%w: max_connections must be between 0 and %d", ErrInvalidItinerarySearch, itineraryMaxConnections)
+	}
+	if filter.MinLayover < itineraryMinLayover || filter.MaxLayover > itineraryMaxLayover || filter.MinLayover > filter.MaxLayover {
+		return nil, fmt.Errorf("%w: layovers must be between %d and %d minutes, the minimum not above the maximum",
+			ErrInvalidItinerarySearch, itineraryMinLayover, itineraryMaxLayover)
+	}
+	switch filter.Sort {
+	case "":
+		filter.Sort = "price"
+	case "price", "duration", "departure":
+	default:
+		return nil, fmt.Errorf("%w: sort must be 'price', 'duration' or 'departure'", ErrInvalidItinerarySearch)
+	}
+
+	// Each number of connections is its own query, ranked and limited by the
+	// database; the best of them are merged here
+	var journeys [][]uint
+	for connections := 0; connections <= maxConnections; connections++ {
+		found, err := is.Repo.FindItineraries(filter, connections)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search itineraries: %w", err)
+		}
+		journeys = append(journeys, found...)
+	}
+	if len(journeys) == 0 {
+		return []Itinerary{}, nil
+	}
+
+	flightsById, err := loadFlightsById(is.Repo, journeys)
+	if err != nil {
+		return nil, err
+	}
+	itineraries := make([]Itinerary, 0, len(journeys))
+	fare := FareChoice{Cabin: filter.CabinClass}
+nextJourney:
+	for _, ids := range journeys {
+		flights := make([]models.Flight, len(ids))
+		for i, id := range ids {
+			flights[i] = flightsById[id]
+			// Journeys whose seats went in the meantime are left out
+			bucket, err := chooseFare(&flights[i], fare, filter.Passengers)
+			if err != nil {
+				continue nextJourney
+			}
+			flights[i].Price = bucket.Price
+			flights[i].CabinClass = bucket.Cabin
+		}
+		itineraries = append(itineraries, newItinerary(flights))
+	}
+
+	sort.SliceStable(itineraries, func(i, j int) bool {
+		a, b := itineraries[i], itineraries[j]
+		switch filter.Sort {
+		case "duration":
+			if a.DurationMinutes != b.DurationMinutes {
+				return a.DurationMinutes < b.DurationMinutes
+			}
+		case "departure":
+			if !a.DepartureAt.Equal(b.DepartureAt) {
+				return a.DepartureAt.Before(b.DepartureAt)
+			}
+		}
+		if a.TotalPrice != b.TotalPrice {
+			return a.TotalPrice < b.TotalPrice
+		}
+		return a.Connections < b.Connections
+	})
+	if len(itineraries) > filter.Limit {
+		itineraries = itineraries[:filter.Limit]
+	}
+	return itineraries, nil
+}
+
+// BookItinerary books every flight of a journey for the user as one
+// reservation. The flights are given in travel order and must connect at the
+// same airport within the layover limits, and are booked at the chosen fare.
+// Either every flight is booked or none is.
+func (is *ItineraryService) BookItinerary(userId uint, flightIds []uint, fare FareChoice, travellerIds []uint) (*Trip, error) {
+	return is.Trips.BookTrip(userId, models.TripOneWay, [][]uint{flightIds}, fare, travellerIds)
+}
+
+// loadFlightsById retrieves every flight of the journeys by ID
+func loadFlightsById(flightRepo *repos.FlightRepo, journeys [][]uint) (map[uint]models.Flight, error) {
+	var ids []uint
+	seen := make(map[uint]bool)
+	for _, journey := range journeys {
+		for _, id := range journey {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	flights, err := flightRepo.GetFlightsByIds(ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve flights: %w", err)
+	}
+	flightsById := make(map[uint]models.Flight, len(flights))
+	for _, flight := range flights {
+		flightsById[flight.ID] = flight
+	}
+	return flightsById, nil
+}
+
+// connectJourney puts the flights of a journey in travel order, checking
+// that each leaves from the airport the previous one lands at within the
+// layover limits and that no airport is visited twice
+func connectJourney(flightsById map[uint]models.Flight, flightIds []uint) ([]models.Flight, error) {
+	if len(flightIds) == 0 || len(flightIds) > itineraryMaxConnections+1 {
+		return nil, fmt.Errorf("%w: a journey has between 1 and %d flights", ErrInvalidItinerary, itineraryMaxConnections+1)
+	}
+
+	flights := make([]models.Flight, len(flightIds))
+	visited := make(map[string]bool, len(flightIds)+1)
+	for i, id := range flightIds {
+		flight, ok := flightsById[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: flight %d not found", ErrInvalidItinerary, id)
+		}
+		flights[i] = flight
+
+		if i == 0 {
+			visited[flight.Origin] = true
+		} else {
+			previous := flights[i-1]
+			if flight.Origin != previous.Destination {
+				return nil, fmt.Errorf("%w: flight %s does not leave from %s, where flight %s lands",
+					ErrInvalidItinerary, flight.FlightNumber, previous.Destination, previous.FlightNumber)
+			}
+			layover := flight.DepartureAt.Sub(previous.ArrivalAt)
+			if layover < itineraryMinLayover*time.Minute || layover > itineraryMaxLayover*time.Minute {
+				return nil, fmt.Errorf("%w: the connection at %s must be between %d and %d minutes",
+					ErrInvalidItinerary, flight.Origin, itineraryMinLayover, itineraryMaxLayover)
+			}
+		}
+		if visited[flight.Destination] {
+			return nil, fmt.Errorf("%w: the journey visits %s twice", ErrInvalidItinerary, flight.Destination)
+		}
+		visited[flight.Destination] = true
+	}
+	return flights, nil
+}
+
+// ItineraryView is an itinerary with its flights and total price rendered
+// for the caller
+type ItineraryView struct {
+	Itinerary
+	Flights   []FlightView       `json:"flights"`
+	Localized LocalizedItinerary `json:"localized"`
+}
+
+type LocalizedItinerary struct {
+	TotalPrice pkg.LocalizedPrice `json:"total_price"`
+	Departure  string             `json:"departure"`
+	Arrival    string             `json:"arrival"`
+}
+
+func NewItineraryViews(itineraries []Itinerary, localizer *pkg.Localizer) []ItineraryView {
+	views := make([]ItineraryView, len(itineraries))
+	for i, itinerary := range itineraries {
+		views[i] = ItineraryView{
+			Itinerary: itinerary,
+			Flights:   NewFlightViews(itinerary.Flights, localizer),
+			Localized: LocalizedItinerary{
+				TotalPrice: localizer.Price(itinerary.TotalPrice),
+				Departure:  localizer.Time(itinerary.DepartureAt),
+				Arrival:    localizer.Time(itinerary.ArrivalAt),
+			},
+		}
+	}
+	return views
+}
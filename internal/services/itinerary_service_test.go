@@ -0,0 +1,144 @@
+package services
+
+import (
+	"Visa/models"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBookItineraryConnections(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	is := NewItineraryService(fs.Repo, newTestTripService(fs))
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+	other := createTestUser(t, db, "other@example.com", RoleUser)
+
+	// Every flight takes two hours
+	departure := time.Now().Add(48 * time.Hour).Truncate(time.Hour)
+	first := createTestFlight(t, fs, "TA100", "LHR", "JFK", departure)
+	onward := createTestFlight(t, fs, "TA200", "JFK", "LAX", departure.Add(3*time.Hour))
+	tooSoon := createTestFlight(t, fs, "TA201", "JFK", "LAX", departure.Add(2*time.Hour+10*time.Minute))
+	elsewhere := createTestFlight(t, fs, "TA202", "EWR", "LAX", departure.Add(3*time.Hour))
+	back := createTestFlight(t, fs, "TA203", "JFK", "LHR", departure.Add(3*time.Hour))
+	full := createTestFlight(t, fs, "TA204", "JFK", "SFO", departure.Add(3*time.Hour),
+		FareDetails{Cabin: models.CabinEconomy, FareFamily: "basic", Price: 80, Seats: 1})
+	if err := fs.BookFlight(other.ID, full.ID, FareChoice{}, nil); err != nil {
+		t.Fatalf("BookFlight: %v", err)
+	}
+
+	invalid := []struct {
+		name    string
+		flights []uint
+		want    error
+	}{
+		{"a layover below the minimum", []uint{first.ID, tooSoon.ID}, ErrInvalidItinerary},
+		{"a connection at another airport", []uint{first.ID, elsewhere.ID}, ErrInvalidItinerary},
+		{"a journey back to its origin", []uint{first.ID, back.ID}, ErrInvalidItinerary},
+		{"flights out of travel order", []uint{onward.ID, first.ID}, ErrInvalidItinerary},
+		{"a full connecting flight", []uint{first.ID, full.ID}, ErrNotEnoughSeats},
+	}
+	for _, c := range invalid {
+		if _, err := is.BookItinerary(user.ID, c.flights, FareChoice{}, nil); !errors.Is(err, c.want) {
+			t.Errorf("BookItinerary with %s = %v, want %v", c.name, err, c.want)
+		}
+	}
+	if seats, _ := seatsAvailable(t, db, first.ID); seats != first.Capacity {
+		t.Errorf("first flight has %d seats available after failed bookings, want %d", seats, first.Capacity)
+	}
+
+	trip, err := is.BookItinerary(user.ID, []uint{first.ID, onward.ID}, FareChoice{}, nil)
+	if err != nil {
+		t.Fatalf("BookItinerary: %v", err)
+	}
+	if len(trip.Journeys) != 1 || trip.Journeys[0].Connections != 1 || trip.TotalPrice != 200 {
+		t.Fatalf("booked %d journeys with a total of %v, want one journey with one connection for 200", len(trip.Journeys), trip.TotalPrice)
+	}
+	if layover := trip.Journeys[0].Layovers[0]; layover.Airport != "JFK" || layover.Minutes != 60 {
+		t.Errorf("layover is %d minutes at %s, want 60 at JFK", layover.Minutes, layover.Airport)
+	}
+	res, err := fs.ReservationRepo.GetReservationByReference(user.ID, trip.Reference)
+	if err != nil {
+		t.Fatalf("GetReservationByReference: %v", err)
+	}
+	if len(res.Segments) != 2 {
+		t.Errorf("reservation has %d segments, want 2", len(res.Segments))
+	}
+	for _, flight := range []*models.Flight{first, onward} {
+		if seats, _ := seatsAvailable(t, db, flight.ID); seats != flight.Capacity-1 {
+			t.Errorf("flight %s has %d seats available, want %d", flight.FlightNumber, seats, flight.Capacity-1)
+		}
+	}
+}
+
+// journeyNumbers lists each itinerary as its flight numbers joined by "+"
+func journeyNumbers(itineraries []Itinerary) []string {
+	journeys := make([]string, len(itineraries))
+	for i, itinerary := range itineraries {
+		numbers := make([]string, len(itinerary.Flights))
+		for j, flight := range itinerary.Flights {
+			numbers[j] = flight.FlightNumber
+		}
+		journeys[i] = strings.Join(numbers, "+")
+	}
+	return journeys
+}
+
+func TestSearchItineraries(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	is := NewItineraryService(fs.Repo, newTestTripService(fs))
+
+	// Every flight takes two hours and costs 100 unless priced otherwise
+	day := time.Now().UTC().AddDate(0, 0, 2)
+	at := func(hour, minute int) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, time.UTC)
+	}
+	createTestFlight(t, fs, "TA100", "LHR", "JFK", at(8, 0))
+	createTestFlight(t, fs, "TA200", "JFK", "LAX", at(11, 0))
+	createTestFlight(t, fs, "TA201", "JFK", "LAX", at(10, 20))
+	createTestFlight(t, fs, "TA202", "JFK", "LAX", at(17, 0))
+	createTestFlight(t, fs, "TA300", "JFK", "ORD", at(11, 0))
+	createTestFlight(t, fs, "TA301", "ORD", "LAX", at(14, 0))
+	createTestFlight(t, fs, "TA400", "LHR", "LAX", at(9, 0),
+		FareDetails{Cabin: models.CabinEconomy, FareFamily: "standard", Price: 300, Seats: 10})
+
+	maxConnections := func(n int) *int { return &n }
+	tests := []struct {
+		name   string
+		search ItinerarySearch
+		want   []string
+	}{
+		// A 20 minute and a 7 hour layover are outside the default window
+		{"by price", ItinerarySearch{}, []string{"TA100+TA200", "TA400", "TA100+TA300+TA301"}},
+		{"by duration", ItinerarySearch{Sort: "duration"}, []string{"TA400", "TA100+TA200", "TA100+TA300+TA301"}},
+		{"direct only", ItinerarySearch{MaxConnections: maxConnections(0)}, []string{"TA400"}},
+		{"one connection at most", ItinerarySearch{MaxConnections: maxConnections(1)}, []string{"TA100+TA200", "TA400"}},
+		{"a wider layover window", ItinerarySearch{MaxConnections: maxConnections(1), MaxLayover: 8 * 60}, []string{"TA100+TA200", "TA100+TA202", "TA400"}},
+		{"a narrower layover window", ItinerarySearch{MinLayover: 90}, []string{"TA400"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			search := tt.search
+			search.Origin, search.Destination, search.Date = "LHR", "LAX", day.Format(dateLayout)
+			itineraries, err := is.SearchItineraries(search)
+			if err != nil {
+				t.Fatalf("SearchItineraries: %v", err)
+			}
+			if got := journeyNumbers(itineraries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("journeys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	itineraries, err := is.SearchItineraries(ItinerarySearch{Origin: "LHR", Destination: "LAX", Date: day.Format(dateLayout), MaxConnections: maxConnections(1)})
+	if err != nil {
+		t.Fatalf("SearchItineraries: %v", err)
+	}
+	if connecting := itineraries[0]; connecting.DurationMinutes != 5*60 || connecting.TotalPrice != 200 || connecting.Layovers[0].Minutes != 60 {
+		t.Errorf("connecting journey takes %d minutes with a %d minute layover for %v, want 300, 60 and 200",
+			connecting.DurationMinutes, connecting.Layovers[0].Minutes, connecting.TotalPrice)
+	}
+}
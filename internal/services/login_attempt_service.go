@@ -0,0 +1,167 @@
+// services/login_attempt_service.go
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// An account is locked after loginMaxFailures consecutive failures. The
+	// lockout starts at loginLockoutBase and doubles with every further
+	// failure, up to loginLockoutMax.
+	loginMaxFailures = 5
+	loginLockoutBase = 30 * time.Second
+	loginLockoutMax  = time.Hour
+
+	// An IP is throttled after ipMaxFailures failures within ipFailureWindow
+	ipMaxFailures   = 20
+	ipFailureWindow = 15 * time.Minute
+
+	recentSignInsLimit = 20
+)
+
+var (
+	ErrAccountLocked   = errors.New("account temporarily locked after too many failed sign-ins")
+	ErrTooManyAttempts = errors.New("too many failed sign-ins from this address")
+)
+
+// failureReasonsCountedPerIP are the failures that tested a credential.
+// Rejected attempts are recorded but do not extend the throttle.
+var failureReasonsCountedPerIP = []string{
+	models.LoginFailureUnknownEmail,
+	models.LoginFailureBadPassword,
+	models.LoginFailureBadTwoFactor,
+}
+
+// LockoutError is returned while sign-ins are blocked. It wraps
+// ErrAccountLocked or ErrTooManyAttempts.
+type LockoutError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string { return e.Err.Error() }
+
+func (e *LockoutError) Unwrap() error { return e.Err }
+
+type LoginAttemptService struct {
+	Repo     *repos.LoginEventRepo
+	UserRepo *repos.UserRepo
+}
+
+func NewLoginAttemptService(loginEventRepo *repos.LoginEventRepo, userRepo *repos.UserRepo) *LoginAttemptService {
+	return &LoginAttemptService{
+		Repo:     loginEventRepo,
+		UserRepo: userRepo,
+	}
+}
+
+// CheckIP returns a LockoutError if the client has failed too many sign-ins recently
+func (ls *LoginAttemptService) CheckIP(client ClientInfo) error {
+	failures, err := ls.Repo.CountFailuresByIP(client.IP, time.Now().Add(-ipFailureWindow), failureReasonsCountedPerIP)
+	if err != nil {
+		return fmt.Errorf("failed to count login failures: %w", err)
+	}
+	if failures >= ipMaxFailures {
+		return &LockoutError{Err: ErrTooManyAttempts, RetryAfter: ipFailureWindow}
+	}
+	return nil
+}
+
+// CheckUser returns a LockoutError if the account is locked
+func (ls *LoginAttemptService) CheckUser(user *models.User) error {
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return &LockoutError{Err: ErrAccountLocked, RetryAfter: time.Until(*user.LockedUntil)}
+	}
+	return nil
+}
+
+// RecordFailure stores a failed sign-in. Failures that tested a credential
+// against an account count towards its lockout.
+func (ls *LoginAttemptService) RecordFailure(user *models.User, email string, client ClientInfo, reason string) error {
+	event := ls.newEvent(user, email, client)
+	event.Reason = reason
+	if err := ls.Repo.CreateEvent(event); err != nil {
+		return fmt.Errorf("failed to record login event: %w", err)
+	}
+
+	if user == nil || (reason != models.LoginFailureBadPassword && reason != models.LoginFailureBadTwoFactor) {
+		return nil
+	}
+
+	count, err := ls.UserRepo.IncrementFailedLogins(user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to count login failure: %w", err)
+	}
+	if count >= loginMaxFailures {
+		if err := ls.UserRepo.LockUser(user.ID, time.Now().Add(lockoutDuration(count))); err != nil {
+			return fmt.Errorf("failed to lock account: %w", err)
+		}
+	}
+	return nil
+}
+
+// RecordSuccess stores a successful sign-in and clears the failure count
+func (ls *LoginAttemptService) RecordSuccess(user *models.User, client ClientInfo) error {
+	event := ls.newEvent(user, user.Email, client)
+	event.Success = true
+	if err := ls.Repo.CreateEvent(event); err != nil {
+		return fmt.Errorf("failed to record login event: %w", err)
+	}
+
+	if user.FailedLoginCount > 0 || user.LockedUntil != nil {
+		if err := ls.UserRepo.ResetFailedLogins(user.ID); err != nil {
+			return fmt.Errorf("failed to reset login failures: %w", err)
+		}
+	}
+	return nil
+}
+
+// RecentSignIns retrieves the latest sign-in attempts on a user's account
+func (ls *LoginAttemptService) RecentSignIns(userId uint) ([]models.LoginEvent, error) {
+	events, err := ls.Repo.GetEventsByUser(userId, recentSignInsLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve sign-ins: %w", err)
+	}
+	return events, nil
+}
+
+// Unlock lifts a lockout and clears the failure count of a user
+func (ls *LoginAttemptService) Unlock(userId uint) error {
+	if _, err := ls.UserRepo.GetUserById(userId); err != nil {
+		return err
+	}
+	if err := ls.UserRepo.ResetFailedLogins(userId); err != nil {
+		return fmt.Errorf("failed to unlock account: %w", err)
+	}
+	return nil
+}
+
+func (ls *LoginAttemptService) newEvent(user *models.User, email string, client ClientInfo) *models.LoginEvent {
+	event := &models.LoginEvent{
+		Email:     email,
+		IP:        client.IP,
+		UserAgent: truncate(client.UserAgent, 255),
+	}
+	if user != nil {
+		event.UserID = &user.ID
+	}
+	return event
+}
+
+// lockoutDuration doubles the lockout for every failure past loginMaxFailures
+func lockoutDuration(failures int) time.Duration {
+	extra := failures - loginMaxFailures
+	if extra > 10 {
+		return loginLockoutMax
+	}
+	d := loginLockoutBase << extra
+	if d > loginLockoutMax {
+		return loginLockoutMax
+	}
+	return d
+}
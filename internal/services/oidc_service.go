@@ -0,0 +1,192 @@
+// services/oidc_service.go
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"Visa/pkg"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const oidcLoginStateTTL = 10 * time.Minute
+
+var (
+	ErrUnknownProvider      = errors.New("unknown identity provider")
+	ErrInvalidOIDCState     = errors.New("invalid or expired login state")
+	ErrOIDCLoginFailed      = errors.New("sign-in with the identity provider failed")
+	ErrOIDCEmailNotVerified = errors.New("the identity provider did not return a verified email address")
+)
+
+// OIDCStart is returned when a login at an external provider begins
+type OIDCStart struct {
+	AuthorizationURL string `json:"authorization_url"`
+	State            string `json:"state"`
+}
+
+type OIDCService struct {
+	Providers    map[string]*pkg.OIDCProvider
+	IdentityRepo *repos.IdentityRepo
+	UserService  *UserService
+}
+
+func NewOIDCService(providers map[string]*pkg.OIDCProvider, identityRepo *repos.IdentityRepo, userService *UserService) *OIDCService {
+	return &OIDCService{
+		Providers:    providers,
+		IdentityRepo: identityRepo,
+		UserService:  userService,
+	}
+}
+
+// ProviderNames lists the configured providers
+func (oc *OIDCService) ProviderNames() []string {
+	names := make([]string, 0, len(oc.Providers))
+	for name := range oc.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StartLogin creates the state, nonce and PKCE verifier for a login and
+// returns the provider URL to send the user to
+func (oc *OIDCService) StartLogin(ctx context.Context, providerName string) (*OIDCStart, error) {
+	provider, ok := oc.Providers[providerName]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	state, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	verifier, challenge, err := pkg.GeneratePKCE()
+	if err != nil {
+		return nil, err
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, challenge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build authorization URL: %w", err)
+	}
+
+	if err := oc.IdentityRepo.CreateLoginState(&models.OIDCLoginState{
+		StateHash:    hashToken(state),
+		Provider:     providerName,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcLoginStateTTL),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to store login state: %w", err)
+	}
+
+	return &OIDCStart{AuthorizationURL: authURL, State: state}, nil
+}
+
+// CompleteLogin exchanges the authorization code returned by the provider
+// and signs in the linked user. Unknown identities are linked to the account
+// with the same verified email, or a new account is created. An account
+// whose email was never verified is reset before it is linked, see
+// UserService.claimUnverifiedAccount.
+func (oc *OIDCService) CompleteLogin(ctx context.Context, providerName, code, state string, client ClientInfo) (*LoginResult, error) {
+	provider, ok := oc.Providers[providerName]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	if code == "" || state == "" {
+		return nil, ErrInvalidOIDCState
+	}
+
+	loginState, err := oc.IdentityRepo.ConsumeLoginState(providerName, hashToken(state))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidOIDCState
+		}
+		return nil, fmt.Errorf("failed to load login state: %w", err)
+	}
+	if time.Now().After(loginState.ExpiresAt) {
+		return nil, ErrInvalidOIDCState
+	}
+
+	tokens, err := provider.Exchange(ctx, code, loginState.CodeVerifier)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCLoginFailed, err)
+	}
+	claims, err := provider.VerifyIDToken(ctx, tokens.IDToken, loginState.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCLoginFailed, err)
+	}
+
+	user, err := oc.findOrCreateUser(providerName, claims)
+	if err != nil {
+		return nil, err
+	}
+	return oc.UserService.completeLogin(user, client)
+}
+
+// GetIdentities lists the external identities linked to a user
+func (oc *OIDCService) GetIdentities(userId uint) ([]models.UserIdentity, error) {
+	identities, err := oc.IdentityRepo.GetIdentitiesByUser(userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve identities: %w", err)
+	}
+	return identities, nil
+}
+
+// findOrCreateUser resolves the user behind an ID token
+func (oc *OIDCService) findOrCreateUser(providerName string, claims *pkg.IDTokenClaims) (*models.User, error) {
+	identity, err := oc.IdentityRepo.GetIdentity(providerName, claims.Subject)
+	if err == nil {
+		user, err := oc.UserService.Repo.GetUserById(identity.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load linked user: %w", err)
+		}
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to look up identity: %w", err)
+	}
+
+	// Linking by email is only safe if the provider vouches for the address
+	email := strings.TrimSpace(claims.Email)
+	if email == "" || !bool(claims.EmailVerified) {
+		return nil, ErrOIDCEmailNotVerified
+	}
+
+	user, err := oc.UserService.Repo.GetUserByEmail(email)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("failed to look up user: %w", err)
+		}
+		user, err = oc.UserService.CreateExternalUser(claims.Name, email)
+		if err != nil {
+			return nil, err
+		}
+	} else if user.EmailVerifiedAt == nil {
+		// Someone registered the address without proving they own it; they
+		// lose access to the account before it is linked
+		if err := oc.UserService.claimUnverifiedAccount(user); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := oc.IdentityRepo.CreateIdentity(&models.UserIdentity{
+		UserID:   user.ID,
+		Provider: providerName,
+		Subject:  claims.Subject,
+		Email:    email,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to link identity: %w", err)
+	}
+	return user, nil
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"Visa/pkg"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// stubOIDCProvider answers discovery, key set and token requests with an ID
+// token for email, signed for the nonce of the login in progress
+type stubOIDCProvider struct {
+	server        *httptest.Server
+	signer        *pkg.KeySetIssuer
+	email         string
+	emailVerified bool
+	nonce         string
+}
+
+func newStubOIDCProvider(t *testing.T) *stubOIDCProvider {
+	t.Helper()
+
+	key, err := pkg.GenerateEd25519Key("stub")
+	if err != nil {
+		t.Fatalf("failed to generate signing key: %v", err)
+	}
+	signer, err := pkg.NewKeySetIssuer("stub", key)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+
+	p := &stubOIDCProvider{signer: signer}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(pkg.OIDCDiscovery{
+			Issuer:                p.server.URL,
+			AuthorizationEndpoint: p.server.URL + "/authorize",
+			TokenEndpoint:         p.server.URL + "/token",
+			JWKSURI:               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(p.signer.JWKS())
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		idToken, err := p.signer.Sign(jwt.MapClaims{
+			"iss":            p.server.URL,
+			"sub":            "stub-" + p.email,
+			"aud":            "travel-app",
+			"iat":            now.Unix(),
+			"exp":            now.Add(time.Minute).Unix(),
+			"nonce":          p.nonce,
+			"email":          p.email,
+			"email_verified": p.emailVerified,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(pkg.OIDCTokenResponse{AccessToken: "access", TokenType: "Bearer", IDToken: idToken})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func newTestOIDCService(t *testing.T, db *gorm.DB, provider *stubOIDCProvider) *OIDCService {
+	t.Helper()
+
+	us := newTestUserService(t, db)
+	providers := map[string]*pkg.OIDCProvider{
+		"stub": {
+			Name:        "stub",
+			Issuer:      provider.server.URL,
+			ClientID:    "travel-app",
+			RedirectURL: "http://localhost/callback",
+			Scopes:      []string{"openid", "email"},
+			HTTPClient:  provider.server.Client(),
+		},
+	}
+	return NewOIDCService(providers, repos.NewIdentityRepo(db), us)
+}
+
+// signInWithStub runs a login at the stub provider for email
+func signInWithStub(oc *OIDCService, provider *stubOIDCProvider, email string, verified bool) (*LoginResult, error) {
+	ctx := context.Background()
+	start, err := oc.StartLogin(ctx, "stub")
+	if err != nil {
+		return nil, err
+	}
+	authURL, err := url.Parse(start.AuthorizationURL)
+	if err != nil {
+		return nil, err
+	}
+	provider.email, provider.emailVerified, provider.nonce = email, verified, authURL.Query().Get("nonce")
+	return oc.CompleteLogin(ctx, "stub", "code", start.State, ClientInfo{})
+}
+
+func TestOIDCLoginLinksVerifiedAccount(t *testing.T) {
+	db := newTestDB(t)
+	provider := newStubOIDCProvider(t)
+	oc := newTestOIDCService(t, db, provider)
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+	pair, err := oc.UserService.Auth.IssueTokens(user, false, ClientInfo{})
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+
+	if _, err := signInWithStub(oc, provider, user.Email, false); !errors.Is(err, ErrOIDCEmailNotVerified) {
+		t.Fatalf("CompleteLogin with an unverified provider email = %v, want %v", err, ErrOIDCEmailNotVerified)
+	}
+
+	result, err := signInWithStub(oc, provider, user.Email, true)
+	if err != nil {
+		t.Fatalf("CompleteLogin: %v", err)
+	}
+	if result.User.ID != user.ID || result.Tokens == nil {
+		t.Fatalf("signed in as user %d with tokens %v, want user %d", result.User.ID, result.Tokens, user.ID)
+	}
+	identities, err := oc.GetIdentities(user.ID)
+	if err != nil || len(identities) != 1 {
+		t.Fatalf("GetIdentities = %v, %v, want one identity", identities, err)
+	}
+	// The owner's other sign-ins are left alone
+	if _, err := oc.UserService.Auth.Refresh(pair.RefreshToken, ClientInfo{}); err != nil {
+		t.Errorf("Refresh of an existing session after linking: %v", err)
+	}
+}
+
+func TestOIDCLoginClaimsUnverifiedAccount(t *testing.T) {
+	db := newTestDB(t)
+	provider := newStubOIDCProvider(t)
+	oc := newTestOIDCService(t, db, provider)
+	us := oc.UserService
+	ks := NewAPIKeyService(us.Auth.APIKeyRepo, us.Repo)
+
+	// Someone registers the victim's address and sets up the account
+	const email, password = "victim@example.com", "chosen-before-the-owner"
+	attacker := &models.User{Name: "Mallory", Email: email, Password: password}
+	if err := us.CreateUser(attacker); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	signedIn, err := us.Login(email, password, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	_, key, err := ks.CreateAPIKey(attacker.ID, "backdoor", []string{models.ScopeBookingsRead}, 0, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if err := db.Model(attacker).Updates(map[string]interface{}{"two_factor_enabled": true, "two_factor_secret": "SECRET"}).Error; err != nil {
+		t.Fatalf("enable two-factor authentication: %v", err)
+	}
+
+	// The owner signs in through the provider and gets the account
+	result, err := signInWithStub(oc, provider, email, true)
+	if err != nil {
+		t.Fatalf("CompleteLogin: %v", err)
+	}
+	if result.User.ID != attacker.ID {
+		t.Errorf("signed in as user %d, want the existing account %d", result.User.ID, attacker.ID)
+	}
+	if result.Tokens == nil {
+		t.Fatal("CompleteLogin asked for the registrant's second factor")
+	}
+	if _, err := us.Auth.ValidateAccessToken(result.Tokens.AccessToken); err != nil {
+		t.Errorf("ValidateAccessToken of the owner's token: %v", err)
+	}
+
+	user, err := us.Repo.GetUserById(attacker.ID)
+	if err != nil {
+		t.Fatalf("GetUserById: %v", err)
+	}
+	if user.EmailVerifiedAt == nil || user.TwoFactorEnabled || user.TwoFactorSecret != "" {
+		t.Errorf("claimed account has verified email %v and two-factor %v", user.EmailVerifiedAt, user.TwoFactorEnabled)
+	}
+
+	// The registrant has no way back in
+	if _, err := us.Login(email, password, ClientInfo{}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login with the registrant's password = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if _, err := us.Auth.ValidateAccessToken(signedIn.Tokens.AccessToken); err == nil {
+		t.Error("the registrant's access token still works")
+	}
+	if _, err := us.Auth.Refresh(signedIn.Tokens.RefreshToken, ClientInfo{}); err == nil {
+		t.Error("the registrant's refresh token still works")
+	}
+	if _, _, err := ks.Authenticate(key, ""); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Authenticate with the registrant's API key = %v, want %v", err, ErrInvalidAPIKey)
+	}
+}
@@ -0,0 +1,130 @@
+// services/preferences_service.go
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"Visa/pkg"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var ErrInvalidPreferences = errors.New("invalid preferences")
+
+// PreferencesUpdate changes the given preferences and keeps the others
+type PreferencesUpdate struct {
+	Currency      *string
+	Locale        *string
+	TimeZone      *string
+	Notifications *models.ChannelOptIns
+	Marketing     *models.ChannelOptIns
+}
+
+type PreferencesService struct {
+	Repo *repos.PreferencesRepo
+}
+
+func NewPreferencesService(preferencesRepo *repos.PreferencesRepo) *PreferencesService {
+	return &PreferencesService{
+		Repo: preferencesRepo,
+	}
+}
+
+// DefaultPreferences are used until a user saves their own. Booking updates
+// go out by email; marketing needs an explicit opt-in.
+func DefaultPreferences(userId uint) *models.UserPreferences {
+	return &models.UserPreferences{
+		UserID:        userId,
+		Currency:      pkg.DefaultCurrency,
+		Locale:        pkg.DefaultLocale,
+		TimeZone:      pkg.DefaultTimeZone,
+		Notifications: models.ChannelOptIns{Email: true},
+	}
+}
+
+// GetPreferences retrieves a user's preferences, or the defaults if none
+// were saved
+func (ps *PreferencesService) GetPreferences(userId uint) (*models.UserPreferences, error) {
+	preferences, err := ps.Repo.GetPreferences(userId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return DefaultPreferences(userId), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve preferences: %w", err)
+	}
+	return preferences, nil
+}
+
+// UpdatePreferences validates and saves the changed preferences of a user
+func (ps *PreferencesService) UpdatePreferences(userId uint, update PreferencesUpdate) (*models.UserPreferences, error) {
+	preferences, err := ps.GetPreferences(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Currency != nil {
+		currency, ok := pkg.NormalizeCurrency(*update.Currency)
+		if !ok {
+			return nil, fmt.Errorf("%w: currency must be one of %s", ErrInvalidPreferences, strings.Join(pkg.SupportedCurrencies(), ", "))
+		}
+		preferences.Currency = currency
+	}
+	if update.Locale != nil {
+		locale, ok := pkg.NormalizeLocale(*update.Locale)
+		if !ok {
+			return nil, fmt.Errorf("%w: locale must be one of %s", ErrInvalidPreferences, strings.Join(pkg.SupportedLocales(), ", "))
+		}
+		preferences.Locale = locale
+	}
+	if update.TimeZone != nil {
+		preferences.TimeZone = strings.TrimSpace(*update.TimeZone)
+	}
+	if update.Notifications != nil {
+		preferences.Notifications = *update.Notifications
+	}
+	if update.Marketing != nil {
+		preferences.Marketing = *update.Marketing
+	}
+
+	// Checks the time zone against the IANA database
+	if _, err := pkg.NewLocalizer(preferences.Currency, preferences.Locale, preferences.TimeZone); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPreferences, err)
+	}
+
+	preferences.UserID = userId
+	if err := ps.Repo.SavePreferences(preferences); err != nil {
+		return nil, fmt.Errorf("failed to save preferences: %w", err)
+	}
+	return preferences, nil
+}
+
+// AllowsNotification reports whether booking updates may be sent to a user
+// over a channel. Users who saved no preferences, or whose preferences cannot
+// be read, get the defaults.
+func (ps *PreferencesService) AllowsNotification(userId uint, channel string) bool {
+	preferences, err := ps.GetPreferences(userId)
+	if err != nil {
+		preferences = DefaultPreferences(userId)
+	}
+	return preferences.Notifications.Allows(channel)
+}
+
+// Localizer renders prices and dates the way a user prefers. Anonymous
+// callers and preferences that are no longer supported get the defaults.
+func (ps *PreferencesService) Localizer(userId uint) *pkg.Localizer {
+	if userId == 0 {
+		return pkg.DefaultLocalizer()
+	}
+	preferences, err := ps.GetPreferences(userId)
+	if err != nil {
+		return pkg.DefaultLocalizer()
+	}
+	localizer, err := pkg.NewLocalizer(preferences.Currency, preferences.Locale, preferences.TimeZone)
+	if err != nil {
+		return pkg.DefaultLocalizer()
+	}
+	return localizer
+}
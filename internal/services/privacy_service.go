@@ -0,0 +1,204 @@
+// services/privacy_service.go
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// At most this many sign-in events are included in an export
+const exportSignInsLimit = 1000
+
+var ErrAlreadyErased = errors.New("account has already been erased")
+
+// UserDataExport is everything stored about a user, as handed out on request
+type UserDataExport struct {
+	ExportedAt       time.Time                `json:"exported_at"`
+	Profile          models.User              `json:"profile"`
+	Preferences      models.UserPreferences   `json:"preferences"`
+	Reservations     []models.Reservation     `json:"reservations"`
+	VisaApplications []models.VisaApplication `json:"visa_applications"`
+	SupportTickets   []models.SupportTicket   `json:"support_tickets"`
+	Travellers       []models.Traveller       `json:"travellers"`
+	Sessions         []models.Session         `json:"sessions"`
+	SignIns          []models.LoginEvent      `json:"sign_ins"`
+	LinkedAccounts   []models.UserIdentity    `json:"linked_accounts"`
+	APIKeys          []APIKeyView             `json:"api_keys"`
+}
+
+type PrivacyService struct {
+	Repo            *repos.PrivacyRepo
+	UserRepo        *repos.UserRepo
+	ReservationRepo *repos.ReservationRepo
+	VisaRepo        *repos.VisaRepo
+	SupportRepo     *repos.SupportRepo
+	TravellerRepo   *repos.TravellerRepo
+	PreferencesRepo *repos.PreferencesRepo
+	SessionRepo     *repos.SessionRepo
+	LoginEventRepo  *repos.LoginEventRepo
+	IdentityRepo    *repos.IdentityRepo
+	APIKeyRepo      *repos.APIKeyRepo
+	TwoFactor       *TwoFactorService
+}
+
+func NewPrivacyService(privacyRepo *repos.PrivacyRepo, userRepo *repos.UserRepo, reservationRepo *repos.ReservationRepo, visaRepo *repos.VisaRepo, supportRepo *repos.SupportRepo, travellerRepo *repos.TravellerRepo, preferencesRepo *repos.PreferencesRepo, sessionRepo *repos.SessionRepo, loginEventRepo *repos.LoginEventRepo, identityRepo *repos.IdentityRepo, apiKeyRepo *repos.APIKeyRepo, twoFactorService *TwoFactorService) *PrivacyService {
+	return &PrivacyService{
+		Repo:            privacyRepo,
+		UserRepo:        userRepo,
+		ReservationRepo: reservationRepo,
+		VisaRepo:        visaRepo,
+		SupportRepo:     supportRepo,
+		TravellerRepo:   travellerRepo,
+		PreferencesRepo: preferencesRepo,
+		SessionRepo:     sessionRepo,
+		LoginEventRepo:  loginEventRepo,
+		IdentityRepo:    identityRepo,
+		APIKeyRepo:      apiKeyRepo,
+		TwoFactor:       twoFactorService,
+	}
+}
+
+// Export collects the personal data stored about a user
+func (ps *PrivacyService) Export(userId uint) (*UserDataExport, error) {
+	user, err := ps.UserRepo.GetUserById(userId)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = ""
+
+	export := &UserDataExport{
+		ExportedAt: time.Now().UTC(),
+		Profile:    *user,
+	}
+	preferences, err := ps.PreferencesRepo.GetPreferences(userId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		preferences, err = DefaultPreferences(userId), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve preferences: %w", err)
+	}
+	export.Preferences = *preferences
+	if export.Reservations, err = ps.ReservationRepo.GetReservationsByUserId(userId); err != nil {
+		return nil, fmt.Errorf("failed to retrieve reservations: %w", err)
+	}
+	if export.VisaApplications, err = ps.VisaRepo.GetVisaByUserId(userId); err != nil {
+		return nil, fmt.Errorf("failed to retrieve visa applications: %w", err)
+	}
+	if export.SupportTickets, err = ps.SupportRepo.GetTicketsByUserId(userId); err != nil {
+		return nil, fmt.Errorf("failed to retrieve support tickets: %w", err)
+	}
+	if export.Travellers, err = ps.TravellerRepo.GetTravellersByUser(userId); err != nil {
+		return nil, fmt.Errorf("failed to retrieve travellers: %w", err)
+	}
+	if export.Sessions, err = ps.SessionRepo.GetActiveSessionsByUser(userId); err != nil {
+		return nil, fmt.Errorf("failed to retrieve sessions: %w", err)
+	}
+	if export.SignIns, err = ps.LoginEventRepo.GetEventsByUser(userId, exportSignInsLimit); err != nil {
+		return nil, fmt.Errorf("failed to retrieve sign-ins: %w", err)
+	}
+	if export.LinkedAccounts, err = ps.IdentityRepo.GetIdentitiesByUser(userId); err != nil {
+		return nil, fmt.Errorf("failed to retrieve linked accounts: %w", err)
+	}
+
+	keys, err := ps.APIKeyRepo.GetAPIKeysByUser(userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve API keys: %w", err)
+	}
+	export.APIKeys = make([]APIKeyView, len(keys))
+	for i := range keys {
+		export.APIKeys[i] = NewAPIKeyView(&keys[i])
+	}
+	return export, nil
+}
+
+// WriteZip writes the export as a ZIP archive with one JSON file per section
+func (export *UserDataExport) WriteZip(w io.Writer) error {
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"preferences.json", export.Preferences},
+		{"reservations.json", export.Reservations},
+		{"visa_applications.json", export.VisaApplications},
+		{"support_tickets.json", export.SupportTickets},
+		{"travellers.json", export.Travellers},
+		{"sessions.json", export.Sessions},
+		{"sign_ins.json", export.SignIns},
+		{"linked_accounts.json", export.LinkedAccounts},
+		{"api_keys.json", export.APIKeys},
+	}
+	for _, file := range files {
+		f, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: export.ExportedAt,
+		})
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.name, err)
+		}
+	}
+	return archive.Close()
+}
+
+// EraseOwnAccount erases the account of the signed in user once they have
+// confirmed it with their password and, if they use two-factor
+// authentication, a code
+func (ps *PrivacyService) EraseOwnAccount(userId uint, password, code string, client ClientInfo) error {
+	user, err := ps.UserRepo.GetUserById(userId)
+	if err != nil {
+		return err
+	}
+	if user.ErasedAt != nil {
+		return ErrAlreadyErased
+	}
+	if err := ps.TwoFactor.Reauthenticate(user, password, code, client); err != nil {
+		return err
+	}
+	return ps.erase(user)
+}
+
+// Erase erases someone else's account. Staff accounts can only be erased if
+// canManageStaff is set.
+func (ps *PrivacyService) Erase(userId uint, canManageStaff bool) error {
+	user, err := ps.UserRepo.GetUserById(userId)
+	if err != nil {
+		return err
+	}
+	if user.ErasedAt != nil {
+		return ErrAlreadyErased
+	}
+	if err := checkStaffAccount(user, canManageStaff); err != nil {
+		return err
+	}
+	return ps.erase(user)
+}
+
+// erase anonymizes a user's personal data and signs them out everywhere.
+// Reservations, visa applications, support tickets and the travellers named
+// on reservations are kept for legal retention, without the details that
+// identify the user.
+func (ps *PrivacyService) erase(user *models.User) error {
+	// The repo keeps at least one admin around
+	if err := ps.Repo.EraseUser(user, time.Now()); err != nil {
+		if errors.Is(err, repos.ErrLastAdmin) {
+			return ErrLastAdmin
+		}
+		return fmt.Errorf("failed to erase user: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func newTestPrivacyService(us *UserService, db *gorm.DB) *PrivacyService {
+	return NewPrivacyService(repos.NewPrivacyRepo(db), us.Repo, repos.NewReservationRepo(db), repos.NewVisaRepo(db), repos.NewSupportRepo(db),
+		repos.NewTravellerRepo(db), repos.NewPreferencesRepo(db), repos.NewSessionRepo(db), repos.NewLoginEventRepo(db), repos.NewIdentityRepo(db),
+		us.Auth.APIKeyRepo, newTestTwoFactorService(us, db))
+}
+
+func TestEraseOwnAccountRequiresPasswordAndCode(t *testing.T) {
+	db := newTestDB(t)
+	us := newTestUserService(t, db)
+	ps := newTestPrivacyService(us, db)
+
+	const password = "correct-horse-battery-staple"
+	user := &models.User{Name: "Traveller", Email: "traveller@example.com", Password: password}
+	if err := us.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := ps.TwoFactor.Setup(user.ID, password, ClientInfo{}); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	codes, err := ps.TwoFactor.Confirm(user.ID, currentTOTPCode(t, ps.TwoFactor, user.ID))
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+
+	// A stolen access token alone does not end the account
+	if err := ps.EraseOwnAccount(user.ID, "guess", codes[0], ClientInfo{}); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("EraseOwnAccount with a wrong password = %v, want %v", err, ErrIncorrectPassword)
+	}
+	if err := ps.EraseOwnAccount(user.ID, password, "", ClientInfo{}); !errors.Is(err, ErrTwoFactorCodeRequired) {
+		t.Errorf("EraseOwnAccount without a code = %v, want %v", err, ErrTwoFactorCodeRequired)
+	}
+	stored, err := us.Repo.GetUserById(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserById: %v", err)
+	}
+	if stored.ErasedAt != nil {
+		t.Fatal("account was erased without confirmation")
+	}
+
+	if err := ps.EraseOwnAccount(user.ID, password, codes[0], ClientInfo{}); err != nil {
+		t.Fatalf("EraseOwnAccount: %v", err)
+	}
+	if stored, err = us.Repo.GetUserById(user.ID); err != nil {
+		t.Fatalf("GetUserById: %v", err)
+	}
+	if stored.ErasedAt == nil {
+		t.Error("account was not erased")
+	}
+}
+
+func TestEraseStaffAccountRequiresRoleManagement(t *testing.T) {
+	db := newTestDB(t)
+	us := newTestUserService(t, db)
+	ps := newTestPrivacyService(us, db)
+	createTestUser(t, db, "admin@example.com", RoleAdmin)
+	officer := createTestUser(t, db, "officer@example.com", RoleVisaOfficer)
+
+	if err := ps.Erase(officer.ID, false); !errors.Is(err, ErrStaffAccount) {
+		t.Errorf("Erase of a staff account = %v, want %v", err, ErrStaffAccount)
+	}
+	if err := ps.Erase(officer.ID, true); err != nil {
+		t.Errorf("Erase with role:manage: %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+// services/rbac_service.go
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// How long role permissions are cached before being reloaded from the database
+const permissionCacheTTL = time.Minute
+
+var (
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrAdminRoleLockout  = errors.New("the admin role must keep the role:manage permission")
+)
+
+// permissionDescriptions lists every permission the application checks
+var permissionDescriptions = map[string]string{
+	models.PermUserRead:     "View user accounts",
+	models.PermUserManage:   "Update and delete user accounts",
+	models.PermRoleManage:   "Change roles, role permissions and invitations",
+	models.PermVisaRead:     "View all visa applications",
+	models.PermVisaApprove:  "Approve and reject visa applications",
+	models.PermVisaDelete:   "Delete visa applications of other users",
+	models.PermHotelWrite:   "Create, update and delete hotels",
+	models.PermFlightWrite:  "Create, update and delete flights",
+	models.PermBookingRead:  "View bookings of all users",
+	models.PermTicketRead:   "View all support tickets",
+	models.PermTicketAssign: "Update, assign and close support tickets",
+	models.PermTicketDelete: "Delete support tickets of other users",
+
+	models.PermUserImpersonate: "Sign in as a regular user to see what they see",
+	models.PermAuditRead:       "View the audit log",
+}
+
+// DefaultRolePermissions is what SeedDefaults grants each built-in role when
+// it is created. Changes made through the API afterwards are kept.
+var DefaultRolePermissions = map[string][]string{
+	RoleAdmin: nil, // every permission, filled in by SeedDefaults
+	RoleUser:  {},
+	RoleSupportAgent: {
+		models.PermTicketRead,
+		models.PermTicketAssign,
+		models.PermUserRead,
+		models.PermBookingRead,
+	},
+	RoleVisaOfficer: {
+		models.PermVisaRead,
+		models.PermVisaApprove,
+	},
+	RoleHotelPartner: {
+		models.PermHotelWrite,
+	},
+	RoleFinance: {
+		models.PermBookingRead,
+		models.PermUserRead,
+	},
+}
+
+type RBACService struct {
+	Repo *repos.RBACRepo
+
+	mu       sync.RWMutex
+	cache    map[string]map[string]bool
+	loadedAt time.Time
+}
+
+func NewRBACService(rbacRepo *repos.RBACRepo) *RBACService {
+	return &RBACService{
+		Repo: rbacRepo,
+	}
+}
+
+// SeedDefaults creates missing permissions and built-in roles. Existing roles
+// are left untouched so that permission changes survive a restart.
+func (rs *RBACService) SeedDefaults() error {
+	existing, err := rs.Repo.GetAllPermissions()
+	if err != nil {
+		return fmt.Errorf("failed to load permissions: %w", err)
+	}
+	known := make(map[string]bool, len(existing))
+	for _, p := range existing {
+		known[p.Name] = true
+	}
+	var added []models.Permission
+	for name, description := range permissionDescriptions {
+		if known[name] {
+			continue
+		}
+		permission := models.Permission{Name: name, Description: description}
+		if err := rs.Repo.CreatePermission(&permission); err != nil {
+			return fmt.Errorf("failed to create permission %s: %w", name, err)
+		}
+		added = append(added, permission)
+	}
+
+	for name, permissionNames := range DefaultRolePermissions {
+		role, err := rs.Repo.GetRoleByName(name)
+		if err == nil {
+			// Admins get permissions introduced after their role was created
+			if name == RoleAdmin && len(added) > 0 {
+				if err := rs.Repo.AddRolePermissions(role, added); err != nil {
+					return fmt.Errorf("failed to grant new permissions to admin: %w", err)
+				}
+			}
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to load role %s: %w", name, err)
+		}
+
+		var permissions []models.Permission
+		if name == RoleAdmin {
+			permissions, err = rs.Repo.GetAllPermissions()
+		} else if len(permissionNames) > 0 {
+			permissions, err = rs.Repo.GetPermissionsByNames(permissionNames)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load permissions for role %s: %w", name, err)
+		}
+		if err := rs.Repo.CreateRole(&models.Role{Name: name, Permissions: permissions}); err != nil {
+			return fmt.Errorf("failed to create role %s: %w", name, err)
+		}
+	}
+
+	rs.invalidate()
+	return nil
+}
+
+// GetAllRoles retrieves all roles with their permissions
+func (rs *RBACService) GetAllRoles() ([]models.Role, error) {
+	roles, err := rs.Repo.GetAllRoles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve roles: %w", err)
+	}
+	return roles, nil
+}
+
+// GetAllPermissions retrieves every known permission
+func (rs *RBACService) GetAllPermissions() ([]models.Permission, error) {
+	permissions, err := rs.Repo.GetAllPermissions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve permissions: %w", err)
+	}
+	return permissions, nil
+}
+
+// SetRolePermissions replaces the permissions granted to a role
+func (rs *RBACService) SetRolePermissions(roleName string, permissionNames []string) (*models.Role, error) {
+	role, err := rs.Repo.GetRoleByName(roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := []models.Permission{}
+	if len(permissionNames) > 0 {
+		permissions, err = rs.Repo.GetPermissionsByNames(permissionNames)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load permissions: %w", err)
+		}
+	}
+	found := make(map[string]bool, len(permissions))
+	for _, p := range permissions {
+		found[p.Name] = true
+	}
+	for _, name := range permissionNames {
+		if !found[name] {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownPermission, name)
+		}
+	}
+
+	// Never lock every admin out of role management
+	if roleName == RoleAdmin && !found[models.PermRoleManage] {
+		return nil, ErrAdminRoleLockout
+	}
+
+	if err := rs.Repo.ReplaceRolePermissions(role, permissions); err != nil {
+		return nil, fmt.Errorf("failed to update role permissions: %w", err)
+	}
+	rs.invalidate()
+
+	role.Permissions = permissions
+	return role, nil
+}
+
+// PermissionsForRole returns the names of the permissions granted to a role,
+// sorted. Lookups are served from a cache refreshed every permissionCacheTTL.
+func (rs *RBACService) PermissionsForRole(role string) []string {
+	granted := rs.lookup(role)
+	names := make([]string, 0, len(granted))
+	for name := range granted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasPermission reports whether a role grants the permission
+func (rs *RBACService) HasPermission(role, permission string) bool {
+	return rs.lookup(role)[permission]
+}
+
+func (rs *RBACService) lookup(role string) map[string]bool {
+	rs.mu.RLock()
+	if rs.cache != nil && time.Since(rs.loadedAt) < permissionCacheTTL {
+		granted := rs.cache[role]
+		rs.mu.RUnlock()
+		return granted
+	}
+	rs.mu.RUnlock()
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.cache == nil || time.Since(rs.loadedAt) >= permissionCacheTTL {
+		if err := rs.reload(); err != nil {
+			// Keep serving the previous permissions rather than failing every request
+			log.Printf("Error loading role permissions: %v", err)
+			if rs.cache == nil {
+				return nil
+			}
+		}
+	}
+	return rs.cache[role]
+}
+
+// reload must be called with mu held
+func (rs *RBACService) reload() error {
+	roles, err := rs.Repo.GetAllRoles()
+	if err != nil {
+		return err
+	}
+	cache := make(map[string]map[string]bool, len(roles))
+	for _, role := range roles {
+		granted := make(map[string]bool, len(role.Permissions))
+		for _, p := range role.Permissions {
+			granted[p.Name] = true
+		}
+		cache[role.Name] = granted
+	}
+	rs.cache = cache
+	rs.loadedAt = time.Now()
+	return nil
+}
+
+func (rs *RBACService) invalidate() {
+	rs.mu.Lock()
+	rs.cache = nil
+	rs.mu.Unlock()
+}
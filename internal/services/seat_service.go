@@ -0,0 +1,463 @@
+// services/seat_service.go
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"Visa/pkg"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	seatMapMaxSections = 6
+	seatMapMaxRow      = 99
+	seatMapMaxPerRow   = 10
+)
+
+// seatLayoutPattern matches the seat letters of a row, with a space for each
+// aisle, such as "ABC DEF"
+var seatLayoutPattern = regexp.MustCompile(`^[A-K]+( [A-K]+)*$`)
+
+var (
+	ErrInvalidSeatMap       = errors.New("invalid seat map")
+	ErrSeatMapNotFound      = errors.New("seat map not found")
+	ErrSeatMapExists        = errors.New("a seat map with this name already exists")
+	ErrSeatsAssigned        = errors.New("passengers have already chosen seats on this flight")
+	ErrNoSeatMap            = errors.New("this flight has no seat map")
+	ErrInvalidSeatSelection = errors.New("invalid seat selection")
+	ErrSeatTaken            = errors.New("this seat is not available")
+)
+
+// SeatMapDetails describe a seat map template as entered by staff. Fees are
+// charged on top of the fare for choosing a seat: ExitRowFee for seats in exit
+// rows, ExtraLegroomFee for other seats with extra legroom and the section's
+// SeatFee for the rest.
+type SeatMapDetails struct {
+	Name             string
+	Aircraft         string
+	Sections         []SeatSection
+	ExitRows         []int
+	ExtraLegroomRows []int
+	// BlockedSeats are seat numbers that are never sold, such as 1A
+	BlockedSeats    []string
+	ExtraLegroomFee float64
+	ExitRowFee      float64
+}
+
+// SeatSection is a run of rows of one cabin laid out alike. Layout lists the
+// seat letters of each row with a space for each aisle, such as "ABC DEF".
+type SeatSection struct {
+	Cabin    string
+	FirstRow int
+	LastRow  int
+	Layout   string
+	SeatFee  float64
+}
+
+// FlightSeatMap is the seats of a flight
+type FlightSeatMap struct {
+	FlightID     uint                `json:"flight_id"`
+	FlightNumber string              `json:"flight_number"`
+	SeatMapID    uint                `json:"seat_map_id"`
+	Seats        []models.FlightSeat `json:"seats"`
+}
+
+type SeatService struct {
+	Repo            *repos.SeatMapRepo
+	FlightRepo      *repos.FlightRepo
+	ReservationRepo *repos.ReservationRepo
+}
+
+func NewSeatService(seatMapRepo *repos.SeatMapRepo, flightRepo *repos.FlightRepo, reservationRepo *repos.ReservationRepo) *SeatService {
+	return &SeatService{
+		Repo:            seatMapRepo,
+		FlightRepo:      flightRepo,
+		ReservationRepo: reservationRepo,
+	}
+}
+
+// CreateSeatMap creates a seat map template with every seat of its sections
+// (admin only)
+func (ss *SeatService) CreateSeatMap(details SeatMapDetails) (*models.SeatMap, error) {
+	seatMap, err := buildSeatMap(details)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ss.Repo.CreateSeatMap(seatMap); err != nil {
+		if errors.Is(err, repos.ErrSeatMapExists) {
+			return nil, ErrSeatMapExists
+		}
+		return nil, fmt.Errorf("failed to create seat map: %w", err)
+	}
+	return seatMap, nil
+}
+
+// GetSeatMaps retrieves all seat map templates without their seats
+func (ss *SeatService) GetSeatMaps() ([]models.SeatMap, error) {
+	seatMaps, err := ss.Repo.GetSeatMaps()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve seat maps: %w", err)
+	}
+	return seatMaps, nil
+}
+
+// GetSeatMap retrieves a seat map template with its seats
+func (ss *SeatService) GetSeatMap(id uint) (*models.SeatMap, error) {
+	seatMap, err := ss.Repo.GetSeatMapById(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSeatMapNotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve seat map: %w", err)
+	}
+	return seatMap, nil
+}
+
+// AssignSeatMap gives a flight the seats of a seat map template, replacing
+// any it had (admin only). The seat map must have a seat that can be sold for
+// every seat of the flight's fares in each cabin. Seats cannot be replaced
+// once passengers have chosen some.
+func (ss *SeatService) AssignSeatMap(flightId, seatMapId uint) (*FlightSeatMap, error) {
+	flight, err := ss.FlightRepo.GetFlightById(flightId)
+	if err != nil {
+		return nil, fmt.Errorf("flight not found: %w", err)
+	}
+	if flight.Status == models.FlightStatusCancelled {
+		return nil, ErrFlightCancelled
+	}
+	seatMap, err := ss.GetSeatMap(seatMapId)
+	if err != nil {
+		return nil, err
+	}
+
+	sold := make(map[string]int)
+	for _, fare := range flight.Fares {
+		sold[fare.Cabin] += fare.Capacity
+	}
+	seats := make(map[string]int)
+	for _, seat := range seatMap.Seats {
+		if !seat.Blocked {
+			seats[seat.Cabin]++
+		}
+	}
+	for cabin, count := range sold {
+		if seats[cabin] < count {
+			return nil, fmt.Errorf("%w: the flight sells %d %s seats but the seat map has %d",
+				ErrInvalidSeatMap, count, cabin, seats[cabin])
+		}
+	}
+
+	flightSeats, err := ss.Repo.GenerateFlightSeats(flight.ID, seatMap)
+	if err != nil {
+		if errors.Is(err, repos.ErrSeatsAssigned) {
+			return nil, ErrSeatsAssigned
+		}
+		return nil, fmt.Errorf("failed to generate flight seats: %w", err)
+	}
+	return &FlightSeatMap{
+		FlightID:     flight.ID,
+		FlightNumber: flight.FlightNumber,
+		SeatMapID:    seatMap.ID,
+		Seats:        flightSeats,
+	}, nil
+}
+
+// GetFlightSeatMap retrieves the seats of a flight and whether each is taken
+func (ss *SeatService) GetFlightSeatMap(flightId uint) (*FlightSeatMap, error) {
+	flight, err := ss.FlightRepo.GetFlightById(flightId)
+	if err != nil {
+		return nil, fmt.Errorf("flight not found: %w", err)
+	}
+	if flight.SeatMapID == nil {
+		return nil, ErrNoSeatMap
+	}
+
+	seats, err := ss.Repo.GetFlightSeats(flight.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve flight seats: %w", err)
+	}
+	return &FlightSeatMap{
+		FlightID:     flight.ID,
+		FlightNumber: flight.FlightNumber,
+		SeatMapID:    *flight.SeatMapID,
+		Seats:        seats,
+	}, nil
+}
+
+// SelectSeat gives a passenger of one of the user's bookings a seat on one of
+// its flights, in the cabin of the fare booked. A passenger who already has a
+// seat on the flight moves to the new one if the fare booked is changeable,
+// paying its change fee. travellerId names one of the booking's travellers,
+// or is 0 for bookings made without travellers. The seat's fee is added to
+// the booking's total price, less the fee of the seat given up. If someone
+// else takes the seat first ErrSeatTaken is returned.
+func (ss *SeatService) SelectSeat(userId uint, reference string, flightId, travellerId uint, number string) (*models.FlightSeat, error) {
+	res, err := ss.ReservationRepo.GetReservationByReference(userId, normalizeBookingReference(reference))
+	if err != nil {
+		return nil, ErrTripNotFound
+	}
+	if res.Status != "booked" {
+		return nil, fmt.Errorf("%w: the booking is %s", ErrInvalidSeatSelection, res.Status)
+	}
+
+	var segment *models.ReservationFlight
+	for i := range res.Segments {
+		if res.Segments[i].FlightID == flightId {
+			segment = &res.Segments[i]
+			break
+		}
+	}
+	if segment == nil {
+		return nil, fmt.Errorf("%w: flight %d is not part of this booking", ErrInvalidSeatSelection, flightId)
+	}
+
+	if len(res.Travellers) == 0 {
+		if travellerId != 0 {
+			return nil, fmt.Errorf("%w: this booking names no travellers", ErrInvalidSeatSelection)
+		}
+	} else {
+		named := false
+		for _, traveller := range res.Travellers {
+			if traveller.ID == travellerId {
+				named = true
+				break
+			}
+		}
+		if !named {
+			return nil, fmt.Errorf("%w: traveller_id must name a traveller on this booking", ErrInvalidSeatSelection)
+		}
+	}
+
+	flight, err := ss.FlightRepo.GetFlightById(flightId)
+	if err != nil {
+		return nil, fmt.Errorf("flight not found: %w", err)
+	}
+	if flight.Status == models.FlightStatusCancelled {
+		return nil, ErrFlightCancelled
+	}
+	if !flight.DepartureAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: the flight has already left", ErrInvalidSeatSelection)
+	}
+	if flight.SeatMapID == nil {
+		return nil, ErrNoSeatMap
+	}
+
+	number = strings.ToUpper(strings.TrimSpace(number))
+	seat, err := ss.Repo.GetFlightSeat(flight.ID, number)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: flight %s has no seat %s", ErrInvalidSeatSelection, flight.FlightNumber, number)
+		}
+		return nil, fmt.Errorf("failed to retrieve seat: %w", err)
+	}
+	if segment.Cabin != "" && seat.Cabin != segment.Cabin {
+		return nil, fmt.Errorf("%w: seat %s is in %s but this booking is in %s",
+			ErrInvalidSeatSelection, number, seat.Cabin, segment.Cabin)
+	}
+	if seat.ReservationID != nil && *seat.ReservationID == res.ID && seat.TravellerID == travellerId {
+		return seat, nil
+	}
+	if !seat.Available() {
+		return nil, ErrSeatTaken
+	}
+
+	// Bookings made before fares existed can change seats free of charge
+	change := repos.SeatChange{Allowed: true}
+	for _, fare := range flight.Fares {
+		if segment.FareBucketID != nil && fare.ID == *segment.FareBucketID {
+			change = repos.SeatChange{Allowed: fare.Changeable, Fee: fare.ChangeFee}
+			break
+		}
+	}
+
+	seat, err = ss.Repo.AssignSeat(res.ID, flight.ID, travellerId, number, change)
+	if err != nil {
+		if errors.Is(err, repos.ErrSeatTaken) {
+			return nil, ErrSeatTaken
+		}
+		if errors.Is(err, repos.ErrSeatChangeNotAllowed) {
+			return nil, fmt.Errorf("%w: the fare booked does not allow changing seats", ErrInvalidSeatSelection)
+		}
+		if errors.Is(err, repos.ErrAlreadyCancelled) {
+			return nil, fmt.Errorf("%w: the booking is cancelled", ErrInvalidSeatSelection)
+		}
+		return nil, fmt.Errorf("failed to assign seat: %w", err)
+	}
+	return seat, nil
+}
+
+// buildSeatMap checks a seat map's details and lays out its seats
+func buildSeatMap(details SeatMapDetails) (*models.SeatMap, error) {
+	name := strings.TrimSpace(details.Name)
+	if name == "" || len(name) > 50 {
+		return nil, invalidSeatMap("name must be between 1 and 50 characters")
+	}
+	aircraft := strings.TrimSpace(details.Aircraft)
+	if len(aircraft) > 50 {
+		return nil, invalidSeatMap("aircraft must be at most 50 characters")
+	}
+	if len(details.Sections) == 0 || len(details.Sections) > seatMapMaxSections {
+		return nil, invalidSeatMap(fmt.Sprintf("a seat map has between 1 and %d sections", seatMapMaxSections))
+	}
+	if details.ExtraLegroomFee < 0 || details.ExitRowFee < 0 {
+		return nil, invalidSeatMap("seat fees cannot be negative")
+	}
+
+	sections := make(map[int]*SeatSection)
+	for i := range details.Sections {
+		section := &details.Sections[i]
+		section.Cabin = strings.ToLower(strings.TrimSpace(section.Cabin))
+		section.Layout = strings.Join(strings.Fields(strings.ToUpper(section.Layout)), " ")
+		if !validCabinClasses[section.Cabin] {
+			return nil, invalidSeatMap("section cabin must be economy, premium_economy, business or first")
+		}
+		if section.FirstRow < 1 || section.LastRow > seatMapMaxRow || section.FirstRow > section.LastRow {
+			return nil, invalidSeatMap(fmt.Sprintf("section rows must be between 1 and %d, the first not after the last", seatMapMaxRow))
+		}
+		if !seatLayoutPattern.MatchString(section.Layout) {
+			return nil, invalidSeatMap("section layout must be seat letters A to K with a space for each aisle, such as ABC DEF")
+		}
+		letters := strings.ReplaceAll(section.Layout, " ", "")
+		if len(letters) > seatMapMaxPerRow {
+			return nil, invalidSeatMap(fmt.Sprintf("a row has at most %d seats", seatMapMaxPerRow))
+		}
+		for j, letter := range letters {
+			if strings.ContainsRune(letters[j+1:], letter) {
+				return nil, invalidSeatMap(fmt.Sprintf("seat letter %c is used twice in layout %s", letter, section.Layout))
+			}
+		}
+		if section.SeatFee < 0 {
+			return nil, invalidSeatMap("seat fees cannot be negative")
+		}
+		for row := section.FirstRow; row <= section.LastRow; row++ {
+			if sections[row] != nil {
+				return nil, invalidSeatMap(fmt.Sprintf("row %d is in more than one section", row))
+			}
+			sections[row] = section
+		}
+	}
+
+	exitRows := make(map[int]bool, len(details.ExitRows))
+	for _, row := range details.ExitRows {
+		if sections[row] == nil {
+			return nil, invalidSeatMap(fmt.Sprintf("exit row %d is not in any section", row))
+		}
+		exitRows[row] = true
+	}
+	legroomRows := make(map[int]bool, len(details.ExtraLegroomRows))
+	for _, row := range details.ExtraLegroomRows {
+		if sections[row] == nil {
+			return nil, invalidSeatMap(fmt.Sprintf("extra legroom row %d is not in any section", row))
+		}
+		legroomRows[row] = true
+	}
+
+	rows := make([]int, 0, len(sections))
+	for row := range sections {
+		rows = append(rows, row)
+	}
+	sort.Ints(rows)
+
+	seatMap := &models.SeatMap{Name: name, Aircraft: aircraft}
+	numbers := make(map[string]int)
+	for _, row := range rows {
+		section := sections[row]
+		for _, group := range strings.Fields(section.Layout) {
+			for i, letter := range group {
+				seat := models.SeatMapSeat{
+					Number: strconv.Itoa(row) + string(letter),
+					Seat: models.Seat{
+						Row:          row,
+						Letter:       string(letter),
+						Cabin:        section.Cabin,
+						Position:     models.SeatMiddle,
+						Exit:         exitRows[row],
+						ExtraLegroom: exitRows[row] || legroomRows[row],
+						Fee:          section.SeatFee,
+					},
+				}
+				if i == 0 || i == len(group)-1 {
+					seat.Position = models.SeatAisle
+				}
+				numbers[seat.Number] = len(seatMap.Seats)
+				seatMap.Seats = append(seatMap.Seats, seat)
+			}
+		}
+		// The outermost seats of a row are by the windows
+		first, last := len(seatMap.Seats)-len(strings.ReplaceAll(section.Layout, " ", "")), len(seatMap.Seats)-1
+		seatMap.Seats[first].Position = models.SeatWindow
+		seatMap.Seats[last].Position = models.SeatWindow
+	}
+	for i := range seatMap.Seats {
+		seat := &seatMap.Seats[i]
+		switch {
+		case seat.Exit:
+			seat.Fee = details.ExitRowFee
+		case seat.ExtraLegroom:
+			seat.Fee = details.ExtraLegroomFee
+		}
+	}
+
+	for _, number := range details.BlockedSeats {
+		number = strings.ToUpper(strings.TrimSpace(number))
+		i, ok := numbers[number]
+		if !ok {
+			return nil, invalidSeatMap(fmt.Sprintf("blocked seat %s is not on the seat map", number))
+		}
+		seatMap.Seats[i].Blocked = true
+	}
+	return seatMap, nil
+}
+
+// invalidSeatMap returns a validation error wrapping ErrInvalidSeatMap
+func invalidSeatMap(message string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidSeatMap, message)
+}
+
+// FlightSeatMapView is the seats of a flight as shown to travellers, without
+// who holds them
+type FlightSeatMapView struct {
+	FlightID     uint             `json:"flight_id"`
+	FlightNumber string           `json:"flight_number"`
+	SeatMapID    uint             `json:"seat_map_id"`
+	Seats        []FlightSeatView `json:"seats"`
+}
+
+type FlightSeatView struct {
+	Number string `json:"number"`
+	models.Seat
+	Available bool          `json:"available"`
+	Localized LocalizedSeat `json:"localized"`
+}
+
+type LocalizedSeat struct {
+	Fee pkg.LocalizedPrice `json:"fee"`
+}
+
+func NewFlightSeatMapView(seatMap *FlightSeatMap, localizer *pkg.Localizer) FlightSeatMapView {
+	view := FlightSeatMapView{
+		FlightID:     seatMap.FlightID,
+		FlightNumber: seatMap.FlightNumber,
+		SeatMapID:    seatMap.SeatMapID,
+		Seats:        make([]FlightSeatView, len(seatMap.Seats)),
+	}
+	for i := range seatMap.Seats {
+		seat := &seatMap.Seats[i]
+		view.Seats[i] = FlightSeatView{
+			Number:    seat.Number,
+			Seat:      seat.Seat,
+			Available: seat.Available(),
+			Localized: LocalizedSeat{Fee: localizer.Price(seat.Fee)},
+		}
+	}
+	return view
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// testSeatMapDetails is ten economy rows of six seats with extra legroom in
+// the first row
+func testSeatMapDetails(name string) SeatMapDetails {
+	return SeatMapDetails{
+		Name:             name,
+		Aircraft:         "A320",
+		Sections:         []SeatSection{{Cabin: models.CabinEconomy, FirstRow: 1, LastRow: 10, Layout: "ABC DEF"}},
+		ExtraLegroomRows: []int{1},
+		ExtraLegroomFee:  20,
+	}
+}
+
+// newTestSeatedFlight creates a flight sold at a basic fare that cannot be
+// changed and a flex fare with a change fee of 15, with the seats of a seat map
+func newTestSeatedFlight(t *testing.T, fs *FlightService, ss *SeatService) *models.Flight {
+	t.Helper()
+
+	flex := testFlexFare
+	flex.ChangeFee = 15
+	flight := createTestFlight(t, fs, "TA100", "LHR", "JFK", time.Now().Add(48*time.Hour), testBasicFare, flex)
+	seatMap, err := ss.CreateSeatMap(testSeatMapDetails("A320 standard"))
+	if err != nil {
+		t.Fatalf("CreateSeatMap: %v", err)
+	}
+	if _, err := ss.AssignSeatMap(flight.ID, seatMap.ID); err != nil {
+		t.Fatalf("AssignSeatMap: %v", err)
+	}
+	return flight
+}
+
+// bookTestTrip books a flight at a fare family and returns the booking reference
+func bookTestTrip(t *testing.T, ts *TripService, userId, flightId uint, family string) string {
+	t.Helper()
+
+	trip, err := ts.BookTrip(userId, models.TripOneWay, [][]uint{{flightId}}, FareChoice{FareFamily: family}, nil)
+	if err != nil {
+		t.Fatalf("BookTrip: %v", err)
+	}
+	return trip.Reference
+}
+
+func TestCreateSeatMapDuplicateName(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	ss := newTestSeatService(db, fs)
+
+	if _, err := ss.CreateSeatMap(testSeatMapDetails("A320 standard")); err != nil {
+		t.Fatalf("CreateSeatMap: %v", err)
+	}
+	if _, err := ss.CreateSeatMap(testSeatMapDetails("A320 standard")); !errors.Is(err, ErrSeatMapExists) {
+		t.Errorf("CreateSeatMap with a taken name = %v, want %v", err, ErrSeatMapExists)
+	}
+}
+
+func TestSelectSeatConcurrently(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	ts := newTestTripService(fs)
+	ss := newTestSeatService(db, fs)
+	flight := newTestSeatedFlight(t, fs, ss)
+
+	const attempts = 5
+	users := make([]*models.User, attempts)
+	references := make([]string, attempts)
+	for i := range users {
+		users[i] = createTestUser(t, db, string(rune('a'+i))+"@example.com", RoleUser)
+		references[i] = bookTestTrip(t, ts, users[i].ID, flight.ID, "flex")
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, attempts)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = ss.SelectSeat(users[i].ID, references[i], flight.ID, 0, "2A")
+		}(i)
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			if winner >= 0 {
+				t.Fatal("more than one passenger got the same seat")
+			}
+			winner = i
+		case !errors.Is(err, ErrSeatTaken):
+			t.Errorf("SelectSeat = %v, want nil or %v", err, ErrSeatTaken)
+		}
+	}
+	if winner < 0 {
+		t.Fatal("no passenger got the seat")
+	}
+
+	// A passenger who saw the seat free before it was taken cannot take it
+	loser := (winner + 1) % attempts
+	res, err := fs.ReservationRepo.GetReservationByReference(users[loser].ID, references[loser])
+	if err != nil {
+		t.Fatalf("GetReservationByReference: %v", err)
+	}
+	if _, err := ss.Repo.AssignSeat(res.ID, flight.ID, 0, "2A", repos.SeatChange{Allowed: true}); !errors.Is(err, repos.ErrSeatTaken) {
+		t.Errorf("AssignSeat of a taken seat = %v, want %v", err, repos.ErrSeatTaken)
+	}
+	seat, err := ss.Repo.GetFlightSeat(flight.ID, "2A")
+	if err != nil {
+		t.Fatalf("GetFlightSeat: %v", err)
+	}
+	winning, err := fs.ReservationRepo.GetReservationByReference(users[winner].ID, references[winner])
+	if err != nil {
+		t.Fatalf("GetReservationByReference: %v", err)
+	}
+	if seat.ReservationID == nil || *seat.ReservationID != winning.ID {
+		t.Errorf("seat 2A is not held by booking %d of the passenger who got it", winning.ID)
+	}
+}
+
+func TestSelectSeatChangeRules(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	ts := newTestTripService(fs)
+	ss := newTestSeatService(db, fs)
+	flight := newTestSeatedFlight(t, fs, ss)
+	basicUser := createTestUser(t, db, "basic@example.com", RoleUser)
+	flexUser := createTestUser(t, db, "flex@example.com", RoleUser)
+	basic := bookTestTrip(t, ts, basicUser.ID, flight.ID, "basic")
+	flex := bookTestTrip(t, ts, flexUser.ID, flight.ID, "flex")
+
+	totalPrice := func(userId uint, reference string) float64 {
+		t.Helper()
+		res, err := fs.ReservationRepo.GetReservationByReference(userId, reference)
+		if err != nil {
+			t.Fatalf("GetReservationByReference: %v", err)
+		}
+		return res.TotalPrice
+	}
+
+	// A fare that cannot be changed still chooses its first seat
+	if _, err := ss.SelectSeat(basicUser.ID, basic, flight.ID, 0, "3A"); err != nil {
+		t.Fatalf("SelectSeat: %v", err)
+	}
+	if _, err := ss.SelectSeat(basicUser.ID, basic, flight.ID, 0, "3B"); !errors.Is(err, ErrInvalidSeatSelection) {
+		t.Errorf("SelectSeat changing a basic fare's seat = %v, want %v", err, ErrInvalidSeatSelection)
+	}
+	if total := totalPrice(basicUser.ID, basic); total != 100 {
+		t.Errorf("basic booking costs %v, want 100", total)
+	}
+
+	steps := []struct {
+		seat  string
+		total float64
+	}{
+		{"4A", 150},
+		{"1A", 150 + 20 + 15},
+		{"5A", 150 + 20 + 15 - 20 + 15},
+		{"5A", 150 + 20 + 15 - 20 + 15},
+	}
+	for _, step := range steps {
+		if _, err := ss.SelectSeat(flexUser.ID, flex, flight.ID, 0, step.seat); err != nil {
+			t.Fatalf("SelectSeat %s: %v", step.seat, err)
+		}
+		if total := totalPrice(flexUser.ID, flex); total != step.total {
+			t.Errorf("after choosing %s the booking costs %v, want %v", step.seat, total, step.total)
+		}
+	}
+
+	for _, number := range []string{"4A", "1A"} {
+		seat, err := ss.Repo.GetFlightSeat(flight.ID, number)
+		if err != nil {
+			t.Fatalf("GetFlightSeat: %v", err)
+		}
+		if !seat.Available() {
+			t.Errorf("seat %s was not freed by the change", number)
+		}
+	}
+}
+
+func TestCancelTripWithholdsSeatFeesOfNonRefundableFares(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	ts := newTestTripService(fs)
+	ss := newTestSeatService(db, fs)
+	flight := newTestSeatedFlight(t, fs, ss)
+	basicUser := createTestUser(t, db, "basic@example.com", RoleUser)
+	flexUser := createTestUser(t, db, "flex@example.com", RoleUser)
+	basic := bookTestTrip(t, ts, basicUser.ID, flight.ID, "basic")
+	flex := bookTestTrip(t, ts, flexUser.ID, flight.ID, "flex")
+
+	if _, err := ss.SelectSeat(basicUser.ID, basic, flight.ID, 0, "1A"); err != nil {
+		t.Fatalf("SelectSeat: %v", err)
+	}
+	for _, number := range []string{"1B", "4B"} {
+		if _, err := ss.SelectSeat(flexUser.ID, flex, flight.ID, 0, number); err != nil {
+			t.Fatalf("SelectSeat %s: %v", number, err)
+		}
+	}
+
+	cases := []struct {
+		user      *models.User
+		reference string
+		total     float64
+		refund    float64
+	}{
+		// The basic fare keeps its price and the fee of its extra legroom seat
+		{basicUser, basic, 100 + 20, 0},
+		// The flex fare refunds its seat and change fees with its price
+		{flexUser, flex, 150 + 20 - 20 + 15, 150 + 15},
+	}
+	for _, c := range cases {
+		if _, err := ts.CancelTrip(c.user.ID, c.reference); err != nil {
+			t.Fatalf("CancelTrip %s: %v", c.reference, err)
+		}
+		res, err := fs.ReservationRepo.GetReservationByReference(c.user.ID, c.reference)
+		if err != nil {
+			t.Fatalf("GetReservationByReference: %v", err)
+		}
+		if res.TotalPrice != c.total || res.RefundAmount != c.refund {
+			t.Errorf("booking %s cost %v and refunded %v, want %v and %v", c.reference, res.TotalPrice, res.RefundAmount, c.total, c.refund)
+		}
+	}
+}
+
+func TestSelectSeatWhileCancelling(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	ts := newTestTripService(fs)
+	ss := newTestSeatService(db, fs)
+	flight := newTestSeatedFlight(t, fs, ss)
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+	reference := bookTestTrip(t, ts, user.ID, flight.ID, "flex")
+
+	// Cancel the booking once the seat selection has checked it is booked
+	// and found the seat free
+	cancelled := false
+	var cancelErr error
+	err := db.Callback().Query().After("gorm:query").Register("test:cancel_during_select", func(tx *gorm.DB) {
+		if tx.Statement.Table != "flight_seats" || cancelled {
+			return
+		}
+		cancelled = true
+		_, cancelErr = ts.CancelTrip(user.ID, reference)
+	})
+	if err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+
+	if _, err := ss.SelectSeat(user.ID, reference, flight.ID, 0, "1A"); !errors.Is(err, ErrInvalidSeatSelection) {
+		t.Errorf("SelectSeat on a booking cancelled meanwhile = %v, want %v", err, ErrInvalidSeatSelection)
+	}
+	if cancelErr != nil {
+		t.Fatalf("CancelTrip: %v", cancelErr)
+	}
+
+	seat, err := ss.Repo.GetFlightSeat(flight.ID, "1A")
+	if err != nil {
+		t.Fatalf("GetFlightSeat: %v", err)
+	}
+	if !seat.Available() {
+		t.Error("seat 1A is held by a cancelled booking")
+	}
+	res, err := fs.ReservationRepo.GetReservationByReference(user.ID, reference)
+	if err != nil {
+		t.Fatalf("GetReservationByReference: %v", err)
+	}
+	if res.TotalPrice != 150 || res.RefundAmount != 150 {
+		t.Errorf("cancelled booking cost %v and refunded %v, want 150 and 150", res.TotalPrice, res.RefundAmount)
+	}
+}
@@ -0,0 +1,211 @@
+// services/traveller_service.go
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	dateLayout             = "2006-01-02"
+	travellerMaxPerUser    = 20
+	loyaltyNumbersMaxCount = 10
+)
+
+var (
+	ErrTravellerNotFound = errors.New("traveller not found")
+	ErrInvalidTraveller  = errors.New("invalid traveller")
+	ErrTooManyTravellers = errors.New("too many travellers")
+	ErrPassportExpired   = errors.New("the traveller's passport expires before the travel date")
+)
+
+var validRelationships = map[string]bool{
+	models.RelationshipSelf:    true,
+	models.RelationshipPartner: true,
+	models.RelationshipChild:   true,
+	models.RelationshipParent:  true,
+	models.RelationshipOther:   true,
+}
+
+type TravellerService struct {
+	Repo *repos.TravellerRepo
+}
+
+func NewTravellerService(travellerRepo *repos.TravellerRepo) *TravellerService {
+	return &TravellerService{
+		Repo: travellerRepo,
+	}
+}
+
+// GetTravellers lists the travellers saved by a user
+func (ts *TravellerService) GetTravellers(userId uint) ([]models.Traveller, error) {
+	travellers, err := ts.Repo.GetTravellersByUser(userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve travellers: %w", err)
+	}
+	return travellers, nil
+}
+
+// GetTraveller retrieves one of a user's travellers
+func (ts *TravellerService) GetTraveller(userId, id uint) (*models.Traveller, error) {
+	traveller, err := ts.Repo.GetTravellerById(userId, id)
+	if err != nil {
+		return nil, ErrTravellerNotFound
+	}
+	return traveller, nil
+}
+
+// CreateTraveller saves a new traveller for a user
+func (ts *TravellerService) CreateTraveller(userId uint, traveller *models.Traveller) error {
+	existing, err := ts.Repo.GetTravellersByUser(userId)
+	if err != nil {
+		return fmt.Errorf("failed to count travellers: %w", err)
+	}
+	if len(existing) >= travellerMaxPerUser {
+		return fmt.Errorf("%w: at most %d can be saved", ErrTooManyTravellers, travellerMaxPerUser)
+	}
+
+	traveller.ID = 0
+	traveller.UserID = userId
+	if err := ts.validateTraveller(traveller); err != nil {
+		return err
+	}
+
+	if err := ts.Repo.CreateTraveller(traveller); err != nil {
+		return fmt.Errorf("failed to create traveller: %w", err)
+	}
+	return nil
+}
+
+// UpdateTraveller replaces the details of one of a user's travellers
+func (ts *TravellerService) UpdateTraveller(userId uint, traveller *models.Traveller) error {
+	existing, err := ts.Repo.GetTravellerById(userId, traveller.ID)
+	if err != nil {
+		return ErrTravellerNotFound
+	}
+
+	traveller.UserID = userId
+	traveller.CreatedAt = existing.CreatedAt
+	if err := ts.validateTraveller(traveller); err != nil {
+		return err
+	}
+
+	if err := ts.Repo.UpdateTraveller(traveller); err != nil {
+		return fmt.Errorf("failed to update traveller: %w", err)
+	}
+	return nil
+}
+
+// DeleteTraveller removes one of a user's travellers
+func (ts *TravellerService) DeleteTraveller(userId, id uint) error {
+	if _, err := ts.Repo.GetTravellerById(userId, id); err != nil {
+		return ErrTravellerNotFound
+	}
+	if err := ts.Repo.DeleteTraveller(id); err != nil {
+		return fmt.Errorf("failed to delete traveller: %w", err)
+	}
+	return nil
+}
+
+// validateTraveller normalizes and checks a traveller's details
+func (ts *TravellerService) validateTraveller(traveller *models.Traveller) error {
+	traveller.FirstName = strings.TrimSpace(traveller.FirstName)
+	traveller.LastName = strings.TrimSpace(traveller.LastName)
+	traveller.Nationality = strings.TrimSpace(traveller.Nationality)
+	traveller.PassportNumber = strings.ToUpper(strings.TrimSpace(traveller.PassportNumber))
+
+	if traveller.Relationship == "" {
+		traveller.Relationship = models.RelationshipOther
+	}
+	if !validRelationships[traveller.Relationship] {
+		return invalidTraveller("invalid relationship. Must be one of 'self', 'partner', 'child', 'parent' or 'other'")
+	}
+	if traveller.Relationship == models.RelationshipSelf {
+		count, err := ts.Repo.CountTravellersByRelationship(traveller.UserID, models.RelationshipSelf, traveller.ID)
+		if err != nil {
+			return fmt.Errorf("failed to check travellers: %w", err)
+		}
+		if count > 0 {
+			return invalidTraveller("only one traveller can be yourself")
+		}
+	}
+
+	if traveller.FirstName == "" || len(traveller.FirstName) > 100 {
+		return invalidTraveller("first name must be between 1 and 100 characters")
+	}
+	if traveller.LastName == "" || len(traveller.LastName) > 100 {
+		return invalidTraveller("last name must be between 1 and 100 characters")
+	}
+
+	dob, err := time.Parse(dateLayout, traveller.DateOfBirth)
+	if err != nil {
+		return invalidTraveller("date of birth must be formatted as YYYY-MM-DD")
+	}
+	if dob.After(time.Now()) {
+		return invalidTraveller("date of birth cannot be in the future")
+	}
+
+	if len(traveller.Nationality) < 2 || len(traveller.Nationality) > 50 {
+		return invalidTraveller("nationality must be between 2 and 50 characters")
+	}
+
+	// Passport details are optional, but must be complete when given
+	if traveller.PassportNumber != "" || traveller.PassportExpiry != "" {
+		if len(traveller.PassportNumber) < 6 || len(traveller.PassportNumber) > 20 {
+			return invalidTraveller("passport number must be between 6 and 20 characters")
+		}
+		if _, err := time.Parse(dateLayout, traveller.PassportExpiry); err != nil {
+			return invalidTraveller("passport expiry must be formatted as YYYY-MM-DD")
+		}
+	}
+
+	if len(traveller.LoyaltyNumbers) > loyaltyNumbersMaxCount {
+		return invalidTraveller(fmt.Sprintf("at most %d loyalty numbers can be saved per traveller", loyaltyNumbersMaxCount))
+	}
+	for i := range traveller.LoyaltyNumbers {
+		loyalty := &traveller.LoyaltyNumbers[i]
+		loyalty.Program = strings.TrimSpace(loyalty.Program)
+		loyalty.Number = strings.TrimSpace(loyalty.Number)
+		if loyalty.Program == "" || len(loyalty.Program) > 100 {
+			return invalidTraveller("loyalty programme must be between 1 and 100 characters")
+		}
+		if loyalty.Number == "" || len(loyalty.Number) > 50 {
+			return invalidTraveller("loyalty number must be between 1 and 50 characters")
+		}
+	}
+	return nil
+}
+
+// invalidTraveller returns a validation error wrapping ErrInvalidTraveller
+func invalidTraveller(message string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidTraveller, message)
+}
+
+// loadTravellers retrieves the travellers with the given IDs, which must all
+// belong to the user. Duplicate IDs are ignored.
+func loadTravellers(travellerRepo *repos.TravellerRepo, userId uint, ids []uint) ([]models.Traveller, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	unique := make([]uint, 0, len(ids))
+	seen := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	travellers, err := travellerRepo.GetTravellersByIds(userId, unique)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve travellers: %w", err)
+	}
+	if len(travellers) != len(unique) {
+		return nil, ErrTravellerNotFound
+	}
+	return travellers, nil
+}
@@ -0,0 +1,254 @@
+// services/trip_service.go
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"Visa/pkg"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const tripMaxJourneys = 6
+
+var (
+	ErrInvalidTrip  = errors.New("invalid trip")
+	ErrTripNotFound = errors.New("trip not found")
+)
+
+// Trip is a flight booking with its flights grouped into journeys, such as
+// the outbound and return of a round trip
+type Trip struct {
+	ReservationID uint               `json:"reservation_id"`
+	Reference     string             `json:"reference"`
+	Type          string             `json:"trip_type"`
+	Status        string             `json:"status"`
+	TotalPrice    float64            `json:"total_price"`
+	RefundAmount  float64            `json:"refund_amount"`
+	Travellers    []models.Traveller `json:"travellers"`
+	Journeys      []Itinerary        `json:"journeys"`
+	// Seats are the seats chosen for the passengers
+	Seats []models.FlightSeat `json:"seats"`
+}
+
+type TripService struct {
+	ReservationRepo *repos.ReservationRepo
+	FlightRepo      *repos.FlightRepo
+	Flights         *FlightService
+}
+
+func NewTripService(reservationRepo *repos.ReservationRepo, flightRepo *repos.FlightRepo, flightService *FlightService) *TripService {
+	return &TripService{
+		ReservationRepo: reservationRepo,
+		FlightRepo:      flightRepo,
+		Flights:         flightService,
+	}
+}
+
+// BookTrip books one or more journeys for the user in one transaction under
+// a single booking reference. Each journey is its flights in travel order. A
+// round trip is two journeys, the second flying back from where the first
+// lands to where it left from; a multi-city trip is two to six journeys in
+// date order. Each flight is booked at the chosen fare. Either every flight
+// is booked or none is.
+func (ts *TripService) BookTrip(userId uint, tripType string, journeyIds [][]uint, fare FareChoice, travellerIds []uint) (*Trip, error) {
+	if tripType == "" {
+		tripType = models.TripOneWay
+	}
+	switch tripType {
+	case models.TripOneWay:
+		if len(journeyIds) != 1 {
+			return nil, fmt.Errorf("%w: a one way trip has one journey", ErrInvalidTrip)
+		}
+	case models.TripRoundTrip:
+		if len(journeyIds) != 2 {
+			return nil, fmt.Errorf("%w: a round trip has an outbound and a return journey", ErrInvalidTrip)
+		}
+	case models.TripMultiCity:
+		if len(journeyIds) < 2 || len(journeyIds) > tripMaxJourneys {
+			return nil, fmt.Errorf("%w: a multi-city trip has between 2 and %d journeys", ErrInvalidTrip, tripMaxJourneys)
+		}
+	default:
+		return nil, fmt.Errorf("%w: trip type must be 'one_way', 'round_trip' or 'multi_city'", ErrInvalidTrip)
+	}
+
+	// A flight can only be taken once per trip
+	seen := make(map[uint]bool)
+	for _, ids := range journeyIds {
+		for _, id := range ids {
+			if seen[id] {
+				return nil, fmt.Errorf("%w: flight %d is booked twice", ErrInvalidTrip, id)
+			}
+			seen[id] = true
+		}
+	}
+
+	flightsById, err := loadFlightsById(ts.FlightRepo, journeyIds)
+	if err != nil {
+		return nil, err
+	}
+	journeys := make([][]models.Flight, len(journeyIds))
+	for i, ids := range journeyIds {
+		if journeys[i], err = connectJourney(flightsById, ids); err != nil {
+			return nil, err
+		}
+	}
+
+	first := journeys[0][0]
+	if !first.DepartureAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: the first flight has already left", ErrInvalidTrip)
+	}
+	for i := 1; i < len(journeys); i++ {
+		previous := journeys[i-1][len(journeys[i-1])-1]
+		if !journeys[i][0].DepartureAt.After(previous.ArrivalAt) {
+			return nil, fmt.Errorf("%w: journey %d must leave after journey %d arrives", ErrInvalidTrip, i+1, i)
+		}
+	}
+	if tripType == models.TripRoundTrip {
+		outbound, inbound := journeys[0], journeys[1]
+		if inbound[0].Origin != outbound[len(outbound)-1].Destination || inbound[len(inbound)-1].Destination != outbound[0].Origin {
+			return nil, fmt.Errorf("%w: the return journey must fly back from %s to %s",
+				ErrInvalidTrip, outbound[len(outbound)-1].Destination, outbound[0].Origin)
+		}
+	}
+
+	res, err := ts.Flights.bookFlights(userId, tripType, journeys, fare, travellerIds)
+	if err != nil {
+		return nil, err
+	}
+	return newTrip(res, journeys), nil
+}
+
+// GetTrip retrieves one of the user's flight bookings by its reference
+func (ts *TripService) GetTrip(userId uint, reference string) (*Trip, error) {
+	res, err := ts.ReservationRepo.GetReservationByReference(userId, normalizeBookingReference(reference))
+	if err != nil {
+		return nil, ErrTripNotFound
+	}
+	return ts.loadTrip(res)
+}
+
+// CancelTrip cancels every flight of one of the user's bookings and gives
+// their seats back. The price of non-refundable fares is not refunded.
+// Bookings can only be cancelled before their first flight leaves.
+func (ts *TripService) CancelTrip(userId uint, reference string) (*Trip, error) {
+	res, err := ts.ReservationRepo.GetReservationByReference(userId, normalizeBookingReference(reference))
+	if err != nil {
+		return nil, ErrTripNotFound
+	}
+	if res.Status != "booked" {
+		return nil, fmt.Errorf("%w: the booking is already %s", ErrInvalidTrip, res.Status)
+	}
+
+	if err := ts.ReservationRepo.CancelFlightReservation(res, res.Seats()); err != nil {
+		if errors.Is(err, repos.ErrAlreadyCancelled) {
+			return nil, fmt.Errorf("%w: the booking is already cancelled", ErrInvalidTrip)
+		}
+		if errors.Is(err, repos.ErrDeparted) {
+			return nil, fmt.Errorf("%w: a flight of the booking has already left", ErrInvalidTrip)
+		}
+		return nil, fmt.Errorf("failed to cancel reservation: %w", err)
+	}
+	res.AssignedSeats = nil
+	return ts.loadTrip(res)
+}
+
+// loadTrip retrieves the flights of a booking and groups them into journeys
+func (ts *TripService) loadTrip(res *models.Reservation) (*Trip, error) {
+	flightIds := res.FlightIDs()
+	flightsById, err := loadFlightsById(ts.FlightRepo, [][]uint{flightIds})
+	if err != nil {
+		return nil, err
+	}
+
+	var journeys [][]models.Flight
+	for i, id := range flightIds {
+		// Bookings made before journeys were recorded are one journey
+		journey := 1
+		if i < len(res.Segments) && res.Segments[i].Journey > 0 {
+			journey = res.Segments[i].Journey
+		}
+		for len(journeys) < journey {
+			journeys = append(journeys, nil)
+		}
+		if flight, ok := flightsById[id]; ok {
+			journeys[journey-1] = append(journeys[journey-1], flight)
+		}
+	}
+	return newTrip(res, journeys), nil
+}
+
+// newTrip groups the flights of a booking into journeys, pricing each flight
+// at the fare booked on it
+func newTrip(res *models.Reservation, journeys [][]models.Flight) *Trip {
+	trip := &Trip{
+		ReservationID: res.ID,
+		Type:          res.TripType,
+		Status:        res.Status,
+		TotalPrice:    res.TotalPrice,
+		RefundAmount:  res.RefundAmount,
+		Travellers:    res.Travellers,
+		Journeys:      make([]Itinerary, 0, len(journeys)),
+		Seats:         res.AssignedSeats,
+	}
+	if trip.Seats == nil {
+		trip.Seats = []models.FlightSeat{}
+	}
+	if res.Reference != nil {
+		trip.Reference = *res.Reference
+	}
+	if trip.Type == "" {
+		trip.Type = models.TripOneWay
+	}
+	booked := make(map[uint]models.ReservationFlight, len(res.Segments))
+	for _, segment := range res.Segments {
+		booked[segment.FlightID] = segment
+	}
+	for _, flights := range journeys {
+		if len(flights) == 0 {
+			continue
+		}
+		priced := make([]models.Flight, len(flights))
+		for i, flight := range flights {
+			// Bookings made before fares were recorded keep the flight's price
+			if segment, ok := booked[flight.ID]; ok && segment.FareBucketID != nil {
+				flight.Price = segment.Price
+				flight.CabinClass = segment.Cabin
+			}
+			priced[i] = flight
+		}
+		trip.Journeys = append(trip.Journeys, newItinerary(priced))
+	}
+	return trip
+}
+
+// normalizeBookingReference ignores case and surrounding spaces
+func normalizeBookingReference(reference string) string {
+	return strings.ToUpper(strings.TrimSpace(reference))
+}
+
+// TripView is a trip with its flights and total price rendered for the
+// caller
+type TripView struct {
+	Trip
+	Journeys  []ItineraryView `json:"journeys"`
+	Localized LocalizedTrip   `json:"localized"`
+}
+
+type LocalizedTrip struct {
+	TotalPrice   pkg.LocalizedPrice `json:"total_price"`
+	RefundAmount pkg.LocalizedPrice `json:"refund_amount"`
+}
+
+func NewTripView(trip *Trip, localizer *pkg.Localizer) TripView {
+	return TripView{
+		Trip:     *trip,
+		Journeys: NewItineraryViews(trip.Journeys, localizer),
+		Localized: LocalizedTrip{
+			TotalPrice:   localizer.Price(trip.TotalPrice),
+			RefundAmount: localizer.Price(trip.RefundAmount),
+		},
+	}
+}
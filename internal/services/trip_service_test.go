@@ -0,0 +1,261 @@
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBookTripIsAllOrNothing(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	ts := newTestTripService(fs)
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+	other := createTestUser(t, db, "other@example.com", RoleUser)
+
+	departure := time.Now().Add(48 * time.Hour)
+	outbound := createTestFlight(t, fs, "TA100", "LHR", "JFK", departure)
+	inbound := createTestFlight(t, fs, "TA101", "JFK", "LHR", departure.Add(24*time.Hour),
+		FareDetails{Cabin: models.CabinEconomy, FareFamily: "basic", Price: 80, Seats: 1})
+	if err := fs.BookFlight(other.ID, inbound.ID, FareChoice{}, nil); err != nil {
+		t.Fatalf("BookFlight: %v", err)
+	}
+
+	if _, err := ts.BookTrip(user.ID, models.TripRoundTrip, [][]uint{{outbound.ID}, {inbound.ID}}, FareChoice{}, nil); !errors.Is(err, ErrNotEnoughSeats) {
+		t.Fatalf("BookTrip with a full return flight = %v, want %v", err, ErrNotEnoughSeats)
+	}
+	if seats, _ := seatsAvailable(t, db, outbound.ID); seats != outbound.Capacity {
+		t.Errorf("outbound has %d seats available, want %d", seats, outbound.Capacity)
+	}
+
+	// Seats running out between choosing the fares and booking them still
+	// books nothing
+	outboundFare, inboundFare := outbound.Fares[0].ID, inbound.Fares[0].ID
+	res := &models.Reservation{
+		UserID: strconv.FormatUint(uint64(user.ID), 10),
+		Status: "booked",
+		Segments: []models.ReservationFlight{
+			{FlightID: outbound.ID, Journey: 1, Sequence: 1, FareBucketID: &outboundFare, Price: 100},
+			{FlightID: inbound.ID, Journey: 2, Sequence: 2, FareBucketID: &inboundFare, Price: 80},
+		},
+	}
+	if err := fs.ReservationRepo.CreateFlightReservation(res, 1); !errors.Is(err, repos.ErrNotEnoughSeats) {
+		t.Fatalf("CreateFlightReservation = %v, want %v", err, repos.ErrNotEnoughSeats)
+	}
+	seats, fares := seatsAvailable(t, db, outbound.ID)
+	if seats != outbound.Capacity || fares[outboundFare] != outbound.Capacity {
+		t.Errorf("outbound has %d seats and its fare %d available after a failed booking, want %d", seats, fares[outboundFare], outbound.Capacity)
+	}
+	var booked int64
+	if err := db.Model(&models.Reservation{}).Where("user_id = ?", user.ID).Count(&booked).Error; err != nil {
+		t.Fatalf("count reservations: %v", err)
+	}
+	if booked != 0 {
+		t.Errorf("%d reservations stored after failed bookings", booked)
+	}
+
+	trip, err := ts.BookTrip(user.ID, models.TripOneWay, [][]uint{{outbound.ID}}, FareChoice{}, nil)
+	if err != nil {
+		t.Fatalf("BookTrip: %v", err)
+	}
+	if trip.Reference == "" || trip.TotalPrice != 100 {
+		t.Errorf("trip has reference %q and total %v, want a reference and 100", trip.Reference, trip.TotalPrice)
+	}
+	if seats, _ := seatsAvailable(t, db, outbound.ID); seats != outbound.Capacity-1 {
+		t.Errorf("outbound has %d seats available, want %d", seats, outbound.Capacity-1)
+	}
+}
+
+func TestBookFlightConcurrentDuplicates(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+	flight := createTestFlight(t, fs, "TA100", "LHR", "JFK", time.Now().Add(48*time.Hour))
+
+	const attempts = 5
+	var wg sync.WaitGroup
+	errs := make([]error, attempts)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = fs.BookFlight(user.ID, flight.ID, FareChoice{}, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	booked := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			booked++
+		case !errors.Is(err, ErrAlreadyBooked):
+			t.Errorf("BookFlight = %v, want nil or %v", err, ErrAlreadyBooked)
+		}
+	}
+	if booked != 1 {
+		t.Fatalf("%d concurrent bookings of the same flight succeeded, want 1", booked)
+	}
+	if seats, _ := seatsAvailable(t, db, flight.ID); seats != flight.Capacity-1 {
+		t.Errorf("flight has %d seats available, want %d", seats, flight.Capacity-1)
+	}
+}
+
+func TestBookDepartedFlight(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	ts := newTestTripService(fs)
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+	flight := createTestFlight(t, fs, "TA100", "LHR", "JFK", time.Now().Add(48*time.Hour))
+	departTestFlight(t, db, flight)
+
+	// A booking of the flight as it was read before it left takes no seats
+	fare := flight.Fares[0].ID
+	res := &models.Reservation{
+		UserID:   strconv.FormatUint(uint64(user.ID), 10),
+		Status:   "booked",
+		Segments: []models.ReservationFlight{{FlightID: flight.ID, Journey: 1, Sequence: 1, FareBucketID: &fare, Price: 100}},
+	}
+	if err := fs.ReservationRepo.CreateFlightReservation(res, 1); !errors.Is(err, repos.ErrDeparted) {
+		t.Errorf("CreateFlightReservation = %v, want %v", err, repos.ErrDeparted)
+	}
+	if err := fs.BookFlight(user.ID, flight.ID, FareChoice{}, nil); !errors.Is(err, ErrFlightDeparted) {
+		t.Errorf("BookFlight = %v, want %v", err, ErrFlightDeparted)
+	}
+	if _, err := ts.BookTrip(user.ID, models.TripOneWay, [][]uint{{flight.ID}}, FareChoice{}, nil); err == nil {
+		t.Error("BookTrip of a departed flight succeeded")
+	}
+	if seats, _ := seatsAvailable(t, db, flight.ID); seats != flight.Capacity {
+		t.Errorf("flight has %d seats available, want %d", seats, flight.Capacity)
+	}
+}
+
+func TestCancelTripConcurrently(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	ts := newTestTripService(fs)
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+
+	departure := time.Now().Add(48 * time.Hour)
+	outbound := createTestFlight(t, fs, "TA100", "LHR", "JFK", departure)
+	inbound := createTestFlight(t, fs, "TA101", "JFK", "LHR", departure.Add(24*time.Hour))
+	trip, err := ts.BookTrip(user.ID, models.TripRoundTrip, [][]uint{{outbound.ID}, {inbound.ID}}, FareChoice{}, nil)
+	if err != nil {
+		t.Fatalf("BookTrip: %v", err)
+	}
+	stale, err := fs.ReservationRepo.GetReservationByReference(user.ID, trip.Reference)
+	if err != nil {
+		t.Fatalf("GetReservationByReference: %v", err)
+	}
+
+	const attempts = 5
+	var wg sync.WaitGroup
+	errs := make([]error, attempts)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = ts.CancelTrip(user.ID, trip.Reference)
+		}(i)
+	}
+	wg.Wait()
+
+	cancelled := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			cancelled++
+		case !errors.Is(err, ErrInvalidTrip):
+			t.Errorf("CancelTrip = %v, want nil or %v", err, ErrInvalidTrip)
+		}
+	}
+	if cancelled != 1 {
+		t.Fatalf("%d concurrent cancellations succeeded, want 1", cancelled)
+	}
+	for _, flight := range []*models.Flight{outbound, inbound} {
+		seats, fares := seatsAvailable(t, db, flight.ID)
+		if seats != flight.Capacity || fares[flight.Fares[0].ID] != flight.Capacity {
+			t.Errorf("flight %s has %d seats and its fare %d available, want %d", flight.FlightNumber, seats, fares[flight.Fares[0].ID], flight.Capacity)
+		}
+	}
+
+	// A booking read before it was cancelled cannot give its seats back again
+	if err := fs.ReservationRepo.CancelFlightReservation(stale, stale.Seats()); !errors.Is(err, repos.ErrAlreadyCancelled) {
+		t.Errorf("CancelFlightReservation of a stale booking = %v, want %v", err, repos.ErrAlreadyCancelled)
+	}
+	if err := fs.CancelFlight(user.ID, outbound.ID); err == nil {
+		t.Error("CancelFlight of a cancelled booking succeeded")
+	}
+	if seats, _ := seatsAvailable(t, db, outbound.ID); seats != outbound.Capacity {
+		t.Errorf("outbound has %d seats available after cancelling again, want %d", seats, outbound.Capacity)
+	}
+}
+
+func TestCancelBookingOfCancelledFlight(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	ts := newTestTripService(fs)
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+	flight := createTestFlight(t, fs, "TA100", "LHR", "JFK", time.Now().Add(48*time.Hour))
+	trip, err := ts.BookTrip(user.ID, models.TripOneWay, [][]uint{{flight.ID}}, FareChoice{}, nil)
+	if err != nil {
+		t.Fatalf("BookTrip: %v", err)
+	}
+
+	cancellation, err := fs.CancelFlightAndNotify(flight.ID, "")
+	if err != nil {
+		t.Fatalf("CancelFlightAndNotify: %v", err)
+	}
+	if cancellation.CancelledReservations != 1 {
+		t.Errorf("%d reservations cancelled with the flight, want 1", cancellation.CancelledReservations)
+	}
+
+	if _, err := ts.CancelTrip(user.ID, trip.Reference); !errors.Is(err, ErrInvalidTrip) {
+		t.Errorf("CancelTrip after the flight was cancelled = %v, want %v", err, ErrInvalidTrip)
+	}
+	if seats, _ := seatsAvailable(t, db, flight.ID); seats != 0 {
+		t.Errorf("cancelled flight has %d seats available, want 0", seats)
+	}
+}
+
+func TestCancelTripAfterDeparture(t *testing.T) {
+	db := newTestDB(t)
+	fs := newTestFlightService(db)
+	ts := newTestTripService(fs)
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+
+	departure := time.Now().Add(48 * time.Hour)
+	flex := FareDetails{Cabin: models.CabinEconomy, FareFamily: "flex", Price: 150, Seats: 10, Refundable: true}
+	outbound := createTestFlight(t, fs, "TA100", "LHR", "JFK", departure, flex)
+	inbound := createTestFlight(t, fs, "TA101", "JFK", "LHR", departure.Add(24*time.Hour), flex)
+	trip, err := ts.BookTrip(user.ID, models.TripRoundTrip, [][]uint{{outbound.ID}, {inbound.ID}}, FareChoice{}, nil)
+	if err != nil {
+		t.Fatalf("BookTrip: %v", err)
+	}
+
+	// Once the outbound has flown the return cannot be refunded either
+	departTestFlight(t, db, outbound)
+	if _, err := ts.CancelTrip(user.ID, trip.Reference); !errors.Is(err, ErrInvalidTrip) {
+		t.Errorf("CancelTrip after departure = %v, want %v", err, ErrInvalidTrip)
+	}
+	if err := fs.CancelFlight(user.ID, inbound.ID); !errors.Is(err, ErrFlightDeparted) {
+		t.Errorf("CancelFlight after departure = %v, want %v", err, ErrFlightDeparted)
+	}
+
+	res, err := fs.ReservationRepo.GetReservationByReference(user.ID, trip.Reference)
+	if err != nil {
+		t.Fatalf("GetReservationByReference: %v", err)
+	}
+	if res.Status != "booked" || res.RefundAmount != 0 {
+		t.Errorf("booking is %s with a refund of %v, want booked without a refund", res.Status, res.RefundAmount)
+	}
+	for _, flight := range []*models.Flight{outbound, inbound} {
+		if seats, _ := seatsAvailable(t, db, flight.ID); seats != flight.Capacity-1 {
+			t.Errorf("flight %s has %d seats available, want %d", flight.FlightNumber, seats, flight.Capacity-1)
+		}
+	}
+}
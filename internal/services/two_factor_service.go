@@ -0,0 +1,303 @@
+// services/two_factor_service.go
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"Visa/pkg"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	totpIssuer        = "Travel Companion"
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrInvalidTwoFactorCode   = errors.New("invalid two-factor code")
+	ErrTwoFactorNotEnabled    = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorAlreadyActive = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotSetUp      = errors.New("two-factor authentication has not been set up")
+	ErrTwoFactorCodeRequired  = errors.New("a two-factor code is required")
+)
+
+// TwoFactorSetup is returned when a user starts enrolling an authenticator
+type TwoFactorSetup struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type TwoFactorService struct {
+	UserRepo         *repos.UserRepo
+	RecoveryCodeRepo *repos.RecoveryCodeRepo
+	Auth             *AuthService
+	Attempts         *LoginAttemptService
+}
+
+func NewTwoFactorService(userRepo *repos.UserRepo, recoveryCodeRepo *repos.RecoveryCodeRepo, authService *AuthService, loginAttempts *LoginAttemptService) *TwoFactorService {
+	return &TwoFactorService{
+		UserRepo:         userRepo,
+		RecoveryCodeRepo: recoveryCodeRepo,
+		Auth:             authService,
+		Attempts:         loginAttempts,
+	}
+}
+
+// Setup generates a new TOTP secret for a user after checking their
+// password, so that a stolen access token cannot enroll another
+// authenticator. The secret only becomes active once it is confirmed with a
+// valid code.
+func (ts *TwoFactorService) Setup(userId uint, password string, client ClientInfo) (*TwoFactorSetup, error) {
+	user, err := ts.UserRepo.GetUserById(userId)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyActive
+	}
+	if err := ts.Reauthenticate(user, password, "", client); err != nil {
+		return nil, err
+	}
+
+	secret, err := pkg.GenerateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+
+	if err := ts.UserRepo.UpdateColumns(user.ID, map[string]interface{}{
+		"two_factor_secret":    secret,
+		"two_factor_last_step": 0,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to save secret: %w", err)
+	}
+
+	return &TwoFactorSetup{
+		Secret:     secret,
+		OTPAuthURI: pkg.TOTPURI(totpIssuer, user.Email, secret),
+	}, nil
+}
+
+// Confirm enables two-factor authentication after checking a code from the
+// authenticator app and returns the user's recovery codes
+func (ts *TwoFactorService) Confirm(userId uint, code string) ([]string, error) {
+	user, err := ts.UserRepo.GetUserById(userId)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyActive
+	}
+	if user.TwoFactorSecret == "" {
+		return nil, ErrTwoFactorNotSetUp
+	}
+
+	if err := ts.verifyCode(user, code); err != nil {
+		return nil, err
+	}
+
+	if err := ts.UserRepo.UpdateColumns(user.ID, map[string]interface{}{"two_factor_enabled": true}); err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+
+	return ts.generateRecoveryCodes(user.ID)
+}
+
+// Disable turns off two-factor authentication. Both the password and a
+// current code (or recovery code) are required, and wrong ones count towards
+// the same lockout as failed sign-ins.
+func (ts *TwoFactorService) Disable(userId uint, password, code string, client ClientInfo) error {
+	user, err := ts.UserRepo.GetUserById(userId)
+	if err != nil {
+		return fmt.Errorf("user not found: %w", err)
+	}
+	if !user.TwoFactorEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if err := ts.Reauthenticate(user, password, code, client); err != nil {
+		return err
+	}
+
+	if err := ts.UserRepo.UpdateColumns(user.ID, map[string]interface{}{
+		"two_factor_enabled":   false,
+		"two_factor_secret":    "",
+		"two_factor_last_step": 0,
+	}); err != nil {
+		return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+	}
+
+	return ts.RecoveryCodeRepo.ReplaceCodes(user.ID, nil)
+}
+
+// Reauthenticate checks the password of a signed in user, and a current code
+// or recovery code if they use two-factor authentication, before a change
+// that takes over or ends the account. Wrong ones count towards the same
+// lockout as failed sign-ins.
+func (ts *TwoFactorService) Reauthenticate(user *models.User, password, code string, client ClientInfo) error {
+	if err := ts.Attempts.CheckUser(user); err != nil {
+		ts.recordLoginFailure(user, client, models.LoginFailureAccountLocked)
+		return err
+	}
+	ok, _, err := pkg.VerifyPassword(user.Password, password)
+	if err != nil {
+		return fmt.Errorf("failed to verify password: %w", err)
+	}
+	if !ok {
+		ts.recordLoginFailure(user, client, models.LoginFailureBadPassword)
+		return ErrIncorrectPassword
+	}
+
+	if !user.TwoFactorEnabled {
+		return nil
+	}
+	if strings.TrimSpace(code) == "" {
+		return ErrTwoFactorCodeRequired
+	}
+	if err := ts.verifyCodeOrRecoveryCode(user, code); err != nil {
+		if errors.Is(err, ErrInvalidTwoFactorCode) {
+			ts.recordLoginFailure(user, client, models.LoginFailureBadTwoFactor)
+		}
+		return err
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a TOTP code
+func (ts *TwoFactorService) RegenerateRecoveryCodes(userId uint, code string) ([]string, error) {
+	user, err := ts.UserRepo.GetUserById(userId)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+	if !user.TwoFactorEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+
+	if err := ts.verifyCode(user, code); err != nil {
+		return nil, err
+	}
+	return ts.generateRecoveryCodes(user.ID)
+}
+
+// CompleteLogin exchanges a challenge token and a TOTP or recovery code for
+// an access and refresh token pair. Wrong codes count towards the same
+// lockout as wrong passwords.
+func (ts *TwoFactorService) CompleteLogin(challengeToken, code string, client ClientInfo) (*models.User, *TokenPair, error) {
+	claims, err := ts.Auth.ParseChallengeToken(challengeToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := ts.UserRepo.GetUserById(claims.UserID)
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+	if !user.TwoFactorEnabled {
+		return nil, nil, ErrTwoFactorNotEnabled
+	}
+	if user.SuspendedAt != nil {
+		return nil, nil, ErrAccountSuspended
+	}
+
+	if err := ts.Attempts.CheckIP(client); err != nil {
+		ts.recordLoginFailure(user, client, models.LoginFailureIPThrottled)
+		return nil, nil, err
+	}
+	if err := ts.Attempts.CheckUser(user); err != nil {
+		ts.recordLoginFailure(user, client, models.LoginFailureAccountLocked)
+		return nil, nil, err
+	}
+
+	if err := ts.verifyCodeOrRecoveryCode(user, code); err != nil {
+		if errors.Is(err, ErrInvalidTwoFactorCode) {
+			ts.recordLoginFailure(user, client, models.LoginFailureBadTwoFactor)
+		}
+		return nil, nil, err
+	}
+
+	tokens, err := ts.Auth.IssueTokens(user, true, client)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	if err := ts.Attempts.RecordSuccess(user, client); err != nil {
+		log.Printf("Error recording sign-in of user %d: %v", user.ID, err)
+	}
+
+	user.Password = ""
+	return user, tokens, nil
+}
+
+func (ts *TwoFactorService) recordLoginFailure(user *models.User, client ClientInfo, reason string) {
+	if err := ts.Attempts.RecordFailure(user, user.Email, client, reason); err != nil {
+		log.Printf("Error recording failed sign-in of user %d: %v", user.ID, err)
+	}
+}
+
+// verifyCode checks a TOTP code and makes sure it cannot be used twice
+func (ts *TwoFactorService) verifyCode(user *models.User, code string) error {
+	step, ok := pkg.ValidateTOTP(user.TwoFactorSecret, code, time.Now())
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	advanced, err := ts.UserRepo.AdvanceTwoFactorStep(user.ID, step)
+	if err != nil {
+		return fmt.Errorf("failed to record two-factor code: %w", err)
+	}
+	if !advanced {
+		return ErrInvalidTwoFactorCode
+	}
+	user.TwoFactorLastStep = step
+	return nil
+}
+
+// verifyCodeOrRecoveryCode accepts either a TOTP code or an unused recovery code
+func (ts *TwoFactorService) verifyCodeOrRecoveryCode(user *models.User, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == 6 {
+		return ts.verifyCode(user, code)
+	}
+
+	used, err := ts.RecoveryCodeRepo.UseCode(user.ID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return fmt.Errorf("failed to check recovery code: %w", err)
+	}
+	if !used {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// generateRecoveryCodes replaces the user's recovery codes with new ones
+func (ts *TwoFactorService) generateRecoveryCodes(userId uint) ([]string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		code, err := randomString(alphabet, 10)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+		}
+		codes[i] = code[:5] + "-" + code[5:]
+		records[i] = models.RecoveryCode{
+			UserID:   userId,
+			CodeHash: hashToken(normalizeRecoveryCode(codes[i])),
+		}
+	}
+
+	if err := ts.RecoveryCodeRepo.ReplaceCodes(userId, records); err != nil {
+		return nil, fmt.Errorf("failed to store recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes in recovery codes
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
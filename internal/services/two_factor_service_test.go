@@ -0,0 +1,144 @@
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/pkg"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func newTestTwoFactorService(us *UserService, db *gorm.DB) *TwoFactorService {
+	return NewTwoFactorService(us.Repo, repos.NewRecoveryCodeRepo(db), us.Auth, us.Attempts)
+}
+
+const testPassword = "correct-horse-battery-staple"
+
+// setTestPassword gives a user created by createTestUser testPassword
+func setTestPassword(t *testing.T, us *UserService, userId uint) {
+	t.Helper()
+
+	hash, err := pkg.HashPassword(testPassword)
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	if err := us.Repo.UpdatePassword(userId, hash); err != nil {
+		t.Fatalf("UpdatePassword: %v", err)
+	}
+}
+
+// currentTOTPCode returns the code an authenticator app shows for the user now
+func currentTOTPCode(t *testing.T, ts *TwoFactorService, userId uint) string {
+	t.Helper()
+
+	user, err := ts.UserRepo.GetUserById(userId)
+	if err != nil {
+		t.Fatalf("GetUserById: %v", err)
+	}
+	code, err := pkg.TOTPCode(user.TwoFactorSecret, pkg.TOTPStep(time.Now()))
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	return code
+}
+
+func TestConfirmTwoFactorKeepsConcurrentRoleChange(t *testing.T) {
+	db := newTestDB(t)
+	us := newTestUserService(t, db)
+	ts := newTestTwoFactorService(us, db)
+	createTestUser(t, db, "admin@example.com", RoleAdmin)
+	user := createTestUser(t, db, "agent@example.com", RoleSupportAgent)
+	setTestPassword(t, us, user.ID)
+
+	if _, err := ts.Setup(user.ID, testPassword, ClientInfo{}); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	code := currentTOTPCode(t, ts, user.ID)
+
+	// An admin demotes the user while they confirm their authenticator
+	changed := false
+	var changeErr error
+	err := db.Callback().Query().After("gorm:query").Register("test:demote_during_confirm", func(tx *gorm.DB) {
+		if tx.Statement.Table != "users" || changed {
+			return
+		}
+		changed = true
+		changeErr = us.Repo.UpdateRole(user.ID, RoleUser)
+	})
+	if err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+
+	if _, err := ts.Confirm(user.ID, code); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	if changeErr != nil {
+		t.Fatalf("UpdateRole: %v", changeErr)
+	}
+	stored, err := us.Repo.GetUserById(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserById: %v", err)
+	}
+	if stored.Role != RoleUser {
+		t.Errorf("role is %s after enabling two-factor authentication, want %s", stored.Role, RoleUser)
+	}
+	if !stored.TwoFactorEnabled {
+		t.Error("two-factor authentication was not enabled")
+	}
+}
+
+func TestDisableTwoFactorCountsWrongPasswords(t *testing.T) {
+	db := newTestDB(t)
+	us := newTestUserService(t, db)
+	ts := newTestTwoFactorService(us, db)
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+	setTestPassword(t, us, user.ID)
+	if _, err := ts.Setup(user.ID, testPassword, ClientInfo{}); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	codes, err := ts.Confirm(user.ID, currentTOTPCode(t, ts, user.ID))
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+
+	for i := 0; i < loginMaxFailures; i++ {
+		if err := ts.Disable(user.ID, "guess", codes[0], ClientInfo{}); !errors.Is(err, ErrIncorrectPassword) {
+			t.Fatalf("Disable with a wrong password = %v, want %v", err, ErrIncorrectPassword)
+		}
+	}
+	if err := ts.Disable(user.ID, testPassword, codes[0], ClientInfo{}); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("Disable on a locked account = %v, want %v", err, ErrAccountLocked)
+	}
+	stored, err := us.Repo.GetUserById(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserById: %v", err)
+	}
+	if !stored.TwoFactorEnabled {
+		t.Error("two-factor authentication was disabled on a locked account")
+	}
+}
+
+func TestSetupTwoFactorRequiresPassword(t *testing.T) {
+	db := newTestDB(t)
+	us := newTestUserService(t, db)
+	ts := newTestTwoFactorService(us, db)
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+	setTestPassword(t, us, user.ID)
+
+	// A stolen access token alone cannot enroll another authenticator
+	if _, err := ts.Setup(user.ID, "guess", ClientInfo{}); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("Setup with a wrong password = %v, want %v", err, ErrIncorrectPassword)
+	}
+	stored, err := us.Repo.GetUserById(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserById: %v", err)
+	}
+	if stored.TwoFactorSecret != "" {
+		t.Error("a secret was stored without the password")
+	}
+	if _, err := ts.Setup(user.ID, testPassword, ClientInfo{}); err != nil {
+		t.Errorf("Setup: %v", err)
+	}
+}
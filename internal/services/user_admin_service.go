@@ -0,0 +1,236 @@
+// services/user_admin_service.go
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	userSearchDefaultLimit = 20
+	userSearchMaxLimit     = 100
+)
+
+var (
+	ErrCannotSuspendSelf = errors.New("you cannot suspend your own account")
+	ErrAlreadySuspended  = errors.New("account is already suspended")
+	ErrNotSuspended      = errors.New("account is not suspended")
+	ErrInvalidUserSearch = errors.New("invalid user search")
+)
+
+// UserSearch is an admin search over users. Dates are formatted as
+// YYYY-MM-DD; CreatedTo includes the whole day.
+type UserSearch struct {
+	Query       string
+	Role        string
+	Status      string
+	CreatedFrom string
+	CreatedTo   string
+	Page        int
+	Limit       int
+}
+
+// UserSearchResult is one page of a user search
+type UserSearchResult struct {
+	Users []models.User `json:"data"`
+	Page  int           `json:"page"`
+	Limit int           `json:"limit"`
+	Total int64         `json:"total"`
+}
+
+// UserOverview is everything support staff need to help a user in one place
+type UserOverview struct {
+	User             *models.User             `json:"user"`
+	Reservations     []models.Reservation     `json:"reservations"`
+	VisaApplications []models.VisaApplication `json:"visa_applications"`
+	SupportTickets   []models.SupportTicket   `json:"support_tickets"`
+	Travellers       []models.Traveller       `json:"travellers"`
+}
+
+type UserAdminService struct {
+	Repo            *repos.UserRepo
+	Users           *UserService
+	ReservationRepo *repos.ReservationRepo
+	VisaRepo        *repos.VisaRepo
+	SupportRepo     *repos.SupportRepo
+	TravellerRepo   *repos.TravellerRepo
+}
+
+func NewUserAdminService(userRepo *repos.UserRepo, userService *UserService, reservationRepo *repos.ReservationRepo, visaRepo *repos.VisaRepo, supportRepo *repos.SupportRepo, travellerRepo *repos.TravellerRepo) *UserAdminService {
+	return &UserAdminService{
+		Repo:            userRepo,
+		Users:           userService,
+		ReservationRepo: reservationRepo,
+		VisaRepo:        visaRepo,
+		SupportRepo:     supportRepo,
+		TravellerRepo:   travellerRepo,
+	}
+}
+
+// SearchUsers retrieves a page of users matching the search from the database
+func (as *UserAdminService) SearchUsers(search UserSearch) (*UserSearchResult, error) {
+	if search.Page < 1 {
+		search.Page = 1
+	}
+	if search.Limit < 1 {
+		search.Limit = userSearchDefaultLimit
+	}
+	if search.Limit > userSearchMaxLimit {
+		search.Limit = userSearchMaxLimit
+	}
+
+	filter := repos.UserFilter{
+		Query:  strings.TrimSpace(search.Query),
+		Role:   search.Role,
+		Status: search.Status,
+		Offset: (search.Page - 1) * search.Limit,
+		Limit:  search.Limit,
+	}
+	if filter.Role != "" && !validRoles[filter.Role] {
+		return nil, ErrInvalidRole
+	}
+	switch filter.Status {
+	case "", "active", "suspended", "erased":
+	default:
+		return nil, fmt.Errorf("%w: status must be 'active', 'suspended' or 'erased'", ErrInvalidUserSearch)
+	}
+	if search.CreatedFrom != "" {
+		from, err := time.Parse(dateLayout, search.CreatedFrom)
+		if err != nil {
+			return nil, fmt.Errorf("%w: created_from must be formatted as YYYY-MM-DD", ErrInvalidUserSearch)
+		}
+		filter.CreatedAfter = from
+	}
+	if search.CreatedTo != "" {
+		to, err := time.Parse(dateLayout, search.CreatedTo)
+		if err != nil {
+			return nil, fmt.Errorf("%w: created_to must be formatted as YYYY-MM-DD", ErrInvalidUserSearch)
+		}
+		filter.CreatedBefore = to.AddDate(0, 0, 1)
+	}
+
+	users, total, err := as.Repo.SearchUsers(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+	for i := range users {
+		users[i].Password = ""
+	}
+	return &UserSearchResult{
+		Users: users,
+		Page:  search.Page,
+		Limit: search.Limit,
+		Total: total,
+	}, nil
+}
+
+// SuspendUser blocks a user from signing in and ends their sessions. Their
+// API keys stop working until the user is reactivated. Staff accounts can
+// only be suspended if canManageStaff is set.
+func (as *UserAdminService) SuspendUser(actorId, userId uint, reason string, canManageStaff bool) (*models.User, error) {
+	if actorId == userId {
+		return nil, ErrCannotSuspendSelf
+	}
+	user, err := as.Repo.GetUserById(userId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStaffAccount(user, canManageStaff); err != nil {
+		return nil, err
+	}
+	if user.SuspendedAt != nil {
+		return nil, ErrAlreadySuspended
+	}
+
+	// The repo keeps at least one admin able to sign in
+	now := time.Now()
+	reason = strings.TrimSpace(reason)
+	if err := as.Repo.SuspendUser(user.ID, reason, now); err != nil {
+		if errors.Is(err, repos.ErrLastAdmin) {
+			return nil, ErrLastAdmin
+		}
+		return nil, fmt.Errorf("failed to suspend user: %w", err)
+	}
+	if err := as.Users.Auth.RevokeAllForUser(user.ID); err != nil {
+		return nil, err
+	}
+
+	user.SuspendedAt = &now
+	user.SuspensionReason = reason
+	user.Password = ""
+	return user, nil
+}
+
+// ReactivateUser lifts a user's suspension. They have to sign in again.
+// Staff accounts can only be reactivated if canManageStaff is set.
+func (as *UserAdminService) ReactivateUser(userId uint, canManageStaff bool) (*models.User, error) {
+	user, err := as.Repo.GetUserById(userId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStaffAccount(user, canManageStaff); err != nil {
+		return nil, err
+	}
+	if user.SuspendedAt == nil {
+		return nil, ErrNotSuspended
+	}
+
+	if err := as.Repo.ReactivateUser(user.ID); err != nil {
+		return nil, fmt.Errorf("failed to reactivate user: %w", err)
+	}
+
+	user.SuspendedAt = nil
+	user.SuspensionReason = ""
+	user.Password = ""
+	return user, nil
+}
+
+// ForcePasswordReset signs a user out everywhere, revokes their API keys,
+// refuses their current password from now on and emails them a reset link.
+// Staff accounts are only reset if canManageStaff is set.
+func (as *UserAdminService) ForcePasswordReset(userId uint, canManageStaff bool) error {
+	user, err := as.Repo.GetUserById(userId)
+	if err != nil {
+		return err
+	}
+	if err := checkStaffAccount(user, canManageStaff); err != nil {
+		return err
+	}
+
+	if err := as.Repo.SetPasswordResetRequired(user.ID, true); err != nil {
+		return fmt.Errorf("failed to require password reset: %w", err)
+	}
+	if err := as.Users.Auth.RevokeCredentialsForUser(user.ID); err != nil {
+		return err
+	}
+	return as.Users.sendPasswordResetEmail(user, "Our support team has asked you to choose a new password. You cannot sign in with your current password until you do.")
+}
+
+// GetUserOverview collects a user's account, bookings, visa applications
+// and support tickets
+func (as *UserAdminService) GetUserOverview(userId uint) (*UserOverview, error) {
+	user, err := as.Repo.GetUserById(userId)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = ""
+
+	overview := &UserOverview{User: user}
+	if overview.Reservations, err = as.ReservationRepo.GetReservationsByUserId(userId); err != nil {
+		return nil, fmt.Errorf("failed to retrieve reservations: %w", err)
+	}
+	if overview.VisaApplications, err = as.VisaRepo.GetVisaByUserId(userId); err != nil {
+		return nil, fmt.Errorf("failed to retrieve visa applications: %w", err)
+	}
+	if overview.SupportTickets, err = as.SupportRepo.GetTicketsByUserId(userId); err != nil {
+		return nil, fmt.Errorf("failed to retrieve support tickets: %w", err)
+	}
+	if overview.Travellers, err = as.TravellerRepo.GetTravellersByUser(userId); err != nil {
+		return nil, fmt.Errorf("failed to retrieve travellers: %w", err)
+	}
+	return overview, nil
+}
@@ -7,74 +7,322 @@ import (
 	"Visa/pkg"
 	"errors"
 	"fmt"
-	"regexp"
+	"log"
 	"strings"
+	"time"
+)
+
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 24 * time.Hour
+	magicLinkTTL         = 15 * time.Minute
 
-	"golang.org/x/crypto/bcrypt"
+	// A verification email can be resent once per interval and at most
+	// verificationMaxPerHour times per hour
+	verificationResendInterval = time.Minute
+	verificationMaxPerHour     = 5
 )
 
+// Roles a user can have. What each role may do is stored in the database,
+// see RBACService.
+const (
+	RoleUser         = "user"
+	RoleAdmin        = "admin"
+	RoleSupportAgent = "support_agent"
+	RoleVisaOfficer  = "visa_officer"
+	RoleHotelPartner = "hotel_partner"
+	RoleFinance      = "finance"
+)
+
+var validRoles = map[string]bool{
+	RoleUser:         true,
+	RoleAdmin:        true,
+	RoleSupportAgent: true,
+	RoleVisaOfficer:  true,
+	RoleHotelPartner: true,
+	RoleFinance:      true,
+}
+
+var (
+	ErrInvalidCredentials     = errors.New("invalid email or password")
+	ErrEmailAlreadyRegistered = errors.New("email already registered")
+	ErrInvalidRole            = errors.New("invalid role. Must be one of 'user', 'admin', 'support_agent', 'visa_officer', 'hotel_partner' or 'finance'")
+	ErrLastAdmin              = repos.ErrLastAdmin
+	ErrEmailNotVerified       = errors.New("email address has not been verified")
+	ErrEmailAlreadyVerified   = errors.New("email address is already verified")
+	ErrTooManyRequests        = errors.New("too many requests, please try again later")
+	ErrWeakPassword           = pkg.ErrWeakPassword
+	ErrPasswordResetRequired  = errors.New("password has to be reset before signing in")
+	ErrIncorrectPassword      = errors.New("current password is incorrect")
+	ErrStaffAccount           = errors.New("changing a staff account requires the role:manage permission")
+)
+
+var frontendURL = getEnvOrDefault("FRONTEND_URL", "http://localhost:5173")
+
 type UserService struct {
-	Repo *repos.UserRepo
+	Repo     *repos.UserRepo
+	Auth     *AuthService
+	Mailer   pkg.Mailer
+	Attempts *LoginAttemptService
 }
 
-func NewUserService(userRepo *repos.UserRepo) *UserService {
-	return &UserService{Repo: userRepo}
+func NewUserService(userRepo *repos.UserRepo, authService *AuthService, mailer pkg.Mailer, loginAttempts *LoginAttemptService) *UserService {
+	return &UserService{
+		Repo:     userRepo,
+		Auth:     authService,
+		Mailer:   mailer,
+		Attempts: loginAttempts,
+	}
+}
+
+// LoginResult is the outcome of a password login. Users with two-factor
+// authentication get a ChallengeToken instead of Tokens.
+type LoginResult struct {
+	User           *models.User
+	Tokens         *TokenPair
+	ChallengeToken string
 }
 
-// Login authenticates a user and returns a JWT token
-func (us *UserService) Login(email, password string) (*models.User, string, error) {
+// Login authenticates a user and returns an access and refresh token pair,
+// or a challenge token if the user has two-factor authentication enabled.
+// Repeated failures lock the account and throttle the client's IP, in which
+// case a *LockoutError is returned.
+func (us *UserService) Login(email, password string, client ClientInfo) (*LoginResult, error) {
 	// Validate input
 	if email == "" {
-		return nil, "", errors.New("email is required")
+		return nil, errors.New("email is required")
 	}
 	if password == "" {
-		return nil, "", errors.New("password is required")
+		return nil, errors.New("password is required")
+	}
+
+	if err := us.Attempts.CheckIP(client); err != nil {
+		us.recordLoginFailure(nil, email, client, models.LoginFailureIPThrottled)
+		return nil, err
 	}
 
 	// Get user by email
 	user, err := us.Repo.GetUserByEmail(email)
 	if err != nil {
-		return nil, "", errors.New("invalid email or password")
+		us.recordLoginFailure(nil, email, client, models.LoginFailureUnknownEmail)
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := us.Attempts.CheckUser(user); err != nil {
+		us.recordLoginFailure(user, email, client, models.LoginFailureAccountLocked)
+		return nil, err
 	}
 
 	// Compare hashed password with plain text password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return nil, "", errors.New("invalid email or password")
+	ok, needsRehash, err := pkg.VerifyPassword(user.Password, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify password: %w", err)
+	}
+	if !ok {
+		us.recordLoginFailure(user, email, client, models.LoginFailureBadPassword)
+		return nil, ErrInvalidCredentials
+	}
+
+	// Staff asked for a new password; the old one must not sign in anymore.
+	// Suspended users learn about their suspension instead.
+	if user.PasswordResetRequired && user.SuspendedAt == nil {
+		us.recordLoginFailure(user, email, client, models.LoginFailureResetRequired)
+		return nil, ErrPasswordResetRequired
+	}
+
+	// Move bcrypt and outdated Argon2id hashes to the current parameters
+	// while the plain text password is at hand
+	if needsRehash {
+		us.rehashPassword(user.ID, password)
 	}
 
-	// Generate JWT token using pkg.GenerateToken (correct one!)
-	token, err := pkg.GenerateToken(user.ID, user.Role, user.Email)
+	return us.completeLogin(user, client)
+}
+
+// rehashPassword stores a new hash of a verified password. Failures are only
+// logged since the old hash keeps working.
+func (us *UserService) rehashPassword(userId uint, password string) {
+	hash, err := pkg.HashPassword(password)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to generate token: %w", err)
+		log.Printf("Error rehashing password of user %d: %v", userId, err)
+		return
+	}
+	if err := us.Repo.UpdatePassword(userId, hash); err != nil {
+		log.Printf("Error storing rehashed password of user %d: %v", userId, err)
+	}
+}
+
+// completeLogin signs in a user whose first factor has been verified. Users
+// with two-factor authentication get a challenge token instead of tokens.
+// Suspended users are turned away only now, so that the suspension is not
+// revealed to someone who does not know the password.
+func (us *UserService) completeLogin(user *models.User, client ClientInfo) (*LoginResult, error) {
+	if user.SuspendedAt != nil {
+		us.recordLoginFailure(user, user.Email, client, models.LoginFailureSuspended)
+		return nil, ErrAccountSuspended
 	}
 
 	// Don't send password hash to frontend
 	user.Password = ""
 
-	return user, token, nil
-}
+	if user.TwoFactorEnabled {
+		challenge, err := us.Auth.IssueChallengeToken(user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate challenge token: %w", err)
+		}
+		return &LoginResult{User: user, ChallengeToken: challenge}, nil
+	}
 
-// GetAllUsers retrieves all users
-func (us *UserService) GetAllUsers() ([]models.User, error) {
-	users, err := us.Repo.GetAllUsers()
+	tokens, err := us.Auth.IssueTokens(user, false, client)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve users: %w", err)
+		return nil, fmt.Errorf("failed to generate token: %w", err)
 	}
 
-	// Remove passwords from response
-	for i := range users {
-		users[i].Password = ""
+	if err := us.Attempts.RecordSuccess(user, client); err != nil {
+		log.Printf("Error recording sign-in of user %d: %v", user.ID, err)
 	}
 
-	return users, nil
+	return &LoginResult{User: user, Tokens: tokens}, nil
 }
 
-// CreateUser creates a new user with password hashing and validation
+// recordLoginFailure stores a failed sign-in. A storage error must not turn
+// into a different response, so it is only logged.
+func (us *UserService) recordLoginFailure(user *models.User, email string, client ClientInfo, reason string) {
+	if err := us.Attempts.RecordFailure(user, email, client, reason); err != nil {
+		log.Printf("Error recording failed sign-in for %s: %v", email, err)
+	}
+}
+
+// GetRecentSignIns retrieves the latest sign-in attempts on a user's account
+func (us *UserService) GetRecentSignIns(userId uint) ([]models.LoginEvent, error) {
+	return us.Attempts.RecentSignIns(userId)
+}
+
+// UnlockUser lifts a lockout caused by failed sign-ins (admin only)
+func (us *UserService) UnlockUser(userId uint) error {
+	return us.Attempts.Unlock(userId)
+}
+
+// CreateUser registers a self-service account. Signups always get the
+// "user" role and have to verify their email.
 func (us *UserService) CreateUser(user *models.User) error {
 	if user == nil {
 		return errors.New("user data is required")
 	}
 
+	user.Role = RoleUser
+	user.EmailVerifiedAt = nil
+	if err := us.createAccount(user); err != nil {
+		return err
+	}
+
+	// The account exists even if the email fails; the user can ask for a resend
+	if err := us.sendVerificationEmail(user); err != nil {
+		log.Printf("Error sending verification email to user %d: %v", user.ID, err)
+	}
+	return nil
+}
+
+// CreateVerifiedUser creates an account with the role set on user and an
+// already verified email. Used for identity provider sign-ins and the
+// bootstrap command, never for public signups.
+func (us *UserService) CreateVerifiedUser(user *models.User) error {
+	if user == nil {
+		return errors.New("user data is required")
+	}
+	if !validRoles[user.Role] {
+		return ErrInvalidRole
+	}
+
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	return us.createAccount(user)
+}
+
+// CreateExternalUser creates a user signing in through an identity provider
+// for the first time. The email was verified by the provider, and the
+// account gets a random password that can be replaced with a password reset.
+func (us *UserService) CreateExternalUser(name, email string) (*models.User, error) {
+	name = strings.TrimSpace(name)
+	if len(name) < 2 {
+		name = strings.SplitN(email, "@", 2)[0]
+	}
+	if len(name) < 2 {
+		name = email
+	}
+	name = truncate(name, 100)
+	password, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		Name:     name,
+		Email:    email,
+		Password: password,
+		Role:     RoleUser,
+	}
+	if err := us.CreateVerifiedUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// claimUnverifiedAccount hands an account whose email was never verified to
+// someone who just proved they own the address through an identity provider
+// or a sign-in link. Whoever registered the account may not own the address and must
+// not keep access: the password is replaced with a random one, two-factor
+// authentication is turned off and every session and API key is revoked.
+// The owner can set a password with a password reset.
+func (us *UserService) claimUnverifiedAccount(user *models.User) error {
+	password, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	hash, err := pkg.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	now := time.Now()
+	claimed, err := us.Repo.ClaimUnverifiedAccount(user.ID, hash, now)
+	if err != nil {
+		return fmt.Errorf("failed to verify email: %w", err)
+	}
+	if !claimed {
+		// The email was verified through its verification link meanwhile
+		return nil
+	}
+	if err := us.Auth.RevokeCredentialsForUser(user.ID); err != nil {
+		return err
+	}
+
+	user.EmailVerifiedAt = &now
+	user.TwoFactorEnabled = false
+	user.TwoFactorSecret = ""
+	user.PasswordResetRequired = false
+	user.FailedLoginCount = 0
+	user.LockedUntil = nil
+	return nil
+}
+
+// createAccount validates, hashes the password and stores a new user
+func (us *UserService) createAccount(user *models.User) error {
+	if err := us.prepareAccount(user); err != nil {
+		return err
+	}
+
+	if err := us.Repo.CreateUser(user); err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	// Clear password before returning
+	user.Password = ""
+	return nil
+}
+
+// prepareAccount validates a new user and replaces their password with its hash
+func (us *UserService) prepareAccount(user *models.User) error {
 	// Validate user data
 	if err := us.validateUser(user); err != nil {
 		return err
@@ -86,27 +334,85 @@ func (us *UserService) CreateUser(user *models.User) error {
 		return fmt.Errorf("failed to check email existence: %w", err)
 	}
 	if exists {
-		return errors.New("email already registered")
+		return ErrEmailAlreadyRegistered
 	}
 
 	// Hash password before storing
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPassword, err := pkg.HashPassword(user.Password)
 	if err != nil {
 		return fmt.Errorf("failed to hash password: %w", err)
 	}
-	user.Password = string(hashedPassword)
+	user.Password = hashedPassword
+	return nil
+}
 
-	// Set default role if not provided
-	if user.Role == "" {
-		user.Role = "user"
+// VerifyEmail marks a user's email as verified using a verification token
+func (us *UserService) VerifyEmail(token string) error {
+	verification, err := us.Auth.ConsumeUserToken(models.TokenPurposeEmailVerification, token)
+	if err != nil {
+		return err
 	}
 
-	if err := us.Repo.CreateUser(user); err != nil {
-		return fmt.Errorf("failed to create user: %w", err)
+	if err := us.Repo.MarkEmailVerified(verification.UserID, time.Now()); err != nil {
+		return fmt.Errorf("failed to verify email: %w", err)
 	}
+	return nil
+}
 
-	// Clear password before returning
-	user.Password = ""
+// ResendVerificationEmail sends a new verification link, limited to one per
+// minute and five per hour
+func (us *UserService) ResendVerificationEmail(userId uint) error {
+	if userId == 0 {
+		return errors.New("invalid user ID")
+	}
+
+	user, err := us.Repo.GetUserById(userId)
+	if err != nil {
+		return fmt.Errorf("user not found: %w", err)
+	}
+	if user.EmailVerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+
+	limited, err := us.userTokenLimitReached(userId, models.TokenPurposeEmailVerification)
+	if err != nil {
+		return fmt.Errorf("failed to check verification emails: %w", err)
+	}
+	if limited {
+		return ErrTooManyRequests
+	}
+
+	return us.sendVerificationEmail(user)
+}
+
+// userTokenLimitReached reports whether a user was emailed a token for the
+// purpose within the last verificationResendInterval, or
+// verificationMaxPerHour times within the last hour
+func (us *UserService) userTokenLimitReached(userId uint, purpose string) (bool, error) {
+	now := time.Now()
+	recent, err := us.Auth.UserTokenRepo.CountTokensSince(userId, purpose, now.Add(-verificationResendInterval))
+	if err != nil {
+		return false, err
+	}
+	lastHour, err := us.Auth.UserTokenRepo.CountTokensSince(userId, purpose, now.Add(-time.Hour))
+	if err != nil {
+		return false, err
+	}
+	return recent > 0 || lastHour >= verificationMaxPerHour, nil
+}
+
+// sendVerificationEmail emails a new verification link to the user
+func (us *UserService) sendVerificationEmail(user *models.User) error {
+	token, err := us.Auth.IssueUserToken(user.ID, models.TokenPurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hello %s,\n\nPlease confirm your email address by opening the link below. It expires in %d hours.\n\n%s/verify-email?token=%s",
+		user.Name, int(emailVerificationTTL.Hours()), frontendURL, token)
+	if err := us.Mailer.Send(user.Email, "Verify your email address", body); err != nil {
+		return fmt.Errorf("failed to send verification email: %w", err)
+	}
 	return nil
 }
 
@@ -144,8 +450,12 @@ func (us *UserService) GetUserByEmail(email string) (*models.User, error) {
 	return user, nil
 }
 
-// UpdateUser updates an existing user
-func (us *UserService) UpdateUser(user *models.User) error {
+// UpdateUser updates the name and email of an existing user. Passwords are
+// changed with ChangePassword. A new email address has to be verified again,
+// and the user is signed out of every session but currentSessionId, which
+// is 0 when staff change someone else's account, and loses their API keys.
+// Staff accounts can only be changed if canManageStaff is set.
+func (us *UserService) UpdateUser(user *models.User, currentSessionId uint, canManageStaff bool) error {
 	if user == nil {
 		return errors.New("user data is required")
 	}
@@ -158,6 +468,12 @@ func (us *UserService) UpdateUser(user *models.User) error {
 	if err != nil {
 		return fmt.Errorf("user not found: %w", err)
 	}
+	if err := checkStaffAccount(existingUser, canManageStaff); err != nil {
+		return err
+	}
+
+	emailChanged := false
+	columns := map[string]interface{}{}
 
 	// Validate updated fields if provided
 	if user.Name != "" {
@@ -168,6 +484,7 @@ func (us *UserService) UpdateUser(user *models.User) error {
 			return errors.New("name must not exceed 100 characters")
 		}
 		existingUser.Name = user.Name
+		columns["name"] = user.Name
 	}
 
 	if user.Email != "" && user.Email != existingUser.Email {
@@ -181,91 +498,232 @@ func (us *UserService) UpdateUser(user *models.User) error {
 			return fmt.Errorf("failed to check email existence: %w", err)
 		}
 		if exists {
-			return errors.New("email already registered")
+			return ErrEmailAlreadyRegistered
 		}
 		existingUser.Email = user.Email
+		existingUser.EmailVerifiedAt = nil
+		columns["email"] = user.Email
+		columns["email_verified_at"] = nil
+		emailChanged = true
 	}
 
-	// Hash new password if provided
-	if user.Password != "" {
-		if len(user.Password) < 8 {
-			return errors.New("password must be at least 8 characters")
-		}
-		if len(user.Password) > 72 {
-			return errors.New("password must not exceed 72 characters")
-		}
-
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
-		if err != nil {
-			return fmt.Errorf("failed to hash password: %w", err)
+	if len(columns) > 0 {
+		if err := us.Repo.UpdateColumns(existingUser.ID, columns); err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
 		}
-		existingUser.Password = string(hashedPassword)
 	}
 
-	if err := us.Repo.UpdateUser(existingUser); err != nil {
-		return fmt.Errorf("failed to update user: %w", err)
+	if emailChanged {
+		if err := us.Auth.RevokeCredentialsExceptSession(existingUser.ID, currentSessionId); err != nil {
+			return err
+		}
+		if err := us.sendVerificationEmail(existingUser); err != nil {
+			log.Printf("Error sending verification email to user %d: %v", existingUser.ID, err)
+		}
 	}
 	return nil
 }
 
-// DeleteUser deletes a user
-func (us *UserService) DeleteUser(id uint) error {
-	if id == 0 {
+// ChangePassword changes a user's password after checking their current one.
+// Wrong current passwords count towards the same lockout as failed sign-ins.
+// The user is signed out of every session but currentSessionId and loses
+// their API keys.
+func (us *UserService) ChangePassword(userId, currentSessionId uint, oldPassword, newPassword string, client ClientInfo) error {
+	if userId == 0 {
 		return errors.New("invalid user ID")
 	}
+	if oldPassword == "" {
+		return errors.New("old password is required")
+	}
+	if newPassword == "" {
+		return errors.New("new password is required")
+	}
 
-	// Verify user exists
-	_, err := us.Repo.GetUserById(id)
+	// Get user
+	user, err := us.Repo.GetUserById(userId)
 	if err != nil {
 		return fmt.Errorf("user not found: %w", err)
 	}
+	if err := validatePassword(newPassword, user); err != nil {
+		return err
+	}
+
+	if err := us.Attempts.CheckUser(user); err != nil {
+		us.recordLoginFailure(user, user.Email, client, models.LoginFailureAccountLocked)
+		return err
+	}
+
+	// Verify old password
+	ok, _, err := pkg.VerifyPassword(user.Password, oldPassword)
+	if err != nil {
+		return fmt.Errorf("failed to verify password: %w", err)
+	}
+	if !ok {
+		us.recordLoginFailure(user, user.Email, client, models.LoginFailureBadPassword)
+		return ErrIncorrectPassword
+	}
+
+	// Hash new password
+	hashedPassword, err := pkg.HashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	if err := us.Repo.UpdateColumns(user.ID, map[string]interface{}{
+		"password":                hashedPassword,
+		"password_reset_required": false,
+	}); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	return us.Auth.RevokeCredentialsExceptSession(user.ID, currentSessionId)
+}
+
+// ForgotPassword emails a password reset link to the user. Unknown emails are
+// ignored so that callers cannot find out which emails are registered, and
+// requests over the verification email limits are dropped silently.
+func (us *UserService) ForgotPassword(email string) error {
+	if email == "" {
+		return errors.New("email is required")
+	}
 
-	if err := us.Repo.DeleteUser(id); err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+	user, err := us.Repo.GetUserByEmail(email)
+	if err != nil {
+		return nil
+	}
+
+	limited, err := us.userTokenLimitReached(user.ID, models.TokenPurposePasswordReset)
+	if err != nil {
+		return fmt.Errorf("failed to check password reset emails: %w", err)
+	}
+	if limited {
+		log.Printf("Password reset for user %d not sent: too many requests", user.ID)
+		return nil
+	}
+
+	return us.sendPasswordResetEmail(user, "If you did not request a password reset, you can ignore this email.")
+}
+
+// sendPasswordResetEmail emails a password reset link to the user, ending
+// with the given note
+func (us *UserService) sendPasswordResetEmail(user *models.User, note string) error {
+	token, err := us.Auth.IssueUserToken(user.ID, models.TokenPurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hello %s,\n\nUse the link below to reset your password. It expires in %d minutes.\n\n%s/reset-password?token=%s\n\n%s",
+		user.Name, int(passwordResetTTL.Minutes()), frontendURL, token, note)
+	if err := us.Mailer.Send(user.Email, "Reset your password", body); err != nil {
+		return fmt.Errorf("failed to send password reset email: %w", err)
 	}
 	return nil
 }
 
-// ChangePassword changes a user's password
-func (us *UserService) ChangePassword(userId uint, oldPassword, newPassword string) error {
-	if userId == 0 {
-		return errors.New("invalid user ID")
+// RequestMagicLink emails a single-use sign-in link. Like ForgotPassword it
+// does not reveal whether the email is registered, and requests over the
+// verification email limits are dropped silently.
+func (us *UserService) RequestMagicLink(email string) error {
+	if email == "" {
+		return errors.New("email is required")
 	}
-	if oldPassword == "" {
-		return errors.New("old password is required")
+
+	user, err := us.Repo.GetUserByEmail(email)
+	if err != nil {
+		return nil
+	}
+
+	limited, err := us.userTokenLimitReached(user.ID, models.TokenPurposeMagicLink)
+	if err != nil {
+		return fmt.Errorf("failed to check sign-in links: %w", err)
+	}
+	if limited {
+		log.Printf("Magic link for user %d not sent: too many requests", user.ID)
+		return nil
 	}
+
+	token, err := us.Auth.IssueUserToken(user.ID, models.TokenPurposeMagicLink, magicLinkTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hello %s,\n\nUse the link below to sign in. It expires in %d minutes and can only be used once.\n\n%s/magic-link?token=%s\n\nIf you did not try to sign in, you can ignore this email.",
+		user.Name, int(magicLinkTTL.Minutes()), frontendURL, token)
+	if err := us.Mailer.Send(user.Email, "Your sign-in link", body); err != nil {
+		return fmt.Errorf("failed to send sign-in link: %w", err)
+	}
+	return nil
+}
+
+// LoginWithMagicLink exchanges a sign-in link for the same result as Login.
+// Two-factor authentication still applies, and opening the link verifies
+// the email address, claiming the account if it was never verified.
+func (us *UserService) LoginWithMagicLink(token string, client ClientInfo) (*LoginResult, error) {
+	if err := us.Attempts.CheckIP(client); err != nil {
+		return nil, err
+	}
+
+	magicLink, err := us.Auth.ConsumeUserToken(models.TokenPurposeMagicLink, token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := us.Repo.GetUserById(magicLink.UserID)
+	if err != nil {
+		return nil, ErrInvalidUserToken
+	}
+	if err := us.Attempts.CheckUser(user); err != nil {
+		us.recordLoginFailure(user, user.Email, client, models.LoginFailureAccountLocked)
+		return nil, err
+	}
+
+	if user.EmailVerifiedAt == nil {
+		// Following the link proves the address; whoever registered it
+		// without doing so loses access to the account
+		if err := us.claimUnverifiedAccount(user); err != nil {
+			return nil, err
+		}
+	}
+
+	return us.completeLogin(user, client)
+}
+
+// ResetPassword sets a new password using a reset token, signs the user out
+// of every existing session and revokes their API keys
+func (us *UserService) ResetPassword(token, newPassword string) error {
 	if newPassword == "" {
 		return errors.New("new password is required")
 	}
-	if len(newPassword) < 8 {
-		return errors.New("new password must be at least 8 characters")
-	}
-	if len(newPassword) > 72 {
-		return errors.New("new password must not exceed 72 characters")
+	// Check what can be checked before the single-use token is consumed
+	if err := validatePassword(newPassword, nil); err != nil {
+		return err
 	}
 
-	// Get user
-	user, err := us.Repo.GetUserById(userId)
+	resetToken, err := us.Auth.ConsumeUserToken(models.TokenPurposePasswordReset, token)
 	if err != nil {
-		return fmt.Errorf("user not found: %w", err)
+		return err
 	}
 
-	// Verify old password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(oldPassword)); err != nil {
-		return errors.New("old password is incorrect")
+	user, err := us.Repo.GetUserById(resetToken.UserID)
+	if err != nil {
+		return fmt.Errorf("user not found: %w", err)
 	}
 
-	// Hash new password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	hashedPassword, err := pkg.HashPassword(newPassword)
 	if err != nil {
 		return fmt.Errorf("failed to hash password: %w", err)
 	}
 
-	user.Password = string(hashedPassword)
-	if err := us.Repo.UpdateUser(user); err != nil {
+	// Proving ownership of the email also lifts a lockout
+	if err := us.Repo.UpdateColumns(user.ID, map[string]interface{}{
+		"password":                hashedPassword,
+		"password_reset_required": false,
+		"failed_login_count":      0,
+		"locked_until":            nil,
+	}); err != nil {
 		return fmt.Errorf("failed to update password: %w", err)
 	}
-	return nil
+
+	return us.Auth.RevokeCredentialsForUser(user.ID)
 }
 
 // GetUsersByRole retrieves all users with a specific role
@@ -275,12 +733,8 @@ func (us *UserService) GetUsersByRole(role string) ([]models.User, error) {
 	}
 
 	// Validate role
-	validRoles := map[string]bool{
-		"user":  true,
-		"admin": true,
-	}
 	if !validRoles[role] {
-		return nil, errors.New("invalid role. Must be 'user' or 'admin'")
+		return nil, ErrInvalidRole
 	}
 
 	users, err := us.Repo.GetUsersByRole(role)
@@ -296,6 +750,56 @@ func (us *UserService) GetUsersByRole(role string) ([]models.User, error) {
 	return users, nil
 }
 
+// ChangeRole promotes or demotes a user (admin only). The user is signed out
+// so that new tokens carry the new role.
+func (us *UserService) ChangeRole(actorId, userId uint, role string) (*models.User, error) {
+	if userId == 0 {
+		return nil, errors.New("invalid user ID")
+	}
+	if !validRoles[role] {
+		return nil, ErrInvalidRole
+	}
+
+	user, err := us.Repo.GetUserById(userId)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+	if user.Role == role {
+		user.Password = ""
+		return user, nil
+	}
+
+	if user.Role == RoleAdmin && actorId == userId {
+		return nil, errors.New("admins cannot demote themselves")
+	}
+
+	// The repo keeps at least one admin around
+	if err := us.Repo.UpdateRole(user.ID, role); err != nil {
+		if errors.Is(err, repos.ErrLastAdmin) {
+			return nil, ErrLastAdmin
+		}
+		return nil, fmt.Errorf("failed to update role: %w", err)
+	}
+	user.Role = role
+	if err := us.Auth.RevokeAllForUser(user.ID); err != nil {
+		return nil, err
+	}
+
+	user.Password = ""
+	return user, nil
+}
+
+// checkStaffAccount refuses changes to an account with a staff role unless
+// canManageStaff is set. Roles are managed in the database, so a staff role
+// may carry permissions that whoever holds user:manage does not have, and
+// taking over the account would hand them out.
+func checkStaffAccount(user *models.User, canManageStaff bool) error {
+	if user.Role != RoleUser && !canManageStaff {
+		return ErrStaffAccount
+	}
+	return nil
+}
+
 // validateUser validates user data
 func (us *UserService) validateUser(user *models.User) error {
 	// Validate name
@@ -321,29 +825,41 @@ func (us *UserService) validateUser(user *models.User) error {
 	if strings.TrimSpace(user.Password) == "" {
 		return errors.New("password is required")
 	}
-	if len(user.Password) < 8 {
-		return errors.New("password must be at least 8 characters")
-	}
-	if len(user.Password) > 72 {
-		return errors.New("password must not exceed 72 characters")
+	if err := validatePassword(user.Password, user); err != nil {
+		return err
 	}
 
 	// Validate role
-	if user.Role != "" {
-		validRoles := map[string]bool{
-			"user":  true,
-			"admin": true,
-		}
-		if !validRoles[user.Role] {
-			return errors.New("invalid role. Must be 'user' or 'admin'")
-		}
+	if user.Role != "" && !validRoles[user.Role] {
+		return ErrInvalidRole
 	}
 
 	return nil
 }
 
-// isValidEmail validates email format using regex
+// validatePassword applies the password policy. Passwords containing the
+// user's name or email are rejected when the user is known.
+func validatePassword(password string, user *models.User) error {
+	if user == nil {
+		return pkg.CheckPasswordPolicy(password)
+	}
+	return pkg.CheckPasswordPolicy(password, user.Name, user.Email)
+}
+
+// isValidEmail validates email format
 func isValidEmail(email string) bool {
-	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
-	return emailRegex.MatchString(email)
+	return pkg.ValidateEmail(email)
+}
+
+// requireVerifiedEmail returns ErrEmailNotVerified if the user has not
+// verified their email address yet
+func requireVerifiedEmail(userRepo *repos.UserRepo, userId uint) error {
+	user, err := userRepo.GetUserById(userId)
+	if err != nil {
+		return fmt.Errorf("user not found: %w", err)
+	}
+	if user.EmailVerifiedAt == nil {
+		return ErrEmailNotVerified
+	}
+	return nil
 }
@@ -0,0 +1,340 @@
+package services
+
+import (
+	"Visa/internal/repos"
+	"Visa/models"
+	"Visa/pkg"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func newTestUserService(t *testing.T, db *gorm.DB) *UserService {
+	t.Helper()
+
+	as := newTestAuthService(t, db)
+	return NewUserService(as.UserRepo, as, pkg.LogMailer{}, NewLoginAttemptService(repos.NewLoginEventRepo(db), as.UserRepo))
+}
+
+func TestMagicLinkClaimsUnverifiedAccount(t *testing.T) {
+	db := newTestDB(t)
+	us := newTestUserService(t, db)
+
+	// Someone registers the victim's address and signs in
+	const email, password = "victim@example.com", "chosen-before-the-owner"
+	attacker := &models.User{Name: "Mallory", Email: email, Password: password}
+	if err := us.CreateUser(attacker); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	signedIn, err := us.Login(email, password, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	// The owner follows a sign-in link sent to the address
+	token, err := us.Auth.IssueUserToken(attacker.ID, models.TokenPurposeMagicLink, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueUserToken: %v", err)
+	}
+	result, err := us.LoginWithMagicLink(token, ClientInfo{})
+	if err != nil {
+		t.Fatalf("LoginWithMagicLink: %v", err)
+	}
+	if result.User.EmailVerifiedAt == nil {
+		t.Error("signing in with the link did not verify the email")
+	}
+	if _, err := us.Auth.ValidateAccessToken(result.Tokens.AccessToken); err != nil {
+		t.Errorf("ValidateAccessToken of the owner's token: %v", err)
+	}
+
+	if _, err := us.Login(email, password, ClientInfo{}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login with the registrant's password = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if _, err := us.Auth.Refresh(signedIn.Tokens.RefreshToken, ClientInfo{}); err == nil {
+		t.Error("the registrant's refresh token still works")
+	}
+}
+
+func TestRemovingAdminsKeepsOne(t *testing.T) {
+	db := newTestDB(t)
+	us := newTestUserService(t, db)
+	as := NewUserAdminService(us.Repo, us, repos.NewReservationRepo(db), repos.NewVisaRepo(db), repos.NewSupportRepo(db), repos.NewTravellerRepo(db))
+	ps := newTestPrivacyService(us, db)
+	first := createTestUser(t, db, "first@example.com", RoleAdmin)
+	second := createTestUser(t, db, "second@example.com", RoleAdmin)
+
+	// The second admin demotes the first as soon as the first has looked up
+	// the admins, and is given the time to commit before the first goes on
+	var once sync.Once
+	demoted := make(chan error, 1)
+	err := db.Callback().Query().After("gorm:query").Register("test:demote_during_demote", func(tx *gorm.DB) {
+		if !strings.Contains(tx.Statement.SQL.String(), "role = ") {
+			return
+		}
+		once.Do(func() {
+			go func() {
+				_, err := us.ChangeRole(second.ID, first.ID, RoleUser)
+				demoted <- err
+			}()
+			select {
+			case err := <-demoted:
+				demoted <- err
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	})
+	if err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+
+	_, firstErr := us.ChangeRole(first.ID, second.ID, RoleUser)
+	secondErr := <-demoted
+	if firstErr == nil && secondErr == nil {
+		t.Fatal("two admins demoted each other")
+	}
+	for _, err := range []error{firstErr, secondErr} {
+		if err != nil && !errors.Is(err, ErrLastAdmin) {
+			t.Errorf("ChangeRole = %v, want nil or %v", err, ErrLastAdmin)
+		}
+	}
+	admins, err := us.Repo.GetAdminUsers()
+	if err != nil {
+		t.Fatalf("GetAdminUsers: %v", err)
+	}
+	if len(admins) != 1 {
+		t.Fatalf("%d admins left, want 1", len(admins))
+	}
+
+	// The last admin can be neither suspended nor erased
+	last, actor := admins[0], createTestUser(t, db, "staff@example.com", RoleSupportAgent)
+	if _, err := as.SuspendUser(actor.ID, last.ID, "", true); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("SuspendUser of the last admin = %v, want %v", err, ErrLastAdmin)
+	}
+	if err := ps.Erase(last.ID, true); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("Erase of the last admin = %v, want %v", err, ErrLastAdmin)
+	}
+	user, err := us.Repo.GetUserById(last.ID)
+	if err != nil {
+		t.Fatalf("GetUserById: %v", err)
+	}
+	if user.Role != RoleAdmin || user.SuspendedAt != nil || user.ErasedAt != nil {
+		t.Errorf("last admin has role %s, suspended at %v and erased at %v", user.Role, user.SuspendedAt, user.ErasedAt)
+	}
+}
+
+func TestChangingCredentialsSignsOutOtherSessions(t *testing.T) {
+	db := newTestDB(t)
+	us := newTestUserService(t, db)
+	ks := NewAPIKeyService(us.Auth.APIKeyRepo, us.Repo)
+
+	const email, password = "traveller@example.com", "correct-horse-battery-staple"
+	user := &models.User{Name: "Traveller", Email: email, Password: password}
+	if err := us.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := us.Repo.MarkEmailVerified(user.ID, time.Now()); err != nil {
+		t.Fatalf("MarkEmailVerified: %v", err)
+	}
+
+	// signIn opens a session, another one elsewhere and an API key
+	signIn := func(password string) (current *Claims, currentToken, otherToken, key string) {
+		t.Helper()
+		var tokens [2]*TokenPair
+		for i := range tokens {
+			result, err := us.Login(email, password, ClientInfo{})
+			if err != nil {
+				t.Fatalf("Login: %v", err)
+			}
+			tokens[i] = result.Tokens
+		}
+		current, err := us.Auth.ValidateAccessToken(tokens[0].AccessToken)
+		if err != nil {
+			t.Fatalf("ValidateAccessToken: %v", err)
+		}
+		if _, key, err = ks.CreateAPIKey(user.ID, "partner", []string{models.ScopeProfileRead}, 0, nil); err != nil {
+			t.Fatalf("CreateAPIKey: %v", err)
+		}
+		return current, tokens[0].AccessToken, tokens[1].RefreshToken, key
+	}
+	signedOut := func(change, currentToken, otherToken, key string) {
+		t.Helper()
+		if _, err := us.Auth.ValidateAccessToken(currentToken); err != nil {
+			t.Errorf("the session the %s was made from was signed out: %v", change, err)
+		}
+		if _, err := us.Auth.Refresh(otherToken, ClientInfo{}); err == nil {
+			t.Errorf("another session survived the %s", change)
+		}
+		if _, _, err := ks.Authenticate(key, ""); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("Authenticate with an API key after the %s = %v, want %v", change, err, ErrInvalidAPIKey)
+		}
+	}
+
+	current, currentToken, otherToken, key := signIn(password)
+	const newPassword = "another-horse-battery-staple"
+	if err := us.ChangePassword(user.ID, current.SessionID, "not-the-password", newPassword, ClientInfo{}); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("ChangePassword with a wrong password = %v, want %v", err, ErrIncorrectPassword)
+	}
+	if err := us.ChangePassword(user.ID, current.SessionID, password, newPassword, ClientInfo{}); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	signedOut("password change", currentToken, otherToken, key)
+
+	current, currentToken, otherToken, key = signIn(newPassword)
+	if err := us.UpdateUser(&models.User{ID: user.ID, Email: "new@example.com"}, current.SessionID, true); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	signedOut("email change", currentToken, otherToken, key)
+
+	// The profile update leaves the password alone
+	if err := us.UpdateUser(&models.User{ID: user.ID, Password: "chosen-by-a-token-thief"}, current.SessionID, true); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if _, err := us.Login("new@example.com", "chosen-by-a-token-thief", ClientInfo{}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login with a password set through UpdateUser = %v, want %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestResetPasswordKeepsConcurrentSuspension(t *testing.T) {
+	db := newTestDB(t)
+	us := newTestUserService(t, db)
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+	token, err := us.Auth.IssueUserToken(user.ID, models.TokenPurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueUserToken: %v", err)
+	}
+
+	// Staff suspend the user while the reset is hashing the new password
+	suspended := false
+	var suspendErr error
+	err = db.Callback().Query().After("gorm:query").Register("test:suspend_during_reset", func(tx *gorm.DB) {
+		if tx.Statement.Table != "users" || suspended {
+			return
+		}
+		suspended = true
+		suspendErr = us.Repo.SuspendUser(user.ID, "fraud", time.Now())
+	})
+	if err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+
+	if err := us.ResetPassword(token, "correct-horse-battery-staple"); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	if suspendErr != nil {
+		t.Fatalf("SuspendUser: %v", suspendErr)
+	}
+	stored, err := us.Repo.GetUserById(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserById: %v", err)
+	}
+	if stored.SuspendedAt == nil || stored.SuspensionReason != "fraud" {
+		t.Errorf("suspension was undone by the password reset")
+	}
+	if ok, _, _ := pkg.VerifyPassword(stored.Password, "correct-horse-battery-staple"); !ok {
+		t.Errorf("password was not reset")
+	}
+}
+
+func TestChangePasswordCountsWrongPasswords(t *testing.T) {
+	db := newTestDB(t)
+	us := newTestUserService(t, db)
+
+	const email, password = "traveller@example.com", "correct-horse-battery-staple"
+	user := &models.User{Name: "Traveller", Email: email, Password: password}
+	if err := us.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	// Someone holding a stolen access token guesses the current password
+	const newPassword = "chosen-by-a-token-thief"
+	for i := 0; i < loginMaxFailures; i++ {
+		if err := us.ChangePassword(user.ID, 0, "guess", newPassword, ClientInfo{}); !errors.Is(err, ErrIncorrectPassword) {
+			t.Fatalf("ChangePassword with a wrong password = %v, want %v", err, ErrIncorrectPassword)
+		}
+	}
+	if err := us.ChangePassword(user.ID, 0, password, newPassword, ClientInfo{}); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("ChangePassword on a locked account = %v, want %v", err, ErrAccountLocked)
+	}
+	if _, err := us.Login(email, password, ClientInfo{}); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("Login after guessing = %v, want %v", err, ErrAccountLocked)
+	}
+}
+
+func TestUpdateStaffAccountRequiresRoleManagement(t *testing.T) {
+	db := newTestDB(t)
+	us := newTestUserService(t, db)
+	admin := createTestUser(t, db, "admin@example.com", RoleAdmin)
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+
+	// Without role:manage only regular accounts can be changed
+	if err := us.UpdateUser(&models.User{ID: admin.ID, Email: "taken-over@example.com"}, 0, false); !errors.Is(err, ErrStaffAccount) {
+		t.Errorf("UpdateUser of an admin = %v, want %v", err, ErrStaffAccount)
+	}
+	if err := us.UpdateUser(&models.User{ID: user.ID, Name: "Renamed"}, 0, false); err != nil {
+		t.Errorf("UpdateUser of a regular user: %v", err)
+	}
+	if err := us.UpdateUser(&models.User{ID: admin.ID, Name: "Renamed"}, 0, true); err != nil {
+		t.Errorf("UpdateUser of an admin with role:manage: %v", err)
+	}
+
+	stored, err := us.Repo.GetUserById(admin.ID)
+	if err != nil {
+		t.Fatalf("GetUserById: %v", err)
+	}
+	if stored.Email != "admin@example.com" {
+		t.Errorf("admin email changed to %s", stored.Email)
+	}
+}
+
+func TestSuspendStaffAccountRequiresRoleManagement(t *testing.T) {
+	db := newTestDB(t)
+	us := newTestUserService(t, db)
+	as := NewUserAdminService(us.Repo, us, repos.NewReservationRepo(db), repos.NewVisaRepo(db), repos.NewSupportRepo(db), repos.NewTravellerRepo(db))
+	createTestUser(t, db, "admin@example.com", RoleAdmin)
+	actor := createTestUser(t, db, "manager@example.com", RoleSupportAgent)
+	officer := createTestUser(t, db, "officer@example.com", RoleVisaOfficer)
+
+	if _, err := as.SuspendUser(actor.ID, officer.ID, "", false); !errors.Is(err, ErrStaffAccount) {
+		t.Errorf("SuspendUser of a staff account = %v, want %v", err, ErrStaffAccount)
+	}
+	if err := as.ForcePasswordReset(officer.ID, false); !errors.Is(err, ErrStaffAccount) {
+		t.Errorf("ForcePasswordReset of a staff account = %v, want %v", err, ErrStaffAccount)
+	}
+	stored, err := us.Repo.GetUserById(officer.ID)
+	if err != nil {
+		t.Fatalf("GetUserById: %v", err)
+	}
+	if stored.SuspendedAt != nil || stored.PasswordResetRequired {
+		t.Errorf("staff account was suspended at %v and required to reset its password: %v", stored.SuspendedAt, stored.PasswordResetRequired)
+	}
+
+	if _, err := as.SuspendUser(actor.ID, officer.ID, "", true); err != nil {
+		t.Errorf("SuspendUser with role:manage: %v", err)
+	}
+	if _, err := as.ReactivateUser(officer.ID, false); !errors.Is(err, ErrStaffAccount) {
+		t.Errorf("ReactivateUser of a staff account = %v, want %v", err, ErrStaffAccount)
+	}
+}
+
+func TestForgotPasswordIsThrottled(t *testing.T) {
+	db := newTestDB(t)
+	us := newTestUserService(t, db)
+	user := createTestUser(t, db, "traveller@example.com", RoleUser)
+
+	for i := 0; i < 3; i++ {
+		if err := us.ForgotPassword(user.Email); err != nil {
+			t.Fatalf("ForgotPassword: %v", err)
+		}
+	}
+	var issued int64
+	if err := db.Model(&models.UserToken{}).Where("user_id = ? AND purpose = ?", user.ID, models.TokenPurposePasswordReset).Count(&issued).Error; err != nil {
+		t.Fatalf("count tokens: %v", err)
+	}
+	if issued != 1 {
+		t.Errorf("%d reset links sent within a minute, want 1", issued)
+	}
+}
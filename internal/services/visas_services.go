@@ -11,24 +11,43 @@ import (
 )
 
 type VisaService struct {
-	Repo *repos.VisaRepo
+	Repo          *repos.VisaRepo
+	UserRepo      *repos.UserRepo
+	TravellerRepo *repos.TravellerRepo
 }
 
-func NewVisaService(visaRepo *repos.VisaRepo) *VisaService {
-	return &VisaService{Repo: visaRepo}
+func NewVisaService(visaRepo *repos.VisaRepo, userRepo *repos.UserRepo, travellerRepo *repos.TravellerRepo) *VisaService {
+	return &VisaService{
+		Repo:          visaRepo,
+		UserRepo:      userRepo,
+		TravellerRepo: travellerRepo,
+	}
 }
 
-// CreateVisa creates a new visa application
+// CreateVisa creates a new visa application. If the application names one of
+// the user's saved travellers, a missing passport number and nationality are
+// taken from the traveller.
 func (vs *VisaService) CreateVisa(visa *models.VisaApplication) error {
 	if visa == nil {
 		return errors.New("visa application data is required")
 	}
 
+	if visa.TravellerID != nil {
+		if err := vs.prefillFromTraveller(visa); err != nil {
+			return err
+		}
+	}
+
 	// Validate visa data
 	if err := vs.validateVisaApplication(visa); err != nil {
 		return err
 	}
 
+	// Only verified accounts can apply
+	if err := requireVerifiedEmail(vs.UserRepo, visa.UserID); err != nil {
+		return err
+	}
+
 	// Set default status if not provided
 	if visa.Status == "" {
 		visa.Status = "pending"
@@ -356,6 +375,31 @@ func (vs *VisaService) BulkRejectVisas(visaIds []uint) error {
 	return nil
 }
 
+// prefillFromTraveller copies passport details of the application's
+// traveller and checks that the passport is still valid on the travel date
+func (vs *VisaService) prefillFromTraveller(visa *models.VisaApplication) error {
+	traveller, err := vs.TravellerRepo.GetTravellerById(visa.UserID, *visa.TravellerID)
+	if err != nil {
+		return ErrTravellerNotFound
+	}
+
+	if visa.PassportNumber == "" {
+		visa.PassportNumber = traveller.PassportNumber
+	}
+	if visa.Nationality == "" {
+		visa.Nationality = traveller.Nationality
+	}
+
+	if visa.PassportNumber == traveller.PassportNumber && traveller.PassportExpiry != "" {
+		expiry, expiryErr := time.Parse(dateLayout, traveller.PassportExpiry)
+		travelDate, travelErr := time.Parse(dateLayout, visa.TravelDate)
+		if expiryErr == nil && travelErr == nil && expiry.Before(travelDate) {
+			return ErrPassportExpired
+		}
+	}
+	return nil
+}
+
 // validateVisaApplication validates visa application data
 func (vs *VisaService) validateVisaApplication(visa *models.VisaApplication) error {
 	// Validate user ID
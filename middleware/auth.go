@@ -1,59 +1,145 @@
 package middleware
 
 import (
+	"Visa/internal/services"
+	"Visa/models"
+	"Visa/pkg"
 	"errors"
+	"log"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware authenticates requests with a bearer access token
+func AuthMiddleware(authService *services.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		if !authenticateBearer(c, authService) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// AuthOrAPIKeyMiddleware authenticates requests with either a bearer access
+// token or an X-API-Key header. API key requests are rate limited per key and
+// only reach routes guarded by a RequireScope the key was granted.
+func AuthOrAPIKeyMiddleware(authService *services.AuthService, apiKeyService *services.APIKeyService, limiter *pkg.RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value := c.GetHeader("X-API-Key")
+		if value == "" {
+			if !authenticateBearer(c, authService) {
+				return
+			}
+			c.Next()
+			return
+		}
+
+		key, user, err := apiKeyService.Authenticate(value, c.ClientIP())
+		if err != nil {
+			if errors.Is(err, services.ErrAccountSuspended) {
+				respondSuspended(c)
+				return
+			}
+			if !errors.Is(err, services.ErrInvalidAPIKey) {
+				log.Printf("Error authenticating API key: %v", err)
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "missing or invalid authorization header",
+				"error": "invalid api key",
 			})
 			c.Abort()
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid authorization format",
+		allowed, remaining, reset := limiter.Allow(strconv.FormatUint(uint64(key.ID), 10), key.RateLimit)
+		c.Header("X-RateLimit-Limit", strconv.Itoa(key.RateLimit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":   "rate_limited",
+				"message": "API key rate limit exceeded",
 			})
 			c.Abort()
 			return
 		}
 
-		tokenStr := parts[1]
+		c.Set("apiKey", key)
+		c.Set("userId", user.ID)
+		c.Set("role", user.Role)
+		c.Set("email", user.Email)
 
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("unexpected signing method")
-			}
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+		c.Next()
+	}
+}
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid token",
+// RequireScope rejects API key requests whose key lacks the scope. Requests
+// authenticated with an access token are let through.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key, ok := c.Get("apiKey"); ok && !key.(*models.APIKey).HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   "forbidden",
+				"message": "API key is missing scope " + scope,
 			})
 			c.Abort()
 			return
 		}
+		c.Next()
+	}
+}
 
-		claims := token.Claims.(jwt.MapClaims)
+// authenticateBearer validates the bearer token and stores its claims in the
+// context. It aborts the request and returns false if the token is invalid.
+func authenticateBearer(c *gin.Context, authService *services.AuthService) bool {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "missing or invalid authorization header",
+		})
+		c.Abort()
+		return false
+	}
 
-		// 🔥 THIS IS THE MOST IMPORTANT PART
-		c.Set("userId", uint(claims["userId"].(float64)))
-		c.Set("role", claims["role"].(string))
-		c.Set("email", claims["email"].(string))
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid authorization format",
+		})
+		c.Abort()
+		return false
+	}
 
-		c.Next()
+	// Validates signature and expiry, and rejects revoked tokens, ended sessions and deleted users
+	claims, err := authService.ValidateAccessToken(parts[1])
+	if errors.Is(err, services.ErrAccountSuspended) {
+		respondSuspended(c)
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid token",
+		})
+		c.Abort()
+		return false
 	}
+
+	c.Set("claims", claims)
+	c.Set("userId", claims.UserID)
+	c.Set("role", claims.Role)
+	c.Set("email", claims.Email)
+	return true
+}
+
+// respondSuspended rejects a request of a suspended user
+func respondSuspended(c *gin.Context) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":   "account_suspended",
+		"message": "This account has been suspended, please contact support",
+	})
+	c.Abort()
 }
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"Visa/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name string
+		key  *models.APIKey
+		want int
+	}{
+		{"access token", nil, http.StatusOK},
+		{"key with scope", &models.APIKey{Scopes: models.ScopeBookingsRead + "," + models.ScopeBookingsWrite}, http.StatusOK},
+		{"key without scope", &models.APIKey{Scopes: models.ScopeBookingsRead}, http.StatusForbidden},
+		{"key without scopes", &models.APIKey{}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.POST("/bookings", func(c *gin.Context) {
+				if tt.key != nil {
+					c.Set("apiKey", tt.key)
+				}
+			}, RequireScope(models.ScopeBookingsWrite), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/bookings", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"Visa/internal/services"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditImpersonation writes every request made with an impersonation token
+// to the audit log, including requests that were rejected. Must run after
+// AuthMiddleware.
+func AuditImpersonation(impersonationService *services.ImpersonationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !IsImpersonating(c) {
+			c.Next()
+			return
+		}
+
+		c.Next()
+
+		claims := c.MustGet("claims").(*services.Claims)
+		if err := impersonationService.RecordRequest(claims, c.Request.Method, c.Request.URL.Path, c.Writer.Status(), c.ClientIP()); err != nil {
+			log.Printf("Error auditing impersonated request of user %d by %d: %v", claims.UserID, claims.ImpersonatorID, err)
+		}
+	}
+}
+
+// BlockImpersonation rejects the request with 403 if it was made with an
+// impersonation token. Use it on sensitive account operations.
+func BlockImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if IsImpersonating(c) {
+			RespondImpersonationForbidden(c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// IsImpersonating reports whether the request was made with an impersonation token
+func IsImpersonating(c *gin.Context) bool {
+	claims, ok := c.Get("claims")
+	return ok && claims.(*services.Claims).ImpersonatorID != 0
+}
+
+// RespondImpersonationForbidden answers 403 for an action that is not
+// allowed while impersonating
+func RespondImpersonationForbidden(c *gin.Context) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":   "impersonation_forbidden",
+		"message": "This action is not allowed while impersonating a user",
+	})
+}
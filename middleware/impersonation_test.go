@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"Visa/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBlockImpersonation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		claims *services.Claims
+		want   int
+	}{
+		{"user", &services.Claims{UserID: 2}, http.StatusOK},
+		{"impersonating staff member", &services.Claims{UserID: 2, ImpersonatorID: 1}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.DELETE("/auth/sessions", func(c *gin.Context) {
+				c.Set("claims", tt.claims)
+			}, BlockImpersonation(), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/auth/sessions", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"Visa/internal/services"
+	"Visa/pkg"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoadLocalizer picks how prices and dates are rendered for the request and
+// stores it in the context. Signed-in callers get their saved preferences;
+// on public routes a valid bearer token is used when sent but not required.
+// The currency, locale and time_zone query parameters override both.
+func LoadLocalizer(authService *services.AuthService, preferencesService *services.PreferencesService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.GetUint("userId")
+		if userId == 0 {
+			if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+				if claims, err := authService.ValidateAccessToken(token); err == nil {
+					userId = claims.UserID
+				}
+			}
+		}
+
+		localizer := preferencesService.Localizer(userId)
+		currency := c.DefaultQuery("currency", localizer.Currency)
+		locale := c.DefaultQuery("locale", localizer.Locale)
+		timeZone := c.DefaultQuery("time_zone", localizer.Location.String())
+		if currency != localizer.Currency || locale != localizer.Locale || timeZone != localizer.Location.String() {
+			override, err := pkg.NewLocalizer(currency, locale, timeZone)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error":   "invalid_input",
+					"message": err.Error(),
+				})
+				c.Abort()
+				return
+			}
+			localizer = override
+		}
+
+		c.Set("localizer", localizer)
+		c.Header("Content-Language", localizer.Locale)
+		c.Next()
+	}
+}
+
+// GetLocalizer returns the localizer stored by LoadLocalizer, or the defaults
+func GetLocalizer(c *gin.Context) *pkg.Localizer {
+	if localizer, ok := c.Get("localizer"); ok {
+		return localizer.(*pkg.Localizer)
+	}
+	return pkg.DefaultLocalizer()
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"Visa/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoadPermissions resolves the permissions granted to the caller's role and
+// stores them in the context. Requests made with an API key never carry
+// staff permissions. Must run after AuthMiddleware.
+func LoadPermissions(rbacService *services.RBACService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		granted := make(map[string]bool)
+		if _, ok := c.Get("apiKey"); ok {
+			c.Set("permissions", granted)
+			c.Next()
+			return
+		}
+		for _, permission := range rbacService.PermissionsForRole(c.GetString("role")) {
+			granted[permission] = true
+		}
+		c.Set("permissions", granted)
+		c.Next()
+	}
+}
+
+// RequirePermission rejects the request with 403 unless the caller holds all
+// of the given permissions. Must run after LoadPermissions.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, permission := range permissions {
+			if !HasPermission(c, permission) {
+				c.JSON(http.StatusForbidden, gin.H{
+					"error":   "forbidden",
+					"message": "Missing permission " + permission,
+				})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// HasPermission reports whether the caller holds the permission
+func HasPermission(c *gin.Context, permission string) bool {
+	granted, ok := c.Get("permissions")
+	if !ok {
+		return false
+	}
+	return granted.(map[string]bool)[permission]
+}
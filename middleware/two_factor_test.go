@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"Visa/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireTwoFactorForOtherUsers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		enabled bool
+		claims  *services.Claims
+		path    string
+		want    int
+	}{
+		{"own account", true, &services.Claims{UserID: 2}, "/users/2", http.StatusOK},
+		{"other account without 2FA", true, &services.Claims{UserID: 1}, "/users/2", http.StatusForbidden},
+		{"other account with 2FA", true, &services.Claims{UserID: 1, MFA: true}, "/users/2", http.StatusOK},
+		{"other account when not required", false, &services.Claims{UserID: 1}, "/users/2", http.StatusOK},
+		{"invalid ID", true, &services.Claims{UserID: 1}, "/users/me", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.PUT("/users/:id", func(c *gin.Context) {
+				c.Set("claims", tt.claims)
+				c.Set("userId", tt.claims.UserID)
+			}, RequireTwoFactorForOtherUsers(tt.enabled), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, tt.path, nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package migration
+
+import (
+	"Visa/models"
+
+	"gorm.io/gorm"
+)
+
+// migrateFareBuckets moves flights sold before fares existed onto a single
+// standard fare holding their seats, and records that fare on their bookings.
+// Bookings made before segments were recorded get their only segment first,
+// so cancelling them gives the seats back to the fare.
+func migrateFareBuckets(db *gorm.DB) error {
+	var flights []models.Flight
+	if err := db.Where("NOT EXISTS (SELECT 1 FROM fare_buckets WHERE fare_buckets.flight_id = flights.id)").
+		Find(&flights).Error; err != nil {
+		return err
+	}
+	for _, flight := range flights {
+		cabin := flight.CabinClass
+		if cabin == "" {
+			cabin = models.CabinEconomy
+		}
+		fare := models.StandardFare(cabin, flight.Price, flight.Capacity)
+		fare.FlightID = flight.ID
+		fare.SeatsAvailable = flight.SeatsAvailable
+		if err := db.Create(&fare).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := db.Exec("INSERT INTO reservation_flights (reservation_id, flight_id, journey, sequence) " +
+		"SELECT r.id, r.flight_id, 1, 1 FROM reservations r " +
+		"WHERE r.flight_id IS NOT NULL AND r.flight_id <> '' " +
+		"AND NOT EXISTS (SELECT 1 FROM reservation_flights rf WHERE rf.reservation_id = r.id)").Error; err != nil {
+		return err
+	}
+	return db.Exec("UPDATE reservation_flights rf JOIN fare_buckets fb ON fb.flight_id = rf.flight_id " +
+		"SET rf.fare_bucket_id = fb.id, rf.cabin = fb.cabin, rf.fare_family = fb.fare_family, rf.price = fb.price " +
+		"WHERE rf.fare_bucket_id IS NULL").Error
+}
@@ -0,0 +1,90 @@
+package migration
+
+import (
+	"Visa/models"
+	"Visa/pkg"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// legacyFlight is a flight as stored before flight numbers, IATA codes and
+// typed departure and arrival times
+type legacyFlight struct {
+	ID        uint
+	From      string
+	To        string
+	Departure string
+	Arrival   string
+}
+
+// migrateLegacyFlights moves flights from the old from, to, departure and
+// arrival text columns to the new schema. Times without an offset are taken
+// as UTC. The old columns are dropped once every flight has been moved; rows
+// that cannot be read are logged and keep the old columns for manual repair.
+func migrateLegacyFlights(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasColumn(&models.Flight{}, "departure") {
+		return nil
+	}
+
+	var legacy []legacyFlight
+	if err := db.Table("flights").
+		Select("id, `from`, `to`, departure, arrival").
+		Where("departure_date = '' OR departure_date IS NULL").
+		Scan(&legacy).Error; err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, old := range legacy {
+		origin := strings.ToUpper(strings.TrimSpace(old.From))
+		destination := strings.ToUpper(strings.TrimSpace(old.To))
+		departure, departureOk := pkg.ParseDateTime(strings.TrimSpace(old.Departure))
+		arrival, arrivalOk := pkg.ParseDateTime(strings.TrimSpace(old.Arrival))
+		if len(origin) != 3 || len(destination) != 3 || !departureOk || !arrivalOk {
+			log.Printf("Flight %d needs manual migration: from %q to %q, departure %q, arrival %q",
+				old.ID, old.From, old.To, old.Departure, old.Arrival)
+			failed++
+			continue
+		}
+
+		var flight models.Flight
+		flight.SetSchedule(departure, arrival)
+		if err := db.Model(&models.Flight{}).Where("id = ?", old.ID).Updates(map[string]interface{}{
+			"origin":              origin,
+			"destination":         destination,
+			"departure_at":        flight.DepartureAt,
+			"departure_time_zone": flight.DepartureTimeZone,
+			"departure_date":      flight.DepartureDate,
+			"arrival_at":          flight.ArrivalAt,
+			"arrival_time_zone":   flight.ArrivalTimeZone,
+			"arrival_date":        flight.ArrivalDate,
+			"duration_minutes":    flight.DurationMinutes,
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		log.Printf("%d flights could not be migrated; keeping the old flight columns", failed)
+		return nil
+	}
+	for _, column := range []string{"from", "to", "departure", "arrival"} {
+		if err := migrator.DropColumn(&models.Flight{}, column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropFlightUserColumn drops flights.user_id, which was never set: the
+// flights of a user are found through their reservations
+func dropFlightUserColumn(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasColumn(&models.Flight{}, "user_id") {
+		return nil
+	}
+	return migrator.DropColumn(&models.Flight{}, "user_id")
+}
@@ -3,21 +3,69 @@ package migration
 import (
 	"Visa/config"
 	"Visa/models"
+
+	"gorm.io/gorm"
 )
 
 func Migrate() {
 	db := config.ConnectToDB()
 
+	// Has to be checked before AutoMigrate adds the column
+	backfillEmails := needsEmailVerificationBackfill(db)
+
 	err := db.AutoMigrate(
 		&models.User{},
 		&models.Flight{},
+		&models.FareBucket{},
 		&models.Reservation{},
+		&models.ReservationFlight{},
+		&models.SeatMap{},
+		&models.SeatMapSeat{},
+		&models.FlightSeat{},
 		&models.Hotel{},
 		&models.VisaApplication{},
 		&models.SupportTicket{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.UserToken{},
+		&models.RecoveryCode{},
+		&models.Invitation{},
+		&models.Permission{},
+		&models.Role{},
+		&models.LoginEvent{},
+		&models.Session{},
+		&models.APIKey{},
+		&models.UserIdentity{},
+		&models.OIDCLoginState{},
+		&models.AuditLog{},
+		&models.Traveller{},
+		&models.LoyaltyNumber{},
+		&models.UserPreferences{},
 	)
 
 	if err != nil {
 		panic("failed to migrate database")
 	}
+
+	if backfillEmails {
+		if err := backfillEmailVerification(db); err != nil {
+			panic("failed to migrate email verification")
+		}
+	}
+
+	// Flights created before capacities were tracked take their free seats
+	// as capacity
+	if err := db.Model(&models.Flight{}).Where("capacity = 0").
+		Update("capacity", gorm.Expr("seats_available")).Error; err != nil {
+		panic("failed to migrate flight capacities")
+	}
+	if err := migrateLegacyFlights(db); err != nil {
+		panic("failed to migrate flights")
+	}
+	if err := migrateFareBuckets(db); err != nil {
+		panic("failed to migrate flight fares")
+	}
+	if err := dropFlightUserColumn(db); err != nil {
+		panic("failed to migrate flights")
+	}
 }
@@ -0,0 +1,26 @@
+package migration
+
+import (
+	"Visa/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// needsEmailVerificationBackfill reports whether the users table exists from
+// before email verification. Must be called before AutoMigrate adds the
+// email_verified_at column.
+func needsEmailVerificationBackfill(db *gorm.DB) bool {
+	migrator := db.Migrator()
+	return migrator.HasTable(&models.User{}) && !migrator.HasColumn(&models.User{}, "email_verified_at")
+}
+
+// backfillEmailVerification marks the accounts that existed before email
+// verification as verified. Otherwise they could not book, and their first
+// sign-in through an identity provider or a sign-in link would claim them as
+// unverified accounts. There is no signup time to use, so they are verified
+// as of the migration.
+func backfillEmailVerification(db *gorm.DB) error {
+	return db.Model(&models.User{}).Where("email_verified_at IS NULL").
+		Update("email_verified_at", time.Now()).Error
+}
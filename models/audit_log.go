@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Audit log actions
+const (
+	AuditImpersonationStarted = "impersonation_started"
+	AuditImpersonatedRequest  = "impersonated_request"
+)
+
+// AuditLog records an action a staff member took on behalf of a user.
+// ActorID is the staff member and UserID the user acted upon.
+type AuditLog struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	ActorID   uint      `json:"actor_id" gorm:"index"`
+	UserID    uint      `json:"user_id" gorm:"index"`
+	SessionID uint      `json:"session_id,omitempty" gorm:"index"`
+	Action    string    `json:"action" gorm:"size:50"`
+	Detail    string    `json:"detail,omitempty" gorm:"size:255"`
+	Method    string    `json:"method,omitempty" gorm:"size:10"`
+	Path      string    `json:"path,omitempty" gorm:"size:255"`
+	Status    int       `json:"status,omitempty"`
+	IP        string    `json:"ip" gorm:"size:45"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
@@ -0,0 +1,50 @@
+package models
+
+// FareFamilyStandard is the fare family of flights sold with a single fare
+const FareFamilyStandard = "standard"
+
+// FareBucket is a fare a flight is sold at: a cabin and fare family with its
+// own price, seats and rules. Seats are sold from each bucket separately.
+// Baggage is per passenger; CheckedBagKg is the weight allowed per checked
+// bag.
+type FareBucket struct {
+	ID             uint    `json:"id" gorm:"primaryKey"`
+	FlightID       uint    `json:"flight_id" gorm:"uniqueIndex:idx_fare_buckets_fare,priority:1"`
+	Cabin          string  `json:"cabin" gorm:"size:20;uniqueIndex:idx_fare_buckets_fare,priority:2"`
+	FareFamily     string  `json:"fare_family" gorm:"size:20;uniqueIndex:idx_fare_buckets_fare,priority:3"`
+	Price          float64 `json:"price"`
+	Capacity       int     `json:"capacity"`
+	SeatsAvailable int     `json:"seats_available"`
+	Refundable     bool    `json:"refundable"`
+	Changeable     bool    `json:"changeable"`
+	ChangeFee      float64 `json:"change_fee"`
+	CabinBags      int     `json:"cabin_bags"`
+	CheckedBags    int     `json:"checked_bags"`
+	CheckedBagKg   int     `json:"checked_bag_kg"`
+}
+
+// StandardFare is the single fare of a flight sold without fare families.
+// Like bookings made before fares existed, it can be cancelled and changed
+// free of charge and comes with one cabin bag and one 23kg checked bag.
+func StandardFare(cabin string, price float64, seats int) FareBucket {
+	return FareBucket{
+		Cabin:          cabin,
+		FareFamily:     FareFamilyStandard,
+		Price:          price,
+		Capacity:       seats,
+		SeatsAvailable: seats,
+		Refundable:     true,
+		Changeable:     true,
+		CabinBags:      1,
+		CheckedBags:    1,
+		CheckedBagKg:   23,
+	}
+}
+
+// Booked is the number of seats sold from the bucket
+func (f *FareBucket) Booked() int {
+	if f.Capacity < f.SeatsAvailable {
+		return 0
+	}
+	return f.Capacity - f.SeatsAvailable
+}
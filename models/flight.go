@@ -1,15 +1,82 @@
 package models
 
+import "time"
+
+// Flight statuses. Cancelled flights are kept so their bookings still point
+// at them, but cannot be booked or changed.
+const (
+	FlightStatusScheduled = "scheduled"
+	FlightStatusCancelled = "cancelled"
+)
+
+// Cabin classes a flight can be sold in
+const (
+	CabinEconomy        = "economy"
+	CabinPremiumEconomy = "premium_economy"
+	CabinBusiness       = "business"
+	CabinFirst          = "first"
+)
+
+// Flight is a scheduled flight between two airports, identified by their
+// IATA codes. Departure and arrival are stored as instants together with the
+// IANA time zone of each airport; DepartureDate and ArrivalDate are the local
+// dates at the airports, formatted as YYYY-MM-DD.
+//
+// Seats are sold from the flight's fares. CabinClass and Price are those of
+// its cheapest fare; Capacity and SeatsAvailable add up the seats of all of
+// them.
 type Flight struct {
-	ID             uint          `json:"id" gorm:"primaryKey"`
-	Airline        string        `json:"airline"`
-	From           string        `json:"from"`
-	To             string        `json:"to"`
-	Departure      string        `json:"departure"`
-	Arrival        string        `json:"arrival"`
-	Price          float64       `json:"price"`
-	City           string        `json:"city"`
-	SeatsAvailable int           `json:"seats_available"`
-	Reservations   []Reservation `json:"reservations" gorm:"foreignKey:FlightID"`
-	UserID         uint          `gorm:"column:user_id" json:"user_id"`
+	ID                uint          `json:"id" gorm:"primaryKey"`
+	FlightNumber      string        `json:"flight_number" gorm:"size:8;index"`
+	Airline           string        `json:"airline" gorm:"size:100"`
+	Origin            string        `json:"origin" gorm:"size:3;index:idx_flights_route,priority:1"`
+	Destination       string        `json:"destination" gorm:"size:3;index:idx_flights_route,priority:2"`
+	City              string        `json:"city"`
+	DepartureAt       time.Time     `json:"departure_at" gorm:"index"`
+	DepartureTimeZone string        `json:"departure_time_zone" gorm:"size:64"`
+	DepartureDate     string        `json:"departure_date" gorm:"size:10;index:idx_flights_route,priority:3"`
+	ArrivalAt         time.Time     `json:"arrival_at"`
+	ArrivalTimeZone   string        `json:"arrival_time_zone" gorm:"size:64"`
+	ArrivalDate       string        `json:"arrival_date" gorm:"size:10;index"`
+	DurationMinutes   int           `json:"duration_minutes"`
+	Aircraft          string        `json:"aircraft" gorm:"size:50"`
+	CabinClass        string        `json:"cabin_class" gorm:"size:20;index;default:economy"`
+	Stops             int           `json:"stops" gorm:"default:0"`
+	Price             float64       `json:"price"`
+	Capacity          int           `json:"capacity"`
+	SeatsAvailable    int           `json:"seats_available"`
+	Status            string        `json:"status" gorm:"size:20;default:scheduled"`
+	Fares             []FareBucket  `json:"fares,omitempty" gorm:"foreignKey:FlightID"`
+	SeatMapID         *uint         `json:"seat_map_id,omitempty"`
+	Reservations      []Reservation `json:"reservations" gorm:"foreignKey:FlightID"`
+}
+
+// SetSchedule stores the departure and arrival, each given in the time zone
+// of its airport, with the local dates and duration derived from them
+func (f *Flight) SetSchedule(departure, arrival time.Time) {
+	f.DepartureAt = departure.UTC()
+	f.DepartureTimeZone = departure.Location().String()
+	f.DepartureDate = departure.Format("2006-01-02")
+	f.ArrivalAt = arrival.UTC()
+	f.ArrivalTimeZone = arrival.Location().String()
+	f.ArrivalDate = arrival.Format("2006-01-02")
+	f.DurationMinutes = int(arrival.Sub(departure).Minutes())
+}
+
+// LocalDeparture is the departure time at the origin airport
+func (f *Flight) LocalDeparture() time.Time {
+	return inTimeZone(f.DepartureAt, f.DepartureTimeZone)
+}
+
+// LocalArrival is the arrival time at the destination airport
+func (f *Flight) LocalArrival() time.Time {
+	return inTimeZone(f.ArrivalAt, f.ArrivalTimeZone)
+}
+
+func inTimeZone(t time.Time, timeZone string) time.Time {
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return t.UTC()
+	}
+	return t.In(location)
 }
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// UserIdentity links an account at an external OpenID Connect provider to a user
+type UserIdentity struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"-" gorm:"index"`
+	Provider  string    `json:"provider" gorm:"size:50;uniqueIndex:idx_identity_provider_subject"`
+	Subject   string    `json:"-" gorm:"size:255;uniqueIndex:idx_identity_provider_subject"`
+	Email     string    `json:"email" gorm:"size:254"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCLoginState holds what is needed to finish a login started at an
+// external provider. Only the SHA-256 hash of the state parameter is stored.
+type OIDCLoginState struct {
+	ID           uint   `gorm:"primaryKey"`
+	StateHash    string `gorm:"uniqueIndex;size:64"`
+	Provider     string `gorm:"size:50"`
+	Nonce        string `gorm:"size:64"`
+	CodeVerifier string `gorm:"size:128"`
+	ExpiresAt    time.Time
+	UsedAt       *time.Time
+	CreatedAt    time.Time
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Invitation lets an admin create a staff account with a given role. The
+// invitee sets their own name and password when accepting.
+type Invitation struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Email       string     `json:"email" gorm:"index"`
+	Role        string     `json:"role"`
+	TokenHash   string     `json:"-" gorm:"uniqueIndex;size:64"`
+	InvitedByID uint       `json:"invited_by_id"`
+	InvitedBy   User       `json:"-" gorm:"foreignKey:InvitedByID"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	AcceptedAt  *time.Time `json:"accepted_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Reasons recorded on failed login events
+const (
+	LoginFailureUnknownEmail  = "unknown_email"
+	LoginFailureBadPassword   = "bad_password"
+	LoginFailureBadTwoFactor  = "bad_two_factor_code"
+	LoginFailureAccountLocked = "account_locked"
+	LoginFailureIPThrottled   = "ip_throttled"
+	LoginFailureSuspended     = "account_suspended"
+	LoginFailureResetRequired = "password_reset_required"
+)
+
+// LoginEvent records a sign-in attempt. UserID is nil when the email did not
+// match an account.
+type LoginEvent struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    *uint     `json:"-" gorm:"index"`
+	Email     string    `json:"-" gorm:"size:254"`
+	IP        string    `json:"ip" gorm:"size:45;index"`
+	UserAgent string    `json:"user_agent" gorm:"size:255"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason,omitempty" gorm:"size:32"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
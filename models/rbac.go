@@ -0,0 +1,35 @@
+package models
+
+// Permission names used by RequirePermission and handler checks
+const (
+	PermUserRead     = "user:read"
+	PermUserManage   = "user:manage"
+	PermRoleManage   = "role:manage"
+	PermVisaRead     = "visa:read"
+	PermVisaApprove  = "visa:approve"
+	PermVisaDelete   = "visa:delete"
+	PermHotelWrite   = "hotel:write"
+	PermFlightWrite  = "flight:write"
+	PermBookingRead  = "booking:read"
+	PermTicketRead   = "ticket:read"
+	PermTicketAssign = "ticket:assign"
+	PermTicketDelete = "ticket:delete"
+
+	PermUserImpersonate = "user:impersonate"
+	PermAuditRead       = "audit:read"
+)
+
+// Role groups permissions. User.Role holds the role name.
+type Role struct {
+	ID          uint         `json:"id" gorm:"primaryKey"`
+	Name        string       `json:"name" gorm:"uniqueIndex;size:50"`
+	Description string       `json:"description"`
+	Permissions []Permission `json:"permissions" gorm:"many2many:role_permissions"`
+}
+
+// Permission is a named action such as "visa:approve"
+type Permission struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Name        string `json:"name" gorm:"uniqueIndex;size:50"`
+	Description string `json:"description"`
+}
@@ -1,8 +1,22 @@
 package models
 
+import "strconv"
+
+// Trip types of a flight booking
+const (
+	TripOneWay    = "one_way"
+	TripRoundTrip = "round_trip"
+	TripMultiCity = "multi_city"
+)
+
 type Reservation struct {
 	ID uint `json:"id" gorm:"primaryKey"`
 
+	// Reference is the booking reference given to the traveller. Only flight
+	// bookings have one.
+	Reference *string `json:"reference,omitempty" gorm:"size:6;uniqueIndex"`
+	TripType  string  `json:"trip_type,omitempty" gorm:"size:20"`
+
 	UserID  string `json:"userId"`
 	User    User   `json:"user" gorm:"foreignKey:UserID"`
 	Status  string `json:"status"`
@@ -12,7 +26,70 @@ type Reservation struct {
 	FlightID *string `json:"flight_id"` // OPTIONAL
 	Flight   *Flight `json:"flight" gorm:"foreignKey:FlightID"`
 
+	// Segments are the flights of a flight booking in travel order, grouped
+	// into journeys such as the outbound and return of a round trip. FlightID
+	// is the first of them.
+	Segments []ReservationFlight `json:"segments,omitempty" gorm:"foreignKey:ReservationID"`
+
+	// AssignedSeats are the seats chosen for the passengers on the flights
+	AssignedSeats []FlightSeat `json:"assigned_seats,omitempty" gorm:"foreignKey:ReservationID"`
+
 	CheckIn    string  `json:"check_in"`
 	CheckOut   string  `json:"check_out"`
 	TotalPrice float64 `json:"total_price"`
+	// RefundAmount is what a cancelled flight booking pays back: all of it
+	// when the airline cancelled a flight, otherwise all but the price and
+	// the seat and change fees of the flights booked at non-refundable fares
+	RefundAmount float64 `json:"refund_amount"`
+
+	// Travellers are the passengers or guests named on the booking
+	Travellers []Traveller `json:"travellers,omitempty" gorm:"many2many:reservation_travellers"`
+}
+
+// ReservationFlight is one flight of a flight booking. Every passenger on
+// the booking holds a seat on each of them. Journey numbers the journeys of
+// the booking from 1; Sequence numbers the flights across all journeys.
+// FareBucketID is the fare the seats were sold from; its cabin, family and
+// price per seat are kept as they were when booked. Fees adds up the seat and
+// change fees paid on the flight since, for all passengers.
+type ReservationFlight struct {
+	ID            uint    `json:"-" gorm:"primaryKey"`
+	ReservationID uint    `json:"-" gorm:"index"`
+	FlightID      uint    `json:"flight_id" gorm:"index"`
+	Journey       int     `json:"journey" gorm:"default:1"`
+	Sequence      int     `json:"sequence"`
+	FareBucketID  *uint   `json:"fare_id,omitempty" gorm:"index"`
+	Cabin         string  `json:"cabin,omitempty" gorm:"size:20"`
+	FareFamily    string  `json:"fare_family,omitempty" gorm:"size:20"`
+	Price         float64 `json:"price"`
+	Fees          float64 `json:"fees"`
+}
+
+// FlightIDs lists the flights of a flight booking in travel order. Bookings
+// made before segments were recorded only have FlightID.
+func (r *Reservation) FlightIDs() []uint {
+	if len(r.Segments) > 0 {
+		ids := make([]uint, len(r.Segments))
+		for i, segment := range r.Segments {
+			ids[i] = segment.FlightID
+		}
+		return ids
+	}
+	if r.FlightID == nil {
+		return nil
+	}
+	id, err := strconv.ParseUint(*r.FlightID, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return []uint{uint(id)}
+}
+
+// Seats is the number of seats a flight booking holds on each of its
+// flights: one per named traveller, or one for the booking user
+func (r *Reservation) Seats() int {
+	if len(r.Travellers) == 0 {
+		return 1
+	}
+	return len(r.Travellers)
 }
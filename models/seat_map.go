@@ -0,0 +1,56 @@
+package models
+
+// Seat positions within a row
+const (
+	SeatWindow = "window"
+	SeatMiddle = "middle"
+	SeatAisle  = "aisle"
+)
+
+// SeatMap is a seat layout template for an aircraft type. Flights given a
+// seat map get their own copy of its seats to sell.
+type SeatMap struct {
+	ID       uint          `json:"id" gorm:"primaryKey"`
+	Name     string        `json:"name" gorm:"size:50;uniqueIndex"`
+	Aircraft string        `json:"aircraft" gorm:"size:50;index"`
+	Seats    []SeatMapSeat `json:"seats,omitempty" gorm:"foreignKey:SeatMapID"`
+}
+
+// Seat describes a seat of a layout. Numbers are the row followed by the
+// seat letter, such as 12A. Blocked seats are never sold; Fee is charged for
+// choosing the seat.
+type Seat struct {
+	Row          int     `json:"row" gorm:"column:seat_row"`
+	Letter       string  `json:"letter" gorm:"size:1"`
+	Cabin        string  `json:"cabin" gorm:"size:20"`
+	Position     string  `json:"position" gorm:"size:10"`
+	Exit         bool    `json:"exit_row"`
+	ExtraLegroom bool    `json:"extra_legroom"`
+	Blocked      bool    `json:"blocked"`
+	Fee          float64 `json:"fee"`
+}
+
+// SeatMapSeat is a seat of a seat map template
+type SeatMapSeat struct {
+	ID        uint   `json:"-" gorm:"primaryKey"`
+	SeatMapID uint   `json:"-" gorm:"uniqueIndex:idx_seat_map_seats_number,priority:1"`
+	Number    string `json:"number" gorm:"size:4;uniqueIndex:idx_seat_map_seats_number,priority:2"`
+	Seat      `gorm:"embedded"`
+}
+
+// FlightSeat is a seat on a flight. A seat is taken when it is assigned to a
+// passenger of a booking: one of its travellers, or the booking user when
+// TravellerID is 0. A passenger holds at most one seat per flight.
+type FlightSeat struct {
+	ID            uint   `json:"-" gorm:"primaryKey"`
+	FlightID      uint   `json:"flight_id" gorm:"uniqueIndex:idx_flight_seats_number,priority:1;uniqueIndex:idx_flight_seats_passenger,priority:1"`
+	Number        string `json:"number" gorm:"size:4;uniqueIndex:idx_flight_seats_number,priority:2"`
+	Seat          `gorm:"embedded"`
+	ReservationID *uint `json:"-" gorm:"uniqueIndex:idx_flight_seats_passenger,priority:2"`
+	TravellerID   uint  `json:"traveller_id" gorm:"uniqueIndex:idx_flight_seats_passenger,priority:3;default:0"`
+}
+
+// Available reports whether the seat can be chosen
+func (s *FlightSeat) Available() bool {
+	return !s.Blocked && s.ReservationID == nil
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Session is a sign-in on one device. Every access and refresh token belongs
+// to a session, and ending the session invalidates them.
+type Session struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	UserID     uint       `json:"-" gorm:"index"`
+	Device     string     `json:"device" gorm:"size:100"`
+	UserAgent  string     `json:"user_agent" gorm:"size:255"`
+	IP         string     `json:"ip" gorm:"size:45"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	EndedAt    *time.Time `json:"-" gorm:"index"`
+
+	// ImpersonatorID is the staff member acting as the user in this session
+	ImpersonatorID *uint `json:"impersonator_id,omitempty" gorm:"index"`
+
+	// Current marks the session of the requesting token in listings
+	Current bool `json:"current" gorm:"-"`
+}
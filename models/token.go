@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// RefreshToken is a long-lived, single-use token that can be exchanged for a
+// new access token. Only the SHA-256 hash of the token is stored.
+type RefreshToken struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	UserID       uint       `json:"user_id" gorm:"index"`
+	SessionID    uint       `json:"session_id" gorm:"index"`
+	TokenHash    string     `json:"-" gorm:"uniqueIndex;size:64"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	ReplacedByID *uint      `json:"replaced_by_id"`
+	MFA          bool       `json:"mfa"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// RevokedToken records the ID (jti) of an access token that was revoked
+// before it expired, e.g. on logout.
+type RevokedToken struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	JTI       string    `json:"jti" gorm:"uniqueIndex;size:64"`
+	UserID    uint      `json:"user_id" gorm:"index"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Purposes of one-time user tokens
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposeMagicLink         = "magic_link"
+)
+
+// UserToken is a single-use token sent to a user by email, e.g. for a
+// password reset. Only the SHA-256 hash of the token is stored.
+type UserToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"index"`
+	Purpose   string     `json:"purpose" gorm:"size:32;index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;size:64"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Relationship of a traveller to the account owner
+const (
+	RelationshipSelf    = "self"
+	RelationshipPartner = "partner"
+	RelationshipChild   = "child"
+	RelationshipParent  = "parent"
+	RelationshipOther   = "other"
+)
+
+// Traveller is a person a user books trips for: themselves or a companion
+// such as a family member. Dates are formatted as YYYY-MM-DD.
+type Traveller struct {
+	ID             uint            `json:"id" gorm:"primaryKey"`
+	UserID         uint            `json:"-" gorm:"index"`
+	Relationship   string          `json:"relationship" gorm:"size:20"`
+	FirstName      string          `json:"first_name" gorm:"size:100"`
+	LastName       string          `json:"last_name" gorm:"size:100"`
+	DateOfBirth    string          `json:"date_of_birth" gorm:"size:10"`
+	Nationality    string          `json:"nationality" gorm:"size:50"`
+	PassportNumber string          `json:"passport_number,omitempty" gorm:"size:20"`
+	PassportExpiry string          `json:"passport_expiry,omitempty" gorm:"size:10"`
+	LoyaltyNumbers []LoyaltyNumber `json:"loyalty_numbers" gorm:"foreignKey:TravellerID"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+
+	// Removed travellers are kept for the reservations that name them
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// LoyaltyNumber is a traveller's membership number in a frequent flyer or
+// hotel rewards programme
+type LoyaltyNumber struct {
+	ID          uint   `json:"-" gorm:"primaryKey"`
+	TravellerID uint   `json:"-" gorm:"index"`
+	Program     string `json:"program" gorm:"size:100"`
+	Number      string `json:"number" gorm:"size:50"`
+}
@@ -1,12 +1,41 @@
 package models
 
+import "time"
+
 type User struct {
-	ID       uint `json:"id" gorm:"primaryKey"`
+	ID       uint   `json:"id" gorm:"primaryKey"`
 	Name     string `json:"name"`
 	Email    string `json:"email" gorm:"unique"`
 	Password string `json:"-"` // Hide in JSON
 	Role     string `json:"role"`
 
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+
+	// TOTP two-factor authentication
+	TwoFactorEnabled  bool   `json:"two_factor_enabled"`
+	TwoFactorSecret   string `json:"-" gorm:"size:64"`
+	TwoFactorLastStep int64  `json:"-"`
+
+	// Access tokens issued before this time are rejected
+	TokensRevokedAt *time.Time `json:"-"`
+
+	// Consecutive failed sign-ins and the lockout they caused
+	FailedLoginCount int        `json:"-"`
+	LockedUntil      *time.Time `json:"locked_until,omitempty"`
+
+	// A suspended user cannot sign in and their tokens and API keys are rejected
+	SuspendedAt      *time.Time `json:"suspended_at,omitempty"`
+	SuspensionReason string     `json:"suspension_reason,omitempty" gorm:"size:255"`
+
+	// Set by staff to refuse password sign-ins until the password is reset
+	PasswordResetRequired bool `json:"password_reset_required"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+
+	// ErasedAt is set once the user's personal data has been anonymized.
+	// The row is kept so that bookings still reference an account.
+	ErasedAt *time.Time `json:"erased_at,omitempty"`
+
 	Reservations     []Reservation     `json:"reservations" gorm:"foreignKey:UserID"`
 	VisaApplications []VisaApplication `json:"visa_applications" gorm:"foreignKey:UserID"`
 	SupportTickets   []SupportTicket   `json:"support_tickets" gorm:"foreignKey:UserID"`
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Notification channels a user can opt in to
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	ChannelPush  = "push"
+)
+
+// ChannelOptIns records consent per notification channel
+type ChannelOptIns struct {
+	Email bool `json:"email"`
+	SMS   bool `json:"sms"`
+	Push  bool `json:"push"`
+}
+
+// Allows reports whether the channel is opted in to
+func (o ChannelOptIns) Allows(channel string) bool {
+	switch channel {
+	case ChannelEmail:
+		return o.Email
+	case ChannelSMS:
+		return o.SMS
+	case ChannelPush:
+		return o.Push
+	}
+	return false
+}
+
+// UserPreferences holds how a user wants prices, dates and messages
+// presented. Users without a row get the defaults.
+type UserPreferences struct {
+	UserID   uint   `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	Currency string `json:"currency" gorm:"size:3"`
+	Locale   string `json:"locale" gorm:"size:10"`
+	TimeZone string `json:"time_zone" gorm:"size:64"`
+
+	// Booking updates and reminders. Security emails such as password
+	// resets are always sent.
+	Notifications ChannelOptIns `json:"notifications" gorm:"embedded;embeddedPrefix:notify_"`
+	// Offers and newsletters
+	Marketing ChannelOptIns `json:"marketing" gorm:"embedded;embeddedPrefix:marketing_"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -4,6 +4,8 @@ type VisaApplication struct {
 	ID             uint `json:"id" gorm:"primaryKey"`
 	UserID         uint
 	User           User `gorm:"foreignKey:UserID"`
+	TravellerID    *uint
+	Traveller      *Traveller `gorm:"foreignKey:TravellerID"`
 	VisaType       string
 	Destination    string
 	TravelDate     string
@@ -0,0 +1,285 @@
+package pkg
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	// Time zones must resolve even on hosts without a zoneinfo database
+	_ "time/tzdata"
+)
+
+// BaseCurrency is the currency prices are stored in
+const BaseCurrency = "USD"
+
+// Defaults for callers without preferences
+const (
+	DefaultCurrency = BaseCurrency
+	DefaultLocale   = "en-US"
+	DefaultTimeZone = "UTC"
+)
+
+type currencyInfo struct {
+	symbol   string
+	decimals int
+}
+
+var currencies = map[string]currencyInfo{
+	"USD": {"$", 2},
+	"EUR": {"€", 2},
+	"GBP": {"£", 2},
+	"JPY": {"¥", 0},
+	"CHF": {"CHF", 2},
+	"CAD": {"CA$", 2},
+	"AUD": {"A$", 2},
+	"INR": {"₹", 2},
+	"AED": {"AED", 2},
+	"NGN": {"₦", 2},
+	"BRL": {"R$", 2},
+}
+
+// Units of each currency per US dollar, overridable with CURRENCY_RATES
+var defaultExchangeRates = map[string]float64{
+	"USD": 1,
+	"EUR": 0.92,
+	"GBP": 0.79,
+	"JPY": 150,
+	"CHF": 0.88,
+	"CAD": 1.36,
+	"AUD": 1.52,
+	"INR": 83,
+	"AED": 3.6725,
+	"NGN": 1500,
+	"BRL": 5.0,
+}
+
+type localeFormat struct {
+	decimal     string
+	group       string
+	symbolAfter bool
+	date        string
+	dateTime    string
+}
+
+var locales = map[string]localeFormat{
+	"en-US": {".", ",", false, "Jan 2, 2006", "Jan 2, 2006 3:04 PM"},
+	"en-GB": {".", ",", false, "2 Jan 2006", "2 Jan 2006 15:04"},
+	"fr-FR": {",", " ", true, "02/01/2006", "02/01/2006 15:04"},
+	"de-DE": {",", ".", true, "02.01.2006", "02.01.2006 15:04"},
+	"es-ES": {",", ".", true, "02/01/2006", "02/01/2006 15:04"},
+	"it-IT": {",", ".", true, "02/01/2006", "02/01/2006 15:04"},
+	"nl-NL": {",", ".", false, "02-01-2006", "02-01-2006 15:04"},
+	"pt-BR": {",", ".", false, "02/01/2006", "02/01/2006 15:04"},
+	"ja-JP": {".", ",", false, "2006/01/02", "2006/01/02 15:04"},
+}
+
+// Layouts stored dates are read with. Times without an offset are taken as UTC.
+var (
+	dateTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02 15:04"}
+	dateLayouts     = []string{"2006-01-02"}
+)
+
+var (
+	exchangeRatesOnce sync.Once
+	exchangeRates     map[string]float64
+)
+
+// loadExchangeRates reads CURRENCY_RATES once, e.g. "EUR=0.91,GBP=0.78",
+// on top of the built-in rates
+func loadExchangeRates() map[string]float64 {
+	exchangeRatesOnce.Do(func() {
+		exchangeRates = make(map[string]float64, len(defaultExchangeRates))
+		for code, rate := range defaultExchangeRates {
+			exchangeRates[code] = rate
+		}
+		value := os.Getenv("CURRENCY_RATES")
+		if value == "" {
+			return
+		}
+		for _, pair := range strings.Split(value, ",") {
+			code, rateStr, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			code = strings.ToUpper(strings.TrimSpace(code))
+			rate, err := strconv.ParseFloat(strings.TrimSpace(rateStr), 64)
+			if _, known := currencies[code]; !known || err != nil || rate <= 0 || code == BaseCurrency {
+				log.Printf("Ignoring invalid CURRENCY_RATES entry %q", pair)
+				continue
+			}
+			exchangeRates[code] = rate
+		}
+	})
+	return exchangeRates
+}
+
+// SupportedCurrencies lists the ISO 4217 codes prices can be shown in
+func SupportedCurrencies() []string {
+	return sortedKeys(currencies)
+}
+
+// SupportedLocales lists the locales prices and dates can be formatted for
+func SupportedLocales() []string {
+	return sortedKeys(locales)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// NormalizeCurrency returns the upper case code if the currency is supported
+func NormalizeCurrency(currency string) (string, bool) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	_, ok := currencies[currency]
+	return currency, ok
+}
+
+// NormalizeLocale returns the canonical form of a supported locale, accepting
+// "de_de" or "de-DE" alike
+func NormalizeLocale(locale string) (string, bool) {
+	language, region, _ := strings.Cut(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"), "-")
+	locale = strings.ToLower(language) + "-" + strings.ToUpper(region)
+	_, ok := locales[locale]
+	return locale, ok
+}
+
+// Localizer renders prices and dates in a user's currency, locale and time zone
+type Localizer struct {
+	Currency string
+	Locale   string
+	Location *time.Location
+
+	rate   float64
+	format localeFormat
+	symbol currencyInfo
+}
+
+// NewLocalizer creates a localizer, rejecting unsupported currencies and
+// locales and unknown IANA time zones
+func NewLocalizer(currency, locale, timeZone string) (*Localizer, error) {
+	currency, ok := NormalizeCurrency(currency)
+	if !ok {
+		return nil, fmt.Errorf("unsupported currency %q", currency)
+	}
+	locale, ok = NormalizeLocale(locale)
+	if !ok {
+		return nil, fmt.Errorf("unsupported locale %q", locale)
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil || timeZone == "" || timeZone == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", timeZone)
+	}
+
+	return &Localizer{
+		Currency: currency,
+		Locale:   locale,
+		Location: location,
+		rate:     loadExchangeRates()[currency],
+		format:   locales[locale],
+		symbol:   currencies[currency],
+	}, nil
+}
+
+// DefaultLocalizer shows prices in the base currency, US English and UTC
+func DefaultLocalizer() *Localizer {
+	l, _ := NewLocalizer(DefaultCurrency, DefaultLocale, DefaultTimeZone)
+	return l
+}
+
+// LocalizedPrice is a price converted to the user's currency
+type LocalizedPrice struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+	Display  string  `json:"display"`
+}
+
+// Price converts an amount in the base currency and formats it for the
+// locale, e.g. "$1,234.50" for en-US or "1.234,50 €" for de-DE
+func (l *Localizer) Price(amount float64) LocalizedPrice {
+	scale := math.Pow(10, float64(l.symbol.decimals))
+	converted := math.Round(amount*l.rate*scale) / scale
+	return LocalizedPrice{
+		Amount:   converted,
+		Currency: l.Currency,
+		Display:  l.formatMoney(converted),
+	}
+}
+
+// ToBase converts an amount in the user's currency to the base currency
+func (l *Localizer) ToBase(amount float64) float64 {
+	return amount / l.rate
+}
+
+func (l *Localizer) formatMoney(amount float64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	digits := strconv.FormatFloat(amount, 'f', l.symbol.decimals, 64)
+	whole, fraction, _ := strings.Cut(digits, ".")
+
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteString(l.format.group)
+		}
+		grouped.WriteRune(digit)
+	}
+	number := grouped.String()
+	if fraction != "" {
+		number += l.format.decimal + fraction
+	}
+
+	if l.format.symbolAfter {
+		return sign + number + " " + l.symbol.symbol
+	}
+	if strings.Trim(l.symbol.symbol, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") == "" {
+		// Codes such as "CHF" need a space before the amount
+		return sign + l.symbol.symbol + " " + number
+	}
+	return sign + l.symbol.symbol + number
+}
+
+// Date formats a stored date or date and time. Times are converted to the
+// user's time zone; plain dates are only reformatted. Values that cannot be
+// parsed are returned as they are.
+func (l *Localizer) Date(value string) string {
+	if t, ok := ParseDateTime(value); ok {
+		return l.Time(t)
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Format(l.format.date)
+		}
+	}
+	return value
+}
+
+// Time formats an instant in the user's time zone
+func (l *Localizer) Time(t time.Time) string {
+	return t.In(l.Location).Format(l.format.dateTime)
+}
+
+// ParseDateTime reads a stored date and time in any of the accepted layouts
+func ParseDateTime(value string) (time.Time, bool) {
+	return ParseDateTimeIn(value, time.UTC)
+}
+
+// ParseDateTimeIn reads a date and time in any of the accepted layouts. Times
+// without an offset are taken to be in loc; times with one are converted to it.
+func ParseDateTimeIn(value string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range dateTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t.In(loc), true
+		}
+	}
+	return time.Time{}, false
+}
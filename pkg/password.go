@@ -0,0 +1,150 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Argon2Params are the cost parameters of new Argon2id password hashes.
+// Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for Argon2id
+var DefaultArgon2Params = Argon2Params{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+var (
+	argon2ParamsOnce sync.Once
+	argon2Params     Argon2Params
+)
+
+// CurrentArgon2Params returns the parameters used for new hashes, read once
+// from ARGON2_MEMORY_KIB, ARGON2_ITERATIONS and ARGON2_PARALLELISM
+func CurrentArgon2Params() Argon2Params {
+	argon2ParamsOnce.Do(func() {
+		argon2Params = DefaultArgon2Params
+		if v, ok := uintFromEnv("ARGON2_MEMORY_KIB", 32); ok && v >= 8*1024 {
+			argon2Params.Memory = uint32(v)
+		}
+		if v, ok := uintFromEnv("ARGON2_ITERATIONS", 32); ok && v >= 1 {
+			argon2Params.Iterations = uint32(v)
+		}
+		if v, ok := uintFromEnv("ARGON2_PARALLELISM", 8); ok && v >= 1 {
+			argon2Params.Parallelism = uint8(v)
+		}
+	})
+	return argon2Params
+}
+
+func uintFromEnv(key string, bits int) (uint64, bool) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(value, 10, bits)
+	if err != nil {
+		log.Printf("Ignoring invalid %s %q: %v", key, value, err)
+		return 0, false
+	}
+	return v, true
+}
+
+// HashPassword hashes a password with Argon2id and the current parameters.
+// The result is in the PHC string format:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+func HashPassword(password string) (string, error) {
+	p := CurrentArgon2Params()
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword checks a password against an Argon2id or legacy bcrypt hash.
+// needsRehash is set when the password matched but the hash uses bcrypt or
+// parameters other than the current ones, so the caller should store a
+// fresh hash.
+func VerifyPassword(hash, password string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		p, salt, key, err := decodeArgon2Hash(hash)
+		if err != nil {
+			return false, false, err
+		}
+		computed := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, false, nil
+		}
+		current := CurrentArgon2Params()
+		p.SaltLength, p.KeyLength = uint32(len(salt)), uint32(len(key))
+		return true, p != current, nil
+
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) || errors.Is(err, bcrypt.ErrPasswordTooLong) {
+				return false, false, nil
+			}
+			return false, false, err
+		}
+		return true, true, nil
+	}
+	return false, false, ErrUnknownPasswordHash
+}
+
+func decodeArgon2Hash(hash string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2 parameters %q: %w", parts[3], err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2 hash: %w", err)
+	}
+	if len(key) == 0 {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+	return p, salt, key, nil
+}
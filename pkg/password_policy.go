@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// MaxPasswordLength caps passwords so that hashing stays cheap. Argon2id has
+// no length limit of its own, unlike bcrypt's 72 bytes.
+const MaxPasswordLength = 256
+
+// ErrWeakPassword is wrapped by every password policy violation
+var ErrWeakPassword = errors.New("weak password")
+
+//go:embed data/common_passwords.txt
+var commonPasswords string
+
+var (
+	passwordPolicyOnce sync.Once
+	minPasswordLength  int
+	// Plain entries are stored lower case, SHA-1 entries as upper case hex
+	blockedPasswords     map[string]bool
+	blockedPasswordSHA1s map[string]bool
+)
+
+// MinPasswordLength is read once from PASSWORD_MIN_LENGTH and defaults to 8
+func MinPasswordLength() int {
+	loadPasswordPolicy()
+	return minPasswordLength
+}
+
+// CheckPasswordPolicy returns an error wrapping ErrWeakPassword if the
+// password is too short or too long, is found in the breached password list,
+// or contains one of the personal values such as the user's name or email.
+//
+// The breached password list is built in and can be extended with
+// PASSWORD_BLOCKLIST_FILE, a file with one password per line. Lines of 40
+// hex characters, optionally followed by ":count", are read as SHA-1 hashes
+// so that Have I Been Pwned exports can be used directly. The file is held in
+// memory, so use a subset such as the most common passwords.
+func CheckPasswordPolicy(password string, personal ...string) error {
+	loadPasswordPolicy()
+
+	length := utf8.RuneCountInString(password)
+	if length < minPasswordLength {
+		return fmt.Errorf("%w: password must be at least %d characters", ErrWeakPassword, minPasswordLength)
+	}
+	if length > MaxPasswordLength {
+		return fmt.Errorf("%w: password must not exceed %d characters", ErrWeakPassword, MaxPasswordLength)
+	}
+
+	lower := strings.ToLower(password)
+	sum := sha1.Sum([]byte(password))
+	if blockedPasswords[lower] || blockedPasswordSHA1s[strings.ToUpper(hex.EncodeToString(sum[:]))] {
+		return fmt.Errorf("%w: password is too common or has appeared in a data breach", ErrWeakPassword)
+	}
+
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if local, _, found := strings.Cut(value, "@"); found {
+			value = local
+		}
+		if len(value) >= 4 && strings.Contains(lower, value) {
+			return fmt.Errorf("%w: password must not contain your name or email", ErrWeakPassword)
+		}
+	}
+	return nil
+}
+
+func loadPasswordPolicy() {
+	passwordPolicyOnce.Do(func() {
+		minPasswordLength = 8
+		if value := os.Getenv("PASSWORD_MIN_LENGTH"); value != "" {
+			if n, err := strconv.Atoi(value); err == nil && n >= 8 && n <= MaxPasswordLength {
+				minPasswordLength = n
+			} else {
+				log.Printf("Ignoring invalid PASSWORD_MIN_LENGTH %q, must be between 8 and %d", value, MaxPasswordLength)
+			}
+		}
+
+		blockedPasswords = make(map[string]bool)
+		blockedPasswordSHA1s = make(map[string]bool)
+		addBlockedPasswords(strings.NewReader(commonPasswords))
+
+		path := os.Getenv("PASSWORD_BLOCKLIST_FILE")
+		if path == "" {
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			log.Printf("Error opening PASSWORD_BLOCKLIST_FILE: %v", err)
+			return
+		}
+		defer f.Close()
+		if err := addBlockedPasswords(f); err != nil {
+			log.Printf("Error reading PASSWORD_BLOCKLIST_FILE: %v", err)
+		}
+	})
+}
+
+func addBlockedPasswords(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if hash, _, _ := strings.Cut(line, ":"); len(hash) == 40 {
+			if _, err := hex.DecodeString(hash); err == nil {
+				blockedPasswordSHA1s[strings.ToUpper(hash)] = true
+				continue
+			}
+		}
+		blockedPasswords[strings.ToLower(line)] = true
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter counts requests per key in fixed windows. It is kept in memory,
+// so limits apply per server instance.
+type RateLimiter struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	start time.Time
+	count int
+}
+
+// NewRateLimiter creates a limiter with the given window length
+func NewRateLimiter(window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		window:  window,
+		buckets: make(map[string]*rateBucket),
+	}
+}
+
+// Allow records a request for key and reports whether it is within limit.
+// It also returns the requests left in the window and when the window resets.
+func (rl *RateLimiter) Allow(key string, limit int) (bool, int, time.Time) {
+	now := time.Now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	bucket, ok := rl.buckets[key]
+	if !ok || now.Sub(bucket.start) >= rl.window {
+		if len(rl.buckets) > 10000 {
+			rl.evictExpired(now)
+		}
+		bucket = &rateBucket{start: now}
+		rl.buckets[key] = bucket
+	}
+
+	reset := bucket.start.Add(rl.window)
+	if bucket.count >= limit {
+		return false, 0, reset
+	}
+	bucket.count++
+	return true, limit - bucket.count, reset
+}
+
+// evictExpired drops finished windows; must be called with mu held
+func (rl *RateLimiter) evictExpired(now time.Time) {
+	for key, bucket := range rl.buckets {
+		if now.Sub(bucket.start) >= rl.window {
+			delete(rl.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	rl := NewRateLimiter(time.Minute)
+
+	for i := 1; i <= 3; i++ {
+		allowed, remaining, _ := rl.Allow("key", 3)
+		if !allowed {
+			t.Fatalf("request %d was not allowed", i)
+		}
+		if remaining != 3-i {
+			t.Errorf("request %d: remaining = %d, want %d", i, remaining, 3-i)
+		}
+	}
+	if allowed, remaining, _ := rl.Allow("key", 3); allowed || remaining != 0 {
+		t.Errorf("request over the limit: allowed = %v, remaining = %d", allowed, remaining)
+	}
+	if allowed, _, _ := rl.Allow("other", 3); !allowed {
+		t.Error("another key shares the limit")
+	}
+}
+
+func TestRateLimiterWindowResets(t *testing.T) {
+	rl := NewRateLimiter(20 * time.Millisecond)
+
+	if allowed, _, _ := rl.Allow("key", 1); !allowed {
+		t.Fatal("first request was not allowed")
+	}
+	if allowed, _, _ := rl.Allow("key", 1); allowed {
+		t.Fatal("second request in the window was allowed")
+	}
+	time.Sleep(25 * time.Millisecond)
+	if allowed, _, _ := rl.Allow("key", 1); !allowed {
+		t.Error("request in a new window was not allowed")
+	}
+}
@@ -0,0 +1,250 @@
+package pkg
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenIssuer signs and verifies JWTs. It is the only place in the
+// application that knows about signing keys.
+type TokenIssuer interface {
+	// Sign signs the claims with the active key
+	Sign(claims jwt.Claims) (string, error)
+	// Parse verifies a token against the published keys and fills claims
+	Parse(tokenString string, claims jwt.Claims) error
+	// JWKS returns the public keys that can verify issued tokens
+	JWKS() JWKS
+}
+
+// SigningKey is a key known to the issuer. Keys without a private part can
+// only verify tokens, which is how retired keys are kept during rotation.
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+// JWK is a single public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set as served from /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeySetIssuer is a TokenIssuer backed by a set of RSA and Ed25519 keys
+type KeySetIssuer struct {
+	keys     map[string]*SigningKey
+	activeID string
+}
+
+// NewKeySetIssuer creates an issuer that signs with the key activeID and
+// verifies with any of the given keys
+func NewKeySetIssuer(activeID string, keys ...*SigningKey) (*KeySetIssuer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one signing key is required")
+	}
+
+	issuer := &KeySetIssuer{keys: make(map[string]*SigningKey, len(keys))}
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("signing key ID is required")
+		}
+		if _, exists := issuer.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate signing key ID %q", key.ID)
+		}
+		issuer.keys[key.ID] = key
+	}
+
+	active, ok := issuer.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active signing key %q not found", activeID)
+	}
+	if active.PrivateKey == nil {
+		return nil, fmt.Errorf("active signing key %q has no private key", activeID)
+	}
+	issuer.activeID = activeID
+
+	return issuer, nil
+}
+
+// NewIssuerFromEnv loads signing keys from the PEM files in JWT_KEYS_DIR.
+// Each file name (without extension) is used as the key ID, and
+// JWT_ACTIVE_KEY_ID selects the signing key (defaults to the last key ID in
+// lexical order). Files holding only a public key are used for verification.
+// Without JWT_KEYS_DIR an ephemeral Ed25519 key is generated for development.
+func NewIssuerFromEnv() (*KeySetIssuer, error) {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		log.Println("JWT_KEYS_DIR not set, using an ephemeral signing key (tokens will not survive a restart)")
+		key, err := GenerateEd25519Key("dev")
+		if err != nil {
+			return nil, err
+		}
+		return NewKeySetIssuer(key.ID, key)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list signing keys: %w", err)
+	}
+	sort.Strings(files)
+
+	var keys []*SigningKey
+	configuredID := os.Getenv("JWT_ACTIVE_KEY_ID")
+	activeID := configuredID
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signing key %s: %w", file, err)
+		}
+		id := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		key, err := ParseSigningKeyPEM(id, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse signing key %s: %w", file, err)
+		}
+		keys = append(keys, key)
+		if configuredID == "" && key.PrivateKey != nil {
+			activeID = id
+		}
+	}
+
+	return NewKeySetIssuer(activeID, keys...)
+}
+
+// GenerateEd25519Key creates a new random Ed25519 signing key
+func GenerateEd25519Key(id string) (*SigningKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	return &SigningKey{
+		ID:         id,
+		Method:     jwt.SigningMethodEdDSA,
+		PrivateKey: priv,
+		PublicKey:  pub,
+	}, nil
+}
+
+// ParseSigningKeyPEM parses a PKCS#8 / PKCS#1 private key or a PKIX public
+// key. RSA keys sign with RS256 and Ed25519 keys with EdDSA.
+func ParseSigningKeyPEM(id string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{ID: id}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.PrivateKey, key.PublicKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.PublicKey = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.PrivateKey, key.PublicKey = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.PublicKey = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	if rsaKey, ok := key.PublicKey.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < 2048 {
+		return nil, errors.New("RSA keys must be at least 2048 bits")
+	}
+	return key, nil
+}
+
+// Sign signs the claims with the active key and sets the kid header
+func (ki *KeySetIssuer) Sign(claims jwt.Claims) (string, error) {
+	key := ki.keys[ki.activeID]
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// Parse verifies the token with the key named by its kid header
+func (ki *KeySetIssuer) Parse(tokenString string, claims jwt.Claims) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ki.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		// The algorithm is bound to the key, never taken from the token
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}))
+	if err != nil {
+		return err
+	}
+	if !token.Valid {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// JWKS returns the public part of every key, sorted by key ID
+func (ki *KeySetIssuer) JWKS() JWKS {
+	set := JWKS{Keys: make([]JWK, 0, len(ki.keys))}
+	for _, key := range ki.keys {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch pub := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}